@@ -0,0 +1,85 @@
+// Command backfill rebuilds the database from the fetched files: it lists the
+// Sitzungen, Tops and Vorlagen folders and syncs every file, Sitzungen before
+// Tops. Done files are recorded in the checkpoint file, an interrupted
+// backfill (Ctrl-C) continues where it stopped. The checkpoint is removed
+// once every file is synced.
+//
+//	go run ./cmd/backfill -config config.json -dir ./fetched -sqlite allris.db
+//	go run ./cmd/backfill -config config.json -project my-project -bucket fetched -postgres "$DSN" -force
+//	go run ./cmd/backfill -config config.json -project my-project -bucket fetched    # Cloud Datastore
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/rismaster/allris-db/cmd/internal/cli"
+	"github.com/rismaster/allris-db/db"
+)
+
+func main() {
+
+	envFlags := cli.RegisterEnvFlags()
+	checkpointPath := flag.String("checkpoint", "backfill-checkpoint.json", "file recording the done files")
+	concurrency := flag.Int("concurrency", db.DefaultConcurrency, "files synced at once")
+	rate := flag.Float64("rate", 0, "max files started per second, 0 for no limit")
+	force := flag.Bool("force", false, "reparse unchanged files, e.g. after a parser fix")
+	anlagen := flag.Bool("anlagen", false, "record the content and text of the Anlagen as well")
+	flag.Parse()
+
+	// Only the backfill is cancelled on an interrupt, the repository keeps
+	// its own context so the running transactions commit.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	interrupted := make(chan os.Signal, 1)
+	signal.Notify(interrupted, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-interrupted
+		fmt.Fprintln(os.Stderr, "interrupted, finishing the running files")
+		cancel()
+	}()
+
+	env, closeRepo, err := envFlags.Env()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%+v\n", err)
+		os.Exit(2)
+	}
+	defer closeRepo()
+	env.Force = *force
+
+	checkpoint, err := db.LoadCheckpoint(*checkpointPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%+v\n", err)
+		os.Exit(2)
+	}
+
+	report, skipped, err := db.Backfill(ctx, env, checkpoint, db.BackfillOptions{
+		BulkOptions: db.BulkOptions{Concurrency: *concurrency, Rate: *rate},
+		Anlagen:     *anlagen,
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%+v\n", err)
+		if report == nil {
+			os.Exit(2)
+		}
+	}
+
+	for _, f := range report.Failed {
+		fmt.Printf("FAIL %s: %v\n", f.File, f.Err)
+	}
+	fmt.Printf("%d synced, %d unchanged, %d failed, %d cancelled, %d done before in %s\n",
+		len(report.Synced), len(report.Unchanged), len(report.Failed), len(report.Cancelled), skipped, report.Duration)
+	if len(report.Failed) > 0 || len(report.Cancelled) > 0 {
+		fmt.Printf("rerun to retry, the checkpoint %s keeps the done files\n", *checkpointPath)
+		closeRepo()
+		os.Exit(1)
+	}
+	err = checkpoint.Remove()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%+v\n", err)
+	}
+}
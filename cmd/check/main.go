@@ -0,0 +1,51 @@
+// Command check validates the stored entities read-only, see
+// db.CheckIntegrity, and prints the report as JSON. It exits with 1 if an
+// invariant is broken or a Vorlage couldn't be checked and with 2 if the
+// check couldn't run, for use in scheduled jobs.
+//
+//	go run ./cmd/check -config config.json -dir ./fetched -sqlite allris.db
+//	go run ./cmd/check -config config.json -project my-project -bucket fetched > report.json
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/rismaster/allris-db/cmd/internal/cli"
+	"github.com/rismaster/allris-db/db"
+)
+
+func main() {
+
+	envFlags := cli.RegisterEnvFlags()
+	flag.Parse()
+
+	env, closeRepo, err := envFlags.Env()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%+v\n", err)
+		os.Exit(2)
+	}
+	defer closeRepo()
+
+	report, err := db.CheckIntegrity(env)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%+v\n", err)
+		closeRepo()
+		os.Exit(2)
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	err = enc.Encode(report)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%+v\n", err)
+		closeRepo()
+		os.Exit(2)
+	}
+	if !report.OK() {
+		closeRepo()
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/rismaster/allris-db/db"
+	"github.com/rismaster/allris-db/db/golden"
+	"github.com/rismaster/allris-db/db/sqlite"
+)
+
+// runMain makes the test binary run main instead of the tests, so the exit
+// status can be checked.
+const runMain = "ALLRIS_CHECK_RUN_MAIN"
+
+func TestMain(m *testing.M) {
+	if os.Getenv(runMain) != "" {
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// check runs main with args and returns its exit status and output.
+func check(t *testing.T, args ...string) (int, string) {
+	t.Helper()
+	cmd := exec.Command(os.Args[0], args...)
+	cmd.Env = append(os.Environ(), runMain+"=1")
+	out, err := cmd.Output()
+	if exit, ok := err.(*exec.ExitError); ok {
+		return exit.ExitCode(), string(out)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return 0, string(out)
+}
+
+// writeConfig writes the config of the fixtures to dir.
+func writeConfig(t *testing.T, dir string) string {
+	t.Helper()
+	var cfg golden.FixtureConfig
+	content, err := json.Marshal(map[string]string{
+		"Timezone":        cfg.GetTimezone(),
+		"DateFormat":      cfg.GetDateFormat(),
+		"EntityTop":       cfg.GetEntityTop(),
+		"EntityAnlage":    cfg.GetEntityAnlage(),
+		"EntitySitzung":   cfg.GetEntitySitzung(),
+		"EntityVorlage":   cfg.GetEntityVorlage(),
+		"EntityTermin":    cfg.GetEntityTermin(),
+		"TopFolder":       cfg.GetTopFolder(),
+		"SitzungenFolder": cfg.GetSitzungenFolder(),
+		"VorlagenFolder":  cfg.GetVorlagenFolder(),
+		"AnlagenFolder":   cfg.GetAnlagenFolder(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "config.json")
+	err = ioutil.WriteFile(path, content, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestExitStatus(t *testing.T) {
+
+	dir := t.TempDir()
+	config := writeConfig(t, dir)
+	database := filepath.Join(dir, "allris.db")
+	repo, err := sqlite.Open(context.Background(), database, golden.FixtureConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo.Close()
+
+	status, out := check(t, "-config", config, "-dir", dir, "-sqlite", database)
+	if status != 0 {
+		t.Errorf("empty database: exit %d\n%s", status, out)
+	}
+
+	repo, err = sqlite.Open(context.Background(), database, golden.FixtureConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = repo.Put(db.NameKey("Termin", "sitzung-4242", nil), &db.Termin{Gremium: "Rat", SILFDNR: 4242})
+	repo.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	status, out = check(t, "-config", config, "-dir", dir, "-sqlite", database)
+	var report db.IntegrityReport
+	if status != 1 || json.Unmarshal([]byte(out), &report) != nil || len(report.Violations) != 1 {
+		t.Errorf("termin without sitzung: exit %d\n%s", status, out)
+	}
+
+	status, _ = check(t, "-dir", dir, "-sqlite", database)
+	if status != 2 {
+		t.Errorf("no config: exit %d", status)
+	}
+	status, _ = check(t, "-config", filepath.Join(dir, "missing.json"), "-dir", dir, "-sqlite", database)
+	if status != 2 {
+		t.Errorf("missing config: exit %d", status)
+	}
+}
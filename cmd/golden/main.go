@@ -0,0 +1,45 @@
+// Command golden checks the ALLRIS parsers against the golden files of a
+// fixture directory and exits non-zero on any difference. The fixtures of
+// the repository are checked by go test ./db/golden as well.
+//
+//	go run ./cmd/golden              # check
+//	go run ./cmd/golden -update      # regenerate the golden files
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/rismaster/allris-db/db/golden"
+)
+
+func main() {
+
+	dir := flag.String("dir", "db/golden/testdata", "directory with the fixture pages and golden files")
+	update := flag.Bool("update", false, "write the golden files instead of comparing")
+	flag.Parse()
+
+	results, err := golden.Run(*dir, golden.FixtureConfig{}, *update)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%+v\n", err)
+		os.Exit(2)
+	}
+
+	var failed int
+	for _, r := range results {
+		switch {
+		case r.Updated:
+			fmt.Printf("updated %s\n", r.Golden)
+		case r.Failed():
+			failed++
+			fmt.Printf("FAIL %s\n%s\n", r.Page, r.Diff)
+		default:
+			fmt.Printf("ok   %s\n", r.Page)
+		}
+	}
+	if failed > 0 {
+		fmt.Printf("%d of %d pages differ from their golden file, rerun with -update if intended\n", failed, len(results))
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,157 @@
+package cli
+
+import (
+	"encoding/json"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Config is the allris_common.Config of a deployment read from a JSON file,
+// see LoadConfig. The keys are the names of the getters without Get, the
+// durations are strings like "30s":
+//
+//	{"ProjectId": "my-project", "BucketFetched": "fetched", "Timezone": "Europe/Berlin",
+//	 "EntitySitzung": "Sitzung", "SitzungenFolder": "sitzungen/", ...}
+type Config struct {
+	ProxySecretHeaderKey  string
+	ProxyHostHeaderKey    string
+	ProxySecret           string
+	ProxyUrl              string
+	ProxyHost             string
+	ProjectId             string
+	BucketFetched         string
+	BucketBackup          string
+	MinAgeBeforeDownload  Duration
+	HttpTimeout           Duration
+	HttpCalldelay         Duration
+	HttpVersuche          int
+	HttpWithproxy         bool
+	HttpWartezeitonretry  Duration
+	Timezone              string
+	DateFormatWithTime    string
+	PathToParse           string
+	EntityTop             string
+	EntityAnlage          string
+	EntitySitzung         string
+	AnlageType            string
+	UrlAnlagedoc          string
+	AnlageDocumentType    string
+	TopFolder             string
+	SitzungenFolder       string
+	VorlagenFolder        string
+	SitzungType           string
+	VorlageType           string
+	AlleSitzungenType     string
+	DateFormatTech        string
+	EntityTermin          string
+	EntityVorlage         string
+	DateFormat            string
+	AnlagenFolder         string
+	TopType               string
+	TargetToParse         string
+	DownloadTopic         string
+	Debug                 bool
+	UrlSitzungsLangeliste string
+	UrlSitzungsliste      string
+	GremienListeType      string
+	UrlSitzungTmpl        string
+	GremienOptionsType    string
+	UrlVorlagenliste      string
+	VorlagenListeType     string
+	UrlVorlageTmpl        string
+	BucketOcr             string
+	MailGunDomain         string
+	MailGunApiString      string
+}
+
+// Duration is a time.Duration written as a string in the config file.
+type Duration time.Duration
+
+// UnmarshalJSON parses the duration with time.ParseDuration.
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	err := json.Unmarshal(b, &s)
+	if err != nil {
+		return err
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// LoadConfig reads the config file, unknown keys are an error so a typo
+// doesn't leave a setting empty.
+func LoadConfig(path string) (*Config, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "error opening config")
+	}
+	defer f.Close()
+	dec := json.NewDecoder(f)
+	dec.DisallowUnknownFields()
+	cfg := &Config{}
+	err = dec.Decode(cfg)
+	if err != nil {
+		return nil, errors.Wrap(err, "error reading config "+path)
+	}
+	return cfg, nil
+}
+
+func (c *Config) GetProxySecretHeaderKey() string { return c.ProxySecretHeaderKey }
+func (c *Config) GetProxyHostHeaderKey() string   { return c.ProxyHostHeaderKey }
+func (c *Config) GetProxySecret() string          { return c.ProxySecret }
+func (c *Config) GetProxyUrl() string             { return c.ProxyUrl }
+func (c *Config) GetProxyHost() string            { return c.ProxyHost }
+func (c *Config) GetProjectId() string            { return c.ProjectId }
+func (c *Config) GetBucketFetched() string        { return c.BucketFetched }
+func (c *Config) GetBucketBackup() string         { return c.BucketBackup }
+func (c *Config) GetMinAgeBeforeDownload() time.Duration {
+	return time.Duration(c.MinAgeBeforeDownload)
+}
+func (c *Config) GetHttpTimeout() time.Duration   { return time.Duration(c.HttpTimeout) }
+func (c *Config) GetHttpCalldelay() time.Duration { return time.Duration(c.HttpCalldelay) }
+func (c *Config) GetHttpVersuche() int            { return c.HttpVersuche }
+func (c *Config) GetHttpWithproxy() bool          { return c.HttpWithproxy }
+func (c *Config) GetHttpWartezeitonretry() time.Duration {
+	return time.Duration(c.HttpWartezeitonretry)
+}
+func (c *Config) GetTimezone() string              { return c.Timezone }
+func (c *Config) GetDateFormatWithTime() string    { return c.DateFormatWithTime }
+func (c *Config) GetPathToParse() string           { return c.PathToParse }
+func (c *Config) GetEntityTop() string             { return c.EntityTop }
+func (c *Config) GetEntityAnlage() string          { return c.EntityAnlage }
+func (c *Config) GetEntitySitzung() string         { return c.EntitySitzung }
+func (c *Config) GetAnlageType() string            { return c.AnlageType }
+func (c *Config) GetUrlAnlagedoc() string          { return c.UrlAnlagedoc }
+func (c *Config) GetAnlageDocumentType() string    { return c.AnlageDocumentType }
+func (c *Config) GetTopFolder() string             { return c.TopFolder }
+func (c *Config) GetSitzungenFolder() string       { return c.SitzungenFolder }
+func (c *Config) GetVorlagenFolder() string        { return c.VorlagenFolder }
+func (c *Config) GetSitzungType() string           { return c.SitzungType }
+func (c *Config) GetVorlageType() string           { return c.VorlageType }
+func (c *Config) GetAlleSitzungenType() string     { return c.AlleSitzungenType }
+func (c *Config) GetDateFormatTech() string        { return c.DateFormatTech }
+func (c *Config) GetEntityTermin() string          { return c.EntityTermin }
+func (c *Config) GetEntityVorlage() string         { return c.EntityVorlage }
+func (c *Config) GetDateFormat() string            { return c.DateFormat }
+func (c *Config) GetAnlagenFolder() string         { return c.AnlagenFolder }
+func (c *Config) GetTopType() string               { return c.TopType }
+func (c *Config) GetTargetToParse() string         { return c.TargetToParse }
+func (c *Config) GetDownloadTopic() string         { return c.DownloadTopic }
+func (c *Config) GetDebug() bool                   { return c.Debug }
+func (c *Config) GetUrlSitzungsLangeliste() string { return c.UrlSitzungsLangeliste }
+func (c *Config) GetUrlSitzungsliste() string      { return c.UrlSitzungsliste }
+func (c *Config) GetGremienListeType() string      { return c.GremienListeType }
+func (c *Config) GetUrlSitzungTmpl() string        { return c.UrlSitzungTmpl }
+func (c *Config) GetGremienOptionsType() string    { return c.GremienOptionsType }
+func (c *Config) GetUrlVorlagenliste() string      { return c.UrlVorlagenliste }
+func (c *Config) GetVorlagenListeType() string     { return c.VorlagenListeType }
+func (c *Config) GetUrlVorlageTmpl() string        { return c.UrlVorlageTmpl }
+func (c *Config) GetBucketOcr() string             { return c.BucketOcr }
+func (c *Config) GetMailGunDomain() string         { return c.MailGunDomain }
+func (c *Config) GetMailGunApiString() string      { return c.MailGunApiString }
@@ -0,0 +1,105 @@
+// Package cli has the flags the commands share to choose the config of the
+// deployment, where the fetched files are read from and where the entities
+// are stored.
+package cli
+
+import (
+	"context"
+	"flag"
+	"fmt"
+
+	allris_common "github.com/rismaster/allris-common"
+	"github.com/rismaster/allris-common/application"
+	"github.com/rismaster/allris-db/db"
+	"github.com/rismaster/allris-db/db/postgres"
+	"github.com/rismaster/allris-db/db/sqlite"
+)
+
+// EnvFlags are the -config, -dir, -project, -bucket, -sqlite and -postgres
+// flags.
+type EnvFlags struct {
+	config      *string
+	dir         *string
+	project     *string
+	bucket      *string
+	sqlitePath  *string
+	postgresDSN *string
+}
+
+// RegisterEnvFlags defines the flags, call it before flag.Parse.
+func RegisterEnvFlags() *EnvFlags {
+	return &EnvFlags{
+		config:      flag.String("config", "", "JSON config of the deployment, see cli.Config"),
+		dir:         flag.String("dir", "", "read the files from this directory instead of the bucket"),
+		project:     flag.String("project", "", "GCP project of the bucket and Cloud Datastore, overrides the config"),
+		bucket:      flag.String("bucket", "", "bucket with the fetched files, overrides the config"),
+		sqlitePath:  flag.String("sqlite", "", "store in this sqlite database"),
+		postgresDSN: flag.String("postgres", "", "store in the postgres database with this DSN"),
+	}
+}
+
+// Env reads the -config file and opens the env with it, see EnvWith.
+func (f *EnvFlags) Env() (env *db.Env, closeRepo func(), err error) {
+
+	if *f.config == "" {
+		return nil, nil, fmt.Errorf("-config is needed")
+	}
+	cfg, err := LoadConfig(*f.config)
+	if err != nil {
+		return nil, nil, err
+	}
+	if *f.project != "" {
+		cfg.ProjectId = *f.project
+	}
+	if *f.bucket != "" {
+		cfg.BucketFetched = *f.bucket
+	}
+	return f.EnvWith(cfg)
+}
+
+// EnvWith reads from -dir or the bucket and stores in sqlite, postgres or
+// Cloud Datastore, in this order of preference, with the config of the
+// deployment, for a main embedding its own config. Call closeRepo when done.
+// The repository and the app outlive any request, their context is never
+// cancelled so an interrupt can't abort a running transaction.
+func (f *EnvFlags) EnvWith(cfg allris_common.Config) (env *db.Env, closeRepo func(), err error) {
+
+	ctx := context.Background()
+	var repo db.Repository
+	closeRepo = func() {}
+	switch {
+	case *f.sqlitePath != "":
+		r, err := sqlite.Open(ctx, *f.sqlitePath, cfg)
+		if err != nil {
+			return nil, nil, err
+		}
+		repo, closeRepo = r, func() { r.Close() }
+	case *f.postgresDSN != "":
+		r, err := postgres.Open(ctx, *f.postgresDSN, cfg)
+		if err != nil {
+			return nil, nil, err
+		}
+		repo, closeRepo = r, func() { r.Close() }
+	}
+
+	if *f.dir != "" && repo != nil {
+		return db.NewLocalEnv(cfg, repo, *f.dir), closeRepo, nil
+	}
+	if cfg.GetProjectId() == "" || (*f.dir == "" && cfg.GetBucketFetched() == "") {
+		closeRepo()
+		return nil, nil, fmt.Errorf("a project and a bucket are needed without -dir and a database")
+	}
+	app, err := application.NewAppContextWithContext(ctx, cfg)
+	if err != nil {
+		closeRepo()
+		return nil, nil, err
+	}
+	env = db.NewEnv(app)
+	if repo != nil {
+		env.Repo = repo
+	}
+	if *f.dir != "" {
+		env.Source = &db.DirSource{Dir: *f.dir}
+	}
+	return env, closeRepo, nil
+}
@@ -0,0 +1,86 @@
+// Command reconcile relates the Termine to their Sitzungen, see
+// db.ReconcileTermine, and prints the status of every Termin from -from on,
+// by default today. It exits with 1 if a Termin doesn't match its Sitzung or
+// a Sitzung has no Termin anymore and with 2 if it couldn't run.
+//
+//	go run ./cmd/reconcile -config config.json -dir ./fetched -sqlite allris.db
+//	go run ./cmd/reconcile -config config.json -project my-project -from 2024-01-01 -json
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/rismaster/allris-db/cmd/internal/cli"
+	"github.com/rismaster/allris-db/db"
+)
+
+func main() {
+
+	envFlags := cli.RegisterEnvFlags()
+	fromFlag := flag.String("from", "", "first day of the Termine as YYYY-MM-DD, today by default")
+	asJSON := flag.Bool("json", false, "print the reconciliations as JSON")
+	flag.Parse()
+
+	env, closeRepo, err := envFlags.Env()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%+v\n", err)
+		os.Exit(2)
+	}
+	defer closeRepo()
+
+	config := env.App.Config
+	loc, err := time.LoadLocation(config.GetTimezone())
+	if err != nil {
+		loc = time.UTC
+	}
+	now := time.Now()
+	from := now
+	if *fromFlag != "" {
+		from, err = time.ParseInLocation("2006-01-02", *fromFlag, loc)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "invalid -from %q, expected YYYY-MM-DD\n", *fromFlag)
+			closeRepo()
+			os.Exit(2)
+		}
+	}
+
+	reconciliations, err := db.ReconcileTermine(env.Repo, config, from, now)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%+v\n", err)
+		closeRepo()
+		os.Exit(2)
+	}
+
+	mismatched := 0
+	for _, r := range reconciliations {
+		if r.Disappeared || len(r.Mismatches) > 0 {
+			mismatched++
+		}
+	}
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(reconciliations)
+	} else {
+		for _, r := range reconciliations {
+			var start time.Time
+			var name string
+			if r.Termin != nil {
+				start, name = r.Termin.Start, r.Termin.Gremium
+			} else {
+				start, name = r.Sitzung.Datum, r.Sitzung.Gremium
+			}
+			fmt.Printf("%-9s %s %s %s\n", r.Status, start.In(loc).Format("2006-01-02 15:04"), name, strings.Join(r.Mismatches, "; "))
+		}
+		fmt.Printf("%d termine, %d mismatched\n", len(reconciliations), mismatched)
+	}
+	if mismatched > 0 {
+		closeRepo()
+		os.Exit(1)
+	}
+}
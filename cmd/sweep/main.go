@@ -0,0 +1,60 @@
+// Command sweep reports the entities that fell out of sync, see db.Sweep, and
+// repairs them with -repair. It exits with 1 if it found anything it didn't
+// repair.
+//
+//	go run ./cmd/sweep -config config.json -dir ./fetched -sqlite allris.db -sources
+//	go run ./cmd/sweep -config config.json -dir ./fetched -sqlite allris.db -sources -repair
+//	go run ./cmd/sweep -config config.json -project my-project -bucket fetched -json
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/rismaster/allris-db/cmd/internal/cli"
+	"github.com/rismaster/allris-db/db"
+)
+
+func main() {
+
+	envFlags := cli.RegisterEnvFlags()
+	repair := flag.Bool("repair", false, "repair or delete the findings instead of only reporting them")
+	sources := flag.Bool("sources", false, "report the Sitzungen, Tops and Vorlagen whose file is gone")
+	maxNoSource := flag.Float64("max-no-source", db.DefaultMaxNoSource, "share of the entities of a folder whose file may be gone before -sources refuses")
+	batch := flag.Int("batch", db.DefaultSweepBatch, "entities deleted per transaction")
+	asJSON := flag.Bool("json", false, "print the report as JSON")
+	flag.Parse()
+
+	env, closeRepo, err := envFlags.Env()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%+v\n", err)
+		os.Exit(2)
+	}
+	defer closeRepo()
+
+	report, err := db.Sweep(env, db.SweepOptions{Repair: *repair, BatchSize: *batch, Sources: *sources, MaxNoSource: *maxNoSource})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%+v\n", err)
+		if report == nil {
+			closeRepo()
+			os.Exit(2)
+		}
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(report)
+	} else {
+		for _, item := range report.Items {
+			fmt.Printf("%-12s %s %s\n", item.Finding, item.Key.String(), item.File)
+		}
+		fmt.Printf("%d found, %d repaired\n", len(report.Items), report.Repaired)
+	}
+	if err != nil || report.Repaired < len(report.Items) {
+		closeRepo()
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,724 @@
+package db
+
+import (
+	"fmt"
+	"html"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/kennygrant/sanitize"
+	"github.com/pkg/errors"
+	allris_common "github.com/rismaster/allris-common"
+	"github.com/rismaster/allris-common/common/domtools"
+)
+
+// Ergebnis of an Abstimmung.
+const (
+	Angenommen = "angenommen"
+	Abgelehnt  = "abgelehnt"
+)
+
+// Votum of a Fraktion.
+const (
+	VotumJa         = "ja"
+	VotumNein       = "nein"
+	VotumEnthaltung = "enthaltung"
+	VotumGeteilt    = "geteilt"
+)
+
+// Abstimmung is the voting result of a Top. Gezaehlt is set if the
+// Zustimmungen and Ablehnungen are counted, a result given in prose
+// ("einstimmig beschlossen") has none.
+// Einstimmig also holds for a unanimous vote with abstentions, Geteilt is a
+// vote with Ablehnungen that isn't unanimous. Text is the raw result.
+type Abstimmung struct {
+	SILFDNR int
+	TOLFDNR int
+	VOLFDNR int
+	Gremium string
+	Datum   time.Time
+
+	Zustimmung   int
+	Ablehnung    int
+	Enthaltung   int
+	Gezaehlt     bool
+	Einstimmig   bool
+	Mehrheitlich bool
+	Geteilt      bool
+	Ergebnis     string
+	Text         string `datastore:",noindex"`
+
+	SavedAt time.Time
+
+	Fraktionen []*FraktionsVotum `datastore:"-"`
+}
+
+// FraktionsVotum is the vote of one Fraktion. Fraktionen listed without
+// counts ("dafür: CDU, FDP") only have a Votum.
+type FraktionsVotum struct {
+	SILFDNR  int
+	TOLFDNR  int
+	Gremium  string
+	Datum    time.Time
+	Fraktion string
+
+	Zustimmung  int
+	Ablehnung   int
+	Enthaltung  int
+	Votum       string
+	Geschlossen bool
+}
+
+func (a *Abstimmung) GetKey(topKey *Key) *Key {
+	return NameKey(EntityAbstimmung, strconv.Itoa(a.TOLFDNR), topKey)
+}
+
+func (f *FraktionsVotum) GetKey(abstimmungKey *Key) *Key {
+	return NameKey(EntityFraktionsVotum, fraktionName(f.Fraktion), abstimmungKey)
+}
+
+// fraktionName is the name of a Fraktion in its key, "CDU" and "cdu" are the
+// same Fraktion.
+func fraktionName(name string) string {
+	return strings.ToLower(sanitize.BaseName(domtools.CleanText(name)))
+}
+
+// mergeFraktionen merges the votes of Fraktionen with the same fraktionName
+// into the first one and drops those without a name, so every key is saved
+// once.
+func mergeFraktionen(fs []*FraktionsVotum) []*FraktionsVotum {
+	byName := make(map[string]*FraktionsVotum)
+	var merged []*FraktionsVotum
+	for _, f := range fs {
+		name := fraktionName(f.Fraktion)
+		if name == "" {
+			continue
+		}
+		m, ok := byName[name]
+		if !ok {
+			byName[name] = f
+			merged = append(merged, f)
+			continue
+		}
+		m.Zustimmung += f.Zustimmung
+		m.Ablehnung += f.Ablehnung
+		m.Enthaltung += f.Enthaltung
+		m.Votum = joinVotum(m.Votum, f.Votum)
+		m.Votum, m.Geschlossen = votum(m)
+	}
+	return merged
+}
+
+// GetAbstimmung is the Abstimmung parsed with the Top, nil if the page has
+// none.
+func (t *Top) GetAbstimmung() *Abstimmung {
+	return t.abstimmung
+}
+
+type voteCategory int
+
+const (
+	noCategory voteCategory = iota
+	ja
+	nein
+	enthaltung
+)
+
+var categoryWords = []struct {
+	c     voteCategory
+	regex *regexp.Regexp
+}{
+	{enthaltung, regexp.MustCompile(`^(stimm)?enthaltung(en)?$|^enthalten$|^enth\.?$`)},
+	{nein, regexp.MustCompile(`^nein(-stimmen?)?$|^ablehnung(en)?$|^gegenstimmen?$|^dagegen$|^gegen$`)},
+	{ja, regexp.MustCompile(`^ja(-stimmen?)?$|^zustimmung(en)?$|^dafür$|^für$`)},
+}
+
+func category(word string) voteCategory {
+	word = strings.ToLower(strings.Trim(strings.TrimSpace(word), ":.,;"))
+	for _, cw := range categoryWords {
+		if cw.regex.MatchString(word) {
+			return cw.c
+		}
+	}
+	return noCategory
+}
+
+const categoryPattern = `(?:ja(?:-stimmen?)?|nein(?:-stimmen?)?|(?:stimm)?enthaltung(?:en)?|zustimmung(?:en)?|ablehnung(?:en)?|gegenstimmen?|dafür|dagegen)`
+
+// regexCountFirst matches "9 Ja-Stimmen", regexLabelFirst "Zustimmung: 9"
+// and "Zustimmung 9", regexLabelColon only the first.
+var (
+	regexCountFirst = regexp.MustCompile(`(?i)(\d+)\s*` + categoryPattern + `\b`)
+	regexLabelColon = regexp.MustCompile(`(?i)\b(` + categoryPattern + `)\s*[:=]\s*(\d+)`)
+	regexLabelFirst = regexp.MustCompile(`(?i)\b` + categoryPattern + `\s*[:=]?\s*(\d+)`)
+	regexLabel      = regexp.MustCompile(`(?i)^` + categoryPattern + `\s*:\s*(.*)$`)
+	regexFraktion   = regexp.MustCompile(`^([^:]{2,60}):\s*(.+)$`)
+	regexGesamt     = regexp.MustCompile(`(?i)^(gesamt|summe|insgesamt|ergebnis)`)
+	regexNumber     = regexp.MustCompile(`^\d+$`)
+	regexUnknown    = regexp.MustCompile(`(?i)^(k\.?\s*a\.?|keine?|-+|–)?$`)
+)
+
+type counts struct {
+	ja, nein, enthaltung int
+	known                bool
+}
+
+func (c *counts) add(cat voteCategory, n int) {
+	switch cat {
+	case ja:
+		c.ja += n
+	case nein:
+		c.nein += n
+	case enthaltung:
+		c.enthaltung += n
+	default:
+		return
+	}
+	c.known = true
+}
+
+// parseCounts reads the counts of "9 Ja, 2 Nein, 1 Enthaltung" or
+// "Ja: 9 Nein: 2 Enthaltung: 1".
+func parseCounts(text string) counts {
+	var c counts
+	// "Ja: 9 Nein: 2" has a count first in "9 Nein" as well, so the labels
+	// with a colon are read first
+	for _, m := range regexLabelColon.FindAllStringSubmatch(text, -1) {
+		n, _ := strconv.Atoi(m[2])
+		c.add(category(m[1]), n)
+	}
+	if c.known {
+		return c
+	}
+	matches := regexCountFirst.FindAllStringSubmatch(text, -1)
+	for _, m := range matches {
+		n, _ := strconv.Atoi(m[1])
+		c.add(category(strings.TrimSpace(strings.TrimPrefix(m[0], m[1]))), n)
+	}
+	if c.known {
+		return c
+	}
+	for _, m := range regexLabelFirst.FindAllStringSubmatch(text, -1) {
+		n, _ := strconv.Atoi(m[1])
+		c.add(category(strings.Fields(strings.Replace(m[0], ":", " ", 1))[0]), n)
+	}
+	return c
+}
+
+// ParseAbstimmung parses the Abstimmungsergebnis of a Top page: tables of
+// the totals or of the votes per Fraktion, "Zustimmung: 9" paragraphs and
+// prose like "einstimmig", "mehrheitlich bei 2 Enthaltungen" or "dafür: CDU,
+// FDP". The Beschlussart is read for einstimmig, mehrheitlich and abgelehnt
+// too. It returns nil if neither has a result.
+func ParseAbstimmung(sel *goquery.Selection, beschlussart string) *Abstimmung {
+
+	a := &Abstimmung{}
+	var total counts
+	fraktionen := make(map[string]*FraktionsVotum)
+	var order []string
+	fraktion := func(name string) *FraktionsVotum {
+		key := fraktionName(name)
+		f, ok := fraktionen[key]
+		if !ok {
+			f = &FraktionsVotum{Fraktion: domtools.CleanText(name)}
+			fraktionen[key] = f
+			order = append(order, key)
+		}
+		return f
+	}
+
+	var raw []string
+	sel.Each(func(i int, s *goquery.Selection) {
+		h, _ := s.Html()
+		raw = append(raw, textLines(h)...)
+	})
+
+	sel = sel.Clone()
+	sel.Find("table").Each(func(i int, table *goquery.Selection) {
+		parseVoteTable(table, &total, fraktion)
+	})
+	sel.Find("table").Remove()
+
+	var lines []string
+	sel.Each(func(i int, s *goquery.Selection) {
+		h, _ := s.Html()
+		lines = append(lines, textLines(h)...)
+	})
+	for _, line := range lines {
+		parseVoteLine(line, &total, fraktion)
+	}
+
+	prose := strings.ToLower(strings.Join(append(lines, beschlussart), "\n"))
+	if !total.known && len(order) == 0 && !strings.Contains(prose, "einstimmig") &&
+		!strings.Contains(prose, "mehrheitlich") && !strings.Contains(prose, "abgelehnt") {
+		return nil
+	}
+
+	var fs []*FraktionsVotum
+	var sum counts
+	for _, name := range order {
+		f := fraktionen[name]
+		f.Votum, f.Geschlossen = votum(f)
+		if f.Zustimmung+f.Ablehnung+f.Enthaltung > 0 {
+			sum.add(ja, f.Zustimmung)
+			sum.add(nein, f.Ablehnung)
+			sum.add(enthaltung, f.Enthaltung)
+		}
+		fs = append(fs, f)
+	}
+	if !total.known {
+		total = sum
+	}
+
+	a.Zustimmung, a.Ablehnung, a.Enthaltung = total.ja, total.nein, total.enthaltung
+	a.Gezaehlt = total.known && a.Zustimmung+a.Ablehnung > 0
+	a.Fraktionen = fs
+	a.Text = strings.Join(raw, "\n")
+	if a.Text == "" {
+		a.Text = beschlussart
+	}
+
+	einstimmig := strings.Contains(prose, "einstimmig") && !strings.Contains(prose, "nicht einstimmig")
+	a.Einstimmig = einstimmig || (a.Gezaehlt && (a.Zustimmung == 0) != (a.Ablehnung == 0))
+	a.Mehrheitlich = strings.Contains(prose, "mehrheitlich") || (a.Gezaehlt && !a.Einstimmig)
+	a.Geteilt = !a.Einstimmig && (a.Mehrheitlich || a.Ablehnung > 0)
+	if !a.Einstimmig && !a.Gezaehlt {
+		for _, f := range fs {
+			if f.Votum == VotumNein || f.Votum == VotumGeteilt {
+				a.Geteilt = true
+			}
+		}
+	}
+
+	switch {
+	case strings.Contains(prose, "abgelehnt"):
+		a.Ergebnis = Abgelehnt
+	case strings.Contains(prose, "beschlossen") || strings.Contains(prose, "angenommen") || strings.Contains(prose, "zugestimmt"):
+		a.Ergebnis = Angenommen
+	case a.Gezaehlt && a.Zustimmung > a.Ablehnung:
+		a.Ergebnis = Angenommen
+	case a.Gezaehlt:
+		a.Ergebnis = Abgelehnt
+	}
+	return a
+}
+
+// parseVoteTable reads a table of the totals, one "Zustimmung: | 9" row per
+// count, or of the votes per Fraktion with a header row like "Fraktion | Ja |
+// Nein | Enthaltung" and an optional "Gesamt" row.
+func parseVoteTable(table *goquery.Selection, total *counts, fraktion func(string) *FraktionsVotum) {
+
+	rows := table.Find("tr")
+	var columns []voteCategory
+	rows.Each(func(i int, tr *goquery.Selection) {
+		var cells []string
+		tr.Find("td, th").Each(func(j int, td *goquery.Selection) {
+			cells = append(cells, domtools.CleanText(td.Text()))
+		})
+		if len(cells) < 2 {
+			return
+		}
+
+		if columns == nil && len(cells) >= 3 {
+			header := make([]voteCategory, len(cells))
+			found := false
+			for j, c := range cells[1:] {
+				header[j+1] = category(c)
+				found = found || header[j+1] != noCategory
+			}
+			if found {
+				columns = header
+				return
+			}
+		}
+
+		if columns == nil {
+			if cat := category(cells[0]); cat != noCategory {
+				if n, err := strconv.Atoi(cells[1]); err == nil {
+					total.add(cat, n)
+				}
+			}
+			return
+		}
+
+		var c counts
+		for j := 1; j < len(cells) && j < len(columns); j++ {
+			if n, err := strconv.Atoi(cells[j]); err == nil {
+				c.add(columns[j], n)
+			}
+		}
+		if !c.known {
+			return
+		}
+		if regexGesamt.MatchString(cells[0]) {
+			*total = c
+			return
+		}
+		f := fraktion(cells[0])
+		f.Zustimmung += c.ja
+		f.Ablehnung += c.nein
+		f.Enthaltung += c.enthaltung
+	})
+}
+
+// parseVoteLine reads one line of prose: "Zustimmung: 9", "dafür: CDU, FDP",
+// "CDU-Fraktion: 5 Ja, 1 Enthaltung" or "9 Ja-Stimmen, 2 Nein-Stimmen".
+func parseVoteLine(line string, total *counts, fraktion func(string) *FraktionsVotum) {
+
+	if m := regexLabel.FindStringSubmatch(line); m != nil {
+		cat := category(strings.SplitN(line, ":", 2)[0])
+		value := strings.TrimSpace(m[1])
+		if regexNumber.MatchString(value) {
+			n, _ := strconv.Atoi(value)
+			total.add(cat, n)
+			return
+		}
+		if regexUnknown.MatchString(value) {
+			return
+		}
+		if !strings.ContainsAny(value, "0123456789") {
+			for _, name := range splitNames(value) {
+				f := fraktion(name)
+				f.Votum = joinVotum(f.Votum, votumOf[cat])
+			}
+			return
+		}
+	}
+
+	if m := regexFraktion.FindStringSubmatch(line); m != nil && !regexGesamt.MatchString(m[1]) {
+		if c := parseCounts(m[2]); c.known {
+			f := fraktion(m[1])
+			f.Zustimmung += c.ja
+			f.Ablehnung += c.nein
+			f.Enthaltung += c.enthaltung
+			return
+		}
+	}
+
+	if !total.known {
+		*total = parseCounts(line)
+	}
+}
+
+var votumOf = map[voteCategory]string{
+	ja:         VotumJa,
+	nein:       VotumNein,
+	enthaltung: VotumEnthaltung,
+}
+
+var regexNameSeparator = regexp.MustCompile(`\s*(?:,|;|\bund\b|\bsowie\b)\s*`)
+
+func splitNames(value string) []string {
+	var names []string
+	for _, n := range regexNameSeparator.Split(strings.TrimRight(value, "."), -1) {
+		if n = strings.TrimSpace(n); n != "" {
+			names = append(names, n)
+		}
+	}
+	return names
+}
+
+func joinVotum(old string, v string) string {
+	if old == "" || old == v {
+		return v
+	}
+	return VotumGeteilt
+}
+
+// votum is the position of a Fraktion from its counts, geschlossen if all
+// its members voted the same.
+func votum(f *FraktionsVotum) (string, bool) {
+	var positions []string
+	if f.Zustimmung > 0 {
+		positions = append(positions, VotumJa)
+	}
+	if f.Ablehnung > 0 {
+		positions = append(positions, VotumNein)
+	}
+	if f.Enthaltung > 0 {
+		positions = append(positions, VotumEnthaltung)
+	}
+	switch len(positions) {
+	case 0:
+		return f.Votum, f.Votum != VotumGeteilt && f.Votum != ""
+	case 1:
+		return positions[0], true
+	}
+	return VotumGeteilt, false
+}
+
+var (
+	regexLineBreak = regexp.MustCompile(`(?i)<br\s*/?>|</(p|div|li|tr|h[1-6])>`)
+	regexCellEnd   = regexp.MustCompile(`(?i)</t[dh]>`)
+)
+
+// textLines splits html at line breaks and block ends into cleaned lines.
+func textLines(h string) []string {
+	var lines []string
+	for _, l := range strings.Split(regexLineBreak.ReplaceAllString(regexCellEnd.ReplaceAllString(h, " "), "\n"), "\n") {
+		l = domtools.CleanText(html.UnescapeString(sanitize.HTML(l)))
+		if l != "" {
+			lines = append(lines, l)
+		}
+	}
+	return lines
+}
+
+// setTop copies the identity of the Top to the Abstimmung and its votes.
+func (a *Abstimmung) setTop(t *Top) {
+	a.SILFDNR, a.TOLFDNR, a.VOLFDNR = t.SILFDNR, t.TOLFDNR, t.VOLFDNR
+	a.Gremium, a.Datum = t.Gremium, t.Datum
+	for _, f := range a.Fraktionen {
+		f.SILFDNR, f.TOLFDNR, f.Gremium, f.Datum = a.SILFDNR, a.TOLFDNR, a.Gremium, a.Datum
+	}
+}
+
+// saveAbstimmung stores the Abstimmung parsed with t and its votes per
+// Fraktion, replacing the stored ones, or deletes them if t has none.
+func saveAbstimmung(repo Repository, t *Top, changes *changeLog) error {
+
+	a := t.abstimmung
+	key := (&Abstimmung{TOLFDNR: t.TOLFDNR}).GetKey(t.GetKey())
+
+	var old Abstimmung
+	err := repo.Get(key, &old)
+	exists := err == nil
+	if err != nil && err != ErrNoSuchEntity {
+		return errors.Wrap(err, "error getting abstimmung "+key.String()+" from db")
+	}
+	oldKeys, err := repo.GetAll(NewQuery(EntityFraktionsVotum).WithAncestor(key).KeysOnly(), nil)
+	if err != nil {
+		return errors.Wrap(err, "error getting fraktionen of "+key.String()+" from db")
+	}
+
+	if a == nil {
+		if !exists && len(oldKeys) == 0 {
+			return nil
+		}
+		return RunInTransaction(repo, func(tx Transaction) error {
+			err := tx.DeleteMulti(append(oldKeys, key))
+			if err != nil {
+				return errors.Wrap(err, "error deleting abstimmung "+key.String())
+			}
+			changes.deleted(oldKeys...)
+			if exists {
+				changes.deleted(key)
+			}
+			return nil
+		})
+	}
+
+	a.Fraktionen = mergeFraktionen(a.Fraktionen)
+	a.setTop(t)
+	a.SavedAt = time.Now()
+
+	keep := make(map[string]bool)
+	fkeys := make([]*Key, len(a.Fraktionen))
+	for i, f := range a.Fraktionen {
+		fkeys[i] = f.GetKey(key)
+		keep[fkeys[i].Encode()] = true
+	}
+	var stale []*Key
+	for _, k := range oldKeys {
+		if !keep[k.Encode()] {
+			stale = append(stale, k)
+		}
+	}
+
+	return RunInTransaction(repo, func(tx Transaction) error {
+		err := tx.Put(key, a)
+		if err != nil {
+			return errors.Wrap(err, "error saving abstimmung "+key.String())
+		}
+		for i, f := range a.Fraktionen {
+			err = tx.Put(fkeys[i], f)
+			if err != nil {
+				return errors.Wrap(err, "error saving "+fkeys[i].String())
+			}
+		}
+		if len(stale) > 0 {
+			err = tx.DeleteMulti(stale)
+			if err != nil {
+				return errors.Wrap(err, "error deleting fraktionen of "+key.String())
+			}
+		}
+
+		if exists {
+			changes.updated(key, &old, a)
+		} else {
+			changes.created(key)
+		}
+		changes.deleted(stale...)
+		return nil
+	})
+}
+
+// abstimmungKeys are the keys of the Abstimmungen and their votes below
+// ancestor, a Sitzung or a Top.
+func abstimmungKeys(repo Repository, ancestor *Key) ([]*Key, error) {
+	var keys []*Key
+	for _, kind := range []string{EntityFraktionsVotum, EntityAbstimmung} {
+		ks, err := repo.GetAll(NewQuery(kind).WithAncestor(ancestor).KeysOnly(), nil)
+		if err != nil {
+			return nil, errors.Wrap(err, "error getting abstimmungen of "+ancestor.String()+" from db")
+		}
+		keys = append(keys, ks...)
+	}
+	return keys, nil
+}
+
+// AbstimmungFilter restricts ListAbstimmungen and FraktionsBilanzen. Zero
+// values match all.
+type AbstimmungFilter struct {
+	Gremium string
+	From    time.Time
+	To      time.Time
+	// Geteilt only matches the split votes.
+	Geteilt bool
+}
+
+func (f AbstimmungFilter) query(kind string) *Query {
+	q := NewQuery(kind)
+	if f.Gremium != "" {
+		q = q.Filter("Gremium =", f.Gremium)
+	}
+	if !f.From.IsZero() {
+		q = q.Filter("Datum >=", f.From)
+	}
+	if !f.To.IsZero() {
+		q = q.Filter("Datum <", f.To)
+	}
+	return q
+}
+
+// GetAbstimmung returns the Abstimmung of a Top with its Fraktionen, nil if
+// there is none.
+func GetAbstimmung(repo Repository, config allris_common.Config, silfdnr int, tolfdnr int) (*Abstimmung, error) {
+
+	topKey := NameKey(config.GetEntityTop(), strconv.Itoa(tolfdnr), NameKey(config.GetEntitySitzung(), strconv.Itoa(silfdnr), nil))
+	a := &Abstimmung{TOLFDNR: tolfdnr}
+	key := a.GetKey(topKey)
+	err := repo.Get(key, a)
+	if err == ErrNoSuchEntity {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "error getting abstimmung "+key.String()+" from db")
+	}
+	_, err = repo.GetAll(NewQuery(EntityFraktionsVotum).WithAncestor(key), &a.Fraktionen)
+	if err != nil {
+		return nil, errors.Wrap(err, "error getting fraktionen of "+key.String()+" from db")
+	}
+	sort.SliceStable(a.Fraktionen, func(i, j int) bool {
+		return a.Fraktionen[i].Fraktion < a.Fraktionen[j].Fraktion
+	})
+	return a, nil
+}
+
+// ListAbstimmungen returns the Abstimmungen matching f, the latest first.
+// The Fraktionen aren't loaded, see GetAbstimmung.
+func ListAbstimmungen(repo Repository, f AbstimmungFilter) ([]*Abstimmung, error) {
+
+	q := f.query(EntityAbstimmung)
+	if f.Geteilt {
+		q = q.Filter("Geteilt =", true)
+	}
+	var abstimmungen []*Abstimmung
+	_, err := repo.GetAll(q, &abstimmungen)
+	if err != nil {
+		return nil, errors.Wrap(err, "error getting abstimmungen from db")
+	}
+	sort.SliceStable(abstimmungen, func(i, j int) bool {
+		if abstimmungen[i].Datum.Equal(abstimmungen[j].Datum) {
+			return abstimmungen[i].TOLFDNR < abstimmungen[j].TOLFDNR
+		}
+		return abstimmungen[i].Datum.After(abstimmungen[j].Datum)
+	})
+	return abstimmungen, nil
+}
+
+// SplitVotes returns the split votes of a Gremium, the latest first.
+func SplitVotes(repo Repository, gremium string) ([]*Abstimmung, error) {
+	return ListAbstimmungen(repo, AbstimmungFilter{Gremium: gremium, Geteilt: true})
+}
+
+// FraktionsBilanz sums up the votes of a Fraktion. Abstimmungen counts the
+// votes the Fraktion is recorded for, Geschlossen those it voted as one.
+type FraktionsBilanz struct {
+	Fraktion     string
+	Abstimmungen int
+	Ja           int
+	Nein         int
+	Enthaltung   int
+	Geteilt      int
+	Geschlossen  int
+	Zustimmung   int
+	Ablehnung    int
+	Enthaltungen int
+}
+
+// FraktionsBilanzen aggregates the votes per Fraktion matching f, ordered by
+// Fraktion. Ja, Nein, Enthaltung and Geteilt count the Voten, Zustimmung,
+// Ablehnung and Enthaltungen the counted members.
+func FraktionsBilanzen(repo Repository, f AbstimmungFilter) ([]*FraktionsBilanz, error) {
+
+	var voten []*FraktionsVotum
+	_, err := repo.GetAll(f.query(EntityFraktionsVotum), &voten)
+	if err != nil {
+		return nil, errors.Wrap(err, "error getting fraktionen from db")
+	}
+
+	var geteilt map[string]bool
+	if f.Geteilt {
+		abstimmungen, err := ListAbstimmungen(repo, f)
+		if err != nil {
+			return nil, err
+		}
+		geteilt = make(map[string]bool)
+		for _, a := range abstimmungen {
+			geteilt[fmt.Sprintf("%d_%d", a.SILFDNR, a.TOLFDNR)] = true
+		}
+	}
+
+	bilanzen := make(map[string]*FraktionsBilanz)
+	for _, v := range voten {
+		if geteilt != nil && !geteilt[fmt.Sprintf("%d_%d", v.SILFDNR, v.TOLFDNR)] {
+			continue
+		}
+		b, ok := bilanzen[fraktionName(v.Fraktion)]
+		if !ok {
+			b = &FraktionsBilanz{Fraktion: v.Fraktion}
+			bilanzen[fraktionName(v.Fraktion)] = b
+		}
+		b.Abstimmungen++
+		switch v.Votum {
+		case VotumJa:
+			b.Ja++
+		case VotumNein:
+			b.Nein++
+		case VotumEnthaltung:
+			b.Enthaltung++
+		case VotumGeteilt:
+			b.Geteilt++
+		}
+		if v.Geschlossen {
+			b.Geschlossen++
+		}
+		b.Zustimmung += v.Zustimmung
+		b.Ablehnung += v.Ablehnung
+		b.Enthaltungen += v.Enthaltung
+	}
+
+	var result []*FraktionsBilanz
+	for _, b := range bilanzen {
+		result = append(result, b)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Fraktion < result[j].Fraktion
+	})
+	return result, nil
+}
@@ -0,0 +1,290 @@
+package db_test
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/rismaster/allris-db/db"
+	"github.com/rismaster/allris-db/db/memory"
+)
+
+func TestParseCounts(t *testing.T) {
+	for _, c := range []struct {
+		text string
+		want db.Counts
+	}{
+		{"9 Ja-Stimmen, 2 Nein", db.Counts{Ja: 9, Nein: 2, Known: true}},
+		{"9 Ja, 2 Nein, 1 Enthaltung", db.Counts{Ja: 9, Nein: 2, Enthaltung: 1, Known: true}},
+		{"Ja: 9 Nein: 2 Enthaltung: 1", db.Counts{Ja: 9, Nein: 2, Enthaltung: 1, Known: true}},
+		{"Zustimmung: 12", db.Counts{Ja: 12, Known: true}},
+		{"mehrheitlich bei 2 Enthaltungen", db.Counts{Enthaltung: 2, Known: true}},
+		{"3 Gegenstimmen", db.Counts{Nein: 3, Known: true}},
+		{"einstimmig", db.Counts{}},
+		{"TOP 5 vertagt", db.Counts{}},
+	} {
+		if got := db.ParseCounts(c.text); got != c.want {
+			t.Errorf("%q: %+v, want %+v", c.text, got, c.want)
+		}
+	}
+}
+
+func TestParseVoteLine(t *testing.T) {
+	for _, c := range []struct {
+		line       string
+		total      db.Counts
+		fraktionen map[string]string
+	}{
+		{"Zustimmung: 9", db.Counts{Ja: 9, Known: true}, nil},
+		{"9 Ja-Stimmen, 2 Nein-Stimmen", db.Counts{Ja: 9, Nein: 2, Known: true}, nil},
+		{"dafür: CDU, FDP", db.Counts{}, map[string]string{"CDU": db.VotumJa, "FDP": db.VotumJa}},
+		{"dagegen: SPD und Grüne.", db.Counts{}, map[string]string{"SPD": db.VotumNein, "Grüne": db.VotumNein}},
+		{"Enthaltung: keine", db.Counts{}, nil},
+		{"CDU-Fraktion: 5 Ja, 1 Enthaltung", db.Counts{}, map[string]string{"CDU-Fraktion": ""}},
+		{"Gesamt: 9 Ja, 2 Nein", db.Counts{Ja: 9, Nein: 2, Known: true}, nil},
+	} {
+		total, fs := db.ParseVoteLine(c.line)
+		if total != c.total {
+			t.Errorf("%q: total %+v, want %+v", c.line, total, c.total)
+		}
+		got := make(map[string]string)
+		for _, f := range fs {
+			got[f.Fraktion] = f.Votum
+		}
+		if len(got) != len(c.fraktionen) || (len(got) > 0 && !reflect.DeepEqual(got, c.fraktionen)) {
+			t.Errorf("%q: fraktionen %v, want %v", c.line, got, c.fraktionen)
+		}
+	}
+
+	_, fs := db.ParseVoteLine("CDU-Fraktion: 5 Ja, 1 Enthaltung")
+	if len(fs) != 1 || fs[0].Zustimmung != 5 || fs[0].Enthaltung != 1 {
+		t.Errorf("counts of the fraktion: %+v", fs)
+	}
+}
+
+func TestSplitNames(t *testing.T) {
+	for _, c := range []struct {
+		value string
+		want  []string
+	}{
+		{"CDU, FDP", []string{"CDU", "FDP"}},
+		{"SPD und Grüne.", []string{"SPD", "Grüne"}},
+		{"Die Linke; AfD sowie Freie Wähler", []string{"Die Linke", "AfD", "Freie Wähler"}},
+		{"CDU", []string{"CDU"}},
+		{"", nil},
+	} {
+		if got := db.SplitNames(c.value); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%q: %q, want %q", c.value, got, c.want)
+		}
+	}
+}
+
+func TestVotum(t *testing.T) {
+	for _, c := range []struct {
+		f           db.FraktionsVotum
+		votum       string
+		geschlossen bool
+	}{
+		{db.FraktionsVotum{Zustimmung: 5}, db.VotumJa, true},
+		{db.FraktionsVotum{Ablehnung: 2}, db.VotumNein, true},
+		{db.FraktionsVotum{Enthaltung: 1}, db.VotumEnthaltung, true},
+		{db.FraktionsVotum{Zustimmung: 4, Enthaltung: 1}, db.VotumGeteilt, false},
+		// only named in prose
+		{db.FraktionsVotum{Votum: db.VotumJa}, db.VotumJa, true},
+		{db.FraktionsVotum{Votum: db.VotumGeteilt}, db.VotumGeteilt, false},
+		{db.FraktionsVotum{}, "", false},
+	} {
+		f := c.f
+		votum, geschlossen := db.Votum(&f)
+		if votum != c.votum || geschlossen != c.geschlossen {
+			t.Errorf("%+v: %q %v, want %q %v", c.f, votum, geschlossen, c.votum, c.geschlossen)
+		}
+	}
+}
+
+func parseAbstimmung(t *testing.T, html string, beschlussart string) *db.Abstimmung {
+	t.Helper()
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader("<html><body><div id=\"a\">" + html + "</div></body></html>"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db.ParseAbstimmung(doc.Find("#a"), beschlussart)
+}
+
+func TestParseAbstimmung(t *testing.T) {
+	for _, c := range []struct {
+		html, beschlussart                string
+		einstimmig, mehrheitlich, geteilt bool
+		gezaehlt                          bool
+		ergebnis                          string
+	}{
+		{"einstimmig beschlossen", "", true, false, false, false, db.Angenommen},
+		{"", "einstimmig", true, false, false, false, ""},
+		{"mehrheitlich beschlossen", "", false, true, true, false, db.Angenommen},
+		{"9 Ja-Stimmen, 2 Nein", "", false, true, true, true, db.Angenommen},
+		{"9 Ja-Stimmen, 0 Nein, 2 Enthaltungen", "", true, false, false, true, db.Angenommen},
+		{"2 Ja-Stimmen, 9 Nein", "", false, true, true, true, db.Abgelehnt},
+		{"dafür: CDU, FDP<br>dagegen: SPD", "", false, false, true, false, ""},
+		{"dafür: CDU, FDP", "einstimmig", true, false, false, false, ""},
+		{"nicht einstimmig abgelehnt", "", false, false, false, false, db.Abgelehnt},
+	} {
+		a := parseAbstimmung(t, c.html, c.beschlussart)
+		if a == nil {
+			t.Errorf("%q %q: no abstimmung", c.html, c.beschlussart)
+			continue
+		}
+		if a.Einstimmig != c.einstimmig || a.Mehrheitlich != c.mehrheitlich || a.Geteilt != c.geteilt ||
+			a.Gezaehlt != c.gezaehlt || a.Ergebnis != c.ergebnis {
+			t.Errorf("%q %q: einstimmig %v, mehrheitlich %v, geteilt %v, gezaehlt %v, ergebnis %q", c.html, c.beschlussart,
+				a.Einstimmig, a.Mehrheitlich, a.Geteilt, a.Gezaehlt, a.Ergebnis)
+		}
+	}
+
+	a := parseAbstimmung(t, "dafür: CDU, FDP<br>dagegen: cdu", "")
+	if len(a.Fraktionen) != 2 || a.Fraktionen[0].Fraktion != "CDU" || a.Fraktionen[0].Votum != db.VotumGeteilt {
+		t.Errorf("fraktionen differing in case: %+v", a.Fraktionen)
+	}
+
+	if a := parseAbstimmung(t, "Der Antrag wird vertagt.", "Kenntnisnahme"); a != nil {
+		t.Errorf("abstimmung without a result: %+v", a)
+	}
+}
+
+// abstimmungenRepo stores three Abstimmungen, the split ones of 5001 in the
+// Stadtrat and 5003 in the Bauausschuss and 5002 in the Stadtrat.
+func abstimmungenRepo(t *testing.T) db.Repository {
+
+	repo := memory.New()
+	day := func(m time.Month, d int) time.Time {
+		return time.Date(2024, m, d, 18, 0, 0, 0, time.UTC)
+	}
+	for _, a := range []*db.Abstimmung{
+		{SILFDNR: 1001, TOLFDNR: 5001, Gremium: "Stadtrat", Datum: day(3, 1), Geteilt: true, Fraktionen: []*db.FraktionsVotum{
+			{Fraktion: "CDU", Zustimmung: 5, Votum: db.VotumJa, Geschlossen: true},
+			{Fraktion: "SPD", Ablehnung: 3, Votum: db.VotumNein, Geschlossen: true},
+		}},
+		{SILFDNR: 1002, TOLFDNR: 5002, Gremium: "Stadtrat", Datum: day(4, 1), Einstimmig: true, Fraktionen: []*db.FraktionsVotum{
+			{Fraktion: "CDU", Votum: db.VotumJa, Geschlossen: true},
+			{Fraktion: "SPD", Votum: db.VotumJa, Geschlossen: true},
+		}},
+		{SILFDNR: 1003, TOLFDNR: 5003, Gremium: "Bauausschuss", Datum: day(3, 15), Geteilt: true, Fraktionen: []*db.FraktionsVotum{
+			{Fraktion: "CDU", Zustimmung: 2, Ablehnung: 1, Votum: db.VotumGeteilt},
+		}},
+	} {
+		topKey := db.NameKey("Top", strconv.Itoa(a.TOLFDNR), db.NameKey("Sitzung", strconv.Itoa(a.SILFDNR), nil))
+		key := a.GetKey(topKey)
+		err := repo.Put(key, a)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, f := range a.Fraktionen {
+			f.SILFDNR, f.TOLFDNR, f.Gremium, f.Datum = a.SILFDNR, a.TOLFDNR, a.Gremium, a.Datum
+			err = repo.Put(f.GetKey(key), f)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	return repo
+}
+
+func tolfdnrs(abstimmungen []*db.Abstimmung) []int {
+	ids := []int{}
+	for _, a := range abstimmungen {
+		ids = append(ids, a.TOLFDNR)
+	}
+	return ids
+}
+
+func TestListAbstimmungen(t *testing.T) {
+
+	repo := abstimmungenRepo(t)
+	for _, c := range []struct {
+		name string
+		f    db.AbstimmungFilter
+		want []int
+	}{
+		{"all, the latest first", db.AbstimmungFilter{}, []int{5002, 5003, 5001}},
+		{"gremium", db.AbstimmungFilter{Gremium: "Stadtrat"}, []int{5002, 5001}},
+		{"range", db.AbstimmungFilter{From: time.Date(2024, 3, 10, 0, 0, 0, 0, time.UTC), To: time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC)}, []int{5003}},
+		{"geteilt", db.AbstimmungFilter{Geteilt: true}, []int{5003, 5001}},
+	} {
+		abstimmungen, err := db.ListAbstimmungen(repo, c.f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := tolfdnrs(abstimmungen); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: %v, want %v", c.name, got, c.want)
+		}
+	}
+
+	split, err := db.SplitVotes(repo, "Stadtrat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := tolfdnrs(split); !reflect.DeepEqual(got, []int{5001}) {
+		t.Errorf("split votes of the Stadtrat: %v", got)
+	}
+}
+
+func TestFraktionsBilanzen(t *testing.T) {
+
+	repo := abstimmungenRepo(t)
+	bilanzen, err := db.FraktionsBilanzen(repo, db.AbstimmungFilter{Gremium: "Stadtrat"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []db.FraktionsBilanz{
+		{Fraktion: "CDU", Abstimmungen: 2, Ja: 2, Geschlossen: 2, Zustimmung: 5},
+		{Fraktion: "SPD", Abstimmungen: 2, Ja: 1, Nein: 1, Geschlossen: 2, Ablehnung: 3},
+	}
+	if len(bilanzen) != len(want) {
+		t.Fatalf("%d bilanzen, want %d", len(bilanzen), len(want))
+	}
+	for i := range want {
+		if *bilanzen[i] != want[i] {
+			t.Errorf("%+v, want %+v", *bilanzen[i], want[i])
+		}
+	}
+
+	bilanzen, err = db.FraktionsBilanzen(repo, db.AbstimmungFilter{Geteilt: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = []db.FraktionsBilanz{
+		{Fraktion: "CDU", Abstimmungen: 2, Ja: 1, Geteilt: 1, Geschlossen: 1, Zustimmung: 7, Ablehnung: 1},
+		{Fraktion: "SPD", Abstimmungen: 1, Nein: 1, Geschlossen: 1, Ablehnung: 3},
+	}
+	if len(bilanzen) != len(want) {
+		t.Fatalf("%d bilanzen of split votes, want %d", len(bilanzen), len(want))
+	}
+	for i := range want {
+		if *bilanzen[i] != want[i] {
+			t.Errorf("split votes: %+v, want %+v", *bilanzen[i], want[i])
+		}
+	}
+}
+
+func TestMergeFraktionen(t *testing.T) {
+
+	fs := db.MergeFraktionen([]*db.FraktionsVotum{
+		{Fraktion: "CDU-Fraktion", Zustimmung: 3},
+		{Fraktion: "SPD", Votum: db.VotumNein},
+		{Fraktion: "cdu fraktion", Ablehnung: 1},
+		{Fraktion: " "},
+	})
+	if len(fs) != 2 {
+		t.Fatalf("%d fraktionen, want 2: %+v", len(fs), fs)
+	}
+	cdu := fs[0]
+	if cdu.Fraktion != "CDU-Fraktion" || cdu.Zustimmung != 3 || cdu.Ablehnung != 1 || cdu.Votum != db.VotumGeteilt || cdu.Geschlossen {
+		t.Errorf("merged %+v", cdu)
+	}
+	abstimmungKey := db.NameKey(db.EntityAbstimmung, "5002", nil)
+	if cdu.GetKey(abstimmungKey).Name != (&db.FraktionsVotum{Fraktion: "cdu fraktion"}).GetKey(abstimmungKey).Name {
+		t.Error("keys differ in case")
+	}
+}
@@ -1,7 +1,6 @@
 package db
 
 import (
-	"cloud.google.com/go/datastore"
 	"fmt"
 	"github.com/PuerkitoBio/goquery"
 	"github.com/kennygrant/sanitize"
@@ -26,16 +25,24 @@ type Anlage struct {
 
 	SavedAt time.Time
 
+	// The content of Filename, see UpdateAnlageContent. Anlagen with the same
+	// SHA256 share one AnlageContent.
+	Size             int64
+	MimeType         string
+	SHA256           string
+	FileGeneration   string `datastore:",noindex"`
+	ContentChangedAt time.Time
+
 	parent TopHolder
-	Config allris_common.Config
+	Config allris_common.Config `datastore:"-" json:"-"`
 }
 
 var RegexTopAnlage = regexp.MustCompile(`sitzung-([0-9]+)-top-([0-9]+)-anlage-(.+)`)
 var RegexAnlagen = regexp.MustCompile(`(vorlage|sitzung)-([0-9]+)-(basisanlage|anlage)-(([0-9]+)-(.+))`)
 
-func (a *Anlage) GetKey(parentKey *datastore.Key) *datastore.Key {
+func (a *Anlage) GetKey(parentKey *Key) *Key {
 	kn := fmt.Sprintf("%d_%d_%d_%d_%s", a.DOLFDNR, a.SILFDNR, a.TOLFDNR, a.VOLFDNR, a.Title)
-	return datastore.NameKey(a.Config.GetEntityAnlage(), sanitize.Name(kn), parentKey)
+	return NameKey(a.Config.GetEntityAnlage(), sanitize.Name(kn), parentKey)
 }
 
 func NewAnlage(app *application.AppContext, file *files.File) (*Anlage, error) {
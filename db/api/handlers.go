@@ -0,0 +1,254 @@
+package api
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rismaster/allris-db/db"
+)
+
+// VorlageResponse is a Vorlage with its Beratungsfolge.
+type VorlageResponse struct {
+	*db.Vorlage
+	Beratungsfolge []*db.Top
+}
+
+func (s *Server) sitzungKey(silfdnr int) *db.Key {
+	return db.NameKey(s.Config.GetEntitySitzung(), strconv.Itoa(silfdnr), nil)
+}
+
+func (s *Server) topKey(silfdnr int, tolfdnr int) *db.Key {
+	return db.NameKey(s.Config.GetEntityTop(), strconv.Itoa(tolfdnr), s.sitzungKey(silfdnr))
+}
+
+func (s *Server) vorlageKey(volfdnr int) *db.Key {
+	return db.NameKey(s.Config.GetEntityVorlage(), strconv.Itoa(volfdnr), nil)
+}
+
+// get loads one entity and maps ErrNoSuchEntity to 404.
+func (s *Server) get(key *db.Key, dst interface{}) error {
+	err := s.Repo.Get(key, dst)
+	if err == db.ErrNoSuchEntity {
+		return notFound("%s %s not found", key.Kind, key.Name)
+	}
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("error getting %s %s from db", key.Kind, key.Name))
+	}
+	return nil
+}
+
+// location is the timezone of the config, the days of the query parameters
+// are in it.
+func (s *Server) location() *time.Location {
+	loc, err := time.LoadLocation(s.Config.GetTimezone())
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
+// parseDay parses a date parameter as the start of the day in the timezone of
+// the config.
+func (s *Server) parseDay(values url.Values, name string) (time.Time, error) {
+	v := values.Get(name)
+	if v == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.ParseInLocation("2006-01-02", v, s.location())
+	if err != nil {
+		return time.Time{}, badRequest("invalid %s %q, expected YYYY-MM-DD", name, v)
+	}
+	return t, nil
+}
+
+// listSitzungen filters by the date range [from, to] and the Gremium and
+// sorts by Datum. Only the requested page is loaded.
+func (s *Server) listSitzungen(values url.Values) (interface{}, error) {
+
+	from, err := s.parseDay(values, "from")
+	if err != nil {
+		return nil, err
+	}
+	to, err := s.parseDay(values, "to")
+	if err != nil {
+		return nil, err
+	}
+
+	q := db.NewQuery(s.Config.GetEntitySitzung())
+	if !from.IsZero() {
+		q = q.Filter("Datum >=", from)
+	}
+	if !to.IsZero() {
+		q = q.Filter("Datum <", to.AddDate(0, 0, 1))
+	}
+	if gremium := values.Get("gremium"); gremium != "" {
+		q = q.Filter("Gremium =", gremium)
+	}
+
+	var sitzungen []*db.Sitzung
+	return s.queryPage(values, q.Order("Datum").Order("SILFDNR"), &sitzungen)
+}
+
+func (s *Server) getSitzung(silfdnr int) (interface{}, error) {
+	var sitzung db.Sitzung
+	err := s.get(s.sitzungKey(silfdnr), &sitzung)
+	if err != nil {
+		return nil, err
+	}
+	return &sitzung, nil
+}
+
+// listTops returns the Tops of a Sitzung in IndexTop order.
+func (s *Server) listTops(values url.Values, silfdnr int) (interface{}, error) {
+
+	_, err := s.getSitzung(silfdnr)
+	if err != nil {
+		return nil, err
+	}
+
+	var tops []*db.Top
+	_, err = s.Repo.GetAll(db.NewQuery(s.Config.GetEntityTop()).WithAncestor(s.sitzungKey(silfdnr)), &tops)
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("error getting tops of sitzung %d from db", silfdnr))
+	}
+	sort.SliceStable(tops, func(i, j int) bool {
+		return tops[i].IndexTop < tops[j].IndexTop
+	})
+
+	return s.paginate(values, tops)
+}
+
+func (s *Server) getTop(silfdnr int, tolfdnr int) (interface{}, error) {
+	var top db.Top
+	err := s.get(s.topKey(silfdnr, tolfdnr), &top)
+	if err != nil {
+		return nil, err
+	}
+	return &top, nil
+}
+
+// listVorlagen sorts the Vorlagen by DatumAngelegt, the newest first. Only the
+// requested page is loaded.
+func (s *Server) listVorlagen(values url.Values) (interface{}, error) {
+
+	var vorlagen []*db.Vorlage
+	q := db.NewQuery(s.Config.GetEntityVorlage()).Order("-DatumAngelegt").Order("-VOLFDNR")
+	return s.queryPage(values, q, &vorlagen)
+}
+
+// getVorlage returns the Vorlage with its Beratungsfolge sorted by IndexBeratung.
+func (s *Server) getVorlage(volfdnr int) (interface{}, error) {
+
+	var vorlage db.Vorlage
+	err := s.get(s.vorlageKey(volfdnr), &vorlage)
+	if err != nil {
+		return nil, err
+	}
+
+	var tops []*db.Top
+	_, err = s.Repo.GetAll(db.NewQuery(s.Config.GetEntityTop()).Filter("VOLFDNR =", volfdnr), &tops)
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("error getting beratungsfolge of vorlage %d from db", volfdnr))
+	}
+	sort.SliceStable(tops, func(i, j int) bool {
+		return tops[i].IndexBeratung < tops[j].IndexBeratung
+	})
+	if tops == nil {
+		tops = []*db.Top{}
+	}
+	return &VorlageResponse{Vorlage: &vorlage, Beratungsfolge: tops}, nil
+}
+
+// listLineage lists the Ancestors or Descendants of a Vorlage in the cached
+// Lineage. Bezueglich is left out, the list already is the chain of
+// references.
+func (s *Server) listLineage(values url.Values, volfdnr int, related func(*db.Lineage, int) []*db.Vorlage) (interface{}, error) {
+
+	err := s.get(s.vorlageKey(volfdnr), &db.Vorlage{})
+	if err != nil {
+		return nil, err
+	}
+	lineage, err := s.cachedLineage()
+	if err != nil {
+		return nil, err
+	}
+	vorlagen := []*db.Vorlage{}
+	for _, v := range related(lineage, volfdnr) {
+		flat := *v
+		flat.Bezueglich = nil
+		vorlagen = append(vorlagen, &flat)
+	}
+	return s.paginate(values, vorlagen)
+}
+
+// listAnlagen returns the Anlagen stored directly under parent, e.g. not the
+// Anlagen of the Tops for a Sitzung.
+func (s *Server) listAnlagen(values url.Values, parent *db.Key) (interface{}, error) {
+
+	err := s.get(parent, parentEntity(s, parent))
+	if err != nil {
+		return nil, err
+	}
+
+	var all []*db.Anlage
+	keys, err := s.Repo.GetAll(db.NewQuery(s.Config.GetEntityAnlage()).WithAncestor(parent), &all)
+	if err != nil {
+		return nil, errors.Wrap(err, "error getting anlagen of "+parent.Encode()+" from db")
+	}
+	var anlagen []*db.Anlage
+	for i, a := range all {
+		if keys[i].Parent != nil && keys[i].Parent.Encode() == parent.Encode() {
+			anlagen = append(anlagen, a)
+		}
+	}
+	sort.SliceStable(anlagen, func(i, j int) bool {
+		if anlagen[i].DOLFDNR == anlagen[j].DOLFDNR {
+			return anlagen[i].Title < anlagen[j].Title
+		}
+		return anlagen[i].DOLFDNR < anlagen[j].DOLFDNR
+	})
+
+	return s.paginate(values, anlagen)
+}
+
+// parentEntity is the destination to check that the parent of Anlagen exists.
+func parentEntity(s *Server, parent *db.Key) interface{} {
+	switch parent.Kind {
+	case s.Config.GetEntityTop():
+		return &db.Top{}
+	case s.Config.GetEntityVorlage():
+		return &db.Vorlage{}
+	}
+	return &db.Sitzung{}
+}
+
+// listMovedTermine returns the moves of the meetings planned before or after
+// the move in the date range [from, to], by default the next 30 days.
+func (s *Server) listMovedTermine(values url.Values) (interface{}, error) {
+
+	from, err := s.parseDay(values, "from")
+	if err != nil {
+		return nil, err
+	}
+	to, err := s.parseDay(values, "to")
+	if err != nil {
+		return nil, err
+	}
+	if from.IsZero() {
+		now := time.Now().In(s.location())
+		from = time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	}
+	if to.IsZero() {
+		to = from.AddDate(0, 0, 30)
+	}
+
+	moves, err := db.MovedTermine(s.Repo, from, to.AddDate(0, 0, 1))
+	if err != nil {
+		return nil, err
+	}
+	return s.paginate(values, moves)
+}
@@ -0,0 +1,363 @@
+// Package api serves the stored entities read-only as JSON over HTTP.
+//
+//	GET /sitzungen?from=2024-01-01&to=2024-12-31&gremium=Stadtrat
+//	GET /sitzungen/{SILFDNR}
+//	GET /sitzungen/{SILFDNR}/tops
+//	GET /sitzungen/{SILFDNR}/tops/{TOLFDNR}
+//	GET /sitzungen/{SILFDNR}/anlagen
+//	GET /sitzungen/{SILFDNR}/tops/{TOLFDNR}/anlagen
+//	GET /vorlagen
+//	GET /vorlagen/{VOLFDNR}
+//	GET /vorlagen/{VOLFDNR}/anlagen
+//	GET /vorlagen/{VOLFDNR}/ancestors
+//	GET /vorlagen/{VOLFDNR}/descendants
+//	GET /termine/moved?from=2024-01-01&to=2024-12-31
+//
+// Lists take the parameters page (starting at 1) and per_page. Every response
+// has an ETag and a matching If-None-Match is answered with 304.
+package api
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	allris_common "github.com/rismaster/allris-common"
+	"github.com/rismaster/allris-common/common/slog"
+	"github.com/rismaster/allris-db/db"
+)
+
+const (
+	DefaultPageSize = 50
+	MaxPageSize     = 500
+	// DefaultLineageTTL is how long the Lineage is cached without a Vorlage
+	// event, for a Server not receiving the events of the sync.
+	DefaultLineageTTL = 5 * time.Minute
+)
+
+// Server is an http.Handler over a Repository. It is an db.EventSink as well,
+// a Vorlage event drops the cached Lineage.
+type Server struct {
+	Repo     db.Repository
+	Config   allris_common.Config
+	PageSize int
+	// LineageTTL bounds the age of the cached Lineage, 0 keeps it until a
+	// Vorlage event.
+	LineageTTL time.Duration
+
+	mu             sync.Mutex
+	lineage        *db.Lineage
+	lineageBuilt   time.Time
+	lineageVersion int
+}
+
+func NewServer(repo db.Repository, config allris_common.Config) *Server {
+	return &Server{Repo: repo, Config: config, PageSize: DefaultPageSize, LineageTTL: DefaultLineageTTL}
+}
+
+// Publish drops the cached Lineage on a change of a Vorlage.
+func (s *Server) Publish(event db.Event) error {
+	if event.Kind == s.Config.GetEntityVorlage() {
+		s.mu.Lock()
+		s.lineage = nil
+		s.lineageVersion++
+		s.mu.Unlock()
+	}
+	return nil
+}
+
+// cachedLineage returns the cached Lineage, built again if it was dropped or
+// is older than LineageTTL. A Lineage built while a Vorlage changed isn't
+// cached.
+func (s *Server) cachedLineage() (*db.Lineage, error) {
+
+	s.mu.Lock()
+	if s.lineage != nil && (s.LineageTTL <= 0 || time.Since(s.lineageBuilt) < s.LineageTTL) {
+		l := s.lineage
+		s.mu.Unlock()
+		return l, nil
+	}
+	version := s.lineageVersion
+	s.mu.Unlock()
+
+	built := time.Now()
+	l, err := db.BuildLineage(s.Repo, s.Config)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	if version == s.lineageVersion {
+		s.lineage, s.lineageBuilt = l, built
+	}
+	s.mu.Unlock()
+	return l, nil
+}
+
+// Page is the envelope of the list responses.
+type Page struct {
+	Data    interface{} `json:"data"`
+	Page    int         `json:"page"`
+	PerPage int         `json:"perPage"`
+	Total   int         `json:"total"`
+}
+
+// httpError is an error with the status code of its response.
+type httpError struct {
+	status int
+	msg    string
+}
+
+func (e *httpError) Error() string {
+	return e.msg
+}
+
+func badRequest(format string, args ...interface{}) error {
+	return &httpError{status: http.StatusBadRequest, msg: fmt.Sprintf(format, args...)}
+}
+
+func notFound(format string, args ...interface{}) error {
+	return &httpError{status: http.StatusNotFound, msg: fmt.Sprintf(format, args...)}
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		s.writeError(w, &httpError{status: http.StatusMethodNotAllowed, msg: "method not allowed"})
+		return
+	}
+
+	result, err := s.route(r)
+	if err != nil {
+		s.writeError(w, err)
+		return
+	}
+	s.write(w, r, result)
+}
+
+// route dispatches on the path segments.
+func (s *Server) route(r *http.Request) (interface{}, error) {
+
+	path := strings.Trim(r.URL.Path, "/")
+	if path == "termine/moved" {
+		return s.listMovedTermine(r.URL.Query())
+	}
+
+	parts := strings.Split(path, "/")
+	ids := make([]int, len(parts))
+	for i := 1; i < len(parts); i += 2 {
+		id, err := strconv.Atoi(parts[i])
+		if err != nil || id <= 0 {
+			return nil, notFound("invalid id %q", parts[i])
+		}
+		ids[i] = id
+	}
+
+	switch pattern(parts) {
+	case "sitzungen":
+		return s.listSitzungen(r.URL.Query())
+	case "sitzungen/*":
+		return s.getSitzung(ids[1])
+	case "sitzungen/*/tops":
+		return s.listTops(r.URL.Query(), ids[1])
+	case "sitzungen/*/tops/*":
+		return s.getTop(ids[1], ids[3])
+	case "sitzungen/*/anlagen":
+		return s.listAnlagen(r.URL.Query(), s.sitzungKey(ids[1]))
+	case "sitzungen/*/tops/*/anlagen":
+		return s.listAnlagen(r.URL.Query(), s.topKey(ids[1], ids[3]))
+	case "vorlagen":
+		return s.listVorlagen(r.URL.Query())
+	case "vorlagen/*":
+		return s.getVorlage(ids[1])
+	case "vorlagen/*/anlagen":
+		return s.listAnlagen(r.URL.Query(), s.vorlageKey(ids[1]))
+	case "vorlagen/*/ancestors":
+		return s.listLineage(r.URL.Query(), ids[1], (*db.Lineage).Ancestors)
+	case "vorlagen/*/descendants":
+		return s.listLineage(r.URL.Query(), ids[1], (*db.Lineage).Descendants)
+	}
+	return nil, notFound("no such resource %s", r.URL.Path)
+}
+
+// pattern replaces every second path segment (the ids) with "*".
+func pattern(parts []string) string {
+	p := make([]string, len(parts))
+	for i, part := range parts {
+		if i%2 == 1 {
+			p[i] = "*"
+		} else {
+			p[i] = part
+		}
+	}
+	return strings.Join(p, "/")
+}
+
+// newPage reads the page and per_page parameters.
+func (s *Server) newPage(values url.Values) (*Page, error) {
+
+	page := &Page{Page: 1, PerPage: s.PageSize}
+	if page.PerPage <= 0 {
+		page.PerPage = DefaultPageSize
+	}
+	var err error
+	if p := values.Get("page"); p != "" {
+		page.Page, err = strconv.Atoi(p)
+		if err != nil || page.Page < 1 {
+			return nil, badRequest("invalid page %q", p)
+		}
+	}
+	if p := values.Get("per_page"); p != "" {
+		page.PerPage, err = strconv.Atoi(p)
+		if err != nil || page.PerPage < 1 || page.PerPage > MaxPageSize {
+			return nil, badRequest("invalid per_page %q, must be between 1 and %d", p, MaxPageSize)
+		}
+	}
+	return page, nil
+}
+
+// paginate cuts the requested page out of all, a slice of entities.
+func (s *Server) paginate(values url.Values, all interface{}) (*Page, error) {
+
+	page, err := s.newPage(values)
+	if err != nil {
+		return nil, err
+	}
+	v := reflect.ValueOf(all)
+	page.Total = v.Len()
+	from := (page.Page - 1) * page.PerPage
+	if from > page.Total {
+		from = page.Total
+	}
+	to := from + page.PerPage
+	if to > page.Total {
+		to = page.Total
+	}
+	data := reflect.MakeSlice(v.Type(), 0, to-from)
+	page.Data = reflect.AppendSlice(data, v.Slice(from, to)).Interface()
+	return page, nil
+}
+
+// queryPage loads the requested page of the query result into dst, a pointer
+// to a slice of entities. The total is counted with a keys-only query.
+func (s *Server) queryPage(values url.Values, q *db.Query, dst interface{}) (*Page, error) {
+
+	page, err := s.newPage(values)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := s.Repo.GetAll(q.KeysOnly(), nil)
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("error counting %s in db", q.Kind))
+	}
+	page.Total = len(keys)
+
+	_, err = s.Repo.GetAll(q.Offset((page.Page-1)*page.PerPage).Limit(page.PerPage), dst)
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("error getting %s from db", q.Kind))
+	}
+	data := reflect.ValueOf(dst).Elem()
+	if data.IsNil() {
+		data = reflect.MakeSlice(data.Type(), 0, 0)
+	}
+	page.Data = data.Interface()
+	return page, nil
+}
+
+func (s *Server) write(w http.ResponseWriter, r *http.Request, result interface{}) {
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	err := enc.Encode(result)
+	if err != nil {
+		s.writeError(w, errors.Wrap(err, "error encoding response"))
+		return
+	}
+
+	sum := sha256.Sum256(buf.Bytes())
+	etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+	w.Header().Set("ETag", etag)
+	if page, ok := result.(*Page); ok {
+		setLinks(w, r, page)
+	}
+	if matchesETag(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+	if r.Method == http.MethodHead {
+		return
+	}
+	_, err = w.Write(buf.Bytes())
+	if err != nil {
+		slog.Error("error writing response to %s: %v", r.URL, err)
+	}
+}
+
+// matchesETag checks an If-None-Match header, weak tags match as well.
+func matchesETag(header string, etag string) bool {
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == "*" || tag == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// setLinks sets the Link header with the first, prev, next and last page.
+func setLinks(w http.ResponseWriter, r *http.Request, page *Page) {
+
+	last := (page.Total + page.PerPage - 1) / page.PerPage
+	if last < 1 {
+		last = 1
+	}
+	link := func(p int, rel string) string {
+		u := *r.URL
+		q := u.Query()
+		q.Set("page", strconv.Itoa(p))
+		q.Set("per_page", strconv.Itoa(page.PerPage))
+		u.RawQuery = q.Encode()
+		return fmt.Sprintf(`<%s>; rel="%s"`, u.RequestURI(), rel)
+	}
+
+	links := []string{link(1, "first")}
+	if page.Page > 1 && page.Page <= last {
+		links = append(links, link(page.Page-1, "prev"))
+	}
+	if page.Page < last {
+		links = append(links, link(page.Page+1, "next"))
+	}
+	links = append(links, link(last, "last"))
+	w.Header().Set("Link", strings.Join(links, ", "))
+}
+
+func (s *Server) writeError(w http.ResponseWriter, err error) {
+
+	status := http.StatusInternalServerError
+	msg := "internal error"
+	var herr *httpError
+	if errors.As(err, &herr) {
+		status = herr.status
+		msg = herr.msg
+	} else {
+		slog.Error("error serving request: %+v", err)
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]string{"error": msg})
+}
@@ -0,0 +1,179 @@
+package api_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rismaster/allris-db/db"
+	"github.com/rismaster/allris-db/db/api"
+	"github.com/rismaster/allris-db/db/golden"
+	"github.com/rismaster/allris-db/db/memory"
+)
+
+// newTestServer serves five Sitzungen of the Stadtrat on consecutive days,
+// the Tops of the first in reverse key order and the Vorlage 2001 with a
+// Beratungsfolge in reverse key order.
+func newTestServer(t *testing.T) *httptest.Server {
+
+	repo := memory.New()
+	put := func(key *db.Key, src interface{}) {
+		err := repo.Put(key, src)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	day := time.Date(2024, 3, 1, 17, 0, 0, 0, time.UTC)
+	for i := 1; i <= 5; i++ {
+		put(db.NameKey("Sitzung", strconv.Itoa(1000+i), nil), &db.Sitzung{
+			SILFDNR: 1000 + i,
+			Datum:   day.AddDate(0, 0, i),
+			Gremium: "Stadtrat",
+		})
+	}
+	sitzungKey := db.NameKey("Sitzung", "1001", nil)
+	for i, tolfdnr := range []int{5003, 5002, 5001} {
+		put(db.NameKey("Top", strconv.Itoa(tolfdnr), sitzungKey), &db.Top{
+			SILFDNR:  1001,
+			TOLFDNR:  tolfdnr,
+			IndexTop: i + 1,
+		})
+	}
+	put(db.NameKey("Vorlage", "2001", nil), &db.Vorlage{VOLFDNR: 2001})
+	for i, silfdnr := range []int{1003, 1002} {
+		key := db.NameKey("Sitzung", strconv.Itoa(silfdnr), nil)
+		put(db.NameKey("Top", strconv.Itoa(6000+silfdnr), key), &db.Top{
+			SILFDNR:       silfdnr,
+			TOLFDNR:       6000 + silfdnr,
+			VOLFDNR:       2001,
+			IndexBeratung: i + 1,
+		})
+	}
+
+	srv := httptest.NewServer(api.NewServer(repo, golden.FixtureConfig{}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func get(t *testing.T, url string, header http.Header, dst interface{}) *http.Response {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if dst != nil && resp.StatusCode == http.StatusOK {
+		err = json.NewDecoder(resp.Body).Decode(dst)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return resp
+}
+
+func TestListSitzungenPage(t *testing.T) {
+
+	srv := newTestServer(t)
+	var page struct {
+		Data    []*db.Sitzung
+		Page    int
+		PerPage int
+		Total   int
+	}
+	resp := get(t, srv.URL+"/sitzungen?page=2&per_page=2&gremium=Stadtrat", nil, &page)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status %d", resp.StatusCode)
+	}
+	if page.Total != 5 || page.Page != 2 || page.PerPage != 2 {
+		t.Errorf("page %d/%d of %d", page.Page, page.PerPage, page.Total)
+	}
+	if len(page.Data) != 2 || page.Data[0].SILFDNR != 1003 || page.Data[1].SILFDNR != 1004 {
+		t.Errorf("data %+v", page.Data)
+	}
+
+	link := resp.Header.Get("Link")
+	for _, want := range []string{
+		`page=1&per_page=2>; rel="first"`,
+		`page=1&per_page=2>; rel="prev"`,
+		`page=3&per_page=2>; rel="next"`,
+		`page=3&per_page=2>; rel="last"`,
+		"gremium=Stadtrat",
+	} {
+		if !strings.Contains(link, want) {
+			t.Errorf("link %q without %q", link, want)
+		}
+	}
+
+	resp = get(t, srv.URL+"/sitzungen?per_page=0", nil, nil)
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("per_page=0: status %d", resp.StatusCode)
+	}
+}
+
+func TestETag(t *testing.T) {
+
+	srv := newTestServer(t)
+	resp := get(t, srv.URL+"/sitzungen/1001", nil, nil)
+	etag := resp.Header.Get("ETag")
+	if resp.StatusCode != http.StatusOK || etag == "" {
+		t.Fatalf("status %d, etag %q", resp.StatusCode, etag)
+	}
+
+	resp = get(t, srv.URL+"/sitzungen/1001", http.Header{"If-None-Match": {`"other", W/` + etag}}, nil)
+	if resp.StatusCode != http.StatusNotModified {
+		t.Errorf("matching etag: status %d", resp.StatusCode)
+	}
+	resp = get(t, srv.URL+"/sitzungen/1002", http.Header{"If-None-Match": {etag}}, nil)
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("etag of another sitzung: status %d", resp.StatusCode)
+	}
+}
+
+func TestNotFound(t *testing.T) {
+
+	srv := newTestServer(t)
+	for _, path := range []string{"/sitzungen/999", "/sitzungen/999/tops", "/sitzungen/abc", "/unknown"} {
+		resp := get(t, srv.URL+path, nil, nil)
+		if resp.StatusCode != http.StatusNotFound {
+			t.Errorf("%s: status %d", path, resp.StatusCode)
+		}
+	}
+}
+
+func TestOrder(t *testing.T) {
+
+	srv := newTestServer(t)
+	var page struct {
+		Data []*db.Top
+	}
+	get(t, srv.URL+"/sitzungen/1001/tops", nil, &page)
+	var tops []int
+	for _, top := range page.Data {
+		tops = append(tops, top.TOLFDNR)
+	}
+	if len(tops) != 3 || tops[0] != 5003 || tops[1] != 5002 || tops[2] != 5001 {
+		t.Errorf("tops not in IndexTop order: %v", tops)
+	}
+
+	var vorlage api.VorlageResponse
+	get(t, srv.URL+"/vorlagen/2001", nil, &vorlage)
+	var beratungen []int
+	for _, top := range vorlage.Beratungsfolge {
+		beratungen = append(beratungen, top.SILFDNR)
+	}
+	if len(beratungen) != 2 || beratungen[0] != 1003 || beratungen[1] != 1002 {
+		t.Errorf("beratungsfolge not in IndexBeratung order: %v", beratungen)
+	}
+}
@@ -0,0 +1,156 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rismaster/allris-common/common/slog"
+)
+
+// checkpointEvery is the number of files after which Backfill saves its
+// Checkpoint, it is saved at the end as well.
+const checkpointEvery = 100
+
+// Checkpoint records the files a Backfill has done, so an interrupted
+// Backfill resumes with the others. Failed files aren't done and are retried.
+type Checkpoint struct {
+	Started time.Time
+	Saved   time.Time
+	Done    map[string]bool
+
+	path string
+	mu   sync.Mutex
+}
+
+// LoadCheckpoint reads the Checkpoint saved at path, a new one if there is
+// none.
+func LoadCheckpoint(path string) (*Checkpoint, error) {
+
+	c := &Checkpoint{path: path}
+	content, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		c.Started = time.Now()
+		c.Done = make(map[string]bool)
+		return c, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "error reading checkpoint "+path)
+	}
+	err = json.Unmarshal(content, c)
+	if err != nil {
+		return nil, errors.Wrap(err, "error parsing checkpoint "+path)
+	}
+	if c.Done == nil {
+		c.Done = make(map[string]bool)
+	}
+	return c, nil
+}
+
+func (c *Checkpoint) isDone(file string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.Done[file]
+}
+
+// done marks a file as done and reports if the Checkpoint is due to be saved.
+func (c *Checkpoint) done(file string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.Done[file] = true
+	return len(c.Done)%checkpointEvery == 0
+}
+
+// Save writes the Checkpoint to its path, replacing the previous one at once.
+func (c *Checkpoint) Save() error {
+
+	c.mu.Lock()
+	c.Saved = time.Now()
+	content, err := json.Marshal(c)
+	c.mu.Unlock()
+	if err != nil {
+		return errors.Wrap(err, "error encoding checkpoint")
+	}
+	tmp := c.path + ".tmp"
+	err = ioutil.WriteFile(tmp, content, 0644)
+	if err != nil {
+		return errors.Wrap(err, "error writing checkpoint "+tmp)
+	}
+	return errors.Wrap(os.Rename(tmp, c.path), "error saving checkpoint "+c.path)
+}
+
+// Remove deletes the saved Checkpoint, e.g. after a complete Backfill.
+func (c *Checkpoint) Remove() error {
+	err := os.Remove(c.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return errors.Wrap(err, "error removing checkpoint "+c.path)
+}
+
+// BackfillOptions configure Backfill.
+type BackfillOptions struct {
+	BulkOptions
+	// Anlagen includes the files of the anlagen folder, see UpdateAnlageText.
+	Anlagen bool
+}
+
+// Backfill syncs all files of the Sitzungen, Tops and Vorlagen folders of
+// env.Source, which must be a ListingSource, with BulkSync: Sitzungen before
+// Tops, so the Tops find their parents. The files done according to
+// checkpoint are left out, the others are added to it as they are synced or
+// found unchanged. checkpoint may be nil. skipped is the number of files left
+// out.
+func Backfill(ctx context.Context, env *Env, checkpoint *Checkpoint, opts BackfillOptions) (report *BulkReport, skipped int, err error) {
+
+	ls, ok := env.Source.(ListingSource)
+	if !ok {
+		return nil, 0, errors.New("source can't list files")
+	}
+	config := env.App.Config
+	folders := []string{config.GetSitzungenFolder(), config.GetTopFolder(), config.GetVorlagenFolder()}
+	if opts.Anlagen {
+		folders = append(folders, config.GetAnlagenFolder())
+	}
+
+	var paths []string
+	for _, folder := range folders {
+		list, err := ls.List(folder)
+		if err != nil {
+			return nil, 0, errors.Wrap(err, "error listing "+folder)
+		}
+		for _, p := range list {
+			if checkpoint != nil && checkpoint.isDone(p) {
+				skipped++
+				continue
+			}
+			paths = append(paths, p)
+		}
+	}
+	slog.Info("backfilling %d files, %d done before", len(paths), skipped)
+
+	bulk := opts.BulkOptions
+	if checkpoint != nil {
+		progress := opts.Progress
+		bulk.Progress = func(r *BulkResult) {
+			if r.Err == nil && checkpoint.done(r.File) {
+				if err := checkpoint.Save(); err != nil {
+					slog.Error("error saving checkpoint: %v", err)
+				}
+			}
+			if progress != nil {
+				progress(r)
+			}
+		}
+	}
+
+	report = BulkSync(ctx, env, paths, bulk)
+	if checkpoint != nil {
+		err = checkpoint.Save()
+	}
+	return report, skipped, err
+}
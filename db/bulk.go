@@ -0,0 +1,188 @@
+package db
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rismaster/allris-common/common/slog"
+)
+
+// DefaultConcurrency is the number of files BulkSync processes at once if
+// BulkOptions.Concurrency isn't set.
+const DefaultConcurrency = 4
+
+// BulkOptions configure BulkSync.
+type BulkOptions struct {
+	// Concurrency is the number of workers, DefaultConcurrency if <= 0.
+	Concurrency int
+	// Rate limits the files started per second across all workers to go
+	// easy on the store, unlimited if <= 0.
+	Rate float64
+	// Progress is called after each file with the result, from the workers.
+	Progress func(result *BulkResult)
+}
+
+// BulkResult is the outcome of one file: Changed reports if anything was
+// written, Err is a *Error if it failed.
+type BulkResult struct {
+	File    string
+	Changed bool
+	Err     error
+}
+
+// BulkReport aggregates the results of BulkSync by outcome, each sorted by
+// file. Synced were written, Unchanged were skipped by Sync as already
+// stored, Cancelled weren't started before the context was done.
+type BulkReport struct {
+	Synced    []string
+	Unchanged []string
+	Failed    []*BulkResult
+	Cancelled []string
+	Duration  time.Duration
+}
+
+// Total is the number of files of the report.
+func (r *BulkReport) Total() int {
+	return len(r.Synced) + len(r.Unchanged) + len(r.Failed) + len(r.Cancelled)
+}
+
+type bulkPhase struct {
+	folder string
+	update func(*Env, string) (bool, error)
+}
+
+// bulkPhases are the folders in the order BulkSync processes them. Tops and
+// Anlagen are stored under their Sitzung, Vorlage or Top, so their parents
+// go first.
+func bulkPhases(env *Env) []bulkPhase {
+	config := env.App.Config
+	return []bulkPhase{
+		{config.GetSitzungenFolder(), UpdateSitzung},
+		{config.GetVorlagenFolder(), UpdateVorlage},
+		{config.GetTopFolder(), UpdateTop},
+		{config.GetAnlagenFolder(), UpdateAnlageText},
+	}
+}
+
+// BulkSync syncs many files of the bucket with the Update* entrypoint of
+// their folder, Anlagen with UpdateAnlageText. The files of a folder are
+// processed concurrently, the folders one after the other: Sitzungen,
+// Vorlagen, Tops and Anlagen. A failing file doesn't stop the others, a file
+// of no known folder fails with ErrFilename. If ctx is done, the running
+// files are finished and the others reported as Cancelled.
+func BulkSync(ctx context.Context, env *Env, paths []string, opts BulkOptions) *BulkReport {
+
+	start := time.Now()
+	phases := bulkPhases(env)
+	byPhase := make([][]string, len(phases))
+	report := &BulkReport{}
+	for _, p := range paths {
+		phase := -1
+		for i, ph := range phases {
+			if strings.HasPrefix(p, ph.folder) {
+				phase = i
+				break
+			}
+		}
+		if phase < 0 {
+			report.Failed = append(report.Failed, &BulkResult{File: p, Err: newError(ErrFilename, p, errors.New("no entity folder"))})
+			continue
+		}
+		byPhase[phase] = append(byPhase[phase], p)
+	}
+
+	concurrency := opts.Concurrency
+	if concurrency <= 0 {
+		concurrency = DefaultConcurrency
+	}
+	var tick <-chan time.Time
+	if opts.Rate > 0 {
+		ticker := time.NewTicker(time.Duration(float64(time.Second) / opts.Rate))
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	var mu sync.Mutex
+	record := func(r *BulkResult) {
+		mu.Lock()
+		switch {
+		case r.Err != nil:
+			report.Failed = append(report.Failed, r)
+		case r.Changed:
+			report.Synced = append(report.Synced, r.File)
+		default:
+			report.Unchanged = append(report.Unchanged, r.File)
+		}
+		mu.Unlock()
+		if opts.Progress != nil {
+			opts.Progress(r)
+		}
+	}
+
+	for i, ph := range phases {
+		if len(byPhase[i]) == 0 {
+			continue
+		}
+		slog.Info("syncing %d files of %s", len(byPhase[i]), ph.folder)
+		todo := make(chan string)
+		var wg sync.WaitGroup
+		for w := 0; w < concurrency; w++ {
+			wg.Add(1)
+			go func(update func(*Env, string) (bool, error)) {
+				defer wg.Done()
+				for file := range todo {
+					changed, err := update(env, file)
+					if err != nil {
+						var e *Error
+						if !errors.As(err, &e) {
+							err = newError(ErrStorage, file, err)
+						}
+					}
+					record(&BulkResult{File: file, Changed: changed, Err: err})
+				}
+			}(ph.update)
+		}
+
+		var cancelled []string
+		for j, file := range byPhase[i] {
+			if tick != nil {
+				select {
+				case <-ctx.Done():
+				case <-tick:
+				}
+			}
+			if ctx.Err() == nil {
+				select {
+				case <-ctx.Done():
+				case todo <- file:
+					continue
+				}
+			}
+			cancelled = byPhase[i][j:]
+			break
+		}
+		close(todo)
+		wg.Wait()
+
+		if cancelled != nil {
+			report.Cancelled = append(report.Cancelled, cancelled...)
+			for _, rest := range byPhase[i+1:] {
+				report.Cancelled = append(report.Cancelled, rest...)
+			}
+			break
+		}
+	}
+
+	sort.Strings(report.Synced)
+	sort.Strings(report.Unchanged)
+	sort.Strings(report.Cancelled)
+	sort.Slice(report.Failed, func(i, j int) bool {
+		return report.Failed[i].File < report.Failed[j].File
+	})
+	report.Duration = time.Since(start)
+	return report
+}
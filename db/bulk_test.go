@@ -0,0 +1,125 @@
+package db_test
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rismaster/allris-db/db"
+	"github.com/rismaster/allris-db/db/internal/dbtest"
+	"github.com/rismaster/allris-db/db/memory"
+)
+
+// bulkPaths are the fixture pages with the Tops and Vorlagen first.
+var bulkPaths = []string{
+	"tops/sitzung-1001-top-5002.html",
+	"tops/sitzung-1002-top-5102.html",
+	"vorlagen/vorlage-2001.html",
+	"vorlagen/vorlage-2002.html",
+	"sitzungen/sitzung-1001.html",
+	"sitzungen/sitzung-1002.html",
+}
+
+func folder(path string) string {
+	return strings.SplitN(path, "/", 2)[0]
+}
+
+func TestBulkSync(t *testing.T) {
+
+	repo := memory.New()
+	env, _ := dbtest.Env(t, repo)
+	var mu sync.Mutex
+	var order []string
+	opts := db.BulkOptions{Concurrency: 2, Progress: func(r *db.BulkResult) {
+		mu.Lock()
+		order = append(order, r.File)
+		mu.Unlock()
+	}}
+	paths := append([]string{"unknown/page.html", "sitzungen/kaputt.html"}, bulkPaths...)
+	report := db.BulkSync(context.Background(), env, paths, opts)
+
+	if len(report.Synced) != len(bulkPaths) || report.Total() != len(paths) {
+		t.Errorf("synced %v of %d", report.Synced, report.Total())
+	}
+	if len(report.Failed) != 2 {
+		t.Fatalf("failed %+v", report.Failed)
+	}
+	for _, f := range report.Failed {
+		if e, ok := f.Err.(*db.Error); !ok || e.Kind != db.ErrFilename {
+			t.Errorf("%s: %+v", f.File, f.Err)
+		}
+	}
+	if report.Failed[0].File != "sitzungen/kaputt.html" || report.Failed[1].File != "unknown/page.html" {
+		t.Errorf("failed not sorted: %s, %s", report.Failed[0].File, report.Failed[1].File)
+	}
+
+	// the folders one after the other, the parents first
+	phase := map[string]int{"sitzungen": 0, "vorlagen": 1, "tops": 2}
+	for i := 1; i < len(order); i++ {
+		if phase[folder(order[i])] < phase[folder(order[i-1])] {
+			t.Errorf("%s after %s", order[i], order[i-1])
+		}
+	}
+	if n := dbtest.Count(t, repo, db.NewQuery("Top")); n == 0 {
+		t.Error("no tops stored")
+	}
+
+	report = db.BulkSync(context.Background(), env, bulkPaths, db.BulkOptions{})
+	if len(report.Unchanged) != len(bulkPaths) || len(report.Synced) != 0 || len(report.Failed) != 0 {
+		t.Errorf("second run: %+v", report)
+	}
+}
+
+func TestBulkSyncCancelled(t *testing.T) {
+
+	repo := memory.New()
+	env, _ := dbtest.Env(t, repo)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	report := db.BulkSync(ctx, env, bulkPaths, db.BulkOptions{})
+	if len(report.Cancelled) != len(bulkPaths) || report.Total() != len(bulkPaths) {
+		t.Errorf("done context: %+v", report)
+	}
+	if repo.Len() != 0 {
+		t.Errorf("%d entities stored", repo.Len())
+	}
+
+	// cancelled after the first file, the running one is finished and the
+	// later folders aren't started
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	opts := db.BulkOptions{Concurrency: 1, Progress: func(*db.BulkResult) { cancel() }}
+	report = db.BulkSync(ctx, env, bulkPaths, opts)
+	if len(report.Synced) == 0 || len(report.Synced) > 2 || report.Total() != len(bulkPaths) {
+		t.Errorf("cancelled: %+v", report)
+	}
+	for _, f := range report.Synced {
+		if folder(f) != "sitzungen" {
+			t.Errorf("%s synced after cancel", f)
+		}
+	}
+	for _, f := range bulkPaths[:4] {
+		found := false
+		for _, c := range report.Cancelled {
+			found = found || c == f
+		}
+		if !found {
+			t.Errorf("%s not cancelled", f)
+		}
+	}
+}
+
+func TestBulkSyncRate(t *testing.T) {
+
+	env, _ := dbtest.Env(t, memory.New())
+	report := db.BulkSync(context.Background(), env, bulkPaths[4:], db.BulkOptions{Rate: 10})
+	// a tick before each of the two files
+	if report.Duration < 150*time.Millisecond {
+		t.Errorf("rate 10/s: %d files in %s", len(bulkPaths[4:]), report.Duration)
+	}
+	if len(report.Synced) != 2 {
+		t.Errorf("synced %v", report.Synced)
+	}
+}
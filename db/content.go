@@ -0,0 +1,267 @@
+package db
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kennygrant/sanitize"
+	"github.com/pkg/errors"
+	allris_common "github.com/rismaster/allris-common"
+	"github.com/rismaster/allris-common/common/files"
+	"github.com/rismaster/allris-common/common/slog"
+)
+
+// AnlageContent is one distinct file content. Identical files attached to
+// several Vorlagen, Sitzungen or Tops are stored once, the Anlagen refer to
+// it by their SHA256.
+type AnlageContent struct {
+	SHA256    string
+	Size      int64
+	MimeType  string
+	File      string // the first file seen with this content
+	FirstSeen time.Time
+
+	// The text of a PDF, see UpdateAnlageText.
+	Pages           int
+	TextExtractedAt time.Time
+	TextError       string `datastore:",noindex"`
+}
+
+func AnlageContentKey(sha256 string) *Key {
+	return NameKey(EntityAnlageContent, sha256, nil)
+}
+
+// UpdateAnlageContent resolves a file of the anlagen folder to its stored
+// Anlage and records the path, size, MIME type and SHA-256 of the file. The
+// Anlage must have been stored by the Sync of its Vorlage, Sitzung or Top
+// before, otherwise the error is ErrNotFound. changed reports a new or
+// different content, ContentChangedAt is set if the content of an Anlage
+// changed between syncs. Errors are of type *Error.
+func UpdateAnlageContent(env *Env, filepath string) (changed bool, err error) {
+	_, changed, err = updateAnlageContent(env, filepath)
+	return changed, err
+}
+
+// anlageFile is a file of the anlagen folder resolved to its stored Anlage.
+type anlageFile struct {
+	file    *files.File
+	key     *Key
+	anlage  *Anlage
+	content []byte // nil if the generation of the file is unchanged
+}
+
+func updateAnlageContent(env *Env, filepath string) (*anlageFile, bool, error) {
+
+	config := env.App.Config
+	file := files.NewFileFromStore(env.App, config.GetAnlagenFolder(), strings.TrimPrefix(filepath, config.GetAnlagenFolder()))
+	parsed, err := NewAnlage(env.App, file)
+	if err != nil {
+		return nil, false, newError(ErrFilename, filepath, err)
+	}
+	parentKey := anlageParentKey(config, parsed)
+	if parentKey == nil {
+		return nil, false, newError(ErrFilename, filepath, errors.New("no anlage file name"))
+	}
+
+	key, stored, err := findAnlage(env.Repo, config, parentKey, parsed, file)
+	if err != nil {
+		return nil, false, newError(ErrStorage, filepath, err)
+	}
+	if stored == nil {
+		return nil, false, newError(ErrNotFound, filepath, errors.New("no anlage stored for "+file.GetPath()))
+	}
+	af := &anlageFile{file: file, key: key, anlage: stored}
+
+	var generation string
+	if gs, ok := env.Source.(GenerationSource); ok {
+		generation, err = gs.Generation(file)
+		if err != nil {
+			return nil, false, readError(file.GetPath(), errors.Wrap(err, fmt.Sprintf("error reading generation of %s", file.GetName())))
+		}
+		if !env.Force && generation != "" && generation == stored.FileGeneration && stored.Filename == file.GetPath() {
+			slog.Debug("unchanged generation %s of %s", generation, file.GetName())
+			return af, false, nil
+		}
+	}
+
+	af.content, err = env.Source.ReadFile(file)
+	if err != nil {
+		return nil, false, readError(file.GetPath(), errors.Wrap(err, fmt.Sprintf("error reading file %s", file.GetName())))
+	}
+
+	before := *stored
+	now := time.Now()
+	a := stored
+	a.Filename = file.GetPath()
+	a.FileGeneration = generation
+	hash := HashContent(af.content)
+	changed := hash != a.SHA256
+	if changed {
+		if a.SHA256 != "" {
+			a.ContentChangedAt = now
+		}
+		a.SHA256 = hash
+		a.Size = int64(len(af.content))
+		a.MimeType = detectMimeType(file.GetName(), af.content)
+	}
+
+	var created bool
+	err = RunInTransaction(env.Repo, func(tx Transaction) error {
+		created = false
+		if changed {
+			err := tx.Get(AnlageContentKey(hash), &AnlageContent{})
+			if err == ErrNoSuchEntity {
+				created = true
+				err = tx.Put(AnlageContentKey(hash), &AnlageContent{
+					SHA256:    hash,
+					Size:      a.Size,
+					MimeType:  a.MimeType,
+					File:      a.Filename,
+					FirstSeen: now,
+				})
+			}
+			if err != nil {
+				return errors.Wrap(err, "error saving content "+hash)
+			}
+		}
+		return tx.Put(key, a)
+	})
+	if err != nil {
+		return nil, false, newError(ErrStorage, filepath, errors.Wrap(err, "error saving anlage "+key.String()))
+	}
+
+	changes := &changeLog{}
+	changes.updated(key, &before, a)
+	if created {
+		changes.created(AnlageContentKey(hash))
+	}
+	env.publish(changes)
+	return af, changed, nil
+}
+
+func anlageParentKey(config allris_common.Config, a *Anlage) *Key {
+	switch {
+	case a.TOLFDNR > 0:
+		return NameKey(config.GetEntityTop(), strconv.Itoa(a.TOLFDNR), NameKey(config.GetEntitySitzung(), strconv.Itoa(a.SILFDNR), nil))
+	case a.SILFDNR > 0:
+		return NameKey(config.GetEntitySitzung(), strconv.Itoa(a.SILFDNR), nil)
+	case a.VOLFDNR > 0:
+		return NameKey(config.GetEntityVorlage(), strconv.Itoa(a.VOLFDNR), nil)
+	}
+	return nil
+}
+
+// findAnlage matches a file to the Anlagen stored directly under parentKey:
+// Basisanlagen by DOLFDNR, the others by the title in the file name. The
+// title has to end the name of the file at a "-", of several matching titles
+// the longest wins, so "Plan" doesn't take the file of "Lageplan" nor "1" the
+// one of "11". A file matching none is not found, it isn't guessed onto
+// another Anlage.
+func findAnlage(repo Repository, config allris_common.Config, parentKey *Key, parsed *Anlage, file *files.File) (*Key, *Anlage, error) {
+
+	var anlagen []*Anlage
+	keys, err := repo.GetAll(NewQuery(config.GetEntityAnlage()).WithAncestor(parentKey), &anlagen)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "error getting anlagen of "+parentKey.String()+" from db")
+	}
+
+	title := fileTitle(file.GetName())
+	found := -1
+	var foundTitle string
+	for i, a := range anlagen {
+		if keys[i].Parent == nil || keys[i].Parent.Encode() != parentKey.Encode() {
+			continue
+		}
+		if a.Filename == file.GetPath() {
+			return keys[i], a, nil
+		}
+		if parsed.DOLFDNR > 0 {
+			if a.DOLFDNR == parsed.DOLFDNR {
+				return keys[i], a, nil
+			}
+			continue
+		}
+		if a.DOLFDNR > 0 {
+			continue
+		}
+		at := normalizeTitle(a.Title)
+		if titleMatches(title, at) && len(at) > len(foundTitle) {
+			found, foundTitle = i, at
+		}
+	}
+	if found < 0 {
+		return nil, nil, nil
+	}
+	return keys[found], anlagen[found], nil
+}
+
+// titleMatches checks that the normalized title of an Anlage is the title of
+// a file name or ends it after a "-", e.g. after the number of the Anlage.
+func titleMatches(name string, title string) bool {
+	if name == "" || title == "" {
+		return false
+	}
+	return name == title || strings.HasSuffix(name, "-"+title)
+}
+
+// fileTitle is the normalized title part of the name of an Anlage file.
+func fileTitle(name string) string {
+	name = strings.TrimSuffix(name, path.Ext(name))
+	if m := RegexTopAnlage.FindStringSubmatch(name); m != nil {
+		return normalizeTitle(m[3])
+	}
+	if m := RegexAnlagen.FindStringSubmatch(name); m != nil {
+		return normalizeTitle(m[6])
+	}
+	return ""
+}
+
+func normalizeTitle(title string) string {
+	return strings.ToLower(sanitize.BaseName(strings.TrimSpace(title)))
+}
+
+// detectMimeType sniffs the content and falls back to the file extension.
+func detectMimeType(name string, content []byte) string {
+	t := http.DetectContentType(content)
+	if strings.HasPrefix(t, "application/octet-stream") || strings.HasPrefix(t, "text/plain") {
+		if byExt := mime.TypeByExtension(path.Ext(name)); byExt != "" {
+			return byExt
+		}
+	}
+	return t
+}
+
+// AnlagenWithContent returns the keys of the Anlagen sharing a content.
+func AnlagenWithContent(repo Repository, config allris_common.Config, sha256 string) ([]*Key, error) {
+	keys, err := repo.GetAll(NewQuery(config.GetEntityAnlage()).Filter("SHA256 =", sha256).KeysOnly(), nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "error getting anlagen with content "+sha256+" from db")
+	}
+	return keys, nil
+}
+
+// DuplicateAnlagen returns the Anlagen by SHA256 for the contents attached
+// more than once.
+func DuplicateAnlagen(repo Repository, config allris_common.Config) (map[string][]*Key, error) {
+
+	var anlagen []*Anlage
+	keys, err := repo.GetAll(NewQuery(config.GetEntityAnlage()).Filter("SHA256 >", ""), &anlagen)
+	if err != nil {
+		return nil, errors.Wrap(err, "error getting anlagen from db")
+	}
+	bySHA := make(map[string][]*Key)
+	for i, a := range anlagen {
+		bySHA[a.SHA256] = append(bySHA[a.SHA256], keys[i])
+	}
+	for sha, ks := range bySHA {
+		if len(ks) < 2 {
+			delete(bySHA, sha)
+		}
+	}
+	return bySHA, nil
+}
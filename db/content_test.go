@@ -0,0 +1,63 @@
+package db_test
+
+import (
+	"testing"
+
+	"github.com/rismaster/allris-common/common/files"
+	"github.com/rismaster/allris-db/db"
+	"github.com/rismaster/allris-db/db/golden"
+	"github.com/rismaster/allris-db/db/memory"
+)
+
+func TestFindAnlage(t *testing.T) {
+
+	repo := memory.New()
+	env := db.NewLocalEnv(golden.FixtureConfig{}, repo, t.TempDir())
+	vorlageKey := db.NameKey("Vorlage", "2001", nil)
+	for name, a := range map[string]*db.Anlage{
+		"plan":     {VOLFDNR: 2001, Title: "Plan"},
+		"lageplan": {VOLFDNR: 2001, Title: "Lageplan"},
+		"1":        {VOLFDNR: 2001, Title: "1"},
+		"11":       {VOLFDNR: 2001, Title: "11"},
+		"empty":    {VOLFDNR: 2001, Title: ""},
+		"7101":     {VOLFDNR: 2001, Title: "Vorlage (öffentlich)", DOLFDNR: 7101},
+	} {
+		err := repo.Put(db.NameKey("Anlage", name, vorlageKey), a)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	// an Anlage of another parent with the same title
+	err := repo.Put(db.NameKey("Anlage", "other", db.NameKey("Vorlage", "2002", nil)), &db.Anlage{VOLFDNR: 2002, Title: "Anhang"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []struct {
+		file    string
+		dolfdnr int
+		want    string
+	}{
+		{"vorlage-2001-anlage-1-lageplan.pdf", 0, "lageplan"},
+		{"vorlage-2001-anlage-2-plan.pdf", 0, "plan"},
+		{"vorlage-2001-anlage-3-11.pdf", 0, "11"},
+		{"vorlage-2001-anlage-4-1.pdf", 0, "1"},
+		{"vorlage-2001-anlage-5-anhang.pdf", 0, ""},
+		{"vorlage-2001-anlage-6-bebauungsplan.pdf", 0, ""},
+		{"vorlage-2001-basisanlage-7101-vorlage-offentlich.pdf", 7101, "7101"},
+		{"vorlage-2001-basisanlage-7102-lageplan.pdf", 7102, ""},
+	} {
+		file := files.NewFileFromStore(env.App, "anlagen/", c.file)
+		key, _, err := db.FindAnlage(repo, golden.FixtureConfig{}, vorlageKey, &db.Anlage{DOLFDNR: c.dolfdnr}, file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := ""
+		if key != nil {
+			got = key.Name
+		}
+		if got != c.want {
+			t.Errorf("%s: found %q, want %q", c.file, got, c.want)
+		}
+	}
+}
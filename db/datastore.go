@@ -0,0 +1,163 @@
+package db
+
+import (
+	"cloud.google.com/go/datastore"
+	"context"
+	"github.com/rismaster/allris-common/application"
+)
+
+// DatastoreRepository stores the entities in Google Cloud Datastore.
+type DatastoreRepository struct {
+	client *datastore.Client
+	ctx    context.Context
+}
+
+type datastoreTransaction struct {
+	tx *datastore.Transaction
+}
+
+func NewDatastoreRepository(app *application.AppContext) *DatastoreRepository {
+	return &DatastoreRepository{
+		client: app.Db(),
+		ctx:    app.Ctx(),
+	}
+}
+
+func toDatastoreKey(k *Key) *datastore.Key {
+	if k == nil {
+		return nil
+	}
+	return datastore.NameKey(k.Kind, k.Name, toDatastoreKey(k.Parent))
+}
+
+func fromDatastoreKey(k *datastore.Key) *Key {
+	if k == nil {
+		return nil
+	}
+	return NameKey(k.Kind, k.Name, fromDatastoreKey(k.Parent))
+}
+
+func toDatastoreKeys(keys []*Key) []*datastore.Key {
+	dks := make([]*datastore.Key, len(keys))
+	for i, k := range keys {
+		dks[i] = toDatastoreKey(k)
+	}
+	return dks
+}
+
+func fromDatastoreKeys(dks []*datastore.Key) []*Key {
+	keys := make([]*Key, len(dks))
+	for i, k := range dks {
+		keys[i] = fromDatastoreKey(k)
+	}
+	return keys
+}
+
+func toDatastoreQuery(q *Query) *datastore.Query {
+	dq := datastore.NewQuery(q.Kind)
+	if q.Ancestor != nil {
+		dq = dq.Ancestor(toDatastoreKey(q.Ancestor))
+	}
+	for _, f := range q.Filters {
+		dq = dq.Filter(f.Field+" "+f.Op, f.Value)
+	}
+	for _, o := range q.Orders {
+		if o.Descending {
+			dq = dq.Order("-" + o.Field)
+		} else {
+			dq = dq.Order(o.Field)
+		}
+	}
+	if q.GetOffset() > 0 {
+		dq = dq.Offset(q.GetOffset())
+	}
+	if q.GetLimit() > 0 {
+		dq = dq.Limit(q.GetLimit())
+	}
+	if q.IsKeysOnly() {
+		dq = dq.KeysOnly()
+	}
+	return dq
+}
+
+func fromDatastoreError(err error) error {
+	if err == datastore.ErrNoSuchEntity {
+		return ErrNoSuchEntity
+	}
+	if me, ok := err.(datastore.MultiError); ok {
+		errs := make(MultiError, len(me))
+		for i, e := range me {
+			errs[i] = fromDatastoreError(e)
+		}
+		return errs
+	}
+	return err
+}
+
+func (r *DatastoreRepository) Get(key *Key, dst interface{}) error {
+	return fromDatastoreError(r.client.Get(r.ctx, toDatastoreKey(key), dst))
+}
+
+func (r *DatastoreRepository) GetAll(q *Query, dst interface{}) ([]*Key, error) {
+	if q.Err() != nil {
+		return nil, q.Err()
+	}
+	dks, err := r.client.GetAll(r.ctx, toDatastoreQuery(q), dst)
+	return fromDatastoreKeys(dks), fromDatastoreError(err)
+}
+
+func (r *DatastoreRepository) Put(key *Key, src interface{}) error {
+	_, err := r.client.Put(r.ctx, toDatastoreKey(key), src)
+	return err
+}
+
+func (r *DatastoreRepository) PutMulti(keys []*Key, src interface{}) error {
+	_, err := r.client.PutMulti(r.ctx, toDatastoreKeys(keys), src)
+	return err
+}
+
+func (r *DatastoreRepository) Delete(key *Key) error {
+	return r.client.Delete(r.ctx, toDatastoreKey(key))
+}
+
+func (r *DatastoreRepository) DeleteMulti(keys []*Key) error {
+	return r.client.DeleteMulti(r.ctx, toDatastoreKeys(keys))
+}
+
+func (r *DatastoreRepository) NewTransaction() (Transaction, error) {
+	tx, err := r.client.NewTransaction(r.ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &datastoreTransaction{tx: tx}, nil
+}
+
+func (t *datastoreTransaction) Get(key *Key, dst interface{}) error {
+	return fromDatastoreError(t.tx.Get(toDatastoreKey(key), dst))
+}
+
+func (t *datastoreTransaction) GetMulti(keys []*Key, dst interface{}) error {
+	return fromDatastoreError(t.tx.GetMulti(toDatastoreKeys(keys), dst))
+}
+
+func (t *datastoreTransaction) Put(key *Key, src interface{}) error {
+	_, err := t.tx.Put(toDatastoreKey(key), src)
+	return err
+}
+
+func (t *datastoreTransaction) Delete(key *Key) error {
+	return t.tx.Delete(toDatastoreKey(key))
+}
+
+func (t *datastoreTransaction) DeleteMulti(keys []*Key) error {
+	return t.tx.DeleteMulti(toDatastoreKeys(keys))
+}
+
+func (t *datastoreTransaction) Commit() error {
+	_, err := t.tx.Commit()
+	return err
+}
+
+func (t *datastoreTransaction) Rollback() error {
+	return t.tx.Rollback()
+}
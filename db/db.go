@@ -1,94 +1,95 @@
 package db
 
 import (
-	"github.com/rismaster/allris-common/application"
 	"github.com/rismaster/allris-common/common/files"
-	"github.com/rismaster/allris-common/common/slog"
 	"strings"
 )
 
-func DeleteTop(app *application.AppContext, filepath string) {
+// The Update*/Delete* entrypoints process one fetched file, filepath is its
+// path in the bucket. Errors are of type *Error, see ErrParse, ErrNotFound,
+// ErrStorage and ErrFilename. The Update* entrypoints report if anything was
+// written, see Sync. All of them publish their changes to env.Events.
 
-	file := files.NewFileFromStore(app, app.Config.GetTopFolder(), strings.TrimPrefix(filepath, app.Config.GetTopFolder()))
-	top, err := NewTop(app, file)
+func DeleteTop(env *Env, filepath string) error {
+
+	file := files.NewFileFromStore(env.App, env.App.Config.GetTopFolder(), strings.TrimPrefix(filepath, env.App.Config.GetTopFolder()))
+	top, err := NewTop(env.App, file)
 	if err != nil {
-		slog.Fatal("err: %+v", err)
+		return newError(ErrFilename, filepath, err)
 	}
 
-	err = top.Delete()
+	changes := &changeLog{}
+	err = top.delete(env.Repo, changes)
 	if err != nil {
-		slog.Fatal("err: %+v", err)
+		return newError(ErrStorage, filepath, err)
 	}
+	env.publish(changes)
+	return nil
 }
 
-func DeleteSitzung(app *application.AppContext, filepath string) {
+func DeleteSitzung(env *Env, filepath string) error {
 
-	file := files.NewFileFromStore(app, app.Config.GetSitzungenFolder(), strings.TrimPrefix(filepath, app.Config.GetSitzungenFolder()))
-	sitzung, err := NewSitzung(app, file)
+	file := files.NewFileFromStore(env.App, env.App.Config.GetSitzungenFolder(), strings.TrimPrefix(filepath, env.App.Config.GetSitzungenFolder()))
+	sitzung, err := NewSitzung(env.App, file)
 	if err != nil {
-		slog.Fatal("err: %+v", err)
+		return newError(ErrFilename, filepath, err)
 	}
 
-	err = sitzung.Delete()
+	changes := &changeLog{}
+	err = sitzung.delete(env.Repo, changes)
 	if err != nil {
-		slog.Fatal("err: %+v", err)
+		return newError(ErrStorage, filepath, err)
 	}
+	env.publish(changes)
+	return nil
 }
 
-func DeleteVorlage(app *application.AppContext, filepath string) {
+func DeleteVorlage(env *Env, filepath string) error {
 
-	file := files.NewFileFromStore(app, app.Config.GetVorlagenFolder(), strings.TrimPrefix(filepath, app.Config.GetVorlagenFolder()))
-	vorlage, err := NewVorlage(app, file)
+	file := files.NewFileFromStore(env.App, env.App.Config.GetVorlagenFolder(), strings.TrimPrefix(filepath, env.App.Config.GetVorlagenFolder()))
+	vorlage, err := NewVorlage(env.App, file)
 	if err != nil {
-		slog.Fatal("err: %+v", err)
+		return newError(ErrFilename, filepath, err)
 	}
 
-	err = vorlage.Delete()
+	changes := &changeLog{}
+	err = vorlage.delete(env.Repo, changes)
 	if err != nil {
-		slog.Fatal("err: %+v", err)
+		return newError(ErrStorage, filepath, err)
 	}
+	env.publish(changes)
+	return nil
 }
 
-func UpdateVorlage(app *application.AppContext, filepath string) {
+func UpdateVorlage(env *Env, filepath string) (bool, error) {
 
-	file := files.NewFileFromStore(app, app.Config.GetVorlagenFolder(), strings.TrimPrefix(filepath, app.Config.GetVorlagenFolder()))
-	vorlage, err := NewVorlage(app, file)
+	file := files.NewFileFromStore(env.App, env.App.Config.GetVorlagenFolder(), strings.TrimPrefix(filepath, env.App.Config.GetVorlagenFolder()))
+	vorlage, err := NewVorlage(env.App, file)
 	if err != nil {
-		slog.Fatal("err: %+v", err)
+		return false, newError(ErrFilename, filepath, err)
 	}
 
-	err = Sync(app, vorlage)
-	if err != nil {
-		slog.Fatal("err: %+v", err)
-	}
+	return Sync(env, vorlage)
 }
 
-func UpdateTop(app *application.AppContext, filepath string) {
+func UpdateTop(env *Env, filepath string) (bool, error) {
 
-	file := files.NewFileFromStore(app, app.Config.GetTopFolder(), strings.TrimPrefix(filepath, app.Config.GetTopFolder()))
-	top, err := NewTop(app, file)
+	file := files.NewFileFromStore(env.App, env.App.Config.GetTopFolder(), strings.TrimPrefix(filepath, env.App.Config.GetTopFolder()))
+	top, err := NewTop(env.App, file)
 	if err != nil {
-		slog.Fatal("err: %+v", err)
-	}
-
-	err = Sync(app, top)
-	if err != nil {
-		slog.Fatal("err: %+v", err)
+		return false, newError(ErrFilename, filepath, err)
 	}
 
+	return Sync(env, top)
 }
 
-func UpdateSitzung(app *application.AppContext, filepath string) {
-
-	file := files.NewFileFromStore(app, app.Config.GetSitzungenFolder(), strings.TrimPrefix(filepath, app.Config.GetSitzungenFolder()))
-	sitzung, err := NewSitzung(app, file)
-	if err != nil {
-		slog.Fatal("err: %+v", err)
-	}
+func UpdateSitzung(env *Env, filepath string) (bool, error) {
 
-	err = Sync(app, sitzung)
+	file := files.NewFileFromStore(env.App, env.App.Config.GetSitzungenFolder(), strings.TrimPrefix(filepath, env.App.Config.GetSitzungenFolder()))
+	sitzung, err := NewSitzung(env.App, file)
 	if err != nil {
-		slog.Fatal("err: %+v", err)
+		return false, newError(ErrFilename, filepath, err)
 	}
 
+	return Sync(env, sitzung)
 }
@@ -0,0 +1,25 @@
+package db
+
+// The kinds of the entities derived by this package. The kinds of the fetched
+// pages (Sitzung, Top, Vorlage, Anlage, Termin) are named by the config, these
+// are fixed.
+const (
+	// EntityAbstimmung is the voting result of a Top, stored as its child.
+	EntityAbstimmung = "Abstimmung"
+	// EntityFraktionsVotum is the vote of one Fraktion, stored as a child of
+	// its Abstimmung.
+	EntityFraktionsVotum = "FraktionsVotum"
+	// EntityAnlageContent is the content of an Anlage file, keyed by its
+	// SHA256.
+	EntityAnlageContent = "AnlageContent"
+	// EntityAnlageText is the text of one page of an AnlageContent, stored as
+	// its child, so the text of identical files is extracted once.
+	EntityAnlageText = "AnlageText"
+	// EntityVorlageRevision is a change of a Vorlage, stored as its child. The
+	// revisions outlive their Vorlage, the delete is recorded as the last one.
+	EntityVorlageRevision = "VorlageRevision"
+	// EntityTerminMove is a rescheduling of a Termin, stored as its child. The
+	// moves outlive their Termin, a Termin dropping out of the list keeps its
+	// history.
+	EntityTerminMove = "TerminMove"
+)
@@ -0,0 +1,38 @@
+package db
+
+import (
+	allris_common "github.com/rismaster/allris-common"
+	"github.com/rismaster/allris-common/application"
+)
+
+// Env is what Sync and the Update*/Delete* entrypoints work with: the app
+// context for the configuration, the Repository the entities are stored in
+// and the Source the fetched html files are read from.
+type Env struct {
+	App    *application.AppContext
+	Repo   Repository
+	Source Source
+
+	// Force syncs files even if they are unchanged, e.g. after a parser fix.
+	Force bool
+	// Events receives the changes, nil if nobody listens.
+	Events EventSink
+}
+
+// NewEnv uses Cloud Datastore and the fetched bucket of the app context.
+func NewEnv(app *application.AppContext) *Env {
+	return &Env{
+		App:    app,
+		Repo:   NewDatastoreRepository(app),
+		Source: NewBucketSource(app),
+	}
+}
+
+// NewLocalEnv reads the html files from dir and needs no GCP credentials.
+func NewLocalEnv(config allris_common.Config, repo Repository, dir string) *Env {
+	return &Env{
+		App:    &application.AppContext{Config: config},
+		Repo:   repo,
+		Source: &DirSource{Dir: dir},
+	}
+}
@@ -0,0 +1,50 @@
+package db
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+// The kinds of errors returned by Sync, UpdateTermine and the Update*/Delete*
+// entrypoints. Test for them with errors.Is, errors.As with *Error gives the
+// file. Storage errors are usually worth a retry, the others are not until
+// the file changes.
+var (
+	ErrParse    = errors.New("parse error")
+	ErrNotFound = errors.New("not found")
+	ErrStorage  = errors.New("storage error")
+	ErrFilename = errors.New("filename pattern mismatch")
+)
+
+// Error is an error while processing a fetched ALLRIS file.
+type Error struct {
+	Kind error // ErrParse, ErrNotFound, ErrStorage or ErrFilename
+	File string
+	Err  error
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s %s: %v", e.Kind, e.File, e.Err)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+func (e *Error) Is(target error) bool {
+	return target == e.Kind
+}
+
+func newError(kind error, file string, err error) error {
+	return &Error{Kind: kind, File: file, Err: err}
+}
+
+// readError classifies an error of Source.ReadFile.
+func readError(file string, err error) error {
+	if errors.Is(err, os.ErrNotExist) {
+		return newError(ErrNotFound, file, err)
+	}
+	return newError(ErrStorage, file, err)
+}
@@ -0,0 +1,187 @@
+package db
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rismaster/allris-common/common/slog"
+)
+
+type EventType string
+
+const (
+	EventCreated EventType = "created"
+	EventUpdated EventType = "updated"
+	EventDeleted EventType = "deleted"
+)
+
+// Event is a change of one stored entity. Key is the encoded key (see
+// DecodeKey), Fields are the changed fields of an update.
+type Event struct {
+	Type   EventType
+	Kind   string
+	Key    string
+	Fields []string `json:",omitempty"`
+	Time   time.Time
+}
+
+// EventSink receives the events of Sync, UpdateTermine and the Delete*
+// entrypoints after the changes are stored. A failing sink is logged and does
+// not fail the sync, the data is already written.
+type EventSink interface {
+	Publish(event Event) error
+}
+
+// ChannelSink sends the events to an in-process consumer. Publish blocks
+// until the event is received or the channel buffer has room.
+type ChannelSink chan Event
+
+func (c ChannelSink) Publish(event Event) error {
+	c <- event
+	return nil
+}
+
+// MultiSink publishes every event to all its sinks and returns the first
+// error after trying all.
+type MultiSink []EventSink
+
+func (m MultiSink) Publish(event Event) error {
+	var first error
+	for _, sink := range m {
+		err := sink.Publish(event)
+		if err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// JSONLinesSink writes every event as one line of JSON.
+type JSONLinesSink struct {
+	mu     sync.Mutex
+	enc    *json.Encoder
+	closer io.Closer
+}
+
+func NewJSONLinesSink(w io.Writer) *JSONLinesSink {
+	return &JSONLinesSink{enc: json.NewEncoder(w)}
+}
+
+// OpenJSONLinesFile appends the events to the file at path.
+func OpenJSONLinesFile(path string) (*JSONLinesSink, error) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, errors.Wrap(err, "error opening event file "+path)
+	}
+	s := NewJSONLinesSink(f)
+	s.closer = f
+	return s, nil
+}
+
+func (s *JSONLinesSink) Publish(event Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.enc.Encode(event)
+}
+
+func (s *JSONLinesSink) Close() error {
+	if s.closer == nil {
+		return nil
+	}
+	return s.closer.Close()
+}
+
+// changeLog collects the events of one call until its writes succeeded. A
+// nil changeLog drops them.
+type changeLog struct {
+	events []Event
+}
+
+func (c *changeLog) add(t EventType, key *Key, fields []string) {
+	if c == nil {
+		return
+	}
+	c.events = append(c.events, Event{
+		Type:   t,
+		Kind:   key.Kind,
+		Key:    key.Encode(),
+		Fields: fields,
+		Time:   time.Now(),
+	})
+}
+
+func (c *changeLog) created(key *Key) {
+	c.add(EventCreated, key, nil)
+}
+
+// updated records an update if any field differs between old and new.
+func (c *changeLog) updated(key *Key, old interface{}, new interface{}) {
+	fields := changedFields(old, new)
+	if len(fields) > 0 {
+		c.add(EventUpdated, key, fields)
+	}
+}
+
+func (c *changeLog) deleted(keys ...*Key) {
+	for _, key := range keys {
+		c.add(EventDeleted, key, nil)
+	}
+}
+
+func (env *Env) publish(c *changeLog) {
+	if env.Events == nil || c == nil {
+		return
+	}
+	for _, e := range c.events {
+		err := env.Events.Publish(e)
+		if err != nil {
+			slog.Error("error publishing %s event for %s: %v", e.Type, e.Key, err)
+		}
+	}
+}
+
+// bookkeepingFields change on every save and are left out of the events.
+var bookkeepingFields = map[string]bool{
+	"SavedAt":          true,
+	"ContentHash":      true,
+	"SourceGeneration": true,
+	"FileGeneration":   true,
+}
+
+// changedFields compares the persisted fields of two entities of the same type.
+func changedFields(old interface{}, new interface{}) []string {
+	ov := reflect.Indirect(reflect.ValueOf(old))
+	nv := reflect.Indirect(reflect.ValueOf(new))
+	if ov.Type() != nv.Type() {
+		return nil
+	}
+
+	var fields []string
+	t := ov.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" || bookkeepingFields[f.Name] || strings.Split(f.Tag.Get("datastore"), ",")[0] == "-" {
+			continue
+		}
+		if f.Type.Kind() == reflect.Ptr || f.Type.Kind() == reflect.Interface {
+			continue
+		}
+		a, b := ov.Field(i).Interface(), nv.Field(i).Interface()
+		if ta, ok := a.(time.Time); ok {
+			if !ta.Equal(b.(time.Time)) {
+				fields = append(fields, f.Name)
+			}
+			continue
+		}
+		if !reflect.DeepEqual(a, b) {
+			fields = append(fields, f.Name)
+		}
+	}
+	return fields
+}
@@ -0,0 +1,42 @@
+package db
+
+// Exported for the tests of package db_test.
+var (
+	FindAnlage         = findAnlage
+	MergeFraktionen    = mergeFraktionen
+	NewVorlageRevision = newVorlageRevision
+	SplitNames         = splitNames
+	Votum              = votum
+)
+
+// Counts are the counts of a vote, Known if any was given.
+type Counts struct {
+	Ja, Nein, Enthaltung int
+	Known                bool
+}
+
+func exportCounts(c counts) Counts {
+	return Counts{Ja: c.ja, Nein: c.nein, Enthaltung: c.enthaltung, Known: c.known}
+}
+
+func ParseCounts(text string) Counts {
+	return exportCounts(parseCounts(text))
+}
+
+// ParseVoteLine parses one line into the total and the votes per Fraktion in
+// the order they were named.
+func ParseVoteLine(line string) (Counts, []*FraktionsVotum) {
+	var total counts
+	var fs []*FraktionsVotum
+	byName := make(map[string]*FraktionsVotum)
+	parseVoteLine(line, &total, func(name string) *FraktionsVotum {
+		f, ok := byName[name]
+		if !ok {
+			f = &FraktionsVotum{Fraktion: name}
+			byName[name] = f
+			fs = append(fs, f)
+		}
+		return f
+	})
+	return exportCounts(total), fs
+}
@@ -0,0 +1,100 @@
+package feed
+
+import (
+	"encoding/xml"
+	"io"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const atomNS = "http://www.w3.org/2005/Atom"
+
+// Feed is an Atom (RFC 4287) feed.
+type Feed struct {
+	XMLName xml.Name `xml:"feed"`
+	Xmlns   string   `xml:"xmlns,attr"`
+	ID      string   `xml:"id"`
+	Title   string   `xml:"title"`
+	Updated string   `xml:"updated"`
+	Author  Person   `xml:"author"`
+	Links   []Link   `xml:"link"`
+	Entries []*Entry `xml:"entry"`
+}
+
+type Person struct {
+	Name string `xml:"name"`
+}
+
+type Link struct {
+	Rel  string `xml:"rel,attr,omitempty"`
+	Href string `xml:"href,attr"`
+}
+
+type Text struct {
+	Type string `xml:"type,attr,omitempty"`
+	Body string `xml:",chardata"`
+}
+
+type Category struct {
+	Term string `xml:"term,attr"`
+}
+
+type Entry struct {
+	ID         string     `xml:"id"`
+	Title      string     `xml:"title"`
+	Updated    string     `xml:"updated"`
+	Published  string     `xml:"published,omitempty"`
+	Links      []Link     `xml:"link"`
+	Categories []Category `xml:"category,omitempty"`
+	Summary    *Text      `xml:"summary,omitempty"`
+}
+
+func formatTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
+
+// updated sets the time of the feed to the newest entry.
+func (f *Feed) updated() {
+	latest := ""
+	for _, e := range f.Entries {
+		if e.Updated > latest {
+			latest = e.Updated
+		}
+	}
+	if latest == "" {
+		latest = formatTime(time.Unix(0, 0))
+	}
+	f.Updated = latest
+}
+
+// WriteTo writes the feed as XML.
+func (f *Feed) WriteTo(w io.Writer) (int64, error) {
+	var sb strings.Builder
+	sb.WriteString(xml.Header)
+	enc := xml.NewEncoder(&sb)
+	enc.Indent("", "  ")
+	err := enc.Encode(f)
+	if err != nil {
+		return 0, errors.Wrap(err, "error encoding feed "+f.ID)
+	}
+	sb.WriteString("\n")
+	n, err := io.WriteString(w, sb.String())
+	return int64(n), err
+}
+
+// WriteFile writes the feed to path.
+func (f *Feed) WriteFile(path string) error {
+	var sb strings.Builder
+	_, err := f.WriteTo(&sb)
+	if err != nil {
+		return err
+	}
+	err = ioutil.WriteFile(path, []byte(sb.String()), 0644)
+	if err != nil {
+		return errors.Wrap(err, "error writing feed "+path)
+	}
+	return nil
+}
@@ -0,0 +1,364 @@
+// Package feed builds Atom feeds of the newly created Vorlagen, the decided
+// Tops and the upcoming Termine, each optionally restricted to a Gremium or a
+// Federfuehrend.
+package feed
+
+import (
+	"fmt"
+	"html"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	allris_common "github.com/rismaster/allris-common"
+	"github.com/rismaster/allris-db/db"
+	"github.com/rismaster/allris-db/db/internal/htmltext"
+)
+
+// DefaultLimit is the number of entries of a feed.
+const DefaultLimit = 50
+
+// Permalinks are the fmt templates of the ALLRIS pages, each with one %d for
+// the VOLFDNR, SILFDNR or TOLFDNR.
+type Permalinks struct {
+	Vorlage string
+	Sitzung string
+	Top     string
+}
+
+// NewPermalinks links to the pages of the ALLRIS instance of the config.
+func NewPermalinks(config allris_common.Config) Permalinks {
+	base := func(page string) string {
+		return "https://" + path.Join(config.GetPathToParse(), page)
+	}
+	return Permalinks{
+		Vorlage: base("vo020.asp?VOLFDNR=%d"),
+		Sitzung: base("to010.asp?SILFDNR=%d"),
+		Top:     base("to020.asp?TOLFDNR=%d"),
+	}
+}
+
+// Filter restricts a feed. Gremium matches the Gremium of a Top or Termin and
+// the Gremien of the Beratungsfolge of a Vorlage, Federfuehrend matches the
+// Federfuehrend of a Vorlage or Top. Termine have no Federfuehrend, the
+// Termine feed only uses Gremium.
+type Filter struct {
+	Gremium       string
+	Federfuehrend string
+}
+
+func (f Filter) query() string {
+	v := url.Values{}
+	if f.Gremium != "" {
+		v.Set("gremium", f.Gremium)
+	}
+	if f.Federfuehrend != "" {
+		v.Set("federfuehrend", f.Federfuehrend)
+	}
+	if len(v) == 0 {
+		return ""
+	}
+	return "?" + v.Encode()
+}
+
+func (f Filter) title(title string) string {
+	var parts []string
+	for _, p := range []string{f.Gremium, f.Federfuehrend} {
+		if p != "" {
+			parts = append(parts, p)
+		}
+	}
+	if len(parts) == 0 {
+		return title
+	}
+	return title + " – " + strings.Join(parts, ", ")
+}
+
+// Builder loads the entities of the feeds from a Repository.
+type Builder struct {
+	Repo   db.Repository
+	Config allris_common.Config
+	Links  Permalinks
+	// Author is the author of the feeds, e.g. the name of the council.
+	Author string
+	Limit  int
+}
+
+func NewBuilder(repo db.Repository, config allris_common.Config) *Builder {
+	return &Builder{
+		Repo:   repo,
+		Config: config,
+		Links:  NewPermalinks(config),
+		Author: "allris-db",
+		Limit:  DefaultLimit,
+	}
+}
+
+func (b *Builder) newFeed(name string, title string, filter Filter) *Feed {
+	id := "urn:allris-db:"
+	if host := strings.Split(b.Config.GetPathToParse(), "/")[0]; host != "" {
+		id += host + ":"
+	}
+	return &Feed{
+		Xmlns:  atomNS,
+		ID:     id + name + filter.query(),
+		Title:  filter.title(title),
+		Author: Person{Name: b.Author},
+	}
+}
+
+// finish sorts, truncates and dates the feed.
+func (b *Builder) finish(f *Feed, less func(i, j int) bool) *Feed {
+	sort.SliceStable(f.Entries, less)
+	if b.Limit > 0 && len(f.Entries) > b.Limit {
+		f.Entries = f.Entries[:b.Limit]
+	}
+	f.updated()
+	return f
+}
+
+// Vorlagen is the feed of the Vorlagen, the newest DatumAngelegt first. The
+// Vorlagen are loaded in that order, Limit at a time, until the feed is full.
+func (b *Builder) Vorlagen(filter Filter) (*Feed, error) {
+
+	q := db.NewQuery(b.Config.GetEntityVorlage()).Order("-DatumAngelegt").Order("-VOLFDNR")
+	if filter.Federfuehrend != "" {
+		q = q.Filter("Federfuehrend =", filter.Federfuehrend)
+	}
+
+	var gremien map[int]map[string]bool
+	var err error
+	if filter.Gremium != "" {
+		gremien, err = b.beratungsGremien()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var vorlagen []*db.Vorlage
+	for offset := 0; ; offset += b.Limit {
+		var batch []*db.Vorlage
+		_, err = b.Repo.GetAll(q.Offset(offset).Limit(b.Limit), &batch)
+		if err != nil {
+			return nil, errors.Wrap(err, "error getting vorlagen from db")
+		}
+		for _, v := range batch {
+			if filter.Gremium == "" || gremien[v.VOLFDNR][filter.Gremium] {
+				vorlagen = append(vorlagen, v)
+			}
+		}
+		if b.Limit <= 0 || len(batch) < b.Limit || len(vorlagen) >= b.Limit {
+			break
+		}
+	}
+
+	f := b.newFeed("vorlagen", "Neue Vorlagen", filter)
+	published := make(map[*Entry]time.Time)
+	for _, v := range vorlagen {
+
+		link := fmt.Sprintf(b.Links.Vorlage, v.VOLFDNR)
+		e := &Entry{
+			ID:      link,
+			Title:   strings.TrimSpace(v.BSVV + " " + v.Betreff),
+			Updated: formatTime(v.SavedAt),
+			Links:   []Link{{Rel: "alternate", Href: link}},
+			Summary: summary(
+				"Federführend", v.Federfuehrend,
+				"Status", v.Status,
+				"Bearbeiter", v.Bearbeiter,
+			),
+		}
+		if !v.DatumAngelegt.IsZero() {
+			e.Published = formatTime(v.DatumAngelegt)
+		}
+		if v.Federfuehrend != "" {
+			e.Categories = []Category{{Term: v.Federfuehrend}}
+		}
+		published[e] = v.DatumAngelegt
+		f.Entries = append(f.Entries, e)
+	}
+
+	return b.finish(f, func(i, j int) bool {
+		pi, pj := published[f.Entries[i]], published[f.Entries[j]]
+		if pi.Equal(pj) {
+			return f.Entries[i].ID > f.Entries[j].ID
+		}
+		return pi.After(pj)
+	}), nil
+}
+
+// beratungsGremien are the Gremien of the Beratungsfolge by VOLFDNR.
+func (b *Builder) beratungsGremien() (map[int]map[string]bool, error) {
+	var tops []*db.Top
+	_, err := b.Repo.GetAll(db.NewQuery(b.Config.GetEntityTop()).Filter("VOLFDNR >", 0), &tops)
+	if err != nil {
+		return nil, errors.Wrap(err, "error getting tops from db")
+	}
+	gremien := make(map[int]map[string]bool)
+	for _, t := range tops {
+		if gremien[t.VOLFDNR] == nil {
+			gremien[t.VOLFDNR] = make(map[string]bool)
+		}
+		gremien[t.VOLFDNR][t.Gremium] = true
+	}
+	return gremien, nil
+}
+
+// Beschluesse is the feed of the Tops with a Beschluss or Beschlussart, the
+// latest Sitzung first.
+func (b *Builder) Beschluesse(filter Filter) (*Feed, error) {
+
+	q := db.NewQuery(b.Config.GetEntityTop())
+	if filter.Gremium != "" {
+		q = q.Filter("Gremium =", filter.Gremium)
+	}
+	if filter.Federfuehrend != "" {
+		q = q.Filter("Federfuehrend =", filter.Federfuehrend)
+	}
+	var tops []*db.Top
+	_, err := b.Repo.GetAll(q, &tops)
+	if err != nil {
+		return nil, errors.Wrap(err, "error getting tops from db")
+	}
+
+	f := b.newFeed("beschluesse", "Beschlüsse", filter)
+	datum := make(map[*Entry]time.Time)
+	for _, t := range tops {
+		if strings.TrimSpace(t.Beschluss) == "" && strings.TrimSpace(t.Beschlussart) == "" {
+			continue
+		}
+
+		link := fmt.Sprintf(b.Links.Top, t.TOLFDNR)
+		title := strings.TrimSpace(t.Betreff)
+		if title == "" {
+			title = strings.Join(strings.Fields("TOP "+t.Nr+" "+t.BSVV), " ")
+		}
+		if t.Beschlussart != "" {
+			title += " (" + t.Beschlussart + ")"
+		}
+		e := &Entry{
+			ID:      link,
+			Title:   title,
+			Updated: formatTime(t.SavedAt),
+			Links: []Link{
+				{Rel: "alternate", Href: link},
+				{Rel: "related", Href: fmt.Sprintf(b.Links.Sitzung, t.SILFDNR)},
+			},
+			Summary: summary(
+				"Gremium", t.Gremium,
+				"Sitzung", formatDay(t.Datum, b.Config),
+				"TOP", t.Nr,
+				"Beschlussart", t.Beschlussart,
+			),
+		}
+		if t.VOLFDNR > 0 {
+			e.Links = append(e.Links, Link{Rel: "related", Href: fmt.Sprintf(b.Links.Vorlage, t.VOLFDNR)})
+		}
+		if text := htmltext.Plain(t.Beschluss); text != "" {
+			e.Summary.Body += "<p>" + html.EscapeString(text) + "</p>"
+		}
+		if t.Gremium != "" {
+			e.Categories = []Category{{Term: t.Gremium}}
+		}
+		datum[e] = t.Datum
+		f.Entries = append(f.Entries, e)
+	}
+
+	return b.finish(f, func(i, j int) bool {
+		di, dj := datum[f.Entries[i]], datum[f.Entries[j]]
+		if di.Equal(dj) {
+			return f.Entries[i].ID < f.Entries[j].ID
+		}
+		return di.After(dj)
+	}), nil
+}
+
+// Termine is the feed of the Termine starting at or after from, the next
+// first.
+func (b *Builder) Termine(filter Filter, from time.Time) (*Feed, error) {
+
+	q := db.NewQuery(b.Config.GetEntityTermin()).Filter("Start >=", from)
+	if filter.Gremium != "" {
+		q = q.Filter("Gremium =", filter.Gremium)
+	}
+	var termine []*db.Termin
+	keys, err := b.Repo.GetAll(q, &termine)
+	if err != nil {
+		return nil, errors.Wrap(err, "error getting termine from db")
+	}
+
+	f := b.newFeed("termine", "Kommende Sitzungen", Filter{Gremium: filter.Gremium})
+	start := make(map[*Entry]time.Time)
+	for i, t := range termine {
+		var link string
+		if t.SILFDNR > 0 {
+			link = fmt.Sprintf(b.Links.Sitzung, t.SILFDNR)
+		}
+		id := link
+		if id == "" {
+			id = fmt.Sprintf("urn:allris-db:termin:%s", url.PathEscape(keys[i].Name))
+		}
+		e := &Entry{
+			ID:      id,
+			Title:   fmt.Sprintf("%s, %s", t.Gremium, formatDateTime(t.Start, b.Config)),
+			Updated: formatTime(t.SavedAt),
+			Summary: summary(
+				"Gremium", t.Gremium,
+				"Beginn", formatDateTime(t.Start, b.Config),
+			),
+		}
+		if link != "" {
+			e.Links = []Link{{Rel: "alternate", Href: link}}
+		}
+		if t.Gremium != "" {
+			e.Categories = []Category{{Term: t.Gremium}}
+		}
+		start[e] = t.Start
+		f.Entries = append(f.Entries, e)
+	}
+
+	return b.finish(f, func(i, j int) bool {
+		si, sj := start[f.Entries[i]], start[f.Entries[j]]
+		if si.Equal(sj) {
+			return f.Entries[i].ID < f.Entries[j].ID
+		}
+		return si.Before(sj)
+	}), nil
+}
+
+// summary is a html definition list of the non-empty label, value pairs.
+func summary(pairs ...string) *Text {
+	var sb strings.Builder
+	for i := 0; i+1 < len(pairs); i += 2 {
+		if strings.TrimSpace(pairs[i+1]) == "" {
+			continue
+		}
+		sb.WriteString("<p><b>" + html.EscapeString(pairs[i]) + ":</b> " + html.EscapeString(pairs[i+1]) + "</p>")
+	}
+	return &Text{Type: "html", Body: sb.String()}
+}
+
+func location(config allris_common.Config) *time.Location {
+	loc, err := time.LoadLocation(config.GetTimezone())
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
+func formatDay(t time.Time, config allris_common.Config) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.In(location(config)).Format(config.GetDateFormat())
+}
+
+func formatDateTime(t time.Time, config allris_common.Config) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.In(location(config)).Format(config.GetDateFormat() + " 15:04")
+}
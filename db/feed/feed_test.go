@@ -0,0 +1,191 @@
+package feed_test
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rismaster/allris-db/db"
+	"github.com/rismaster/allris-db/db/feed"
+	"github.com/rismaster/allris-db/db/golden"
+	"github.com/rismaster/allris-db/db/memory"
+)
+
+type config struct {
+	golden.FixtureConfig
+}
+
+func (config) GetPathToParse() string { return "ratsinfo.example.org/bi" }
+
+func day(d int) time.Time {
+	return time.Date(2024, 3, d, 0, 0, 0, 0, time.UTC)
+}
+
+// newBuilder stores six Vorlagen created on consecutive days, the odd ones
+// federführend by the Bauamt. The first is in the Beratungsfolge of the
+// Stadtrat, the fourth of the Bauausschuss, both decided.
+func newBuilder(t *testing.T) *feed.Builder {
+
+	repo := memory.New()
+	put := func(key *db.Key, src interface{}) {
+		err := repo.Put(key, src)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 1; i <= 6; i++ {
+		federfuehrend := "Kämmerei"
+		if i%2 == 1 {
+			federfuehrend = "Bauamt"
+		}
+		put(db.NameKey("Vorlage", strconv.Itoa(i), nil), &db.Vorlage{
+			VOLFDNR:       i,
+			BSVV:          "VO/" + strconv.Itoa(i),
+			Betreff:       "Vorlage " + strconv.Itoa(i),
+			Federfuehrend: federfuehrend,
+			DatumAngelegt: day(i),
+		})
+	}
+	for _, top := range []*db.Top{
+		{SILFDNR: 100, TOLFDNR: 11, VOLFDNR: 1, Gremium: "Stadtrat", Federfuehrend: "Bauamt", Datum: day(20), Betreff: "Vorlage 1",
+			Beschluss: `<p>Angenommen <script>alert(1)</script>& <a onclick="x()">mehr</a></p>`},
+		{SILFDNR: 101, TOLFDNR: 12, VOLFDNR: 4, Gremium: "Bauausschuss", Federfuehrend: "Kämmerei", Datum: day(21), Betreff: "Vorlage 4",
+			Beschlussart: "abgelehnt"},
+		{SILFDNR: 101, TOLFDNR: 13, Gremium: "Bauausschuss", Datum: day(21), Betreff: "Mitteilungen"},
+	} {
+		put(db.NameKey("Top", strconv.Itoa(top.TOLFDNR), db.NameKey("Sitzung", strconv.Itoa(top.SILFDNR), nil)), top)
+	}
+	for _, termin := range []*db.Termin{
+		{SILFDNR: 102, Gremium: "Stadtrat", Start: day(25)},
+		{SILFDNR: 103, Gremium: "Bauausschuss", Start: day(26)},
+		{Gremium: "Bauausschuss", Start: day(27)},
+		{SILFDNR: 104, Gremium: "Bauausschuss", Start: day(10)},
+	} {
+		put(termin.GetKey(config{}), termin)
+	}
+
+	b := feed.NewBuilder(repo, config{})
+	b.Limit = 2
+	return b
+}
+
+func ids(f *feed.Feed) string {
+	var ids []string
+	for _, e := range f.Entries {
+		ids = append(ids, e.ID[strings.LastIndex(e.ID, "=")+1:])
+	}
+	return strings.Join(ids, " ")
+}
+
+func TestVorlagen(t *testing.T) {
+
+	b := newBuilder(t)
+	for _, c := range []struct {
+		filter feed.Filter
+		want   string
+	}{
+		{feed.Filter{}, "6 5"},
+		{feed.Filter{Federfuehrend: "Bauamt"}, "5 3"},
+		// matched beyond the first batch
+		{feed.Filter{Gremium: "Stadtrat"}, "1"},
+		{feed.Filter{Gremium: "Bauausschuss", Federfuehrend: "Kämmerei"}, "4"},
+		{feed.Filter{Gremium: "Bauausschuss", Federfuehrend: "Bauamt"}, ""},
+	} {
+		f, err := b.Vorlagen(c.filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := ids(f); got != c.want {
+			t.Errorf("%+v: %q, want %q", c.filter, got, c.want)
+		}
+	}
+
+	b.Limit = 0
+	f, err := b.Vorlagen(feed.Filter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := ids(f); got != "6 5 4 3 2 1" {
+		t.Errorf("without limit: %q", got)
+	}
+}
+
+func TestBeschluesse(t *testing.T) {
+
+	b := newBuilder(t)
+	for _, c := range []struct {
+		filter feed.Filter
+		want   string
+	}{
+		{feed.Filter{}, "12 11"},
+		{feed.Filter{Gremium: "Stadtrat"}, "11"},
+		{feed.Filter{Federfuehrend: "Kämmerei"}, "12"},
+		{feed.Filter{Gremium: "Stadtrat", Federfuehrend: "Kämmerei"}, ""},
+	} {
+		f, err := b.Beschluesse(c.filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := ids(f); got != c.want {
+			t.Errorf("%+v: %q, want %q", c.filter, got, c.want)
+		}
+	}
+
+	f, err := b.Beschluesse(feed.Filter{Gremium: "Stadtrat"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := f.Entries[0].Summary.Body
+	if !strings.Contains(body, "<p>Angenommen") || !strings.Contains(body, "&amp; mehr</p>") {
+		t.Errorf("beschluss missing in %q", body)
+	}
+	for _, unsafe := range []string{"<script", "onclick", "<a "} {
+		if strings.Contains(body, unsafe) {
+			t.Errorf("%s in summary %q", unsafe, body)
+		}
+	}
+}
+
+func TestTermine(t *testing.T) {
+
+	b := newBuilder(t)
+	b.Limit = 0
+	f, err := b.Termine(feed.Filter{Gremium: "Bauausschuss", Federfuehrend: "ignored"}, day(20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(f.Entries) != 2 || !strings.HasSuffix(f.Entries[0].ID, "SILFDNR=103") || !strings.HasPrefix(f.Entries[1].ID, "urn:allris-db:termin:") {
+		t.Errorf("entries %+v", f.Entries)
+	}
+	if f.ID != "urn:allris-db:ratsinfo.example.org:termine?gremium=Bauausschuss" {
+		t.Errorf("id %s", f.ID)
+	}
+}
+
+func TestPermalinks(t *testing.T) {
+
+	b := newBuilder(t)
+	f, err := b.Beschluesse(feed.Filter{Gremium: "Stadtrat"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var links []string
+	for _, l := range f.Entries[0].Links {
+		links = append(links, l.Rel+" "+l.Href)
+	}
+	want := []string{
+		"alternate https://ratsinfo.example.org/bi/to020.asp?TOLFDNR=11",
+		"related https://ratsinfo.example.org/bi/to010.asp?SILFDNR=100",
+		"related https://ratsinfo.example.org/bi/vo020.asp?VOLFDNR=1",
+	}
+	if strings.Join(links, "\n") != strings.Join(want, "\n") {
+		t.Errorf("links\n%s\nwant\n%s", strings.Join(links, "\n"), strings.Join(want, "\n"))
+	}
+	if f.Entries[0].ID != "https://ratsinfo.example.org/bi/to020.asp?TOLFDNR=11" {
+		t.Errorf("id %s", f.Entries[0].ID)
+	}
+	if f.ID != "urn:allris-db:ratsinfo.example.org:beschluesse?gremium=Stadtrat" || f.Title != "Beschlüsse – Stadtrat" {
+		t.Errorf("feed %s %q", f.ID, f.Title)
+	}
+}
@@ -0,0 +1,57 @@
+package golden
+
+import "time"
+
+// FixtureConfig is the configuration the fixture pages are parsed with. Only
+// the entity names, folders, types and date formats matter for parsing.
+type FixtureConfig struct{}
+
+func (FixtureConfig) GetProxySecretHeaderKey() string        { return "" }
+func (FixtureConfig) GetProxyHostHeaderKey() string          { return "" }
+func (FixtureConfig) GetProxySecret() string                 { return "" }
+func (FixtureConfig) GetProxyUrl() string                    { return "" }
+func (FixtureConfig) GetProxyHost() string                   { return "" }
+func (FixtureConfig) GetProjectId() string                   { return "" }
+func (FixtureConfig) GetBucketFetched() string               { return "" }
+func (FixtureConfig) GetBucketBackup() string                { return "" }
+func (FixtureConfig) GetBucketOcr() string                   { return "" }
+func (FixtureConfig) GetMinAgeBeforeDownload() time.Duration { return 0 }
+func (FixtureConfig) GetHttpTimeout() time.Duration          { return 0 }
+func (FixtureConfig) GetHttpCalldelay() time.Duration        { return 0 }
+func (FixtureConfig) GetHttpVersuche() int                   { return 0 }
+func (FixtureConfig) GetHttpWithproxy() bool                 { return false }
+func (FixtureConfig) GetHttpWartezeitonretry() time.Duration { return 0 }
+func (FixtureConfig) GetTimezone() string                    { return "Europe/Berlin" }
+func (FixtureConfig) GetDateFormat() string                  { return "02.01.2006" }
+func (FixtureConfig) GetDateFormatWithTime() string          { return "02.01.2006 15:04:05" }
+func (FixtureConfig) GetDateFormatTech() string              { return "2006-01-02-15-04" }
+func (FixtureConfig) GetPathToParse() string                 { return "" }
+func (FixtureConfig) GetTargetToParse() string               { return "" }
+func (FixtureConfig) GetEntityTop() string                   { return "Top" }
+func (FixtureConfig) GetEntityAnlage() string                { return "Anlage" }
+func (FixtureConfig) GetEntitySitzung() string               { return "Sitzung" }
+func (FixtureConfig) GetEntityVorlage() string               { return "Vorlage" }
+func (FixtureConfig) GetEntityTermin() string                { return "Termin" }
+func (FixtureConfig) GetTopFolder() string                   { return "tops/" }
+func (FixtureConfig) GetSitzungenFolder() string             { return "sitzungen/" }
+func (FixtureConfig) GetVorlagenFolder() string              { return "vorlagen/" }
+func (FixtureConfig) GetAnlagenFolder() string               { return "anlagen/" }
+func (FixtureConfig) GetTopType() string                     { return "top" }
+func (FixtureConfig) GetSitzungType() string                 { return "sitzung" }
+func (FixtureConfig) GetVorlageType() string                 { return "vorlage" }
+func (FixtureConfig) GetAnlageType() string                  { return "anlage" }
+func (FixtureConfig) GetAnlageDocumentType() string          { return "basisanlage" }
+func (FixtureConfig) GetAlleSitzungenType() string           { return "si010" }
+func (FixtureConfig) GetGremienListeType() string            { return "" }
+func (FixtureConfig) GetGremienOptionsType() string          { return "" }
+func (FixtureConfig) GetVorlagenListeType() string           { return "" }
+func (FixtureConfig) GetUrlAnlagedoc() string                { return "do027.asp" }
+func (FixtureConfig) GetUrlSitzungsLangeliste() string       { return "" }
+func (FixtureConfig) GetUrlSitzungsliste() string            { return "" }
+func (FixtureConfig) GetUrlSitzungTmpl() string              { return "" }
+func (FixtureConfig) GetUrlVorlagenliste() string            { return "" }
+func (FixtureConfig) GetUrlVorlageTmpl() string              { return "" }
+func (FixtureConfig) GetDownloadTopic() string               { return "" }
+func (FixtureConfig) GetDebug() bool                         { return false }
+func (FixtureConfig) GetMailGunDomain() string               { return "" }
+func (FixtureConfig) GetMailGunApiString() string            { return "" }
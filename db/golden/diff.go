@@ -0,0 +1,49 @@
+package golden
+
+import (
+	"fmt"
+	"strings"
+)
+
+// diff returns a line diff from want to got, prefixing removed lines with
+// "-" and added lines with "+". Unchanged lines are left out, the line
+// numbers refer to want.
+func diff(want string, got string) string {
+
+	a := strings.Split(want, "\n")
+	b := strings.Split(got, "\n")
+
+	// lcs[i][j] is the length of the longest common subsequence of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var sb strings.Builder
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			i++
+			j++
+		case j < len(b) && (i == len(a) || lcs[i][j+1] >= lcs[i+1][j]):
+			sb.WriteString(fmt.Sprintf("%4d +%s\n", i+1, b[j]))
+			j++
+		default:
+			sb.WriteString(fmt.Sprintf("%4d -%s\n", i+1, a[i]))
+			i++
+		}
+	}
+	return sb.String()
+}
@@ -0,0 +1,189 @@
+// Package golden runs the ALLRIS parsers on the pages of a fixture directory
+// and compares the results, serialized as JSON, with the .golden.json file
+// next to each page. If the scraper or the ALLRIS markup changes, regenerate
+// the golden files with go test ./db/golden -update and review their diff
+// like code.
+//
+// The page kind and the ids are taken from the file name, like in the
+// fetched bucket: sitzung-<SILFDNR>.html, sitzung-<SILFDNR>-top-<TOLFDNR>.html,
+// vorlage-<VOLFDNR>.html and <alle sitzungen type>.html for the list of
+// Termine (si010).
+package golden
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	allris_common "github.com/rismaster/allris-common"
+	"github.com/rismaster/allris-db/db"
+)
+
+const goldenExt = ".golden.json"
+
+var (
+	regexSitzung = regexp.MustCompile(`^sitzung-([0-9]+)\.html$`)
+	regexTop     = regexp.MustCompile(`^sitzung-([0-9]+)-top-([0-9]+)\.html$`)
+	regexVorlage = regexp.MustCompile(`^vorlage-([0-9]+)\.html$`)
+)
+
+// Result is the outcome for one fixture page. Diff is empty if the parsed
+// page matches its golden file.
+type Result struct {
+	Page    string
+	Golden  string
+	Diff    string
+	Updated bool
+}
+
+func (r Result) Failed() bool {
+	return r.Diff != "" && !r.Updated
+}
+
+type sitzungPage struct {
+	Sitzung *db.Sitzung
+	Tops    []*db.Top
+	Anlagen []*db.Anlage
+}
+
+type topPage struct {
+	Top        *db.Top
+	Anlagen    []*db.Anlage
+	Abstimmung *db.Abstimmung
+}
+
+type vorlagePage struct {
+	Vorlage        *db.Vorlage
+	Beratungsfolge []*db.Top
+	Anlagen        []*db.Anlage
+}
+
+type terminePage struct {
+	Termine []db.Termin
+}
+
+// Run renders every .html page in dir and compares it with its golden file.
+// With update, missing or differing golden files are (re)written instead.
+func Run(dir string, config allris_common.Config, update bool) ([]Result, error) {
+
+	pages, err := filepath.Glob(filepath.Join(dir, "*.html"))
+	if err != nil {
+		return nil, errors.Wrap(err, "error listing fixtures in "+dir)
+	}
+	sort.Strings(pages)
+
+	var results []Result
+	for _, page := range pages {
+
+		content, err := ioutil.ReadFile(page)
+		if err != nil {
+			return nil, errors.Wrap(err, "error reading fixture "+page)
+		}
+		got, err := Render(filepath.Base(page), content, config)
+		if err != nil {
+			return nil, errors.Wrap(err, "error rendering fixture "+page)
+		}
+
+		res := Result{
+			Page:   page,
+			Golden: strings.TrimSuffix(page, ".html") + goldenExt,
+		}
+		want, err := ioutil.ReadFile(res.Golden)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, errors.Wrap(err, "error reading golden file "+res.Golden)
+		}
+		if !bytes.Equal(got, want) {
+			res.Diff = diff(string(want), string(got))
+			if update {
+				err = ioutil.WriteFile(res.Golden, got, 0644)
+				if err != nil {
+					return nil, errors.Wrap(err, "error writing golden file "+res.Golden)
+				}
+				res.Updated = true
+			}
+		}
+		results = append(results, res)
+	}
+	return results, nil
+}
+
+// Render parses a page with the parser matching its file name and returns
+// the result as indented JSON. SavedAt is cleared, it is the parse time.
+func Render(name string, content []byte, config allris_common.Config) ([]byte, error) {
+
+	var page interface{}
+	if m := regexTop.FindStringSubmatch(name); m != nil {
+		silfdnr, _ := strconv.Atoi(m[1])
+		tolfdnr, _ := strconv.Atoi(m[2])
+		top, anlagen, err := db.ParseTopHTML(bytes.NewReader(content), silfdnr, tolfdnr, config)
+		if err != nil {
+			return nil, err
+		}
+		top.SavedAt = time.Time{}
+		clearAnlagen(anlagen)
+		page = topPage{Top: top, Anlagen: anlagen, Abstimmung: top.GetAbstimmung()}
+	} else if m := regexSitzung.FindStringSubmatch(name); m != nil {
+		silfdnr, _ := strconv.Atoi(m[1])
+		sitzung, tops, anlagen, err := db.ParseSitzungHTML(bytes.NewReader(content), silfdnr, config)
+		if err != nil {
+			return nil, err
+		}
+		sitzung.SavedAt = time.Time{}
+		clearTops(tops)
+		clearAnlagen(anlagen)
+		page = sitzungPage{Sitzung: sitzung, Tops: tops, Anlagen: anlagen}
+	} else if m := regexVorlage.FindStringSubmatch(name); m != nil {
+		volfdnr, _ := strconv.Atoi(m[1])
+		vorlage, beratungsfolge, anlagen, err := db.ParseVorlageHTML(bytes.NewReader(content), volfdnr, config)
+		if err != nil {
+			return nil, err
+		}
+		vorlage.SavedAt = time.Time{}
+		clearTops(beratungsfolge)
+		clearAnlagen(anlagen)
+		page = vorlagePage{Vorlage: vorlage, Beratungsfolge: beratungsfolge, Anlagen: anlagen}
+	} else if name == config.GetAlleSitzungenType()+".html" {
+		termine, err := db.ParseTermineHTML(bytes.NewReader(content), config)
+		if err != nil {
+			return nil, err
+		}
+		for i := range termine {
+			termine[i].SavedAt = time.Time{}
+		}
+		page = terminePage{Termine: termine}
+	} else {
+		return nil, fmt.Errorf("no parser for file name %s", name)
+	}
+
+	// html stays readable in the golden files
+	var out bytes.Buffer
+	enc := json.NewEncoder(&out)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	err := enc.Encode(page)
+	if err != nil {
+		return nil, errors.Wrap(err, "error marshal "+name)
+	}
+	return out.Bytes(), nil
+}
+
+func clearTops(tops []*db.Top) {
+	for _, t := range tops {
+		t.SavedAt = time.Time{}
+	}
+}
+
+func clearAnlagen(anlagen []*db.Anlage) {
+	for _, a := range anlagen {
+		a.SavedAt = time.Time{}
+	}
+}
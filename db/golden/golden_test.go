@@ -0,0 +1,32 @@
+package golden
+
+import (
+	"flag"
+	"path/filepath"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "write the golden files instead of comparing")
+
+// go test ./db/golden -update regenerates the golden files.
+func TestGolden(t *testing.T) {
+
+	results, err := Run("testdata", FixtureConfig{}, *update)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if len(results) == 0 {
+		t.Fatal("no fixture pages in testdata")
+	}
+	for _, r := range results {
+		r := r
+		t.Run(filepath.Base(r.Page), func(t *testing.T) {
+			switch {
+			case r.Updated:
+				t.Logf("updated %s", r.Golden)
+			case r.Failed():
+				t.Errorf("differs from %s, rerun with -update if intended\n%s", r.Golden, r.Diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,278 @@
+// Package ical exports the stored Termine as iCalendar (RFC 5545) feeds, one
+// with all Termine and one per Gremium, for subscription in calendar apps.
+package ical
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kennygrant/sanitize"
+	"github.com/pkg/errors"
+	allris_common "github.com/rismaster/allris-common"
+	"github.com/rismaster/allris-db/db"
+)
+
+const prodID = "-//rismaster//allris-db//DE"
+
+// AllFile is the file name of the calendar with all Termine.
+const AllFile = "termine.ics"
+
+// Event is one VEVENT.
+type Event struct {
+	UID         string
+	Stamp       time.Time
+	Start       time.Time
+	End         time.Time
+	Summary     string
+	Location    string
+	Description string
+	Cancelled   bool
+}
+
+// Calendar is one .ics feed.
+type Calendar struct {
+	Name   string
+	Events []Event
+}
+
+// Feeds are the calendar of all Termine and one per Gremium.
+type Feeds struct {
+	All     *Calendar
+	Gremien map[string]*Calendar
+}
+
+// Export loads the Termine starting at or after from together with their
+// Sitzung and Tops and builds the feeds.
+func Export(repo db.Repository, config allris_common.Config, from time.Time) (*Feeds, error) {
+
+	var termine []db.Termin
+	keys, err := repo.GetAll(db.NewQuery(config.GetEntityTermin()).Filter("Start >=", from), &termine)
+	if err != nil {
+		return nil, errors.Wrap(err, "error getting termine from db")
+	}
+
+	feeds := &Feeds{
+		All:     &Calendar{Name: "Sitzungstermine"},
+		Gremien: make(map[string]*Calendar),
+	}
+	host := uidHost(config)
+	for i, termin := range termine {
+
+		event, err := newEvent(repo, config, keys[i], termin, host)
+		if err != nil {
+			return nil, err
+		}
+
+		feeds.All.Events = append(feeds.All.Events, event)
+		cal, exist := feeds.Gremien[termin.Gremium]
+		if !exist {
+			cal = &Calendar{Name: termin.Gremium}
+			feeds.Gremien[termin.Gremium] = cal
+		}
+		cal.Events = append(cal.Events, event)
+	}
+
+	feeds.All.sort()
+	for _, cal := range feeds.Gremien {
+		cal.sort()
+	}
+	return feeds, nil
+}
+
+func newEvent(repo db.Repository, config allris_common.Config, key *db.Key, termin db.Termin, host string) (Event, error) {
+
+	event := Event{
+		UID:     uid(key, termin, host),
+		Stamp:   termin.SavedAt,
+		Start:   termin.Start,
+		End:     termin.End,
+		Summary: termin.Gremium,
+	}
+	if termin.SILFDNR == 0 {
+		return event, nil
+	}
+
+	sitzungKey := db.NameKey(config.GetEntitySitzung(), strconv.Itoa(termin.SILFDNR), nil)
+	var sitzung db.Sitzung
+	err := repo.Get(sitzungKey, &sitzung)
+	if err == db.ErrNoSuchEntity {
+		return event, nil
+	}
+	if err != nil {
+		return event, errors.Wrap(err, fmt.Sprintf("error getting sitzung %d from db", termin.SILFDNR))
+	}
+
+	if strings.TrimSpace(sitzung.Title) != "" {
+		event.Summary = fmt.Sprintf("%s: %s", termin.Gremium, sitzung.Title)
+	}
+	var location []string
+	for _, l := range []string{sitzung.Raum, sitzung.Ort} {
+		if strings.TrimSpace(l) != "" {
+			location = append(location, strings.TrimSpace(l))
+		}
+	}
+	event.Location = strings.Join(location, ", ")
+	event.Cancelled = db.IsCancelled(sitzung.Status)
+
+	var tops []*db.Top
+	_, err = repo.GetAll(db.NewQuery(config.GetEntityTop()).WithAncestor(sitzungKey), &tops)
+	if err != nil {
+		return event, errors.Wrap(err, fmt.Sprintf("error getting tops of sitzung %d from db", termin.SILFDNR))
+	}
+	sort.SliceStable(tops, func(i, j int) bool {
+		return tops[i].IndexTop < tops[j].IndexTop
+	})
+	var agenda []string
+	for _, t := range tops {
+		agenda = append(agenda, strings.TrimSpace(t.Nr+" "+t.Betreff))
+	}
+	event.Description = strings.Join(agenda, "\n")
+
+	return event, nil
+}
+
+// uid identifies the event of a Termin across updates. A Termin of a Sitzung
+// is identified by the SILFDNR, not by its key, so the event keeps its UID
+// when the Termin is rescheduled or was stored under its former Gremium_Start
+// key.
+func uid(key *db.Key, termin db.Termin, host string) string {
+	if termin.SILFDNR > 0 {
+		return fmt.Sprintf("sitzung-%d@%s", termin.SILFDNR, host)
+	}
+	return fmt.Sprintf("%s@%s", key.Name, host)
+}
+
+// uidHost is the host of the ALLRIS instance, it makes the UIDs globally unique.
+func uidHost(config allris_common.Config) string {
+	host := strings.TrimPrefix(strings.TrimPrefix(config.GetPathToParse(), "https://"), "http://")
+	host = strings.Split(host, "/")[0]
+	if host == "" {
+		return "allris-db"
+	}
+	return host
+}
+
+func (c *Calendar) sort() {
+	sort.SliceStable(c.Events, func(i, j int) bool {
+		if c.Events[i].Start.Equal(c.Events[j].Start) {
+			return c.Events[i].UID < c.Events[j].UID
+		}
+		return c.Events[i].Start.Before(c.Events[j].Start)
+	})
+}
+
+// FileName is the file name of the calendar of a Gremium.
+func FileName(gremium string) string {
+	return "gremium-" + strings.ToLower(sanitize.BaseName(gremium)) + ".ics"
+}
+
+// WriteFiles writes the feeds to dir, see AllFile and FileName.
+func (f *Feeds) WriteFiles(dir string) error {
+
+	err := writeFile(filepath.Join(dir, AllFile), f.All)
+	if err != nil {
+		return err
+	}
+	for gremium, cal := range f.Gremien {
+		err = writeFile(filepath.Join(dir, FileName(gremium)), cal)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeFile(path string, cal *Calendar) error {
+	var sb strings.Builder
+	_, err := cal.WriteTo(&sb)
+	if err != nil {
+		return err
+	}
+	err = ioutil.WriteFile(path, []byte(sb.String()), 0644)
+	if err != nil {
+		return errors.Wrap(err, "error writing calendar "+path)
+	}
+	return nil
+}
+
+// WriteTo writes the calendar in iCalendar format.
+func (c *Calendar) WriteTo(w io.Writer) (int64, error) {
+
+	e := &encoder{w: w}
+	e.line("BEGIN", "VCALENDAR")
+	e.line("VERSION", "2.0")
+	e.line("PRODID", prodID)
+	e.line("CALSCALE", "GREGORIAN")
+	e.line("METHOD", "PUBLISH")
+	e.line("X-WR-CALNAME", escape(c.Name))
+	for _, ev := range c.Events {
+		e.line("BEGIN", "VEVENT")
+		e.line("UID", escape(ev.UID))
+		e.line("DTSTAMP", formatTime(ev.Stamp))
+		e.line("DTSTART", formatTime(ev.Start))
+		if ev.End.After(ev.Start) {
+			e.line("DTEND", formatTime(ev.End))
+		}
+		e.line("SUMMARY", escape(ev.Summary))
+		if ev.Location != "" {
+			e.line("LOCATION", escape(ev.Location))
+		}
+		if ev.Description != "" {
+			e.line("DESCRIPTION", escape(ev.Description))
+		}
+		if ev.Cancelled {
+			e.line("STATUS", "CANCELLED")
+		} else {
+			e.line("STATUS", "CONFIRMED")
+		}
+		e.line("END", "VEVENT")
+	}
+	e.line("END", "VCALENDAR")
+	return e.n, e.err
+}
+
+func formatTime(t time.Time) string {
+	return t.UTC().Format("20060102T150405Z")
+}
+
+var escaper = strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`)
+
+func escape(text string) string {
+	return escaper.Replace(text)
+}
+
+type encoder struct {
+	w   io.Writer
+	n   int64
+	err error
+}
+
+// line writes a content line folded after 75 octets without splitting
+// UTF-8 sequences.
+func (e *encoder) line(name string, value string) {
+	if e.err != nil {
+		return
+	}
+	l := name + ":" + value
+	var sb strings.Builder
+	width := 0
+	for _, r := range l {
+		size := len(string(r))
+		if width+size > 75 {
+			sb.WriteString("\r\n ")
+			width = 1
+		}
+		sb.WriteRune(r)
+		width += size
+	}
+	sb.WriteString("\r\n")
+	n, err := io.WriteString(e.w, sb.String())
+	e.n += int64(n)
+	e.err = err
+}
@@ -0,0 +1,215 @@
+package ical_test
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/rismaster/allris-db/db"
+	"github.com/rismaster/allris-db/db/golden"
+	"github.com/rismaster/allris-db/db/ical"
+	"github.com/rismaster/allris-db/db/internal/dbtest"
+	"github.com/rismaster/allris-db/db/memory"
+)
+
+var berlin, _ = time.LoadLocation("Europe/Berlin")
+
+var from = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// write returns the calendar as written by WriteTo and checks the folding of
+// its lines.
+func write(t *testing.T, cal *ical.Calendar) string {
+	t.Helper()
+	var sb strings.Builder
+	n, err := cal.WriteTo(&sb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := sb.String()
+	if n != int64(len(out)) {
+		t.Errorf("wrote %d bytes, counted %d", len(out), n)
+	}
+	if !strings.HasSuffix(out, "\r\n") {
+		t.Error("last line not terminated by CRLF")
+	}
+	for _, l := range strings.Split(strings.TrimSuffix(out, "\r\n"), "\r\n") {
+		if len(l) > 75 {
+			t.Errorf("line of %d octets: %q", len(l), l)
+		}
+		if !utf8.ValidString(l) {
+			t.Errorf("utf-8 sequence split: %q", l)
+		}
+	}
+	return out
+}
+
+// unfold joins the folded lines.
+func unfold(out string) []string {
+	return strings.Split(strings.ReplaceAll(out, "\r\n ", ""), "\r\n")
+}
+
+func property(lines []string, name string) []string {
+	var values []string
+	for _, l := range lines {
+		if strings.HasPrefix(l, name+":") {
+			values = append(values, strings.TrimPrefix(l, name+":"))
+		}
+	}
+	return values
+}
+
+func TestEscape(t *testing.T) {
+
+	cal := &ical.Calendar{Name: "Rat, Ausschüsse", Events: []ical.Event{{
+		UID:         "1@example.org",
+		Summary:     `Haushalt; Teil 1, Anlage C:\Daten`,
+		Description: "Ö 1 Eröffnung\r\nÖ 2 Haushalt\nN 3 Verträge",
+	}}}
+	lines := unfold(write(t, cal))
+	for name, want := range map[string]string{
+		"X-WR-CALNAME": `Rat\, Ausschüsse`,
+		"SUMMARY":      `Haushalt\; Teil 1\, Anlage C:\\Daten`,
+		"DESCRIPTION":  `Ö 1 Eröffnung\nÖ 2 Haushalt\nN 3 Verträge`,
+		"STATUS":       "CONFIRMED",
+	} {
+		if got := property(lines, name); len(got) != 1 || got[0] != want {
+			t.Errorf("%s: %q, want %q", name, got, want)
+		}
+	}
+	if got := property(lines, "LOCATION"); len(got) != 0 {
+		t.Errorf("empty location written: %q", got)
+	}
+}
+
+func TestFolding(t *testing.T) {
+
+	// 2 octets per ü, the fold must not split them
+	summary := strings.Repeat("Ausschuss für Umwelt ", 10)
+	cal := &ical.Calendar{Name: "x", Events: []ical.Event{{UID: "1@example.org", Summary: summary}}}
+	out := write(t, cal)
+	if !strings.Contains(out, "\r\n ") {
+		t.Fatal("long line not folded")
+	}
+	if got := property(unfold(out), "SUMMARY"); len(got) != 1 || got[0] != summary {
+		t.Errorf("unfolded %q", got)
+	}
+}
+
+// env syncs the Termine of si010 and the Sitzung 1001 with its Tops.
+func env(t *testing.T, repo db.Repository) (*db.Env, string) {
+	env, dir := dbtest.Env(t, repo)
+	content, err := ioutil.ReadFile(filepath.Join("..", "golden", "testdata", "si010.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(filepath.Join(dir, "si010.html"), content, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return env, dir
+}
+
+func updateTermine(t *testing.T, env *db.Env) {
+	t.Helper()
+	err := db.UpdateTermine(env, from)
+	if err != nil {
+		t.Fatalf("update termine: %+v", err)
+	}
+}
+
+func export(t *testing.T, repo db.Repository) *ical.Feeds {
+	t.Helper()
+	feeds, err := ical.Export(repo, golden.FixtureConfig{}, from)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return feeds
+}
+
+func TestExport(t *testing.T) {
+
+	repo := memory.New()
+	env, _ := env(t, repo)
+	updateTermine(t, env)
+	dbtest.MustSync(t, db.UpdateSitzung, env, "sitzungen/sitzung-1001.html")
+
+	feeds := export(t, repo)
+	if len(feeds.All.Events) != 3 || len(feeds.Gremien) != 3 {
+		t.Fatalf("%d events in %d gremien", len(feeds.All.Events), len(feeds.Gremien))
+	}
+	lines := unfold(write(t, feeds.Gremien["Ausschuss für Umwelt und Verkehr"]))
+	for name, want := range map[string]string{
+		"UID":         "sitzung-1001@allris-db",
+		"DTSTART":     "20240314T170000Z",
+		"DTEND":       "20240314T193000Z",
+		"SUMMARY":     "Ausschuss für Umwelt und Verkehr: 12. Sitzung des Ausschusses für Umwelt und Verkehr",
+		"LOCATION":    `Sitzungssaal 1\, Rathaus\, Marktplatz 1`,
+		"DESCRIPTION": `Ö 1 Eröffnung der Sitzung und Feststellung der Beschlussfähigkeit\nÖ 2 Radverkehrskonzept Innenstadt\nN 3 Grundstücksangelegenheiten`,
+		"STATUS":      "CONFIRMED",
+	} {
+		if got := property(lines, name); len(got) != 1 || got[0] != want {
+			t.Errorf("%s: %q, want %q", name, got, want)
+		}
+	}
+
+	// without a stored Sitzung the event has the Gremium only
+	lines = unfold(write(t, feeds.Gremien["Stadtrat"]))
+	if got := property(lines, "SUMMARY"); len(got) != 1 || got[0] != "Stadtrat" {
+		t.Errorf("summary %q", got)
+	}
+	if got := property(lines, "DESCRIPTION"); len(got) != 0 {
+		t.Errorf("description %q", got)
+	}
+	if got := property(lines, "DTEND"); len(got) != 0 {
+		t.Errorf("end without end time %q", got)
+	}
+}
+
+func TestStableUID(t *testing.T) {
+
+	repo := memory.New()
+	// the Termin of 1001 stored under the Gremium_Start key of former versions
+	legacy := db.Termin{Gremium: "Ausschuss für Umwelt und Verkehr", Start: time.Date(2024, 3, 14, 18, 0, 0, 0, berlin)}
+	key := legacy.GetKey(golden.FixtureConfig{})
+	legacy.SILFDNR = 1001
+	err := repo.Put(key, &legacy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	uids := func() map[string]string {
+		byGremium := make(map[string]string)
+		for gremium, cal := range export(t, repo).Gremien {
+			byGremium[gremium] = cal.Events[0].UID
+		}
+		return byGremium
+	}
+	before := uids()["Ausschuss für Umwelt und Verkehr"]
+
+	env, dir := env(t, repo)
+	updateTermine(t, env)
+	after := uids()
+	if got := after["Ausschuss für Umwelt und Verkehr"]; got != before {
+		t.Errorf("uid changed with the key: %s, before %s", got, before)
+	}
+
+	// rescheduled Termine keep their UID, also without SILFDNR as their key is
+	// the same in both versions
+	content, err := ioutil.ReadFile(filepath.Join(dir, "si010.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(filepath.Join(dir, "si010.html"), []byte(strings.ReplaceAll(string(content), "14.03.2024", "21.03.2024")), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	updateTermine(t, env)
+	moved := uids()
+	for gremium, uid := range after {
+		if moved[gremium] != uid {
+			t.Errorf("%s: uid %s after the move, before %s", gremium, moved[gremium], uid)
+		}
+	}
+}
@@ -0,0 +1,189 @@
+package db
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rismaster/allris-common/common/files"
+)
+
+// The invariants CheckIntegrity checks.
+const (
+	// CheckTopSitzung: every Top has its Sitzung as parent, with the SILFDNR
+	// of the key, and is keyed by its TOLFDNR.
+	CheckTopSitzung = "top-sitzung"
+	// CheckTopDatum: a Top is on the day of its Sitzung.
+	CheckTopDatum = "top-datum"
+	// CheckIndexTop: the IndexTop of the Tops of a Sitzung are contiguous.
+	CheckIndexTop = "index-top"
+	// CheckBeratung: every Beratung on the page of a Vorlage is a stored Top
+	// with the same SILFDNR, TOLFDNR and VOLFDNR.
+	CheckBeratung = "beratung"
+	// CheckTerminSitzung: the SILFDNR of a Termin is a stored Sitzung.
+	CheckTerminSitzung = "termin-sitzung"
+)
+
+// Violation is a broken invariant of an entity, Check is one of the Check*
+// constants.
+type Violation struct {
+	Check   string
+	Key     string
+	Message string
+}
+
+// IntegrityReport is the result of CheckIntegrity. Checked counts the
+// entities by kind, Unchecked are the Vorlage files that couldn't be read or
+// parsed to check their Beratungen.
+type IntegrityReport struct {
+	Checked    map[string]int
+	Violations []*Violation
+	Unchecked  []string
+}
+
+// OK reports if no invariant is broken and every Vorlage was checked, a
+// file that couldn't be read may hide violations.
+func (r *IntegrityReport) OK() bool {
+	return len(r.Violations) == 0 && len(r.Unchecked) == 0
+}
+
+func (r *IntegrityReport) violated(check string, key *Key, format string, args ...interface{}) {
+	r.Violations = append(r.Violations, &Violation{Check: check, Key: key.String(), Message: fmt.Sprintf(format, args...)})
+}
+
+// CheckIntegrity walks the stored Sitzungen, Tops, Vorlagen and Termine and
+// reports the broken invariants, see the Check* constants. It only reads, use
+// Sweep to repair. The Beratungen are read from the Vorlage files of
+// env.Source. Errors are those of the Repository, the violations are in the
+// report.
+func CheckIntegrity(env *Env) (*IntegrityReport, error) {
+
+	config := env.App.Config
+	repo := env.Repo
+	report := &IntegrityReport{Checked: make(map[string]int)}
+
+	var sitzungen []*Sitzung
+	sitzungKeys, err := repo.GetAll(NewQuery(config.GetEntitySitzung()), &sitzungen)
+	if err != nil {
+		return nil, errors.Wrap(err, "error getting sitzungen from db")
+	}
+	report.Checked[config.GetEntitySitzung()] = len(sitzungen)
+	bySILFDNR := make(map[int]*Sitzung)
+	for i, s := range sitzungen {
+		bySILFDNR[atoi(sitzungKeys[i].Name)] = s
+	}
+
+	var tops []*Top
+	topKeys, err := repo.GetAll(NewQuery(config.GetEntityTop()), &tops)
+	if err != nil {
+		return nil, errors.Wrap(err, "error getting tops from db")
+	}
+	report.Checked[config.GetEntityTop()] = len(tops)
+	loc, err := time.LoadLocation(config.GetTimezone())
+	if err != nil {
+		loc = time.UTC
+	}
+	topsBySitzung := make(map[int][]int)
+	topByKey := make(map[string]*Top)
+	for i, t := range tops {
+		key := topKeys[i]
+		topByKey[key.Encode()] = t
+		if key.Parent == nil || key.Parent.Kind != config.GetEntitySitzung() {
+			report.violated(CheckTopSitzung, key, "no sitzung parent")
+			continue
+		}
+		silfdnr := atoi(key.Parent.Name)
+		if t.SILFDNR != silfdnr {
+			report.violated(CheckTopSitzung, key, "SILFDNR %d differs from the sitzung %d of the key", t.SILFDNR, silfdnr)
+		}
+		if strconv.Itoa(t.TOLFDNR) != key.Name {
+			report.violated(CheckTopSitzung, key, "TOLFDNR %d differs from the key", t.TOLFDNR)
+		}
+		s, ok := bySILFDNR[silfdnr]
+		if !ok {
+			report.violated(CheckTopSitzung, key, "sitzung %d not stored", silfdnr)
+			continue
+		}
+		topsBySitzung[silfdnr] = append(topsBySitzung[silfdnr], i)
+		if !t.Datum.IsZero() && !s.Datum.IsZero() {
+			if td, sd := t.Datum.In(loc).Format("2006-01-02"), s.Datum.In(loc).Format("2006-01-02"); td != sd {
+				report.violated(CheckTopDatum, key, "datum %s differs from the sitzung on %s", td, sd)
+			}
+		}
+	}
+
+	for silfdnr, indexes := range topsBySitzung {
+		var index []int
+		for _, i := range indexes {
+			index = append(index, tops[i].IndexTop)
+		}
+		sort.Ints(index)
+		for j := 1; j < len(index); j++ {
+			if index[j] != index[j-1]+1 {
+				report.violated(CheckIndexTop, NameKey(config.GetEntitySitzung(), strconv.Itoa(silfdnr), nil),
+					"IndexTop %d follows %d", index[j], index[j-1])
+				break
+			}
+		}
+	}
+
+	vorlageKeys, err := repo.GetAll(NewQuery(config.GetEntityVorlage()).KeysOnly(), nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "error getting vorlagen from db")
+	}
+	report.Checked[config.GetEntityVorlage()] = len(vorlageKeys)
+	for _, vk := range vorlageKeys {
+		file := files.NewFileFromStore(env.App, config.GetVorlagenFolder(), "vorlage-"+vk.Name+".html")
+		content, err := env.Source.ReadFile(file)
+		if err != nil {
+			report.Unchecked = append(report.Unchecked, file.GetPath())
+			continue
+		}
+		_, beratungen, _, err := ParseVorlageHTML(bytes.NewReader(content), atoi(vk.Name), config)
+		if err != nil {
+			report.Unchecked = append(report.Unchecked, file.GetPath())
+			continue
+		}
+		for _, b := range beratungen {
+			if b.SILFDNR <= 0 || b.TOLFDNR <= 0 {
+				continue
+			}
+			t, ok := topByKey[b.GetKey().Encode()]
+			switch {
+			case !ok:
+				report.violated(CheckBeratung, vk, "beratung %d/%d is no stored top", b.SILFDNR, b.TOLFDNR)
+			case t.VOLFDNR != b.VOLFDNR:
+				report.violated(CheckBeratung, vk, "top %d/%d refers to vorlage %d", b.SILFDNR, b.TOLFDNR, t.VOLFDNR)
+			}
+		}
+	}
+
+	var termine []*Termin
+	terminKeys, err := repo.GetAll(NewQuery(config.GetEntityTermin()), &termine)
+	if err != nil {
+		return nil, errors.Wrap(err, "error getting termine from db")
+	}
+	report.Checked[config.GetEntityTermin()] = len(termine)
+	for i, t := range termine {
+		if _, ok := bySILFDNR[t.SILFDNR]; t.SILFDNR > 0 && !ok {
+			report.violated(CheckTerminSitzung, terminKeys[i], "sitzung %d not stored", t.SILFDNR)
+		}
+	}
+
+	sort.SliceStable(report.Violations, func(i, j int) bool {
+		if report.Violations[i].Check != report.Violations[j].Check {
+			return report.Violations[i].Check < report.Violations[j].Check
+		}
+		return report.Violations[i].Key < report.Violations[j].Key
+	})
+	sort.Strings(report.Unchecked)
+	return report, nil
+}
+
+func atoi(s string) int {
+	n, _ := strconv.Atoi(s)
+	return n
+}
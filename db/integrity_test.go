@@ -0,0 +1,73 @@
+package db_test
+
+import (
+	"testing"
+
+	"github.com/rismaster/allris-db/db"
+	"github.com/rismaster/allris-db/db/internal/dbtest"
+	"github.com/rismaster/allris-db/db/memory"
+)
+
+func TestCheckIntegrity(t *testing.T) {
+
+	repo := memory.New()
+	env, _ := dbtest.Env(t, repo)
+	for _, file := range []string{"sitzungen/sitzung-1001.html", "sitzungen/sitzung-1002.html"} {
+		dbtest.MustSync(t, db.UpdateSitzung, env, file)
+	}
+	for _, file := range []string{"vorlagen/vorlage-2001.html", "vorlagen/vorlage-2002.html"} {
+		dbtest.MustSync(t, db.UpdateVorlage, env, file)
+	}
+	err := repo.Put(db.NameKey("Termin", "sitzung-1001", nil), &db.Termin{Gremium: "Bauausschuss", SILFDNR: 1001})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := db.CheckIntegrity(env)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.OK() {
+		t.Fatalf("synced fixtures: %+v %v", report.Violations, report.Unchecked)
+	}
+	if report.Checked["Top"] != 5 || report.Checked["Termin"] != 1 {
+		t.Errorf("checked %v", report.Checked)
+	}
+
+	// a gap after the first Top of 1001 and a Termin of a Sitzung not stored
+	sitzung := db.NameKey("Sitzung", "1001", nil)
+	var top db.Top
+	err = repo.Get(db.NameKey("Top", "5001", sitzung), &top)
+	if err != nil {
+		t.Fatal(err)
+	}
+	top.IndexTop = -1
+	err = repo.Put(db.NameKey("Top", "5001", sitzung), &top)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = repo.Put(db.NameKey("Termin", "sitzung-4242", nil), &db.Termin{Gremium: "Rat", SILFDNR: 4242})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report, err = db.CheckIntegrity(env)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []db.Violation{
+		{Check: db.CheckIndexTop, Key: sitzung.String(), Message: "IndexTop 1 follows -1"},
+		{Check: db.CheckTerminSitzung, Key: db.NameKey("Termin", "sitzung-4242", nil).String(), Message: "sitzung 4242 not stored"},
+	}
+	if len(report.Violations) != len(want) {
+		t.Fatalf("violations %+v", report.Violations)
+	}
+	for i, v := range report.Violations {
+		if *v != want[i] {
+			t.Errorf("violation %+v, want %+v", *v, want[i])
+		}
+	}
+	if report.OK() {
+		t.Error("OK with violations")
+	}
+}
@@ -0,0 +1,224 @@
+// Package dbtest runs the Sync scenarios of the golden fixtures and the
+// queries built on them against a Repository, so the memory, sqlite and
+// postgres stores are checked to behave alike.
+package dbtest
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/rismaster/allris-db/db"
+	"github.com/rismaster/allris-db/db/golden"
+)
+
+// Fixtures are the pages of the golden tests by the folder they are fetched
+// to.
+var Fixtures = map[string][]string{
+	"sitzungen/": {"sitzung-1001.html", "sitzung-1002.html"},
+	"tops/":      {"sitzung-1001-top-5002.html", "sitzung-1002-top-5102.html"},
+	"vorlagen/":  {"vorlage-2001.html", "vorlage-2002.html"},
+}
+
+// NewRepository returns an empty Repository for one scenario.
+type NewRepository func(t *testing.T) db.Repository
+
+// testdata is the directory of the golden fixtures.
+func testdata() string {
+	_, file, _, _ := runtime.Caller(0)
+	return filepath.Join(filepath.Dir(file), "..", "..", "golden", "testdata")
+}
+
+// Env copies the fixture pages into the folders of a temporary directory and
+// returns an Env reading it with a DirSource and storing in repo.
+func Env(t *testing.T, repo db.Repository) (*db.Env, string) {
+
+	dir := t.TempDir()
+	for folder, pages := range Fixtures {
+		err := os.MkdirAll(filepath.Join(dir, folder), 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, page := range pages {
+			content, err := ioutil.ReadFile(filepath.Join(testdata(), page))
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = ioutil.WriteFile(filepath.Join(dir, folder, page), content, 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	return db.NewLocalEnv(golden.FixtureConfig{}, repo, dir), dir
+}
+
+// MustSync runs update on the page at path and fails the test on an error.
+func MustSync(t *testing.T, update func(*db.Env, string) (bool, error), env *db.Env, path string) bool {
+	t.Helper()
+	changed, err := update(env, path)
+	if err != nil {
+		t.Fatalf("sync %s: %+v", path, err)
+	}
+	return changed
+}
+
+// Count returns the number of entities matching q.
+func Count(t *testing.T, repo db.Repository, q *db.Query) int {
+	t.Helper()
+	keys, err := repo.GetAll(q.KeysOnly(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return len(keys)
+}
+
+// RunSyncScenarios syncs the fixtures into a new Repository per scenario and
+// checks the stored entities.
+func RunSyncScenarios(t *testing.T, newRepo NewRepository) {
+
+	for _, s := range []struct {
+		name string
+		run  func(*testing.T, db.Repository)
+	}{
+		{"Sitzung", syncSitzung},
+		{"TopAnlagen", syncTopAnlagen},
+		{"Vorlage", syncVorlage},
+		{"ParentsSyncedLater", syncParentsLater},
+		{"RemovesGoneTops", syncRemovesGoneTops},
+		{"PublishesChanges", syncPublishesChanges},
+		{"Lineage", lineage},
+		{"RecordsGeneration", syncRecordsGeneration},
+		{"RecordsRevisions", syncRecordsRevisions},
+	} {
+		s := s
+		t.Run(s.name, func(t *testing.T) {
+			s.run(t, newRepo(t))
+		})
+	}
+}
+
+func syncSitzung(t *testing.T, repo db.Repository) {
+
+	env, _ := Env(t, repo)
+	sitzungKey := db.NameKey("Sitzung", "1001", nil)
+
+	if !MustSync(t, db.UpdateSitzung, env, "sitzungen/sitzung-1001.html") {
+		t.Error("first sync unchanged")
+	}
+	if n := Count(t, repo, db.NewQuery("Top").WithAncestor(sitzungKey)); n != 3 {
+		t.Errorf("%d tops, want 3", n)
+	}
+	// the direct Anlagen of the Sitzung, not those of its Tops
+	if n := Count(t, repo, db.NewQuery("Anlage").WithAncestor(sitzungKey).Filter("TOLFDNR = ", 0)); n != 4 {
+		t.Errorf("%d anlagen, want 4", n)
+	}
+
+	if MustSync(t, db.UpdateSitzung, env, "sitzungen/sitzung-1001.html") {
+		t.Error("unchanged file synced again")
+	}
+	// a forced sync upserts the stored rows
+	env.Force = true
+	if !MustSync(t, db.UpdateSitzung, env, "sitzungen/sitzung-1001.html") {
+		t.Error("forced sync unchanged")
+	}
+	if n := Count(t, repo, db.NewQuery("Top").WithAncestor(sitzungKey)); n != 3 {
+		t.Errorf("%d tops after resync, want 3", n)
+	}
+	if n := Count(t, repo, db.NewQuery("Anlage").WithAncestor(sitzungKey)); n != 4 {
+		t.Errorf("%d anlagen after resync, want 4", n)
+	}
+}
+
+func syncTopAnlagen(t *testing.T, repo db.Repository) {
+
+	env, _ := Env(t, repo)
+	MustSync(t, db.UpdateSitzung, env, "sitzungen/sitzung-1002.html")
+	MustSync(t, db.UpdateTop, env, "tops/sitzung-1002-top-5102.html")
+
+	sitzungKey := db.NameKey("Sitzung", "1002", nil)
+	topKey := db.NameKey("Top", "5102", sitzungKey)
+	if n := Count(t, repo, db.NewQuery("Anlage").WithAncestor(sitzungKey)); n != 1 {
+		t.Errorf("%d anlagen below the sitzung, want the 1 of the top", n)
+	}
+	if n := Count(t, repo, db.NewQuery("Anlage").WithAncestor(sitzungKey).Filter("TOLFDNR = ", 0)); n != 0 {
+		t.Errorf("%d direct anlagen of the sitzung, want 0", n)
+	}
+	if n := Count(t, repo, db.NewQuery("Anlage").WithAncestor(topKey)); n != 1 {
+		t.Errorf("%d anlagen of the top, want 1", n)
+	}
+	if n := Count(t, repo, db.NewQuery(db.EntityAbstimmung).WithAncestor(topKey)); n != 1 {
+		t.Errorf("%d abstimmungen of the top, want 1", n)
+	}
+}
+
+func syncVorlage(t *testing.T, repo db.Repository) {
+
+	env, _ := Env(t, repo)
+	MustSync(t, db.UpdateVorlage, env, "vorlagen/vorlage-2001.html")
+	MustSync(t, db.UpdateVorlage, env, "vorlagen/vorlage-2002.html")
+
+	var beratungen []*db.Top
+	keys, err := repo.GetAll(db.NewQuery("Top").Filter("VOLFDNR =", 2001), &beratungen)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make(map[string]bool)
+	for _, k := range keys {
+		got[k.String()] = true
+	}
+	for _, want := range []string{"/Sitzung,1001/Top,5002", "/Sitzung,1002/Top,5101"} {
+		if !got[want] {
+			t.Errorf("beratung %s missing in %v", want, keys)
+		}
+	}
+	if len(keys) != 2 {
+		t.Errorf("%d beratungen of 2001, want 2", len(keys))
+	}
+	if n := Count(t, repo, db.NewQuery("Anlage").WithAncestor(db.NameKey("Vorlage", "2001", nil))); n != 2 {
+		t.Errorf("%d anlagen of the vorlage, want 2", n)
+	}
+}
+
+// syncParentsLater stores the Beratungsfolge of a Vorlage and a Top before
+// their Sitzung. The SQL stores keep placeholder rows for the missing
+// Sitzungen, they must stay invisible until the Sitzung is synced.
+func syncParentsLater(t *testing.T, repo db.Repository) {
+
+	env, _ := Env(t, repo)
+	MustSync(t, db.UpdateVorlage, env, "vorlagen/vorlage-2001.html")
+	MustSync(t, db.UpdateTop, env, "tops/sitzung-1002-top-5102.html")
+
+	if n := Count(t, repo, db.NewQuery("Sitzung")); n != 0 {
+		t.Errorf("%d sitzungen before their sync, want 0", n)
+	}
+	sitzungKey := db.NameKey("Sitzung", "1001", nil)
+	err := repo.Get(sitzungKey, &db.Sitzung{})
+	if err != db.ErrNoSuchEntity {
+		t.Errorf("get sitzung before its sync: %v", err)
+	}
+	if n := Count(t, repo, db.NewQuery("Top").WithAncestor(sitzungKey)); n != 1 {
+		t.Errorf("%d tops of the unsynced sitzung, want the beratung", n)
+	}
+	topKey := db.NameKey("Top", "5102", db.NameKey("Sitzung", "1002", nil))
+	if n := Count(t, repo, db.NewQuery(db.EntityAbstimmung).WithAncestor(topKey)); n != 1 {
+		t.Errorf("%d abstimmungen of the top of the unsynced sitzung, want 1", n)
+	}
+
+	MustSync(t, db.UpdateSitzung, env, "sitzungen/sitzung-1001.html")
+	var sitzung db.Sitzung
+	err = repo.Get(sitzungKey, &sitzung)
+	if err != nil || sitzung.SILFDNR != 1001 {
+		t.Errorf("get synced sitzung: %v %+v", err, sitzung)
+	}
+	if n := Count(t, repo, db.NewQuery("Sitzung")); n != 1 {
+		t.Errorf("%d sitzungen, want 1", n)
+	}
+	var top db.Top
+	err = repo.Get(db.NameKey("Top", "5002", sitzungKey), &top)
+	if err != nil || top.VOLFDNR != 2001 {
+		t.Errorf("beratung after the sitzung sync: %v, VOLFDNR %d", err, top.VOLFDNR)
+	}
+}
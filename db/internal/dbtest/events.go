@@ -0,0 +1,68 @@
+package dbtest
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/rismaster/allris-db/db"
+)
+
+// events syncs the page at path and returns the keys of the published events
+// by their type.
+func events(t *testing.T, env *db.Env, update func(*db.Env, string) (bool, error), path string) map[db.EventType]map[string]bool {
+	t.Helper()
+	sink := make(db.ChannelSink, 100)
+	env.Events = sink
+	MustSync(t, update, env, path)
+	env.Events = nil
+	close(sink)
+	keys := make(map[db.EventType]map[string]bool)
+	for e := range sink {
+		if keys[e.Type] == nil {
+			keys[e.Type] = make(map[string]bool)
+		}
+		keys[e.Type][e.Key] = true
+	}
+	return keys
+}
+
+// syncPublishesChanges syncs a Sitzung and renames one of its Tops, the
+// created and the deleted entities are published once the sync commits.
+func syncPublishesChanges(t *testing.T, repo db.Repository) {
+
+	env, dir := Env(t, repo)
+	sitzungKey := db.NameKey("Sitzung", "1001", nil)
+	gone := db.NameKey("Top", "5002", sitzungKey)
+
+	published := events(t, env, db.UpdateSitzung, "sitzungen/sitzung-1001.html")
+	for _, k := range []*db.Key{sitzungKey, gone} {
+		if !published[db.EventCreated][k.Encode()] {
+			t.Errorf("no created event for %s", k.String())
+		}
+	}
+	if len(published[db.EventDeleted]) != 0 {
+		t.Errorf("deleted events of a new sitzung: %v", published[db.EventDeleted])
+	}
+	MustSync(t, db.UpdateTop, env, "tops/sitzung-1001-top-5002.html")
+
+	page := filepath.Join(dir, "sitzungen", "sitzung-1001.html")
+	content, err := ioutil.ReadFile(page)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(page, bytes.ReplaceAll(content, []byte("5002"), []byte("5009")), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	published = events(t, env, db.UpdateSitzung, "sitzungen/sitzung-1001.html")
+	for _, k := range []*db.Key{gone, db.NameKey(db.EntityAbstimmung, "5002", gone)} {
+		if !published[db.EventDeleted][k.Encode()] {
+			t.Errorf("no deleted event for %s", k.String())
+		}
+	}
+	if k := db.NameKey("Top", "5009", sitzungKey); !published[db.EventCreated][k.Encode()] {
+		t.Errorf("no created event for %s", k.String())
+	}
+}
@@ -0,0 +1,44 @@
+package dbtest
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rismaster/allris-db/db"
+)
+
+// syncRecordsGeneration touches a synced page, the new generation is stored
+// without saving the unchanged Vorlage again.
+func syncRecordsGeneration(t *testing.T, repo db.Repository) {
+
+	env, dir := Env(t, repo)
+	MustSync(t, db.UpdateVorlage, env, "vorlagen/vorlage-2001.html")
+	key := db.NameKey("Vorlage", "2001", nil)
+	var before db.Vorlage
+	err := repo.Get(key, &before)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	later := time.Now().Add(time.Hour)
+	err = os.Chtimes(filepath.Join(dir, "vorlagen", "vorlage-2001.html"), later, later)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if MustSync(t, db.UpdateVorlage, env, "vorlagen/vorlage-2001.html") {
+		t.Error("same content synced again")
+	}
+	var after db.Vorlage
+	err = repo.Get(key, &after)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after.SourceGeneration == before.SourceGeneration {
+		t.Error("new generation not recorded")
+	}
+	if !after.SavedAt.Equal(before.SavedAt) {
+		t.Error("unchanged vorlage saved again")
+	}
+}
@@ -0,0 +1,59 @@
+package dbtest
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/rismaster/allris-db/db"
+	"github.com/rismaster/allris-db/db/golden"
+)
+
+func volfdnrs(vorlagen []*db.Vorlage) []int {
+	ids := []int{}
+	for _, v := range vorlagen {
+		ids = append(ids, v.VOLFDNR)
+	}
+	return ids
+}
+
+// lineage stores Vorlagen referring to each other, by VOLFDNR, by BSVV, to a
+// Vorlage not stored and in a cycle, and builds their Lineage.
+func lineage(t *testing.T, repo db.Repository) {
+
+	for _, v := range []*db.Vorlage{
+		{VOLFDNR: 1, BSVV: "VO/2024/001"},
+		{VOLFDNR: 2, BezueglichVOLFDNR: 1},
+		// resolved by the BSVV, the VOLFDNR isn't stored
+		{VOLFDNR: 3, BezueglichVOLFDNR: 99, BezueglichBSVV: "vo/2024/ 001"},
+		{VOLFDNR: 4, BezueglichVOLFDNR: 2},
+		{VOLFDNR: 5, BezueglichVOLFDNR: 98},
+		{VOLFDNR: 6, BezueglichVOLFDNR: 7},
+		{VOLFDNR: 7, BezueglichVOLFDNR: 6},
+	} {
+		err := repo.Put(db.NameKey("Vorlage", strconv.Itoa(v.VOLFDNR), nil), v)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	l, err := db.BuildLineage(repo, golden.FixtureConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := volfdnrs(l.Ancestors(4)); !reflect.DeepEqual(got, []int{2, 1}) {
+		t.Errorf("ancestors of 4: %v", got)
+	}
+	if got := volfdnrs(l.Descendants(1)); !reflect.DeepEqual(got, []int{2, 3, 4}) {
+		t.Errorf("descendants of 1: %v", got)
+	}
+	if len(l.Dangling) != 1 || l.Dangling[0].VOLFDNR != 5 {
+		t.Errorf("dangling: %+v", l.Dangling)
+	}
+	if !reflect.DeepEqual(l.Cycles, [][]int{{6, 7}}) {
+		t.Errorf("cycles: %v", l.Cycles)
+	}
+	if got := volfdnrs(l.Ancestors(6)); !reflect.DeepEqual(got, []int{7}) {
+		t.Errorf("ancestors in the cycle: %v", got)
+	}
+}
@@ -0,0 +1,135 @@
+package dbtest
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rismaster/allris-db/db"
+	"github.com/rismaster/allris-db/db/golden"
+)
+
+// syncRecordsRevisions syncs a Vorlage twice with a changed Status, deletes
+// and syncs it again. The revisions outlive the delete and VorlageAsOf
+// restores the Vorlage between them.
+func syncRecordsRevisions(t *testing.T, repo db.Repository) {
+
+	env, dir := Env(t, repo)
+	config := golden.FixtureConfig{}
+	path := filepath.Join(dir, "vorlagen", "vorlage-2001.html")
+	MustSync(t, db.UpdateVorlage, env, "vorlagen/vorlage-2001.html")
+
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content = bytes.Replace(content, []byte("<td class=\"text1\">öffentlich</td>"), []byte("<td class=\"text1\">zurückgezogen</td>"), 1)
+	err = ioutil.WriteFile(path, content, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Hour)
+	err = os.Chtimes(path, later, later)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !MustSync(t, db.UpdateVorlage, env, "vorlagen/vorlage-2001.html") {
+		t.Fatal("changed vorlage not synced")
+	}
+
+	revisions, err := db.ListVorlageRevisions(repo, config, 2001)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(revisions) != 2 || !revisions[0].Created || revisions[1].Created {
+		t.Fatalf("revisions %+v", revisions)
+	}
+	changes, err := revisions[1].Changes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 1 || changes[0].Field != "Status" || string(changes[0].Old) != `"öffentlich"` || string(changes[0].New) != `"zurückgezogen"` {
+		t.Errorf("changes %s", changes)
+	}
+	created, changed := revisions[0].ChangedAt, revisions[1].ChangedAt
+
+	asOf := func(at time.Time) (string, error) {
+		t.Helper()
+		v, err := db.VorlageAsOf(repo, config, 2001, at)
+		if err != nil {
+			return "", err
+		}
+		if v.Betreff != "Radverkehrskonzept Innenstadt" {
+			t.Errorf("as of %s: betreff %q", at, v.Betreff)
+		}
+		return v.Status, nil
+	}
+	if status, err := asOf(changed.Add(-time.Nanosecond)); err != nil || status != "öffentlich" {
+		t.Errorf("before the change: %q, %v", status, err)
+	}
+	if status, err := asOf(changed); err != nil || status != "zurückgezogen" {
+		t.Errorf("at the change: %q, %v", status, err)
+	}
+	if _, err := asOf(created.Add(-time.Nanosecond)); err != db.ErrNoSuchEntity {
+		t.Errorf("before created: %v", err)
+	}
+
+	events := make(db.ChannelSink, 100)
+	env.Events = events
+	err = db.DeleteVorlage(env, "vorlagen/vorlage-2001.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	env.Events = nil
+	close(events)
+	var revisionEvents int
+	for e := range events {
+		if e.Kind == db.EntityVorlageRevision && e.Type == db.EventCreated {
+			revisionEvents++
+		}
+	}
+	if revisionEvents != 1 {
+		t.Errorf("%d created events of revisions, want 1", revisionEvents)
+	}
+
+	revisions, err = db.ListVorlageRevisions(repo, config, 2001)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(revisions) != 3 || !revisions[2].Deleted {
+		t.Fatalf("revisions after the delete %+v", revisions)
+	}
+	deleted := revisions[2].ChangedAt
+	if _, err := asOf(deleted); err != db.ErrNoSuchEntity {
+		t.Errorf("after the delete: %v", err)
+	}
+	if status, err := asOf(deleted.Add(-time.Nanosecond)); err != nil || status != "zurückgezogen" {
+		t.Errorf("before the delete: %q, %v", status, err)
+	}
+
+	// synced again the Vorlage is created anew, the gap stays
+	err = os.Chtimes(path, later.Add(time.Hour), later.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	MustSync(t, db.UpdateVorlage, env, "vorlagen/vorlage-2001.html")
+	revisions, err = db.ListVorlageRevisions(repo, config, 2001)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(revisions) != 4 || !revisions[3].Created {
+		t.Fatalf("revisions after the new sync %+v", revisions)
+	}
+	if _, err := asOf(deleted); err != db.ErrNoSuchEntity {
+		t.Errorf("between delete and new sync: %v", err)
+	}
+	if status, err := asOf(changed); err != nil || status != "zurückgezogen" {
+		t.Errorf("before the delete, synced again: %q, %v", status, err)
+	}
+	if status, err := asOf(time.Now().Add(time.Hour)); err != nil || status != "zurückgezogen" {
+		t.Errorf("now: %q, %v", status, err)
+	}
+}
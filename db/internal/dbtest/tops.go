@@ -0,0 +1,49 @@
+package dbtest
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/rismaster/allris-db/db"
+)
+
+// syncRemovesGoneTops renames a Top of a synced Sitzung, the old one is
+// deleted with its Abstimmung, also below a referenced parent row.
+func syncRemovesGoneTops(t *testing.T, repo db.Repository) {
+
+	env, dir := Env(t, repo)
+	MustSync(t, db.UpdateSitzung, env, "sitzungen/sitzung-1001.html")
+	MustSync(t, db.UpdateTop, env, "tops/sitzung-1001-top-5002.html")
+
+	page := filepath.Join(dir, "sitzungen", "sitzung-1001.html")
+	content, err := ioutil.ReadFile(page)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(page, bytes.ReplaceAll(content, []byte("5002"), []byte("5009")), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !MustSync(t, db.UpdateSitzung, env, "sitzungen/sitzung-1001.html") {
+		t.Fatal("changed page unchanged")
+	}
+
+	sitzungKey := db.NameKey("Sitzung", "1001", nil)
+	gone := db.NameKey("Top", "5002", sitzungKey)
+	err = repo.Get(gone, &db.Top{})
+	if err != db.ErrNoSuchEntity {
+		t.Errorf("get removed top: %v", err)
+	}
+	if n := Count(t, repo, db.NewQuery(db.EntityAbstimmung).WithAncestor(gone)); n != 0 {
+		t.Errorf("%d abstimmungen of the removed top left", n)
+	}
+	err = repo.Get(db.NameKey("Top", "5009", sitzungKey), &db.Top{})
+	if err != nil {
+		t.Errorf("get new top: %v", err)
+	}
+	if n := Count(t, repo, db.NewQuery("Top").WithAncestor(sitzungKey)); n != 3 {
+		t.Errorf("%d tops, want 3", n)
+	}
+}
@@ -0,0 +1,19 @@
+// Package htmltext turns the html of the stored texts into plain text.
+package htmltext
+
+import (
+	"html"
+	"regexp"
+	"strings"
+
+	"github.com/kennygrant/sanitize"
+)
+
+var openingTag = regexp.MustCompile(`<([a-zA-Z])`)
+
+// Plain strips the html and returns the unescaped text. A space is put before
+// the opening tags first, so the words of adjacent elements like <li> aren't
+// joined.
+func Plain(s string) string {
+	return html.UnescapeString(strings.Join(strings.Fields(sanitize.HTML(openingTag.ReplaceAllString(s, " <$1"))), " "))
+}
@@ -0,0 +1,82 @@
+// Package pdftext extracts the plain text of the pages of a PDF.
+package pdftext
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/ledongthuc/pdf"
+	"github.com/pkg/errors"
+)
+
+// Pages returns the text of each page, empty for pages without text, e.g.
+// scans. Malformed and encrypted PDFs are errors.
+func Pages(content []byte) (pages []string, err error) {
+
+	// the pdf package panics on malformed input
+	defer func() {
+		if r := recover(); r != nil {
+			pages = nil
+			err = errors.New(fmt.Sprintf("malformed pdf: %v", r))
+		}
+	}()
+
+	r, err := pdf.NewReader(bytes.NewReader(content), int64(len(content)))
+	if err != nil {
+		return nil, errors.Wrap(err, "error opening pdf")
+	}
+
+	n := r.NumPage()
+	for i := 1; i <= n; i++ {
+		p := r.Page(i)
+		if p.V.IsNull() {
+			pages = append(pages, "")
+			continue
+		}
+		pages = append(pages, layout(p.Content().Text))
+	}
+	return pages, nil
+}
+
+// layout groups the glyphs into lines top to bottom. Glyphs are on one line
+// if their baselines are close, a gap wider than a fraction of the font size
+// is a space.
+func layout(glyphs []pdf.Text) string {
+
+	sort.SliceStable(glyphs, func(i, j int) bool {
+		return glyphs[i].Y > glyphs[j].Y
+	})
+	var lines [][]pdf.Text
+	for _, g := range glyphs {
+		n := len(lines)
+		if n > 0 && math.Abs(lines[n-1][0].Y-g.Y) < math.Max(g.FontSize, 1)/2 {
+			lines[n-1] = append(lines[n-1], g)
+		} else {
+			lines = append(lines, []pdf.Text{g})
+		}
+	}
+
+	var text []string
+	for _, line := range lines {
+		sort.SliceStable(line, func(i, j int) bool {
+			return line[i].X < line[j].X
+		})
+		var sb strings.Builder
+		for i, g := range line {
+			if i > 0 {
+				prev := line[i-1]
+				if g.X-(prev.X+prev.W) > g.FontSize*0.15 {
+					sb.WriteString(" ")
+				}
+			}
+			sb.WriteString(g.S)
+		}
+		if l := strings.Join(strings.Fields(sb.String()), " "); l != "" {
+			text = append(text, l)
+		}
+	}
+	return strings.Join(text, "\n")
+}
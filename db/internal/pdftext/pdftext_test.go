@@ -0,0 +1,51 @@
+package pdftext_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/rismaster/allris-db/db/internal/pdftext"
+)
+
+func fixture(t *testing.T) []byte {
+	t.Helper()
+	content, err := ioutil.ReadFile(filepath.Join("testdata", "zwei-seiten.pdf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return content
+}
+
+func TestPages(t *testing.T) {
+
+	pages, err := pdftext.Pages(fixture(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"Lageplan Marktplatz", "Seite zwei"}; !reflect.DeepEqual(pages, want) {
+		t.Errorf("pages %q, want %q", pages, want)
+	}
+}
+
+func TestPagesMalformed(t *testing.T) {
+
+	content := fixture(t)
+	for name, c := range map[string]struct {
+		content []byte
+		want    string
+	}{
+		"no pdf":    {[]byte("<html></html>"), "error opening pdf"},
+		"truncated": {content[:len(content)/2], "error opening pdf"},
+		// the pdf package panics on contents that aren't a stream
+		"panic": {bytes.Replace(content, []byte("/Contents 5 0 R"), []byte("/Contents 3 0 R"), 1), "malformed pdf"},
+	} {
+		pages, err := pdftext.Pages(c.content)
+		if err == nil || !strings.Contains(err.Error(), c.want) || pages != nil {
+			t.Errorf("%s: %q, %v", name, pages, err)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package sqlstore
+
+import (
+	"database/sql"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+)
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// field is an exported entity field stored in a column of the same name in
+// lower case. Fields tagged with datastore:"-" and pointer fields are skipped.
+type field struct {
+	column     string
+	index      int
+	nullIfZero bool
+}
+
+func fieldsOf(t reflect.Type, nullIfZero map[string]bool) []field {
+	var fields []field
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" || f.Type.Kind() == reflect.Ptr || f.Type.Kind() == reflect.Interface {
+			continue
+		}
+		if strings.Split(f.Tag.Get("datastore"), ",")[0] == "-" {
+			continue
+		}
+		column := strings.ToLower(f.Name)
+		fields = append(fields, field{
+			column:     column,
+			index:      i,
+			nullIfZero: nullIfZero[column],
+		})
+	}
+	return fields
+}
+
+func (f field) value(entity reflect.Value) interface{} {
+	v := entity.Field(f.index)
+	if f.nullIfZero && v.IsZero() {
+		return nil
+	}
+	return v.Interface()
+}
+
+// scanTarget returns a nullable holder matching the field type.
+func (f field) scanTarget(t reflect.Type) (interface{}, error) {
+	ft := t.Field(f.index).Type
+	switch {
+	case ft == timeType:
+		return new(sql.NullTime), nil
+	case ft.Kind() == reflect.String:
+		return new(sql.NullString), nil
+	case ft.Kind() == reflect.Bool:
+		return new(sql.NullBool), nil
+	case ft.Kind() >= reflect.Int && ft.Kind() <= reflect.Int64:
+		return new(sql.NullInt64), nil
+	case ft.Kind() == reflect.Float32 || ft.Kind() == reflect.Float64:
+		return new(sql.NullFloat64), nil
+	}
+	return nil, fmt.Errorf("unsupported field type %s for column %s", ft, f.column)
+}
+
+func (f field) set(entity reflect.Value, holder interface{}) {
+	v := entity.Field(f.index)
+	switch h := holder.(type) {
+	case *sql.NullTime:
+		v.Set(reflect.ValueOf(h.Time))
+	case *sql.NullString:
+		v.SetString(h.String)
+	case *sql.NullBool:
+		v.SetBool(h.Bool)
+	case *sql.NullInt64:
+		v.SetInt(h.Int64)
+	case *sql.NullFloat64:
+		v.SetFloat(h.Float64)
+	}
+}
+
+// entityValue returns the struct value behind src (T or *T).
+func entityValue(src interface{}, t reflect.Type) (reflect.Value, error) {
+	v := reflect.ValueOf(src)
+	if v.Kind() == reflect.Ptr {
+		v = v.Elem()
+	}
+	if !v.IsValid() || v.Type() != t {
+		return reflect.Value{}, fmt.Errorf("invalid entity type %T, expected %s", src, t)
+	}
+	return v, nil
+}
@@ -0,0 +1,79 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rismaster/allris-common/common/slog"
+)
+
+// Migration is one versioned step of a database schema.
+type Migration struct {
+	Version    int
+	Name       string
+	Statements []string
+}
+
+// Migrate applies all migrations of the dialect with a version not yet
+// recorded in the schema_migrations table, each one in its own transaction.
+func Migrate(ctx context.Context, sqlDb *sql.DB, dialect Dialect) error {
+
+	_, err := sqlDb.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at TIMESTAMP NOT NULL
+	)`)
+	if err != nil {
+		return errors.Wrap(err, "error creating schema_migrations")
+	}
+
+	applied := make(map[int]bool)
+	rows, err := sqlDb.QueryContext(ctx, "SELECT version FROM schema_migrations")
+	if err != nil {
+		return errors.Wrap(err, "error reading schema_migrations")
+	}
+	for rows.Next() {
+		var version int
+		err = rows.Scan(&version)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		applied[version] = true
+	}
+	rows.Close()
+
+	for _, m := range dialect.Migrations() {
+		if applied[m.Version] {
+			continue
+		}
+		slog.Info("apply migration %d %s", m.Version, m.Name)
+
+		tx, err := sqlDb.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		for _, stmt := range m.Statements {
+			_, err = tx.ExecContext(ctx, stmt)
+			if err != nil {
+				_ = tx.Rollback()
+				return errors.Wrap(err, fmt.Sprintf("error in migration %d %s", m.Version, m.Name))
+			}
+		}
+		a := &args{dialect: dialect}
+		_, err = tx.ExecContext(ctx, fmt.Sprintf("INSERT INTO schema_migrations (version, name, applied_at) VALUES (%s, %s, %s)",
+			a.add(m.Version), a.add(m.Name), a.add(time.Now())), a.values...)
+		if err != nil {
+			_ = tx.Rollback()
+			return errors.Wrap(err, fmt.Sprintf("error recording migration %d", m.Version))
+		}
+		err = tx.Commit()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
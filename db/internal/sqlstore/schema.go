@@ -0,0 +1,314 @@
+package sqlstore
+
+import "strings"
+
+// The column types the migrations leave to the Dialect, see Dialect.Types.
+const (
+	TypeInt  = "int"
+	TypeTime = "time"
+)
+
+// Migrations are the migrations of the dialect, the {int} and {time} column
+// types of the shared definition replaced by the types of the dialect.
+func (d Dialect) Migrations() []Migration {
+
+	var pairs []string
+	for name, typ := range d.Types {
+		pairs = append(pairs, "{"+name+"}", typ)
+	}
+	r := strings.NewReplacer(pairs...)
+	result := make([]Migration, len(migrations))
+	for i, m := range migrations {
+		result[i] = Migration{Version: m.Version, Name: m.Name}
+		for _, stmt := range m.Statements {
+			result[i].Statements = append(result[i].Statements, r.Replace(stmt))
+		}
+	}
+	return result
+}
+
+// migrations creates and evolves the tables of every dialect. Append new
+// versions, never change an already released one.
+var migrations = []Migration{
+	{
+		Version: 1,
+		Name:    "create entity tables",
+		Statements: []string{
+			`CREATE TABLE sitzung (
+				silfdnr {int} PRIMARY KEY,
+				datum {time},
+				gremium TEXT,
+				status TEXT,
+				title TEXT,
+				uhrzeit TEXT,
+				raum TEXT,
+				ort TEXT,
+				savedat {time},
+				placeholder BOOLEAN NOT NULL DEFAULT FALSE
+			)`,
+			`CREATE TABLE vorlage (
+				volfdnr {int} PRIMARY KEY,
+				bsvv TEXT,
+				betreff TEXT,
+				status TEXT,
+				federfuehrend TEXT,
+				bearbeiter TEXT,
+				beschlussvorlage TEXT,
+				begruendung TEXT,
+				finanzielleauswirkung TEXT,
+				datumangelegt {time},
+				bezueglichvolfdnr {int},
+				bezueglichbsvv TEXT,
+				savedat {time},
+				placeholder BOOLEAN NOT NULL DEFAULT FALSE
+			)`,
+			`CREATE TABLE top (
+				silfdnr {int} NOT NULL REFERENCES sitzung (silfdnr),
+				tolfdnr {int} NOT NULL,
+				volfdnr {int} REFERENCES vorlage (volfdnr),
+				savedat {time},
+				betreff TEXT,
+				beschluss TEXT,
+				protokoll TEXT,
+				protokollre TEXT,
+				nr TEXT,
+				beschlussart TEXT,
+				gremium TEXT,
+				federfuehrend TEXT,
+				bearbeiter TEXT,
+				datum {time},
+				abstimmungzustimmung {int},
+				abstimmungablehnung {int},
+				abstimmungenthaltung {int},
+				indextop {int},
+				typ TEXT,
+				status TEXT,
+				indexberatung {int},
+				bsvv TEXT,
+				beschlussstatus TEXT,
+				placeholder BOOLEAN NOT NULL DEFAULT FALSE,
+				PRIMARY KEY (silfdnr, tolfdnr)
+			)`,
+			`CREATE INDEX top_volfdnr ON top (volfdnr)`,
+			`CREATE TABLE anlage (
+				key TEXT PRIMARY KEY,
+				parent_silfdnr {int} REFERENCES sitzung (silfdnr),
+				parent_tolfdnr {int},
+				parent_volfdnr {int} REFERENCES vorlage (volfdnr),
+				silfdnr {int},
+				tolfdnr {int},
+				volfdnr {int},
+				dolfdnr {int},
+				type TEXT,
+				filename TEXT,
+				title TEXT,
+				savedat {time},
+				FOREIGN KEY (parent_silfdnr, parent_tolfdnr) REFERENCES top (silfdnr, tolfdnr),
+				CHECK ((parent_silfdnr IS NULL) <> (parent_volfdnr IS NULL))
+			)`,
+			`CREATE INDEX anlage_parent_sitzung ON anlage (parent_silfdnr, parent_tolfdnr)`,
+			`CREATE INDEX anlage_parent_vorlage ON anlage (parent_volfdnr)`,
+			`CREATE TABLE termin (
+				name TEXT PRIMARY KEY,
+				gremium TEXT,
+				silfdnr {int},
+				start {time},
+				"end" {time},
+				savedat {time}
+			)`,
+			`CREATE INDEX termin_start ON termin (start)`,
+		},
+	},
+	{
+		Version: 2,
+		Name:    "add content hash and source generation",
+		Statements: []string{
+			`ALTER TABLE sitzung ADD COLUMN contenthash TEXT`,
+			`ALTER TABLE sitzung ADD COLUMN sourcegeneration TEXT`,
+			`ALTER TABLE vorlage ADD COLUMN contenthash TEXT`,
+			`ALTER TABLE vorlage ADD COLUMN sourcegeneration TEXT`,
+			`ALTER TABLE top ADD COLUMN contenthash TEXT`,
+			`ALTER TABLE top ADD COLUMN sourcegeneration TEXT`,
+		},
+	},
+	{
+		Version: 3,
+		Name:    "create vorlage revisions",
+		Statements: []string{
+			`CREATE TABLE vorlage_revision (
+				volfdnr {int} NOT NULL REFERENCES vorlage (volfdnr),
+				name TEXT NOT NULL,
+				changedat {time},
+				created BOOLEAN,
+				diff TEXT,
+				PRIMARY KEY (volfdnr, name)
+			)`,
+		},
+	},
+	{
+		Version: 4,
+		Name:    "create termin moves",
+		Statements: []string{
+			`CREATE TABLE termin_move (
+				termin TEXT NOT NULL,
+				name TEXT NOT NULL,
+				silfdnr {int},
+				gremium TEXT,
+				oldstart {time},
+				oldend {time},
+				newstart {time},
+				newend {time},
+				movedat {time},
+				PRIMARY KEY (termin, name)
+			)`,
+			`CREATE INDEX termin_move_oldstart ON termin_move (oldstart)`,
+			`CREATE INDEX termin_move_newstart ON termin_move (newstart)`,
+		},
+	},
+	{
+		Version: 5,
+		Name:    "add anlage content",
+		Statements: []string{
+			`ALTER TABLE anlage ADD COLUMN size {int}`,
+			`ALTER TABLE anlage ADD COLUMN mimetype TEXT`,
+			`ALTER TABLE anlage ADD COLUMN sha256 TEXT`,
+			`ALTER TABLE anlage ADD COLUMN filegeneration TEXT`,
+			`ALTER TABLE anlage ADD COLUMN contentchangedat {time}`,
+			`CREATE INDEX anlage_sha256 ON anlage (sha256)`,
+			`CREATE TABLE anlage_content (
+				sha256 TEXT PRIMARY KEY,
+				size {int},
+				mimetype TEXT,
+				file TEXT,
+				firstseen {time}
+			)`,
+		},
+	},
+	{
+		Version: 6,
+		Name:    "create anlage text",
+		Statements: []string{
+			`ALTER TABLE anlage_content ADD COLUMN pages {int}`,
+			`ALTER TABLE anlage_content ADD COLUMN textextractedat {time}`,
+			`ALTER TABLE anlage_content ADD COLUMN texterror TEXT`,
+			`CREATE TABLE anlage_text (
+				sha256 TEXT NOT NULL,
+				page {int} NOT NULL,
+				text TEXT,
+				PRIMARY KEY (sha256, page)
+			)`,
+		},
+	},
+	{
+		Version: 7,
+		Name:    "create abstimmungen",
+		Statements: []string{
+			`CREATE TABLE abstimmung (
+				silfdnr {int} NOT NULL,
+				tolfdnr {int} NOT NULL,
+				volfdnr {int},
+				gremium TEXT,
+				datum {time},
+				zustimmung {int},
+				ablehnung {int},
+				enthaltung {int},
+				gezaehlt BOOLEAN,
+				einstimmig BOOLEAN,
+				mehrheitlich BOOLEAN,
+				geteilt BOOLEAN,
+				ergebnis TEXT,
+				text TEXT,
+				savedat {time},
+				PRIMARY KEY (silfdnr, tolfdnr)
+			)`,
+			`CREATE INDEX abstimmung_gremium ON abstimmung (gremium, datum)`,
+			`CREATE TABLE fraktions_votum (
+				silfdnr {int} NOT NULL,
+				tolfdnr {int} NOT NULL,
+				name TEXT NOT NULL,
+				gremium TEXT,
+				datum {time},
+				fraktion TEXT,
+				zustimmung {int},
+				ablehnung {int},
+				enthaltung {int},
+				votum TEXT,
+				geschlossen BOOLEAN,
+				PRIMARY KEY (silfdnr, tolfdnr, name)
+			)`,
+		},
+	},
+	{
+		Version: 8,
+		Name:    "reference tops from abstimmungen",
+		Statements: []string{
+			`CREATE TABLE abstimmung_v8 (
+				silfdnr {int} NOT NULL,
+				tolfdnr {int} NOT NULL,
+				volfdnr {int},
+				gremium TEXT,
+				datum {time},
+				zustimmung {int},
+				ablehnung {int},
+				enthaltung {int},
+				gezaehlt BOOLEAN,
+				einstimmig BOOLEAN,
+				mehrheitlich BOOLEAN,
+				geteilt BOOLEAN,
+				ergebnis TEXT,
+				text TEXT,
+				savedat {time},
+				placeholder BOOLEAN NOT NULL DEFAULT FALSE,
+				PRIMARY KEY (silfdnr, tolfdnr),
+				FOREIGN KEY (silfdnr, tolfdnr) REFERENCES top (silfdnr, tolfdnr)
+			)`,
+			`CREATE TABLE fraktions_votum_v8 (
+				silfdnr {int} NOT NULL,
+				tolfdnr {int} NOT NULL,
+				name TEXT NOT NULL,
+				gremium TEXT,
+				datum {time},
+				fraktion TEXT,
+				zustimmung {int},
+				ablehnung {int},
+				enthaltung {int},
+				votum TEXT,
+				geschlossen BOOLEAN,
+				PRIMARY KEY (silfdnr, tolfdnr, name),
+				FOREIGN KEY (silfdnr, tolfdnr) REFERENCES abstimmung_v8 (silfdnr, tolfdnr)
+			)`,
+			// the parents of orphaned rows are kept as placeholders
+			`INSERT INTO sitzung (silfdnr, placeholder)
+				SELECT DISTINCT silfdnr, TRUE FROM fraktions_votum f
+				WHERE NOT EXISTS (SELECT 1 FROM sitzung s WHERE s.silfdnr = f.silfdnr)
+				UNION SELECT DISTINCT silfdnr, TRUE FROM abstimmung a
+				WHERE NOT EXISTS (SELECT 1 FROM sitzung s WHERE s.silfdnr = a.silfdnr)`,
+			`INSERT INTO top (silfdnr, tolfdnr, placeholder)
+				SELECT DISTINCT silfdnr, tolfdnr, TRUE FROM fraktions_votum f
+				WHERE NOT EXISTS (SELECT 1 FROM top t WHERE t.silfdnr = f.silfdnr AND t.tolfdnr = f.tolfdnr)
+				UNION SELECT DISTINCT silfdnr, tolfdnr, TRUE FROM abstimmung a
+				WHERE NOT EXISTS (SELECT 1 FROM top t WHERE t.silfdnr = a.silfdnr AND t.tolfdnr = a.tolfdnr)`,
+			`INSERT INTO abstimmung_v8 (silfdnr, tolfdnr, volfdnr, gremium, datum, zustimmung, ablehnung, enthaltung,
+				gezaehlt, einstimmig, mehrheitlich, geteilt, ergebnis, text, savedat)
+				SELECT silfdnr, tolfdnr, volfdnr, gremium, datum, zustimmung, ablehnung, enthaltung,
+				gezaehlt, einstimmig, mehrheitlich, geteilt, ergebnis, text, savedat FROM abstimmung`,
+			`INSERT INTO abstimmung_v8 (silfdnr, tolfdnr, placeholder)
+				SELECT DISTINCT silfdnr, tolfdnr, TRUE FROM fraktions_votum f
+				WHERE NOT EXISTS (SELECT 1 FROM abstimmung a WHERE a.silfdnr = f.silfdnr AND a.tolfdnr = f.tolfdnr)`,
+			`INSERT INTO fraktions_votum_v8 SELECT silfdnr, tolfdnr, name, gremium, datum, fraktion,
+				zustimmung, ablehnung, enthaltung, votum, geschlossen FROM fraktions_votum`,
+			`DROP TABLE fraktions_votum`,
+			`DROP TABLE abstimmung`,
+			`ALTER TABLE abstimmung_v8 RENAME TO abstimmung`,
+			`ALTER TABLE fraktions_votum_v8 RENAME TO fraktions_votum`,
+			`CREATE INDEX abstimmung_gremium ON abstimmung (gremium, datum)`,
+		},
+	},
+	{
+		Version: 9,
+		Name:    "record deleted vorlagen",
+		Statements: []string{
+			`ALTER TABLE vorlage_revision ADD COLUMN deleted BOOLEAN`,
+		},
+	},
+}
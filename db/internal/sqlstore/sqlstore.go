@@ -0,0 +1,587 @@
+// Package sqlstore implements db.Repository on top of database/sql. It is
+// shared by the relational backends, which only differ in their Dialect. The
+// schema migrations are shared as well, with the column types of the
+// Dialect.
+//
+// Every entity kind lives in its own table with foreign keys between them.
+// Datastore allows writing a child before its parent and deleting a parent
+// while children remain, so rows referenced by a foreign key but not stored
+// themselves are kept as placeholders, invisible to Get and GetAll.
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	allris_common "github.com/rismaster/allris-common"
+	"github.com/rismaster/allris-db/db"
+)
+
+// Dialect holds the differences between the supported SQL databases.
+type Dialect struct {
+	Name string
+	// Placeholder returns the bind parameter for the n-th argument (1-based).
+	Placeholder func(n int) string
+	// Value converts an argument before it is passed to the driver.
+	Value func(v interface{}) interface{}
+	// Types are the column types of TypeInt and TypeTime in the migrations.
+	Types map[string]string
+	// NoLimit is the LIMIT of a query with an OFFSET only.
+	NoLimit string
+}
+
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+type Repository struct {
+	db      *sql.DB
+	ctx     context.Context
+	dialect Dialect
+	schema  *schema
+}
+
+type Transaction struct {
+	tx *sql.Tx
+	r  *Repository
+}
+
+func New(ctx context.Context, sqlDb *sql.DB, dialect Dialect, config allris_common.Config) *Repository {
+	return &Repository{
+		db:      sqlDb,
+		ctx:     ctx,
+		dialect: dialect,
+		schema:  newSchema(config),
+	}
+}
+
+func (r *Repository) DB() *sql.DB {
+	return r.db
+}
+
+func (r *Repository) Close() error {
+	return r.db.Close()
+}
+
+func (r *Repository) Get(key *db.Key, dst interface{}) error {
+	return r.get(r.db, key, dst)
+}
+
+func (r *Repository) GetAll(q *db.Query, dst interface{}) ([]*db.Key, error) {
+	return r.getAll(r.db, q, dst)
+}
+
+func (r *Repository) Put(key *db.Key, src interface{}) error {
+	return db.RunInTransaction(r, func(tx db.Transaction) error {
+		return tx.Put(key, src)
+	})
+}
+
+func (r *Repository) PutMulti(keys []*db.Key, src interface{}) error {
+	return db.RunInTransaction(r, func(tx db.Transaction) error {
+		return r.putMulti(tx.(*Transaction).tx, keys, src)
+	})
+}
+
+func (r *Repository) Delete(key *db.Key) error {
+	return r.DeleteMulti([]*db.Key{key})
+}
+
+func (r *Repository) DeleteMulti(keys []*db.Key) error {
+	return db.RunInTransaction(r, func(tx db.Transaction) error {
+		return tx.DeleteMulti(keys)
+	})
+}
+
+func (r *Repository) NewTransaction() (db.Transaction, error) {
+	tx, err := r.db.BeginTx(r.ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &Transaction{tx: tx, r: r}, nil
+}
+
+func (t *Transaction) Get(key *db.Key, dst interface{}) error {
+	return t.r.get(t.tx, key, dst)
+}
+
+func (t *Transaction) GetMulti(keys []*db.Key, dst interface{}) error {
+	v := reflect.ValueOf(dst)
+	if v.Kind() != reflect.Slice || v.Len() != len(keys) {
+		return fmt.Errorf("dst must be a slice of length %d", len(keys))
+	}
+
+	var errs = make(db.MultiError, len(keys))
+	var failed bool
+	for i, key := range keys {
+		elem := v.Index(i)
+		if elem.Kind() == reflect.Ptr {
+			if elem.IsNil() {
+				elem.Set(reflect.New(elem.Type().Elem()))
+			}
+			errs[i] = t.r.get(t.tx, key, elem.Interface())
+		} else {
+			errs[i] = t.r.get(t.tx, key, elem.Addr().Interface())
+		}
+		failed = failed || errs[i] != nil
+	}
+	if failed {
+		return errs
+	}
+	return nil
+}
+
+func (t *Transaction) Put(key *db.Key, src interface{}) error {
+	return t.r.put(t.tx, key, src)
+}
+
+func (t *Transaction) Delete(key *db.Key) error {
+	return t.r.delete(t.tx, key)
+}
+
+func (t *Transaction) DeleteMulti(keys []*db.Key) error {
+	for _, key := range keys {
+		err := t.r.delete(t.tx, key)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *Transaction) Commit() error {
+	return t.tx.Commit()
+}
+
+func (t *Transaction) Rollback() error {
+	return t.tx.Rollback()
+}
+
+func quote(ident string) string {
+	return `"` + ident + `"`
+}
+
+// args collects bind parameters while a statement is built.
+type args struct {
+	dialect Dialect
+	values  []interface{}
+}
+
+func (a *args) add(v interface{}) string {
+	if a.dialect.Value != nil {
+		v = a.dialect.Value(v)
+	}
+	a.values = append(a.values, v)
+	return a.dialect.Placeholder(len(a.values))
+}
+
+func sortedColumns(cols map[string]interface{}) []string {
+	var names []string
+	for c := range cols {
+		names = append(names, c)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (r *Repository) equals(tbl string, cols map[string]interface{}, a *args) []string {
+	var conds []string
+	for _, c := range sortedColumns(cols) {
+		if cols[c] == nil {
+			conds = append(conds, fmt.Sprintf("%s.%s IS NULL", tbl, quote(c)))
+		} else {
+			conds = append(conds, fmt.Sprintf("%s.%s = %s", tbl, quote(c), a.add(cols[c])))
+		}
+	}
+	return conds
+}
+
+func (t *table) pkColumns(k *db.Key) (map[string]interface{}, error) {
+	cols, err := t.keyColumns(k)
+	if err != nil {
+		return nil, err
+	}
+	pk := make(map[string]interface{})
+	for _, c := range t.pk {
+		pk[c] = cols[c]
+	}
+	return pk, nil
+}
+
+func (t *table) selectList() string {
+	var cols []string
+	for _, c := range t.pk {
+		cols = append(cols, t.name+"."+quote(c))
+	}
+	for _, f := range t.fields {
+		cols = append(cols, t.name+"."+quote(f.column))
+	}
+	return strings.Join(cols, ", ")
+}
+
+// scan reads a row selected with selectList into entity (if valid).
+func (t *table) scan(rows *sql.Rows, entity reflect.Value) (*db.Key, error) {
+	var pk = make([]interface{}, len(t.pk))
+	var targets []interface{}
+	for i := range pk {
+		targets = append(targets, &pk[i])
+	}
+	var holders []interface{}
+	for _, f := range t.fields {
+		h, err := f.scanTarget(t.typ)
+		if err != nil {
+			return nil, err
+		}
+		holders = append(holders, h)
+	}
+	err := rows.Scan(append(targets, holders...)...)
+	if err != nil {
+		return nil, err
+	}
+	if entity.IsValid() {
+		for i, f := range t.fields {
+			f.set(entity, holders[i])
+		}
+	}
+	for i, v := range pk {
+		if b, ok := v.([]byte); ok {
+			pk[i] = string(b)
+		}
+	}
+	return t.key(pk)
+}
+
+func (r *Repository) get(ex execer, key *db.Key, dst interface{}) error {
+	t, err := r.schema.byKind(key.Kind)
+	if err != nil {
+		return err
+	}
+	entity, err := entityValue(dst, t.typ)
+	if err != nil {
+		return err
+	}
+	return r.load(ex, t, key, entity, false)
+}
+
+func (r *Repository) load(ex execer, t *table, key *db.Key, entity reflect.Value, withPlaceholder bool) error {
+	pk, err := t.pkColumns(key)
+	if err != nil {
+		return err
+	}
+	a := &args{dialect: r.dialect}
+	conds := r.equals(t.name, pk, a)
+	if t.placeholder && !withPlaceholder {
+		conds = append(conds, "NOT "+t.name+".placeholder")
+	}
+
+	rows, err := ex.QueryContext(r.ctx, fmt.Sprintf("SELECT %s FROM %s WHERE %s",
+		t.selectList(), t.name, strings.Join(conds, " AND ")), a.values...)
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("error loading %s", key.String()))
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		if rows.Err() != nil {
+			return rows.Err()
+		}
+		return db.ErrNoSuchEntity
+	}
+	_, err = t.scan(rows, entity)
+	return err
+}
+
+var sqlOps = map[string]string{"=": "=", "<": "<", "<=": "<=", ">": ">", ">=": ">="}
+
+func (r *Repository) getAll(ex execer, q *db.Query, dst interface{}) ([]*db.Key, error) {
+	if q.Err() != nil {
+		return nil, q.Err()
+	}
+	t, err := r.schema.byKind(q.Kind)
+	if err != nil {
+		return nil, err
+	}
+
+	var slice reflect.Value
+	if dst != nil && !q.IsKeysOnly() {
+		slice = reflect.ValueOf(dst)
+		if slice.Kind() != reflect.Ptr || slice.Elem().Kind() != reflect.Slice {
+			return nil, fmt.Errorf("dst must be a pointer to a slice, is %T", dst)
+		}
+		slice = slice.Elem()
+	}
+
+	a := &args{dialect: r.dialect}
+	var conds []string
+	if t.placeholder {
+		conds = append(conds, "NOT "+t.name+".placeholder")
+	}
+	if q.Ancestor != nil {
+		cols, err := t.ancestor(q.Ancestor)
+		if err != nil {
+			return nil, err
+		}
+		conds = append(conds, r.equals(t.name, cols, a)...)
+	}
+	for _, f := range q.Filters {
+		column := strings.ToLower(f.Field)
+		fld, exist := t.field(column)
+		if !exist {
+			return nil, fmt.Errorf("unknown property %s of kind %s", f.Field, q.Kind)
+		}
+		if fld.nullIfZero && f.Op == "=" && reflect.ValueOf(f.Value).IsZero() {
+			conds = append(conds, fmt.Sprintf("%s.%s IS NULL", t.name, quote(column)))
+			continue
+		}
+		conds = append(conds, fmt.Sprintf("%s.%s %s %s", t.name, quote(column), sqlOps[f.Op], a.add(f.Value)))
+	}
+
+	stmt := fmt.Sprintf("SELECT %s FROM %s", t.selectList(), t.name)
+	if len(conds) > 0 {
+		stmt += " WHERE " + strings.Join(conds, " AND ")
+	}
+	var order []string
+	for _, o := range q.Orders {
+		column := strings.ToLower(o.Field)
+		if _, exist := t.field(column); !exist {
+			return nil, fmt.Errorf("unknown property %s of kind %s", o.Field, q.Kind)
+		}
+		if o.Descending {
+			order = append(order, t.name+"."+quote(column)+" DESC")
+		} else {
+			order = append(order, t.name+"."+quote(column))
+		}
+	}
+	for _, c := range t.pk {
+		order = append(order, t.name+"."+quote(c))
+	}
+	stmt += " ORDER BY " + strings.Join(order, ", ")
+	if q.GetLimit() > 0 {
+		stmt += fmt.Sprintf(" LIMIT %d", q.GetLimit())
+	} else if q.GetOffset() > 0 {
+		stmt += " LIMIT " + r.dialect.NoLimit
+	}
+	if q.GetOffset() > 0 {
+		stmt += fmt.Sprintf(" OFFSET %d", q.GetOffset())
+	}
+
+	rows, err := ex.QueryContext(r.ctx, stmt, a.values...)
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("error querying %s", q.Kind))
+	}
+	defer rows.Close()
+
+	var keys []*db.Key
+	for rows.Next() {
+		var entity reflect.Value
+		if slice.IsValid() {
+			entity = reflect.New(t.typ).Elem()
+		}
+		key, err := t.scan(rows, entity)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+		if slice.IsValid() {
+			if slice.Type().Elem().Kind() == reflect.Ptr {
+				slice.Set(reflect.Append(slice, entity.Addr()))
+			} else {
+				slice.Set(reflect.Append(slice, entity))
+			}
+		}
+	}
+	return keys, rows.Err()
+}
+
+func (r *Repository) putMulti(ex execer, keys []*db.Key, src interface{}) error {
+	v := reflect.ValueOf(src)
+	if v.Kind() != reflect.Slice || v.Len() != len(keys) {
+		return fmt.Errorf("src must be a slice of length %d", len(keys))
+	}
+	for i, key := range keys {
+		err := r.put(ex, key, v.Index(i).Interface())
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *Repository) put(ex execer, key *db.Key, src interface{}) error {
+	t, err := r.schema.byKind(key.Kind)
+	if err != nil {
+		return err
+	}
+	entity, err := entityValue(src, t.typ)
+	if err != nil {
+		return err
+	}
+
+	for _, p := range t.parents(key, entity) {
+		err = r.ensure(ex, p)
+		if err != nil {
+			return err
+		}
+	}
+
+	cols, err := t.keyColumns(key)
+	if err != nil {
+		return err
+	}
+	for _, f := range t.fields {
+		if _, isKey := cols[f.column]; !isKey {
+			cols[f.column] = f.value(entity)
+		}
+	}
+	if t.placeholder {
+		cols["placeholder"] = false
+	}
+
+	a := &args{dialect: r.dialect}
+	var names, values, updates []string
+	for _, c := range sortedColumns(cols) {
+		names = append(names, quote(c))
+		values = append(values, a.add(cols[c]))
+		updates = append(updates, fmt.Sprintf("%s = excluded.%s", quote(c), quote(c)))
+	}
+	var pk []string
+	for _, c := range t.pk {
+		pk = append(pk, quote(c))
+	}
+
+	_, err = ex.ExecContext(r.ctx, fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s) ON CONFLICT (%s) DO UPDATE SET %s",
+		t.name, strings.Join(names, ", "), strings.Join(values, ", "), strings.Join(pk, ", "), strings.Join(updates, ", ")),
+		a.values...)
+	return errors.Wrap(err, fmt.Sprintf("error saving %s", key.String()))
+}
+
+// ensure creates a placeholder row for a referenced key not stored yet.
+func (r *Repository) ensure(ex execer, key *db.Key) error {
+	t, err := r.schema.byKind(key.Kind)
+	if err != nil {
+		return err
+	}
+	if !t.placeholder {
+		return nil
+	}
+	for _, p := range t.parents(key, reflect.Value{}) {
+		err = r.ensure(ex, p)
+		if err != nil {
+			return err
+		}
+	}
+
+	cols, err := t.keyColumns(key)
+	if err != nil {
+		return err
+	}
+	cols["placeholder"] = true
+
+	a := &args{dialect: r.dialect}
+	var names, values []string
+	for _, c := range sortedColumns(cols) {
+		names = append(names, quote(c))
+		values = append(values, a.add(cols[c]))
+	}
+	_, err = ex.ExecContext(r.ctx, fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s) ON CONFLICT DO NOTHING",
+		t.name, strings.Join(names, ", "), strings.Join(values, ", ")), a.values...)
+	return errors.Wrap(err, fmt.Sprintf("error creating placeholder %s", key.String()))
+}
+
+func (t *table) unreferenced() []string {
+	var conds []string
+	for _, ref := range t.referenced {
+		var match []string
+		for i, c := range ref.columns {
+			match = append(match, fmt.Sprintf("c.%s = %s.%s", quote(c), t.name, quote(t.pk[i])))
+		}
+		conds = append(conds, fmt.Sprintf("NOT EXISTS (SELECT 1 FROM %s c WHERE %s)", ref.table, strings.Join(match, " AND ")))
+	}
+	return conds
+}
+
+// delete removes the row of the key. A row still referenced by others is
+// turned into a placeholder instead.
+func (r *Repository) delete(ex execer, key *db.Key) error {
+	t, err := r.schema.byKind(key.Kind)
+	if err != nil {
+		return err
+	}
+
+	entity := reflect.New(t.typ).Elem()
+	err = r.load(ex, t, key, entity, true)
+	if err == db.ErrNoSuchEntity {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	pk, err := t.pkColumns(key)
+	if err != nil {
+		return err
+	}
+
+	if t.placeholder && len(t.referenced) > 0 {
+		a := &args{dialect: r.dialect}
+		_, err = ex.ExecContext(r.ctx, fmt.Sprintf("UPDATE %s SET placeholder = %s WHERE %s",
+			t.name, a.add(true), strings.Join(r.equals(t.name, pk, a), " AND ")), a.values...)
+		if err != nil {
+			return errors.Wrap(err, fmt.Sprintf("error deleting %s", key.String()))
+		}
+	}
+
+	a := &args{dialect: r.dialect}
+	conds := append(r.equals(t.name, pk, a), t.unreferenced()...)
+	_, err = ex.ExecContext(r.ctx, fmt.Sprintf("DELETE FROM %s WHERE %s", t.name, strings.Join(conds, " AND ")), a.values...)
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("error deleting %s", key.String()))
+	}
+
+	for _, p := range t.parents(key, entity) {
+		err = r.prune(ex, p)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// prune removes a placeholder row that is not referenced anymore.
+func (r *Repository) prune(ex execer, key *db.Key) error {
+	t, err := r.schema.byKind(key.Kind)
+	if err != nil {
+		return err
+	}
+	if !t.placeholder {
+		return nil
+	}
+	pk, err := t.pkColumns(key)
+	if err != nil {
+		return err
+	}
+
+	a := &args{dialect: r.dialect}
+	conds := append(r.equals(t.name, pk, a), t.name+".placeholder")
+	conds = append(conds, t.unreferenced()...)
+	res, err := ex.ExecContext(r.ctx, fmt.Sprintf("DELETE FROM %s WHERE %s", t.name, strings.Join(conds, " AND ")), a.values...)
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("error pruning %s", key.String()))
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return nil
+	}
+	for _, p := range t.parents(key, reflect.Value{}) {
+		err = r.prune(ex, p)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,460 @@
+package sqlstore
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+
+	allris_common "github.com/rismaster/allris-common"
+	"github.com/rismaster/allris-db/db"
+)
+
+// reference is a column set of another table pointing to a row of this table.
+type reference struct {
+	table   string
+	columns []string
+}
+
+// table maps one entity kind to its relational table. Key columns are
+// derived from the entity key, the remaining columns from the entity fields.
+type table struct {
+	kind        string
+	name        string
+	typ         reflect.Type
+	fields      []field
+	pk          []string
+	placeholder bool
+	referenced  []reference
+
+	// keyColumns returns the primary key and parent reference columns of a key.
+	keyColumns func(k *db.Key) (map[string]interface{}, error)
+	// key rebuilds the entity key from the scanned primary key columns.
+	key func(pk []interface{}) (*db.Key, error)
+	// ancestor returns the columns selecting all descendants of a key.
+	ancestor func(a *db.Key) (map[string]interface{}, error)
+	// parents returns the keys of rows the entity references by foreign key.
+	parents func(k *db.Key, entity reflect.Value) []*db.Key
+}
+
+type schema struct {
+	tables []*table
+}
+
+func keyID(k *db.Key) (int64, error) {
+	if k == nil {
+		return 0, fmt.Errorf("missing key")
+	}
+	id, err := strconv.ParseInt(k.Name, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("key %s has no numeric name", k.String())
+	}
+	return id, nil
+}
+
+func keyName(v interface{}) string {
+	return fmt.Sprintf("%v", v)
+}
+
+func newSchema(config allris_common.Config) *schema {
+
+	sitzungKind := config.GetEntitySitzung()
+	vorlageKind := config.GetEntityVorlage()
+	topKind := config.GetEntityTop()
+	anlageKind := config.GetEntityAnlage()
+	terminKind := config.GetEntityTermin()
+
+	sitzung := &table{
+		kind:        sitzungKind,
+		name:        "sitzung",
+		typ:         reflect.TypeOf(db.Sitzung{}),
+		pk:          []string{"silfdnr"},
+		placeholder: true,
+		referenced: []reference{
+			{table: "top", columns: []string{"silfdnr"}},
+			{table: "anlage", columns: []string{"parent_silfdnr"}},
+		},
+		keyColumns: func(k *db.Key) (map[string]interface{}, error) {
+			id, err := keyID(k)
+			return map[string]interface{}{"silfdnr": id}, err
+		},
+		key: func(pk []interface{}) (*db.Key, error) {
+			return db.NameKey(sitzungKind, keyName(pk[0]), nil), nil
+		},
+		parents: func(k *db.Key, entity reflect.Value) []*db.Key {
+			return nil
+		},
+	}
+	sitzung.ancestor = func(a *db.Key) (map[string]interface{}, error) {
+		if a.Kind != sitzungKind {
+			return nil, fmt.Errorf("%s cannot be ancestor of %s", a.Kind, sitzungKind)
+		}
+		return sitzung.keyColumns(a)
+	}
+
+	vorlage := &table{
+		kind:        vorlageKind,
+		name:        "vorlage",
+		typ:         reflect.TypeOf(db.Vorlage{}),
+		pk:          []string{"volfdnr"},
+		placeholder: true,
+		referenced: []reference{
+			{table: "top", columns: []string{"volfdnr"}},
+			{table: "anlage", columns: []string{"parent_volfdnr"}},
+			{table: "vorlage_revision", columns: []string{"volfdnr"}},
+		},
+		keyColumns: func(k *db.Key) (map[string]interface{}, error) {
+			id, err := keyID(k)
+			return map[string]interface{}{"volfdnr": id}, err
+		},
+		key: func(pk []interface{}) (*db.Key, error) {
+			return db.NameKey(vorlageKind, keyName(pk[0]), nil), nil
+		},
+		parents: func(k *db.Key, entity reflect.Value) []*db.Key {
+			return nil
+		},
+	}
+	vorlage.ancestor = func(a *db.Key) (map[string]interface{}, error) {
+		if a.Kind != vorlageKind {
+			return nil, fmt.Errorf("%s cannot be ancestor of %s", a.Kind, vorlageKind)
+		}
+		return vorlage.keyColumns(a)
+	}
+
+	top := &table{
+		kind:        topKind,
+		name:        "top",
+		typ:         reflect.TypeOf(db.Top{}),
+		pk:          []string{"silfdnr", "tolfdnr"},
+		placeholder: true,
+		referenced: []reference{
+			{table: "anlage", columns: []string{"parent_silfdnr", "parent_tolfdnr"}},
+			{table: "abstimmung", columns: []string{"silfdnr", "tolfdnr"}},
+		},
+		keyColumns: func(k *db.Key) (map[string]interface{}, error) {
+			if k == nil || k.Parent == nil || k.Parent.Kind != sitzungKind {
+				return nil, fmt.Errorf("%s key %s needs a %s parent", topKind, k.String(), sitzungKind)
+			}
+			silfdnr, err := keyID(k.Parent)
+			if err != nil {
+				return nil, err
+			}
+			tolfdnr, err := keyID(k)
+			return map[string]interface{}{"silfdnr": silfdnr, "tolfdnr": tolfdnr}, err
+		},
+		key: func(pk []interface{}) (*db.Key, error) {
+			return db.NameKey(topKind, keyName(pk[1]), db.NameKey(sitzungKind, keyName(pk[0]), nil)), nil
+		},
+		parents: func(k *db.Key, entity reflect.Value) []*db.Key {
+			parents := []*db.Key{k.Parent}
+			if entity.IsValid() {
+				volfdnr := entity.FieldByName("VOLFDNR").Int()
+				if volfdnr != 0 {
+					parents = append(parents, db.NameKey(vorlageKind, keyName(volfdnr), nil))
+				}
+			}
+			return parents
+		},
+	}
+	top.ancestor = func(a *db.Key) (map[string]interface{}, error) {
+		switch a.Kind {
+		case sitzungKind:
+			id, err := keyID(a)
+			return map[string]interface{}{"silfdnr": id}, err
+		case topKind:
+			return top.keyColumns(a)
+		}
+		return nil, fmt.Errorf("%s cannot be ancestor of %s", a.Kind, topKind)
+	}
+
+	anlageParents := func(a *db.Key) (map[string]interface{}, error) {
+		cols := map[string]interface{}{
+			"parent_silfdnr": nil,
+			"parent_tolfdnr": nil,
+			"parent_volfdnr": nil,
+		}
+		switch a.Kind {
+		case sitzungKind:
+			id, err := keyID(a)
+			cols["parent_silfdnr"] = id
+			return cols, err
+		case vorlageKind:
+			id, err := keyID(a)
+			cols["parent_volfdnr"] = id
+			return cols, err
+		case topKind:
+			tc, err := top.keyColumns(a)
+			cols["parent_silfdnr"] = tc["silfdnr"]
+			cols["parent_tolfdnr"] = tc["tolfdnr"]
+			return cols, err
+		}
+		return nil, fmt.Errorf("%s cannot be parent of %s", a.Kind, anlageKind)
+	}
+
+	anlage := &table{
+		kind: anlageKind,
+		name: "anlage",
+		typ:  reflect.TypeOf(db.Anlage{}),
+		pk:   []string{"key"},
+		keyColumns: func(k *db.Key) (map[string]interface{}, error) {
+			if k == nil || k.Parent == nil {
+				return nil, fmt.Errorf("%s key %s needs a parent", anlageKind, k.String())
+			}
+			cols, err := anlageParents(k.Parent)
+			if err != nil {
+				return nil, err
+			}
+			cols["key"] = k.Encode()
+			return cols, nil
+		},
+		key: func(pk []interface{}) (*db.Key, error) {
+			return db.DecodeKey(keyName(pk[0]))
+		},
+		ancestor: func(a *db.Key) (map[string]interface{}, error) {
+			cols, err := anlageParents(a)
+			if err != nil {
+				return nil, err
+			}
+			for c, v := range cols {
+				if v == nil {
+					delete(cols, c)
+				}
+			}
+			return cols, nil
+		},
+		parents: func(k *db.Key, entity reflect.Value) []*db.Key {
+			return []*db.Key{k.Parent}
+		},
+	}
+
+	revision := &table{
+		kind: db.EntityVorlageRevision,
+		name: "vorlage_revision",
+		typ:  reflect.TypeOf(db.VorlageRevision{}),
+		pk:   []string{"volfdnr", "name"},
+		keyColumns: func(k *db.Key) (map[string]interface{}, error) {
+			if k == nil || k.Parent == nil || k.Parent.Kind != vorlageKind {
+				return nil, fmt.Errorf("%s key %s needs a %s parent", db.EntityVorlageRevision, k.String(), vorlageKind)
+			}
+			volfdnr, err := keyID(k.Parent)
+			return map[string]interface{}{"volfdnr": volfdnr, "name": k.Name}, err
+		},
+		key: func(pk []interface{}) (*db.Key, error) {
+			return db.NameKey(db.EntityVorlageRevision, keyName(pk[1]), db.NameKey(vorlageKind, keyName(pk[0]), nil)), nil
+		},
+		parents: func(k *db.Key, entity reflect.Value) []*db.Key {
+			return []*db.Key{k.Parent}
+		},
+	}
+	revision.ancestor = func(a *db.Key) (map[string]interface{}, error) {
+		switch a.Kind {
+		case vorlageKind:
+			return vorlage.keyColumns(a)
+		case db.EntityVorlageRevision:
+			return revision.keyColumns(a)
+		}
+		return nil, fmt.Errorf("%s cannot be ancestor of %s", a.Kind, db.EntityVorlageRevision)
+	}
+
+	termin := &table{
+		kind: terminKind,
+		name: "termin",
+		typ:  reflect.TypeOf(db.Termin{}),
+		pk:   []string{"name"},
+		keyColumns: func(k *db.Key) (map[string]interface{}, error) {
+			return map[string]interface{}{"name": k.Name}, nil
+		},
+		key: func(pk []interface{}) (*db.Key, error) {
+			return db.NameKey(terminKind, keyName(pk[0]), nil), nil
+		},
+		parents: func(k *db.Key, entity reflect.Value) []*db.Key {
+			return nil
+		},
+	}
+	termin.ancestor = func(a *db.Key) (map[string]interface{}, error) {
+		if a.Kind != terminKind {
+			return nil, fmt.Errorf("%s cannot be ancestor of %s", a.Kind, terminKind)
+		}
+		return termin.keyColumns(a)
+	}
+
+	move := &table{
+		kind: db.EntityTerminMove,
+		name: "termin_move",
+		typ:  reflect.TypeOf(db.TerminMove{}),
+		pk:   []string{"termin", "name"},
+		keyColumns: func(k *db.Key) (map[string]interface{}, error) {
+			if k == nil || k.Parent == nil || k.Parent.Kind != terminKind {
+				return nil, fmt.Errorf("%s key %s needs a %s parent", db.EntityTerminMove, k.String(), terminKind)
+			}
+			return map[string]interface{}{"termin": k.Parent.Name, "name": k.Name}, nil
+		},
+		key: func(pk []interface{}) (*db.Key, error) {
+			return db.NameKey(db.EntityTerminMove, keyName(pk[1]), db.NameKey(terminKind, keyName(pk[0]), nil)), nil
+		},
+		parents: func(k *db.Key, entity reflect.Value) []*db.Key {
+			return nil
+		},
+	}
+	move.ancestor = func(a *db.Key) (map[string]interface{}, error) {
+		switch a.Kind {
+		case terminKind:
+			return map[string]interface{}{"termin": a.Name}, nil
+		case db.EntityTerminMove:
+			return move.keyColumns(a)
+		}
+		return nil, fmt.Errorf("%s cannot be ancestor of %s", a.Kind, db.EntityTerminMove)
+	}
+
+	content := &table{
+		kind: db.EntityAnlageContent,
+		name: "anlage_content",
+		typ:  reflect.TypeOf(db.AnlageContent{}),
+		pk:   []string{"sha256"},
+		keyColumns: func(k *db.Key) (map[string]interface{}, error) {
+			return map[string]interface{}{"sha256": k.Name}, nil
+		},
+		key: func(pk []interface{}) (*db.Key, error) {
+			return db.AnlageContentKey(keyName(pk[0])), nil
+		},
+		parents: func(k *db.Key, entity reflect.Value) []*db.Key {
+			return nil
+		},
+	}
+	content.ancestor = func(a *db.Key) (map[string]interface{}, error) {
+		if a.Kind != db.EntityAnlageContent {
+			return nil, fmt.Errorf("%s cannot be ancestor of %s", a.Kind, db.EntityAnlageContent)
+		}
+		return content.keyColumns(a)
+	}
+
+	text := &table{
+		kind: db.EntityAnlageText,
+		name: "anlage_text",
+		typ:  reflect.TypeOf(db.AnlageText{}),
+		pk:   []string{"sha256", "page"},
+		keyColumns: func(k *db.Key) (map[string]interface{}, error) {
+			if k == nil || k.Parent == nil || k.Parent.Kind != db.EntityAnlageContent {
+				return nil, fmt.Errorf("%s key %s needs a %s parent", db.EntityAnlageText, k.String(), db.EntityAnlageContent)
+			}
+			page, err := keyID(k)
+			return map[string]interface{}{"sha256": k.Parent.Name, "page": page}, err
+		},
+		key: func(pk []interface{}) (*db.Key, error) {
+			return db.NameKey(db.EntityAnlageText, keyName(pk[1]), db.AnlageContentKey(keyName(pk[0]))), nil
+		},
+		parents: func(k *db.Key, entity reflect.Value) []*db.Key {
+			return nil
+		},
+	}
+	text.ancestor = func(a *db.Key) (map[string]interface{}, error) {
+		switch a.Kind {
+		case db.EntityAnlageContent:
+			return content.keyColumns(a)
+		case db.EntityAnlageText:
+			return text.keyColumns(a)
+		}
+		return nil, fmt.Errorf("%s cannot be ancestor of %s", a.Kind, db.EntityAnlageText)
+	}
+
+	abstimmung := &table{
+		kind:        db.EntityAbstimmung,
+		name:        "abstimmung",
+		typ:         reflect.TypeOf(db.Abstimmung{}),
+		pk:          []string{"silfdnr", "tolfdnr"},
+		placeholder: true,
+		referenced: []reference{
+			{table: "fraktions_votum", columns: []string{"silfdnr", "tolfdnr"}},
+		},
+		keyColumns: func(k *db.Key) (map[string]interface{}, error) {
+			if k == nil || k.Parent == nil || k.Parent.Kind != topKind {
+				return nil, fmt.Errorf("%s key %s needs a %s parent", db.EntityAbstimmung, k.String(), topKind)
+			}
+			return top.keyColumns(k.Parent)
+		},
+		key: func(pk []interface{}) (*db.Key, error) {
+			sitzung := db.NameKey(sitzungKind, keyName(pk[0]), nil)
+			return db.NameKey(db.EntityAbstimmung, keyName(pk[1]), db.NameKey(topKind, keyName(pk[1]), sitzung)), nil
+		},
+		parents: func(k *db.Key, entity reflect.Value) []*db.Key {
+			return []*db.Key{k.Parent}
+		},
+	}
+	abstimmung.ancestor = func(a *db.Key) (map[string]interface{}, error) {
+		switch a.Kind {
+		case sitzungKind:
+			return sitzung.keyColumns(a)
+		case topKind:
+			return top.keyColumns(a)
+		case db.EntityAbstimmung:
+			return abstimmung.keyColumns(a)
+		}
+		return nil, fmt.Errorf("%s cannot be ancestor of %s", a.Kind, db.EntityAbstimmung)
+	}
+
+	votum := &table{
+		kind: db.EntityFraktionsVotum,
+		name: "fraktions_votum",
+		typ:  reflect.TypeOf(db.FraktionsVotum{}),
+		pk:   []string{"silfdnr", "tolfdnr", "name"},
+		keyColumns: func(k *db.Key) (map[string]interface{}, error) {
+			if k == nil || k.Parent == nil || k.Parent.Kind != db.EntityAbstimmung {
+				return nil, fmt.Errorf("%s key %s needs a %s parent", db.EntityFraktionsVotum, k.String(), db.EntityAbstimmung)
+			}
+			cols, err := abstimmung.keyColumns(k.Parent)
+			if err != nil {
+				return nil, err
+			}
+			cols["name"] = k.Name
+			return cols, nil
+		},
+		key: func(pk []interface{}) (*db.Key, error) {
+			a, err := abstimmung.key(pk[:2])
+			return db.NameKey(db.EntityFraktionsVotum, keyName(pk[2]), a), err
+		},
+		parents: func(k *db.Key, entity reflect.Value) []*db.Key {
+			return []*db.Key{k.Parent}
+		},
+	}
+	votum.ancestor = func(a *db.Key) (map[string]interface{}, error) {
+		if a.Kind == db.EntityFraktionsVotum {
+			return votum.keyColumns(a)
+		}
+		return abstimmung.ancestor(a)
+	}
+
+	nullIfZero := map[string]map[string]bool{
+		"top": {"volfdnr": true},
+	}
+	s := &schema{tables: []*table{sitzung, vorlage, top, anlage, revision, termin, move, content, text, abstimmung, votum}}
+	for _, t := range s.tables {
+		t.fields = fieldsOf(t.typ, nullIfZero[t.name])
+	}
+	return s
+}
+
+func (s *schema) byKind(kind string) (*table, error) {
+	for _, t := range s.tables {
+		if t.kind == kind {
+			return t, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown kind %s", kind)
+}
+
+func (s *schema) byType(typ reflect.Type) (*table, error) {
+	for _, t := range s.tables {
+		if t.typ == typ {
+			return t, nil
+		}
+	}
+	return nil, fmt.Errorf("no table for type %s", typ)
+}
+
+func (t *table) field(column string) (field, bool) {
+	for _, f := range t.fields {
+		if f.column == column {
+			return f, true
+		}
+	}
+	return field{}, false
+}
@@ -0,0 +1,86 @@
+package db
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// Key identifies a stored entity independent of the storage backend.
+// It follows the datastore model of a kind, a name and an optional parent.
+type Key struct {
+	Kind   string
+	Name   string
+	Parent *Key
+}
+
+func NameKey(kind string, name string, parent *Key) *Key {
+	return &Key{
+		Kind:   kind,
+		Name:   name,
+		Parent: parent,
+	}
+}
+
+// Encode returns a string usable as map key, unique for the whole key path.
+func (k *Key) Encode() string {
+	if k == nil {
+		return ""
+	}
+	enc := url.PathEscape(k.Kind) + ":" + url.PathEscape(k.Name)
+	if k.Parent != nil {
+		return k.Parent.Encode() + "/" + enc
+	}
+	return enc
+}
+
+func DecodeKey(encoded string) (*Key, error) {
+	var key *Key
+	for _, elem := range strings.Split(encoded, "/") {
+		parts := strings.SplitN(elem, ":", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid key element %q in %q", elem, encoded)
+		}
+		kind, err := url.PathUnescape(parts[0])
+		if err != nil {
+			return nil, err
+		}
+		name, err := url.PathUnescape(parts[1])
+		if err != nil {
+			return nil, err
+		}
+		key = NameKey(kind, name, key)
+	}
+	return key, nil
+}
+
+func (k *Key) String() string {
+	if k == nil {
+		return ""
+	}
+	s := fmt.Sprintf("/%s,%s", k.Kind, k.Name)
+	if k.Parent != nil {
+		return k.Parent.String() + s
+	}
+	return s
+}
+
+func (k *Key) Equal(o *Key) bool {
+	for k != nil && o != nil {
+		if k.Kind != o.Kind || k.Name != o.Name {
+			return false
+		}
+		k, o = k.Parent, o.Parent
+	}
+	return k == o
+}
+
+// HasAncestor reports whether a is k itself or one of its parents.
+func (k *Key) HasAncestor(a *Key) bool {
+	for ; k != nil; k = k.Parent {
+		if k.Equal(a) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,247 @@
+package db
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	allris_common "github.com/rismaster/allris-common"
+)
+
+// Lineage is the graph of the Bezueglich references between the stored
+// Vorlagen: Änderungsanträge, Anträge and follow-ups refer to the Vorlage
+// they are about, a Vorlage is the parent of the Vorlagen referring to it.
+// A reference is resolved by BezueglichVOLFDNR, by BezueglichBSVV if the
+// VOLFDNR is unknown or not stored. Unresolved references are Dangling,
+// Cycles are the reference cycles, each starting at its lowest VOLFDNR.
+type Lineage struct {
+	Dangling []*DanglingReference
+	Cycles   [][]int
+
+	vorlagen map[int]*Vorlage
+	parent   map[int]int
+	children map[int][]int
+}
+
+// DanglingReference is a Bezueglich reference to a Vorlage not stored.
+type DanglingReference struct {
+	VOLFDNR           int
+	BezueglichVOLFDNR int
+	BezueglichBSVV    string
+}
+
+// BuildLineage resolves the Bezueglich references of all stored Vorlagen.
+// The Bezueglich field of the Vorlagen of the Lineage is set to the
+// referenced Vorlage, except for references within a cycle.
+func BuildLineage(repo Repository, config allris_common.Config) (*Lineage, error) {
+
+	var vorlagen []*Vorlage
+	_, err := repo.GetAll(NewQuery(config.GetEntityVorlage()), &vorlagen)
+	if err != nil {
+		return nil, errors.Wrap(err, "error getting vorlagen from db")
+	}
+	return NewLineage(vorlagen), nil
+}
+
+// NewLineage builds the Lineage of the given Vorlagen, see BuildLineage.
+func NewLineage(vorlagen []*Vorlage) *Lineage {
+
+	l := &Lineage{
+		vorlagen: make(map[int]*Vorlage),
+		parent:   make(map[int]int),
+		children: make(map[int][]int),
+	}
+	byBSVV := make(map[string]int)
+	for _, v := range vorlagen {
+		l.vorlagen[v.VOLFDNR] = v
+	}
+	ids := l.ids()
+	for _, id := range ids {
+		bsvv := normalizeBSVV(l.vorlagen[id].BSVV)
+		if _, ok := byBSVV[bsvv]; bsvv != "" && !ok {
+			byBSVV[bsvv] = id
+		}
+	}
+
+	for _, id := range ids {
+		v := l.vorlagen[id]
+		if v.BezueglichVOLFDNR <= 0 && normalizeBSVV(v.BezueglichBSVV) == "" {
+			continue
+		}
+		parent, ok := v.BezueglichVOLFDNR, false
+		if _, stored := l.vorlagen[parent]; parent > 0 && stored {
+			ok = true
+		} else if parent, ok = byBSVV[normalizeBSVV(v.BezueglichBSVV)]; !ok {
+			l.Dangling = append(l.Dangling, &DanglingReference{
+				VOLFDNR:           id,
+				BezueglichVOLFDNR: v.BezueglichVOLFDNR,
+				BezueglichBSVV:    v.BezueglichBSVV,
+			})
+			continue
+		}
+		l.parent[id] = parent
+		l.children[parent] = append(l.children[parent], id)
+	}
+
+	l.findCycles(ids)
+	inCycle := make(map[int]bool)
+	for _, c := range l.Cycles {
+		for _, id := range c {
+			inCycle[id] = true
+		}
+	}
+	for id, parent := range l.parent {
+		if !inCycle[id] {
+			l.vorlagen[id].Bezueglich = l.vorlagen[parent]
+		}
+	}
+	return l
+}
+
+func normalizeBSVV(bsvv string) string {
+	return strings.ToLower(strings.Join(strings.Fields(bsvv), ""))
+}
+
+// ids are the VOLFDNRs of the Vorlagen in ascending order.
+func (l *Lineage) ids() []int {
+	ids := make([]int, 0, len(l.vorlagen))
+	for id := range l.vorlagen {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	return ids
+}
+
+// findCycles follows the references from every Vorlage. Each Vorlage has at
+// most one parent, so a path either ends at a root or runs into a cycle.
+func (l *Lineage) findCycles(ids []int) {
+
+	const (
+		unvisited = iota
+		onPath
+		done
+	)
+	state := make(map[int]int)
+	for _, start := range ids {
+		var path []int
+		id, ok := start, true
+		for ok && state[id] == unvisited {
+			state[id] = onPath
+			path = append(path, id)
+			id, ok = l.parent[id]
+		}
+		if ok && state[id] == onPath {
+			for i, p := range path {
+				if p == id {
+					l.Cycles = append(l.Cycles, rotateToMin(path[i:]))
+					break
+				}
+			}
+		}
+		for _, p := range path {
+			state[p] = done
+		}
+	}
+	sort.Slice(l.Cycles, func(i, j int) bool {
+		return l.Cycles[i][0] < l.Cycles[j][0]
+	})
+}
+
+func rotateToMin(cycle []int) []int {
+	min := 0
+	for i, id := range cycle {
+		if id < cycle[min] {
+			min = i
+		}
+	}
+	return append(append([]int{}, cycle[min:]...), cycle[:min]...)
+}
+
+// Vorlage returns the Vorlage with the VOLFDNR, nil if it isn't stored.
+func (l *Lineage) Vorlage(volfdnr int) *Vorlage {
+	return l.vorlagen[volfdnr]
+}
+
+// Roots returns the Vorlagen referred to by others without referring to a
+// stored Vorlage themselves.
+func (l *Lineage) Roots() []*Vorlage {
+	var roots []*Vorlage
+	for _, id := range l.ids() {
+		if _, ok := l.parent[id]; !ok && len(l.children[id]) > 0 {
+			roots = append(roots, l.vorlagen[id])
+		}
+	}
+	return roots
+}
+
+// Ancestors returns the Vorlagen a Vorlage refers to, directly or not, the
+// referenced Vorlage first. It stops before repeating a Vorlage of a cycle.
+func (l *Lineage) Ancestors(volfdnr int) []*Vorlage {
+
+	var ancestors []*Vorlage
+	seen := map[int]bool{volfdnr: true}
+	for {
+		parent, ok := l.parent[volfdnr]
+		if !ok || seen[parent] {
+			return ancestors
+		}
+		seen[parent] = true
+		ancestors = append(ancestors, l.vorlagen[parent])
+		volfdnr = parent
+	}
+}
+
+// Descendants returns the Vorlagen referring to a Vorlage, directly or not,
+// breadth first and by VOLFDNR within a generation.
+func (l *Lineage) Descendants(volfdnr int) []*Vorlage {
+
+	var descendants []*Vorlage
+	seen := map[int]bool{volfdnr: true}
+	queue := []int{volfdnr}
+	for len(queue) > 0 {
+		children := append([]int{}, l.children[queue[0]]...)
+		queue = queue[1:]
+		sort.Ints(children)
+		for _, child := range children {
+			if seen[child] {
+				continue
+			}
+			seen[child] = true
+			descendants = append(descendants, l.vorlagen[child])
+			queue = append(queue, child)
+		}
+	}
+	return descendants
+}
+
+// LineageNode is a Vorlage with the Vorlagen referring to it.
+type LineageNode struct {
+	VOLFDNR  int
+	BSVV     string
+	Betreff  string
+	Children []*LineageNode
+}
+
+// Tree returns the tree of the Descendants of a Vorlage, nil if it isn't
+// stored. A cycle is cut where it returns to a Vorlage of the tree.
+func (l *Lineage) Tree(volfdnr int) *LineageNode {
+	if l.vorlagen[volfdnr] == nil {
+		return nil
+	}
+	return l.tree(volfdnr, make(map[int]bool))
+}
+
+func (l *Lineage) tree(volfdnr int, seen map[int]bool) *LineageNode {
+
+	seen[volfdnr] = true
+	v := l.vorlagen[volfdnr]
+	node := &LineageNode{VOLFDNR: v.VOLFDNR, BSVV: v.BSVV, Betreff: v.Betreff}
+	children := append([]int{}, l.children[volfdnr]...)
+	sort.Ints(children)
+	for _, child := range children {
+		if !seen[child] {
+			node.Children = append(node.Children, l.tree(child, seen))
+		}
+	}
+	return node
+}
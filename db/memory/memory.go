@@ -0,0 +1,493 @@
+// Package memory keeps the ALLRIS entities in memory with the semantics of
+// Cloud Datastore: ancestor and property filters, results ordered by key and
+// transactions that read committed state and fail on concurrent changes.
+// Fields tagged datastore:",noindex" aren't indexed, filters and orders on
+// them match nothing.
+package memory
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rismaster/allris-common/common/files"
+	"github.com/rismaster/allris-db/db"
+)
+
+// ErrConcurrentTransaction is returned by Commit if an entity read in the
+// transaction was changed by someone else in the meantime.
+var ErrConcurrentTransaction = errors.New("memory: concurrent transaction")
+
+var ErrTransactionFinished = errors.New("memory: transaction already committed or rolled back")
+
+type entry struct {
+	key     *db.Key
+	value   reflect.Value
+	version int64
+}
+
+type Repository struct {
+	mu      sync.RWMutex
+	entries map[string]*entry
+	version int64
+}
+
+type mutation struct {
+	key   *db.Key
+	value reflect.Value // invalid for deletes
+}
+
+type Transaction struct {
+	r         *Repository
+	reads     map[string]int64
+	mutations []mutation
+	finished  bool
+}
+
+func New() *Repository {
+	return &Repository{entries: make(map[string]*entry)}
+}
+
+// Len returns the number of stored entities.
+func (r *Repository) Len() int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return len(r.entries)
+}
+
+// copyEntity copies the persisted fields, like a round trip through the
+// datastore would: exported and not tagged with datastore:"-".
+func copyEntity(dst reflect.Value, src reflect.Value) {
+	t := src.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" || strings.Split(f.Tag.Get("datastore"), ",")[0] == "-" {
+			continue
+		}
+		dst.Field(i).Set(src.Field(i))
+	}
+}
+
+func structValue(v interface{}) (reflect.Value, error) {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() == reflect.Ptr {
+		rv = rv.Elem()
+	}
+	if !rv.IsValid() || rv.Kind() != reflect.Struct {
+		return reflect.Value{}, fmt.Errorf("invalid entity type %T", v)
+	}
+	return rv, nil
+}
+
+func snapshot(src interface{}) (reflect.Value, error) {
+	rv, err := structValue(src)
+	if err != nil {
+		return reflect.Value{}, err
+	}
+	c := reflect.New(rv.Type()).Elem()
+	copyEntity(c, rv)
+	return c, nil
+}
+
+func load(e *entry, dst interface{}) error {
+	rv := reflect.ValueOf(dst)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Type() != e.value.Type() {
+		return fmt.Errorf("invalid dst %T for %s", dst, e.key.String())
+	}
+	copyEntity(rv.Elem(), e.value)
+	return nil
+}
+
+func (r *Repository) Get(key *db.Key, dst interface{}) error {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	e, exist := r.entries[key.Encode()]
+	if !exist {
+		return db.ErrNoSuchEntity
+	}
+	return load(e, dst)
+}
+
+func (r *Repository) GetAll(q *db.Query, dst interface{}) ([]*db.Key, error) {
+	if q.Err() != nil {
+		return nil, q.Err()
+	}
+
+	var slice reflect.Value
+	if dst != nil && !q.IsKeysOnly() {
+		slice = reflect.ValueOf(dst)
+		if slice.Kind() != reflect.Ptr || slice.Elem().Kind() != reflect.Slice {
+			return nil, fmt.Errorf("dst must be a pointer to a slice, is %T", dst)
+		}
+		slice = slice.Elem()
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var found []*entry
+	for _, e := range r.entries {
+		if e.key.Kind != q.Kind {
+			continue
+		}
+		if q.Ancestor != nil && !e.key.HasAncestor(q.Ancestor) {
+			continue
+		}
+		match, err := matches(e.value, q.Filters)
+		if err != nil {
+			return nil, err
+		}
+		if match && hasFields(e.value, q.Orders) {
+			found = append(found, e)
+		}
+	}
+	sort.Slice(found, func(i, j int) bool {
+		return found[i].key.Encode() < found[j].key.Encode()
+	})
+	var sortErr error
+	sort.SliceStable(found, func(i, j int) bool {
+		less, err := ordered(found[i].value, found[j].value, q.Orders)
+		if err != nil && sortErr == nil {
+			sortErr = err
+		}
+		return less
+	})
+	if sortErr != nil {
+		return nil, sortErr
+	}
+	found = window(found, q.GetOffset(), q.GetLimit())
+
+	var keys []*db.Key
+	for _, e := range found {
+		keys = append(keys, e.key)
+		if !slice.IsValid() {
+			continue
+		}
+		elemType := slice.Type().Elem()
+		if elemType.Kind() == reflect.Ptr {
+			elemType = elemType.Elem()
+		}
+		if elemType != e.value.Type() {
+			return nil, fmt.Errorf("invalid dst %T for %s", dst, e.key.String())
+		}
+		v := reflect.New(elemType)
+		copyEntity(v.Elem(), e.value)
+		if slice.Type().Elem().Kind() == reflect.Ptr {
+			slice.Set(reflect.Append(slice, v))
+		} else {
+			slice.Set(reflect.Append(slice, v.Elem()))
+		}
+	}
+	return keys, nil
+}
+
+func (r *Repository) Put(key *db.Key, src interface{}) error {
+	return db.RunInTransaction(r, func(tx db.Transaction) error {
+		return tx.Put(key, src)
+	})
+}
+
+func (r *Repository) PutMulti(keys []*db.Key, src interface{}) error {
+	v := reflect.ValueOf(src)
+	if v.Kind() != reflect.Slice || v.Len() != len(keys) {
+		return fmt.Errorf("src must be a slice of length %d", len(keys))
+	}
+	return db.RunInTransaction(r, func(tx db.Transaction) error {
+		for i, key := range keys {
+			err := tx.Put(key, v.Index(i).Interface())
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (r *Repository) Delete(key *db.Key) error {
+	return r.DeleteMulti([]*db.Key{key})
+}
+
+func (r *Repository) DeleteMulti(keys []*db.Key) error {
+	return db.RunInTransaction(r, func(tx db.Transaction) error {
+		return tx.DeleteMulti(keys)
+	})
+}
+
+func (r *Repository) NewTransaction() (db.Transaction, error) {
+	return &Transaction{r: r, reads: make(map[string]int64)}, nil
+}
+
+func (t *Transaction) Get(key *db.Key, dst interface{}) error {
+	if t.finished {
+		return ErrTransactionFinished
+	}
+	t.r.mu.RLock()
+	defer t.r.mu.RUnlock()
+
+	e, exist := t.r.entries[key.Encode()]
+	if !exist {
+		t.reads[key.Encode()] = 0
+		return db.ErrNoSuchEntity
+	}
+	t.reads[key.Encode()] = e.version
+	return load(e, dst)
+}
+
+func (t *Transaction) GetMulti(keys []*db.Key, dst interface{}) error {
+	v := reflect.ValueOf(dst)
+	if v.Kind() != reflect.Slice || v.Len() != len(keys) {
+		return fmt.Errorf("dst must be a slice of length %d", len(keys))
+	}
+
+	var errs = make(db.MultiError, len(keys))
+	var failed bool
+	for i, key := range keys {
+		elem := v.Index(i)
+		if elem.Kind() == reflect.Ptr {
+			if elem.IsNil() {
+				elem.Set(reflect.New(elem.Type().Elem()))
+			}
+			errs[i] = t.Get(key, elem.Interface())
+		} else {
+			errs[i] = t.Get(key, elem.Addr().Interface())
+		}
+		failed = failed || errs[i] != nil
+	}
+	if failed {
+		return errs
+	}
+	return nil
+}
+
+func (t *Transaction) Put(key *db.Key, src interface{}) error {
+	if t.finished {
+		return ErrTransactionFinished
+	}
+	v, err := snapshot(src)
+	if err != nil {
+		return err
+	}
+	t.mutations = append(t.mutations, mutation{key: key, value: v})
+	return nil
+}
+
+func (t *Transaction) Delete(key *db.Key) error {
+	if t.finished {
+		return ErrTransactionFinished
+	}
+	t.mutations = append(t.mutations, mutation{key: key})
+	return nil
+}
+
+func (t *Transaction) DeleteMulti(keys []*db.Key) error {
+	for _, key := range keys {
+		err := t.Delete(key)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *Transaction) Commit() error {
+	if t.finished {
+		return ErrTransactionFinished
+	}
+	t.finished = true
+
+	t.r.mu.Lock()
+	defer t.r.mu.Unlock()
+
+	for k, version := range t.reads {
+		var current int64
+		if e, exist := t.r.entries[k]; exist {
+			current = e.version
+		}
+		if current != version {
+			return ErrConcurrentTransaction
+		}
+	}
+
+	for _, m := range t.mutations {
+		k := m.key.Encode()
+		if !m.value.IsValid() {
+			delete(t.r.entries, k)
+			continue
+		}
+		t.r.version++
+		t.r.entries[k] = &entry{key: m.key, value: m.value, version: t.r.version}
+	}
+	return nil
+}
+
+func (t *Transaction) Rollback() error {
+	if t.finished {
+		return ErrTransactionFinished
+	}
+	t.finished = true
+	return nil
+}
+
+// matches evaluates the property filters of a query against an entity.
+func matches(v reflect.Value, filters []db.Filter) (bool, error) {
+	for _, f := range filters {
+		fv, isIndexed := indexed(v, f.Field)
+		if !isIndexed {
+			return false, nil
+		}
+		c, err := compare(fv, reflect.ValueOf(f.Value))
+		if err != nil {
+			return false, errors.Wrap(err, fmt.Sprintf("filter %s %s", f.Field, f.Op))
+		}
+		var ok bool
+		switch f.Op {
+		case "=":
+			ok = c == 0
+		case "<":
+			ok = c < 0
+		case "<=":
+			ok = c <= 0
+		case ">":
+			ok = c > 0
+		case ">=":
+			ok = c >= 0
+		}
+		if !ok {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// hasFields checks that an entity has indexed properties of the orders, like
+// in datastore an entity without one is left out.
+func hasFields(v reflect.Value, orders []db.Order) bool {
+	for _, o := range orders {
+		if _, ok := indexed(v, o.Field); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// indexed returns the field of an entity if datastore would index it: stored
+// and not tagged noindex.
+func indexed(v reflect.Value, name string) (reflect.Value, bool) {
+	f, ok := v.Type().FieldByName(name)
+	if !ok || f.PkgPath != "" {
+		return reflect.Value{}, false
+	}
+	options := strings.Split(f.Tag.Get("datastore"), ",")
+	if options[0] == "-" {
+		return reflect.Value{}, false
+	}
+	for _, o := range options[1:] {
+		if o == "noindex" {
+			return reflect.Value{}, false
+		}
+	}
+	return v.FieldByIndex(f.Index), true
+}
+
+// ordered reports whether the entity a sorts before b by the orders.
+func ordered(a reflect.Value, b reflect.Value, orders []db.Order) (bool, error) {
+	for _, o := range orders {
+		c, err := compare(a.FieldByName(o.Field), b.FieldByName(o.Field))
+		if err != nil {
+			return false, errors.Wrap(err, fmt.Sprintf("order %s", o.Field))
+		}
+		if c != 0 {
+			return (c < 0) != o.Descending, nil
+		}
+	}
+	return false, nil
+}
+
+// window cuts the results after offset to at most limit entries.
+func window(found []*entry, offset int, limit int) []*entry {
+	if offset > len(found) {
+		offset = len(found)
+	}
+	found = found[offset:]
+	if limit > 0 && limit < len(found) {
+		found = found[:limit]
+	}
+	return found
+}
+
+func compare(a reflect.Value, b reflect.Value) (int, error) {
+	if ta, ok := a.Interface().(time.Time); ok {
+		tb, ok := b.Interface().(time.Time)
+		if !ok {
+			return 0, fmt.Errorf("cannot compare time with %s", b.Type())
+		}
+		switch {
+		case ta.Before(tb):
+			return -1, nil
+		case ta.After(tb):
+			return 1, nil
+		}
+		return 0, nil
+	}
+
+	switch a.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		switch b.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			return cmpInt(a.Int(), b.Int()), nil
+		}
+	case reflect.String:
+		if b.Kind() == reflect.String {
+			return strings.Compare(a.String(), b.String()), nil
+		}
+	case reflect.Bool:
+		if b.Kind() == reflect.Bool {
+			ia, ib := 0, 0
+			if a.Bool() {
+				ia = 1
+			}
+			if b.Bool() {
+				ib = 1
+			}
+			return ia - ib, nil
+		}
+	case reflect.Float32, reflect.Float64:
+		if b.Kind() == reflect.Float32 || b.Kind() == reflect.Float64 {
+			switch {
+			case a.Float() < b.Float():
+				return -1, nil
+			case a.Float() > b.Float():
+				return 1, nil
+			}
+			return 0, nil
+		}
+	}
+	return 0, fmt.Errorf("cannot compare %s with %s", a.Type(), b.Type())
+}
+
+func cmpInt(a int64, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// Files is a Source serving file contents by their path in the bucket.
+type Files map[string][]byte
+
+func (f Files) ReadFile(file *files.File) ([]byte, error) {
+	content, exist := f[file.GetPath()]
+	if !exist {
+		return nil, &os.PathError{Op: "read", Path: file.GetPath(), Err: os.ErrNotExist}
+	}
+	return content, nil
+}
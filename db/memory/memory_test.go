@@ -0,0 +1,50 @@
+package memory_test
+
+import (
+	"testing"
+
+	"github.com/rismaster/allris-db/db"
+	"github.com/rismaster/allris-db/db/memory"
+)
+
+type note struct {
+	Title  string
+	Body   string `datastore:",noindex"`
+	Hidden string `datastore:"-"`
+}
+
+func TestNoindex(t *testing.T) {
+
+	repo := memory.New()
+	for _, n := range []*note{{Title: "a", Body: "x", Hidden: "y"}, {Title: "b", Body: "x"}} {
+		err := repo.Put(db.NameKey("Note", n.Title, nil), n)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, c := range []struct {
+		q    *db.Query
+		want int
+	}{
+		{db.NewQuery("Note").Filter("Title =", "a"), 1},
+		{db.NewQuery("Note").Order("-Title"), 2},
+		{db.NewQuery("Note").Filter("Body =", "x"), 0},
+		{db.NewQuery("Note").Order("Body"), 0},
+		{db.NewQuery("Note").Filter("Hidden =", "y"), 0},
+	} {
+		keys, err := repo.GetAll(c.q.KeysOnly(), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(keys) != c.want {
+			t.Errorf("%+v: %d entities, want %d", c.q, len(keys), c.want)
+		}
+	}
+
+	var n note
+	err := repo.Get(db.NameKey("Note", "a", nil), &n)
+	if err != nil || n.Body != "x" || n.Hidden != "" {
+		t.Errorf("noindex field not stored: %+v, %v", n, err)
+	}
+}
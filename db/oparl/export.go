@@ -0,0 +1,289 @@
+package oparl
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/rismaster/allris-db/db"
+)
+
+// Exporter writes the whole dataset as a static OParl tree: system.json,
+// bodies.json, body/1.json with its lists and one file per object.
+type Exporter struct {
+	Repo    db.Repository
+	Mapper  *Mapper
+	Name    string // name of the System and the Body
+	Website string
+	Dir     string
+
+	objects       map[string]interface{}
+	lists         map[string][]interface{}
+	organizations map[string]*organization
+}
+
+type organization struct {
+	gremium  bool
+	meetings []string
+}
+
+func NewExporter(repo db.Repository, mapper *Mapper, name string, dir string) *Exporter {
+	return &Exporter{Repo: repo, Mapper: mapper, Name: name, Dir: dir}
+}
+
+// Export loads all entities and writes the tree. It fails if two objects get
+// the same id.
+func (e *Exporter) Export() error {
+
+	config := e.Mapper.Config
+	m := e.Mapper
+	e.objects = make(map[string]interface{})
+	e.lists = make(map[string][]interface{})
+	e.organizations = make(map[string]*organization)
+
+	var sitzungen []*db.Sitzung
+	sitzungKeys, err := e.Repo.GetAll(db.NewQuery(config.GetEntitySitzung()), &sitzungen)
+	if err != nil {
+		return errors.Wrap(err, "error getting sitzungen from db")
+	}
+	var tops []*db.Top
+	topKeys, err := e.Repo.GetAll(db.NewQuery(config.GetEntityTop()), &tops)
+	if err != nil {
+		return errors.Wrap(err, "error getting tops from db")
+	}
+	var vorlagen []*db.Vorlage
+	vorlageKeys, err := e.Repo.GetAll(db.NewQuery(config.GetEntityVorlage()), &vorlagen)
+	if err != nil {
+		return errors.Wrap(err, "error getting vorlagen from db")
+	}
+	var anlagen []*db.Anlage
+	anlageKeys, err := e.Repo.GetAll(db.NewQuery(config.GetEntityAnlage()), &anlagen)
+	if err != nil {
+		return errors.Wrap(err, "error getting anlagen from db")
+	}
+	var termine []*db.Termin
+	_, err = e.Repo.GetAll(db.NewQuery(config.GetEntityTermin()), &termine)
+	if err != nil {
+		return errors.Wrap(err, "error getting termine from db")
+	}
+
+	// Files by the encoded key of their parent, the keys are those returned
+	// by GetAll as the loaded entities have no AppContext for GetKey
+	files := make(map[string][]*File)
+	for i, a := range anlagen {
+		f := m.File(anlageKeys[i], a)
+		err = e.add("file", f.ID, f)
+		if err != nil {
+			return err
+		}
+		if anlageKeys[i].Parent != nil {
+			parent := anlageKeys[i].Parent.Encode()
+			files[parent] = append(files[parent], f)
+		}
+	}
+
+	termineBySitzung := make(map[int]*db.Termin)
+	for _, t := range termine {
+		if t.SILFDNR > 0 {
+			termineBySitzung[t.SILFDNR] = t
+		}
+	}
+
+	items := make(map[int][]*AgendaItem)
+	var beratungen = make(map[int][]*db.Top)
+	for i, t := range tops {
+		item := m.AgendaItem(t, files[topKeys[i].Encode()])
+		err = e.add("agendaitem", item.ID, item)
+		if err != nil {
+			return err
+		}
+		items[t.SILFDNR] = append(items[t.SILFDNR], item)
+		if t.VOLFDNR > 0 {
+			c := m.Consultation(t)
+			err = e.add("consultation", c.ID, c)
+			if err != nil {
+				return err
+			}
+			beratungen[t.VOLFDNR] = append(beratungen[t.VOLFDNR], t)
+			if t.Gremium != "" {
+				e.organization(t.Gremium, true, "")
+			}
+		}
+	}
+
+	for i, s := range sitzungen {
+		meeting := m.Meeting(s, termineBySitzung[s.SILFDNR], items[s.SILFDNR], files[sitzungKeys[i].Encode()])
+		err = e.add("meeting", meeting.ID, meeting)
+		if err != nil {
+			return err
+		}
+		if meeting.Location != nil {
+			err = e.add("location", meeting.Location.ID, meeting.Location)
+			if err != nil {
+				return err
+			}
+		}
+		if s.Gremium != "" {
+			e.organization(s.Gremium, true, meeting.ID)
+		}
+	}
+
+	for i, v := range vorlagen {
+		paper := m.Paper(v, beratungen[v.VOLFDNR], files[vorlageKeys[i].Encode()])
+		err = e.add("paper", paper.ID, paper)
+		if err != nil {
+			return err
+		}
+		if v.Federfuehrend != "" {
+			e.organization(v.Federfuehrend, false, "")
+		}
+	}
+
+	for name, org := range e.organizations {
+		var organizationType string
+		if org.gremium {
+			organizationType = "Gremium"
+		}
+		sort.Strings(org.meetings)
+		o := m.Organization(name, organizationType, org.meetings)
+		err = e.add("organization", o.ID, o)
+		if err != nil {
+			return err
+		}
+	}
+
+	return e.write()
+}
+
+// add collects an object for its list and its file. Two objects with the same
+// id are an error, the second would overwrite the file of the first.
+func (e *Exporter) add(list string, id string, object interface{}) error {
+	if _, exist := e.objects[id]; exist {
+		return errors.New("duplicate id " + id + " in " + list)
+	}
+	e.objects[id] = object
+	e.lists[list] = append(e.lists[list], object)
+	return nil
+}
+
+// organization collects the Gremien and the other organizations (e.g. the
+// Federfuehrend of a Vorlage) with their meetings, the objects are built once
+// all are known.
+func (e *Exporter) organization(name string, gremium bool, meeting string) {
+	org, exist := e.organizations[name]
+	if !exist {
+		org = &organization{}
+		e.organizations[name] = org
+	}
+	org.gremium = org.gremium || gremium
+	if meeting != "" {
+		org.meetings = append(org.meetings, meeting)
+	}
+}
+
+func (e *Exporter) write() error {
+
+	m := e.Mapper
+	system := m.System(e.Name)
+	body := m.Body(e.Name, e.Website)
+	err := e.writeObject(system.ID, system)
+	if err != nil {
+		return err
+	}
+	err = e.writeObject(system.Body, list(system.Body, []interface{}{body}))
+	if err != nil {
+		return err
+	}
+	err = e.writeObject(body.ID, body)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range []string{"organization", "person", "meeting", "paper", "agendaitem", "consultation", "file", "location", "legislativeterm", "membership"} {
+		objects := e.lists[name]
+		sort.SliceStable(objects, func(i, j int) bool {
+			return idOf(objects[i]) < idOf(objects[j])
+		})
+		err = e.writeObject(m.ListURL(name), list(m.ListURL(name), objects))
+		if err != nil {
+			return err
+		}
+	}
+
+	for id, object := range e.objects {
+		err = e.writeObject(id, object)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func list(id string, objects []interface{}) *List {
+	if objects == nil {
+		objects = []interface{}{}
+	}
+	return &List{
+		Data: objects,
+		Pagination: Pagination{
+			TotalElements:   len(objects),
+			ElementsPerPage: len(objects),
+			CurrentPage:     1,
+			TotalPages:      1,
+		},
+		Links: map[string]string{"first": id, "last": id},
+	}
+}
+
+func idOf(object interface{}) string {
+	switch o := object.(type) {
+	case *Organization:
+		return o.ID
+	case *Meeting:
+		return o.ID
+	case *AgendaItem:
+		return o.ID
+	case *Paper:
+		return o.ID
+	case *Consultation:
+		return o.ID
+	case *File:
+		return o.ID
+	case *Location:
+		return o.ID
+	}
+	return ""
+}
+
+// writeObject writes an object to the path of its id below BaseURL.
+func (e *Exporter) writeObject(id string, object interface{}) error {
+
+	if !strings.HasPrefix(id, e.Mapper.BaseURL) {
+		return errors.New("id " + id + " is not below " + e.Mapper.BaseURL)
+	}
+	path := filepath.Join(e.Dir, filepath.FromSlash(strings.TrimPrefix(id, e.Mapper.BaseURL)))
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	err := enc.Encode(object)
+	if err != nil {
+		return errors.Wrap(err, "error encoding "+id)
+	}
+
+	err = os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return errors.Wrap(err, "error creating directory for "+path)
+	}
+	err = ioutil.WriteFile(path, buf.Bytes(), 0644)
+	if err != nil {
+		return errors.Wrap(err, "error writing "+path)
+	}
+	return nil
+}
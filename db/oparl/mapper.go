@@ -0,0 +1,304 @@
+// Package oparl maps the ALLRIS entities to OParl 1.1 objects and exports
+// the whole dataset as a static, browsable OParl tree of JSON files.
+package oparl
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kennygrant/sanitize"
+	allris_common "github.com/rismaster/allris-common"
+	"github.com/rismaster/allris-db/db"
+)
+
+// Mapper builds the OParl objects. The object ids are URLs below BaseURL
+// ending in .json, so the exported files can be served by any static host.
+type Mapper struct {
+	BaseURL string
+	Config  allris_common.Config
+	// FileURL returns the accessUrl of an Anlage. The default is the document
+	// url of ALLRIS for Anlagen with a DOLFDNR and the File id otherwise.
+	FileURL func(a *db.Anlage) string
+}
+
+func NewMapper(baseURL string, config allris_common.Config) *Mapper {
+	if !strings.HasSuffix(baseURL, "/") {
+		baseURL += "/"
+	}
+	return &Mapper{BaseURL: baseURL, Config: config}
+}
+
+func (m *Mapper) url(path string) string {
+	return m.BaseURL + path + ".json"
+}
+
+func (m *Mapper) SystemURL() string {
+	return m.url("system")
+}
+
+func (m *Mapper) BodyURL() string {
+	return m.url("body/1")
+}
+
+// ListURL is the url of a list of the body, e.g. "meeting".
+func (m *Mapper) ListURL(name string) string {
+	return m.url("body/1/" + name)
+}
+
+// OrganizationURL is the id of the organization of a name, a readable slug of
+// the name and a short hash of it. The slug alone is lowercased and drops
+// punctuation, the hash keeps the names with the same slug apart.
+func (m *Mapper) OrganizationURL(name string) string {
+	sum := sha256.Sum256([]byte(name))
+	return m.url(fmt.Sprintf("organization/%s-%x", strings.ToLower(sanitize.BaseName(name)), sum[:4]))
+}
+
+func (m *Mapper) MeetingURL(silfdnr int) string {
+	return m.url(fmt.Sprintf("meeting/%d", silfdnr))
+}
+
+func (m *Mapper) LocationURL(silfdnr int) string {
+	return m.url(fmt.Sprintf("location/%d", silfdnr))
+}
+
+func (m *Mapper) AgendaItemURL(silfdnr int, tolfdnr int) string {
+	return m.url(fmt.Sprintf("agendaitem/%d-%d", silfdnr, tolfdnr))
+}
+
+func (m *Mapper) ConsultationURL(silfdnr int, tolfdnr int) string {
+	return m.url(fmt.Sprintf("consultation/%d-%d", silfdnr, tolfdnr))
+}
+
+func (m *Mapper) PaperURL(volfdnr int) string {
+	return m.url(fmt.Sprintf("paper/%d", volfdnr))
+}
+
+// FileURLOf is the id of the File of an Anlage stored under key.
+func (m *Mapper) FileURLOf(key *db.Key) string {
+	var parts []string
+	for k := key; k != nil; k = k.Parent {
+		parts = append([]string{k.Name}, parts...)
+	}
+	return m.url("file/" + strings.Join(parts, "-"))
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
+
+func formatDate(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format("2006-01-02")
+}
+
+// isPublic follows the ALLRIS convention of numbering the nichtöffentliche
+// Tops with "N" and the öffentliche with "Ö".
+func isPublic(nr string, status string) bool {
+	if strings.HasPrefix(strings.TrimSpace(nr), "N") {
+		return false
+	}
+	return !strings.Contains(strings.ToLower(status), "nicht")
+}
+
+// File maps an Anlage stored under key.
+func (m *Mapper) File(key *db.Key, a *db.Anlage) *File {
+	f := &File{
+		ID:       m.FileURLOf(key),
+		Type:     TypeFile,
+		Name:     a.Title,
+		FileName: a.Filename,
+		Modified: formatTime(a.SavedAt),
+	}
+	if m.FileURL != nil {
+		f.AccessURL = m.FileURL(a)
+	} else if a.DOLFDNR > 0 {
+		f.AccessURL = fmt.Sprintf("https://%s%s?DOLFDNR=%d", m.Config.GetPathToParse(), m.Config.GetUrlAnlagedoc(), a.DOLFDNR)
+	}
+	if f.AccessURL == "" {
+		f.AccessURL = f.ID
+	}
+
+	parent := key.Parent
+	switch {
+	case parent == nil:
+	case parent.Kind == m.Config.GetEntityVorlage():
+		f.Paper = []string{m.PaperURL(keyID(parent))}
+	case parent.Kind == m.Config.GetEntityTop() && parent.Parent != nil:
+		f.AgendaItem = []string{m.AgendaItemURL(keyID(parent.Parent), keyID(parent))}
+	case parent.Kind == m.Config.GetEntitySitzung():
+		f.Meeting = []string{m.MeetingURL(keyID(parent))}
+	}
+	return f
+}
+
+// keyID is the SILFDNR, TOLFDNR or VOLFDNR of a key.
+func keyID(k *db.Key) int {
+	id, _ := strconv.Atoi(k.Name)
+	return id
+}
+
+// Meeting maps a Sitzung with its agenda and the Anlagen stored directly
+// under it. termin may be nil, it gives the exact start and end.
+func (m *Mapper) Meeting(s *db.Sitzung, termin *db.Termin, items []*AgendaItem, files []*File) *Meeting {
+	meeting := &Meeting{
+		ID:            m.MeetingURL(s.SILFDNR),
+		Type:          TypeMeeting,
+		Name:          s.Title,
+		MeetingState:  s.Status,
+		Cancelled:     db.IsCancelled(s.Status),
+		Start:         formatTime(s.Datum),
+		AuxiliaryFile: files,
+		Modified:      formatTime(s.SavedAt),
+	}
+	if termin != nil {
+		meeting.Start = formatTime(termin.Start)
+		if termin.End.After(termin.Start) {
+			meeting.End = formatTime(termin.End)
+		}
+	}
+	if s.Gremium != "" {
+		meeting.Organization = []string{m.OrganizationURL(s.Gremium)}
+	}
+	if s.Raum != "" || s.Ort != "" {
+		meeting.Location = m.Location(s)
+	}
+
+	meeting.AgendaItem = append([]*AgendaItem(nil), items...)
+	sort.SliceStable(meeting.AgendaItem, func(i, j int) bool {
+		return meeting.AgendaItem[i].Order < meeting.AgendaItem[j].Order
+	})
+	return meeting
+}
+
+func (m *Mapper) Location(s *db.Sitzung) *Location {
+	var description []string
+	for _, l := range []string{s.Raum, s.Ort} {
+		if strings.TrimSpace(l) != "" {
+			description = append(description, strings.TrimSpace(l))
+		}
+	}
+	return &Location{
+		ID:          m.LocationURL(s.SILFDNR),
+		Type:        TypeLocation,
+		Description: strings.Join(description, ", "),
+		Room:        s.Raum,
+		Locality:    s.Ort,
+		Meeting:     []string{m.MeetingURL(s.SILFDNR)},
+	}
+}
+
+func (m *Mapper) AgendaItem(t *db.Top, files []*File) *AgendaItem {
+	item := &AgendaItem{
+		ID:             m.AgendaItemURL(t.SILFDNR, t.TOLFDNR),
+		Type:           TypeAgendaItem,
+		Meeting:        m.MeetingURL(t.SILFDNR),
+		Number:         t.Nr,
+		Order:          t.IndexTop,
+		Name:           t.Betreff,
+		Public:         isPublic(t.Nr, t.Status),
+		Result:         t.Beschlussart,
+		ResolutionText: t.Beschluss,
+		AuxiliaryFile:  files,
+		Modified:       formatTime(t.SavedAt),
+	}
+	if t.VOLFDNR > 0 {
+		item.Consultation = m.ConsultationURL(t.SILFDNR, t.TOLFDNR)
+	}
+	return item
+}
+
+// Consultation maps a Top of the Beratungsfolge of a Vorlage.
+func (m *Mapper) Consultation(t *db.Top) *Consultation {
+	c := &Consultation{
+		ID:         m.ConsultationURL(t.SILFDNR, t.TOLFDNR),
+		Type:       TypeConsultation,
+		Paper:      m.PaperURL(t.VOLFDNR),
+		AgendaItem: m.AgendaItemURL(t.SILFDNR, t.TOLFDNR),
+		Meeting:    m.MeetingURL(t.SILFDNR),
+		Role:       t.Beschlussstatus,
+	}
+	if t.Gremium != "" {
+		c.Organization = []string{m.OrganizationURL(t.Gremium)}
+	}
+	return c
+}
+
+// Paper maps a Vorlage with its Beratungsfolge and Anlagen.
+func (m *Mapper) Paper(v *db.Vorlage, beratungsfolge []*db.Top, files []*File) *Paper {
+	paper := &Paper{
+		ID:            m.PaperURL(v.VOLFDNR),
+		Type:          TypePaper,
+		Body:          m.BodyURL(),
+		Name:          v.Betreff,
+		Reference:     v.BSVV,
+		Date:          formatDate(v.DatumAngelegt),
+		AuxiliaryFile: files,
+		Modified:      formatTime(v.SavedAt),
+	}
+	if v.BezueglichVOLFDNR > 0 {
+		paper.RelatedPaper = []string{m.PaperURL(v.BezueglichVOLFDNR)}
+	}
+	if v.Federfuehrend != "" {
+		paper.UnderDirectionOf = []string{m.OrganizationURL(v.Federfuehrend)}
+	}
+
+	sorted := append([]*db.Top(nil), beratungsfolge...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].IndexBeratung < sorted[j].IndexBeratung
+	})
+	for _, t := range sorted {
+		paper.Consultation = append(paper.Consultation, m.Consultation(t))
+	}
+	return paper
+}
+
+func (m *Mapper) Organization(name string, organizationType string, meetings []string) *Organization {
+	return &Organization{
+		ID:               m.OrganizationURL(name),
+		Type:             TypeOrganization,
+		Body:             m.BodyURL(),
+		Name:             name,
+		OrganizationType: organizationType,
+		Meeting:          meetings,
+	}
+}
+
+func (m *Mapper) System(name string) *System {
+	return &System{
+		ID:           m.SystemURL(),
+		Type:         TypeSystem,
+		OparlVersion: Version,
+		Body:         m.url("bodies"),
+		Name:         name,
+	}
+}
+
+func (m *Mapper) Body(name string, website string) *Body {
+	return &Body{
+		ID:                  m.BodyURL(),
+		Type:                TypeBody,
+		System:              m.SystemURL(),
+		Name:                name,
+		Website:             website,
+		Organization:        m.ListURL("organization"),
+		Person:              m.ListURL("person"),
+		Meeting:             m.ListURL("meeting"),
+		Paper:               m.ListURL("paper"),
+		LegislativeTerm:     []string{},
+		AgendaItem:          m.ListURL("agendaitem"),
+		Consultation:        m.ListURL("consultation"),
+		File:                m.ListURL("file"),
+		LocationList:        m.ListURL("location"),
+		LegislativeTermList: m.ListURL("legislativeterm"),
+		Membership:          m.ListURL("membership"),
+	}
+}
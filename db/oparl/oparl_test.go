@@ -0,0 +1,135 @@
+package oparl_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rismaster/allris-db/db"
+	"github.com/rismaster/allris-db/db/golden"
+	"github.com/rismaster/allris-db/db/memory"
+	"github.com/rismaster/allris-db/db/oparl"
+)
+
+const baseURL = "https://oparl.example.org/"
+
+func TestOrganizationURL(t *testing.T) {
+
+	m := oparl.NewMapper("https://oparl.example.org", golden.FixtureConfig{})
+	seen := make(map[string]string)
+	for _, name := range []string{"Bau-Ausschuss", "Bau Ausschuss", "bau ausschuss", "Bau/Ausschuss"} {
+		url := m.OrganizationURL(name)
+		if !strings.HasPrefix(url, baseURL+"organization/bau-ausschuss-") || !strings.HasSuffix(url, ".json") {
+			t.Errorf("%s: %s", name, url)
+		}
+		if other, ok := seen[url]; ok {
+			t.Errorf("%s and %s share %s", name, other, url)
+		}
+		seen[url] = name
+		if m.OrganizationURL(name) != url {
+			t.Errorf("%s: url not stable", name)
+		}
+	}
+}
+
+func put(t *testing.T, repo db.Repository, key *db.Key, src interface{}) {
+	t.Helper()
+	err := repo.Put(key, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readJSON(t *testing.T, dir string, id string, dst interface{}) {
+	t.Helper()
+	content, err := ioutil.ReadFile(filepath.Join(dir, filepath.FromSlash(strings.TrimPrefix(id, baseURL))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = json.Unmarshal(content, dst)
+	if err != nil {
+		t.Fatalf("%s: %v", id, err)
+	}
+}
+
+func TestExport(t *testing.T) {
+
+	repo := memory.New()
+	datum := time.Date(2024, 3, 14, 18, 0, 0, 0, time.UTC)
+	sitzung1 := db.NameKey("Sitzung", "1001", nil)
+	put(t, repo, sitzung1, &db.Sitzung{SILFDNR: 1001, Gremium: "Bau-Ausschuss", Datum: datum, Raum: "Saal 1"})
+	put(t, repo, db.NameKey("Sitzung", "1002", nil), &db.Sitzung{SILFDNR: 1002, Gremium: "Bau Ausschuss", Datum: datum, Status: "abgesagt"})
+	top := db.NameKey("Top", "5001", sitzung1)
+	put(t, repo, top, &db.Top{SILFDNR: 1001, TOLFDNR: 5001, VOLFDNR: 2001, Gremium: "Bau-Ausschuss", Nr: "Ö 1"})
+	put(t, repo, db.NameKey("Anlage", "7", top), &db.Anlage{Title: "Plan", DOLFDNR: 7})
+	put(t, repo, db.NameKey("Vorlage", "2001", nil), &db.Vorlage{VOLFDNR: 2001, Federfuehrend: "Bauamt"})
+
+	dir := t.TempDir()
+	m := oparl.NewMapper(baseURL, golden.FixtureConfig{})
+	err := oparl.NewExporter(repo, m, "Stadt", dir).Export()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var organizations struct {
+		Data []*oparl.Organization
+	}
+	readJSON(t, dir, m.ListURL("organization"), &organizations)
+	gremien := make(map[string]*oparl.Organization)
+	for _, o := range organizations.Data {
+		gremien[o.Name] = o
+		var file oparl.Organization
+		readJSON(t, dir, o.ID, &file)
+		if file.Name != o.Name {
+			t.Errorf("%s: file of %q", o.ID, file.Name)
+		}
+	}
+	if len(organizations.Data) != 3 || gremien["Bau-Ausschuss"] == nil || gremien["Bau Ausschuss"] == nil {
+		t.Fatalf("organizations %+v", organizations.Data)
+	}
+	if o := gremien["Bau-Ausschuss"]; o.OrganizationType != "Gremium" || len(o.Meeting) != 1 || o.Meeting[0] != m.MeetingURL(1001) {
+		t.Errorf("gremium %+v", o)
+	}
+	if o := gremien["Bauamt"]; o == nil || o.OrganizationType != "" {
+		t.Errorf("federfuehrend %+v", o)
+	}
+
+	var meeting oparl.Meeting
+	readJSON(t, dir, m.MeetingURL(1002), &meeting)
+	if !meeting.Cancelled || len(meeting.Organization) != 1 || meeting.Organization[0] != gremien["Bau Ausschuss"].ID {
+		t.Errorf("meeting %+v", meeting)
+	}
+	readJSON(t, dir, m.MeetingURL(1001), &meeting)
+	if meeting.Location == nil || len(meeting.AgendaItem) != 1 || len(meeting.AgendaItem[0].AuxiliaryFile) != 1 {
+		t.Errorf("meeting %+v", meeting)
+	}
+
+	var paper oparl.Paper
+	readJSON(t, dir, m.PaperURL(2001), &paper)
+	if len(paper.UnderDirectionOf) != 1 || paper.UnderDirectionOf[0] != gremien["Bauamt"].ID || len(paper.Consultation) != 1 {
+		t.Errorf("paper %+v", paper)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "consultation", "1001-5001.json")); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestExportDuplicateID(t *testing.T) {
+
+	// the File ids join the key names with "-"
+	repo := memory.New()
+	sitzung := db.NameKey("Sitzung", "1", nil)
+	put(t, repo, sitzung, &db.Sitzung{SILFDNR: 1})
+	put(t, repo, db.NameKey("Top", "2", sitzung), &db.Top{SILFDNR: 1, TOLFDNR: 2})
+	put(t, repo, db.NameKey("Anlage", "3", db.NameKey("Top", "2", sitzung)), &db.Anlage{Title: "a"})
+	put(t, repo, db.NameKey("Anlage", "2-3", sitzung), &db.Anlage{Title: "b"})
+
+	err := oparl.NewExporter(repo, oparl.NewMapper(baseURL, golden.FixtureConfig{}), "Stadt", t.TempDir()).Export()
+	if err == nil || !strings.Contains(err.Error(), "duplicate id") {
+		t.Errorf("duplicate file id: %v", err)
+	}
+}
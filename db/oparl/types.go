@@ -0,0 +1,144 @@
+package oparl
+
+// The OParl 1.1 objects written by the exporter. Only the fields backed by
+// ALLRIS data are mapped, see https://oparl.org/spezifikation/ for the rest.
+
+const (
+	Version = "https://schema.oparl.org/1.1/"
+
+	TypeSystem       = Version + "System"
+	TypeBody         = Version + "Body"
+	TypeOrganization = Version + "Organization"
+	TypeMeeting      = Version + "Meeting"
+	TypeAgendaItem   = Version + "AgendaItem"
+	TypePaper        = Version + "Paper"
+	TypeConsultation = Version + "Consultation"
+	TypeFile         = Version + "File"
+	TypeLocation     = Version + "Location"
+)
+
+type System struct {
+	ID           string `json:"id"`
+	Type         string `json:"type"`
+	OparlVersion string `json:"oparlVersion"`
+	Body         string `json:"body"`
+	Name         string `json:"name,omitempty"`
+}
+
+type Body struct {
+	ID                  string   `json:"id"`
+	Type                string   `json:"type"`
+	System              string   `json:"system"`
+	Name                string   `json:"name"`
+	Website             string   `json:"website,omitempty"`
+	Organization        string   `json:"organization"`
+	Person              string   `json:"person"`
+	Meeting             string   `json:"meeting"`
+	Paper               string   `json:"paper"`
+	LegislativeTerm     []string `json:"legislativeTerm"`
+	AgendaItem          string   `json:"agendaItem"`
+	Consultation        string   `json:"consultation"`
+	File                string   `json:"file"`
+	LocationList        string   `json:"locationList"`
+	LegislativeTermList string   `json:"legislativeTermList"`
+	Membership          string   `json:"membership"`
+}
+
+type Organization struct {
+	ID               string   `json:"id"`
+	Type             string   `json:"type"`
+	Body             string   `json:"body"`
+	Name             string   `json:"name"`
+	OrganizationType string   `json:"organizationType,omitempty"`
+	Meeting          []string `json:"meeting,omitempty"`
+}
+
+type Location struct {
+	ID          string   `json:"id"`
+	Type        string   `json:"type"`
+	Description string   `json:"description,omitempty"`
+	Room        string   `json:"room,omitempty"`
+	Locality    string   `json:"locality,omitempty"`
+	Meeting     []string `json:"meeting,omitempty"`
+}
+
+type Meeting struct {
+	ID            string        `json:"id"`
+	Type          string        `json:"type"`
+	Name          string        `json:"name,omitempty"`
+	MeetingState  string        `json:"meetingState,omitempty"`
+	Cancelled     bool          `json:"cancelled,omitempty"`
+	Start         string        `json:"start,omitempty"`
+	End           string        `json:"end,omitempty"`
+	Location      *Location     `json:"location,omitempty"`
+	Organization  []string      `json:"organization,omitempty"`
+	AgendaItem    []*AgendaItem `json:"agendaItem,omitempty"`
+	AuxiliaryFile []*File       `json:"auxiliaryFile,omitempty"`
+	Modified      string        `json:"modified,omitempty"`
+}
+
+type AgendaItem struct {
+	ID             string  `json:"id"`
+	Type           string  `json:"type"`
+	Meeting        string  `json:"meeting,omitempty"`
+	Number         string  `json:"number,omitempty"`
+	Order          int     `json:"order"`
+	Name           string  `json:"name,omitempty"`
+	Public         bool    `json:"public"`
+	Consultation   string  `json:"consultation,omitempty"`
+	Result         string  `json:"result,omitempty"`
+	ResolutionText string  `json:"resolutionText,omitempty"`
+	AuxiliaryFile  []*File `json:"auxiliaryFile,omitempty"`
+	Modified       string  `json:"modified,omitempty"`
+}
+
+type Paper struct {
+	ID               string          `json:"id"`
+	Type             string          `json:"type"`
+	Body             string          `json:"body"`
+	Name             string          `json:"name,omitempty"`
+	Reference        string          `json:"reference,omitempty"`
+	Date             string          `json:"date,omitempty"`
+	RelatedPaper     []string        `json:"relatedPaper,omitempty"`
+	UnderDirectionOf []string        `json:"underDirectionOf,omitempty"`
+	AuxiliaryFile    []*File         `json:"auxiliaryFile,omitempty"`
+	Consultation     []*Consultation `json:"consultation,omitempty"`
+	Modified         string          `json:"modified,omitempty"`
+}
+
+type Consultation struct {
+	ID           string   `json:"id"`
+	Type         string   `json:"type"`
+	Paper        string   `json:"paper,omitempty"`
+	AgendaItem   string   `json:"agendaItem,omitempty"`
+	Meeting      string   `json:"meeting,omitempty"`
+	Organization []string `json:"organization,omitempty"`
+	Role         string   `json:"role,omitempty"`
+}
+
+type File struct {
+	ID          string   `json:"id"`
+	Type        string   `json:"type"`
+	Name        string   `json:"name,omitempty"`
+	FileName    string   `json:"fileName,omitempty"`
+	AccessURL   string   `json:"accessUrl"`
+	DownloadURL string   `json:"downloadUrl,omitempty"`
+	Meeting     []string `json:"meeting,omitempty"`
+	AgendaItem  []string `json:"agendaItem,omitempty"`
+	Paper       []string `json:"paper,omitempty"`
+	Modified    string   `json:"modified,omitempty"`
+}
+
+// List is an external object list, the static export has a single page.
+type List struct {
+	Data       []interface{}     `json:"data"`
+	Pagination Pagination        `json:"pagination"`
+	Links      map[string]string `json:"links"`
+}
+
+type Pagination struct {
+	TotalElements   int `json:"totalElements"`
+	ElementsPerPage int `json:"elementsPerPage"`
+	CurrentPage     int `json:"currentPage"`
+	TotalPages      int `json:"totalPages"`
+}
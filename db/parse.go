@@ -0,0 +1,88 @@
+package db
+
+import (
+	"io"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/pkg/errors"
+	allris_common "github.com/rismaster/allris-common"
+)
+
+// The Parse*HTML functions run the ALLRIS scraper on a single page without
+// an app context, bucket or database. The ids are not reliably part of the
+// pages, pass the ones from the url or filename (see NewSitzung, NewTop and
+// NewVorlage for the file name conventions).
+
+// ParseSitzungHTML parses a sitzung page into the Sitzung, its Tops and its Anlagen.
+func ParseSitzungHTML(r io.Reader, silfdnr int, config allris_common.Config) (*Sitzung, []*Top, []*Anlage, error) {
+
+	doc, err := goquery.NewDocumentFromReader(r)
+	if err != nil {
+		return nil, nil, nil, errors.Wrap(err, "error create dom")
+	}
+
+	s := &Sitzung{
+		SILFDNR: silfdnr,
+		config:  config,
+	}
+	err = s.Parse(doc)
+	if err != nil {
+		return nil, nil, nil, errors.Wrap(err, "error parsing sitzung")
+	}
+	return s, s.tops, s.anlagen, nil
+}
+
+// ParseTopHTML parses a top page (Auszug) into the Top and its Anlagen.
+func ParseTopHTML(r io.Reader, silfdnr int, tolfdnr int, config allris_common.Config) (*Top, []*Anlage, error) {
+
+	doc, err := goquery.NewDocumentFromReader(r)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "error create dom")
+	}
+
+	t := &Top{
+		SILFDNR: silfdnr,
+		TOLFDNR: tolfdnr,
+		config:  config,
+	}
+	err = t.Parse(doc)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "error parsing top")
+	}
+	return t, t.anlagen, nil
+}
+
+// ParseVorlageHTML parses a vorlage page into the Vorlage, its Beratungsfolge
+// as Tops and its Anlagen.
+func ParseVorlageHTML(r io.Reader, volfdnr int, config allris_common.Config) (*Vorlage, []*Top, []*Anlage, error) {
+
+	doc, err := goquery.NewDocumentFromReader(r)
+	if err != nil {
+		return nil, nil, nil, errors.Wrap(err, "error create dom")
+	}
+
+	v := &Vorlage{
+		VOLFDNR: volfdnr,
+		config:  config,
+	}
+	err = v.Parse(doc)
+	if err != nil {
+		return nil, nil, nil, errors.Wrap(err, "error parsing vorlage")
+	}
+	return v, v.beratungsfolge, v.anlagen, nil
+}
+
+// ParseTermineHTML parses the list of all Sitzungen (si010).
+func ParseTermineHTML(r io.Reader, config allris_common.Config) ([]Termin, error) {
+
+	doc, err := goquery.NewDocumentFromReader(r)
+	if err != nil {
+		return nil, errors.Wrap(err, "error create dom")
+	}
+
+	termine, err := parseTerminList(config, doc)
+	if err != nil {
+		return nil, errors.Wrap(err, "error parsing termine")
+	}
+	return termine, nil
+}
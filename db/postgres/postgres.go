@@ -0,0 +1,50 @@
+// Package postgres stores the ALLRIS entities in PostgreSQL tables with
+// foreign keys between Sitzung, Top, Vorlage and Anlage.
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	_ "github.com/lib/pq"
+	"github.com/pkg/errors"
+	allris_common "github.com/rismaster/allris-common"
+	"github.com/rismaster/allris-db/db/internal/sqlstore"
+)
+
+var dialect = sqlstore.Dialect{
+	Name: "postgres",
+	Placeholder: func(n int) string {
+		return fmt.Sprintf("$%d", n)
+	},
+	Types: map[string]string{
+		sqlstore.TypeInt:  "BIGINT",
+		sqlstore.TypeTime: "TIMESTAMPTZ",
+	},
+	NoLimit: "ALL",
+}
+
+// Open connects to the database given by dsn, e.g.
+// "postgres://allris@localhost/allris?sslmode=disable", and migrates the
+// schema to the latest version.
+func Open(ctx context.Context, dsn string, config allris_common.Config) (*sqlstore.Repository, error) {
+
+	sqlDb, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, errors.Wrap(err, "error opening postgres")
+	}
+
+	err = Migrate(ctx, sqlDb)
+	if err != nil {
+		sqlDb.Close()
+		return nil, err
+	}
+
+	return sqlstore.New(ctx, sqlDb, dialect, config), nil
+}
+
+// Migrate brings the schema of the database up to date.
+func Migrate(ctx context.Context, sqlDb *sql.DB) error {
+	return sqlstore.Migrate(ctx, sqlDb, dialect)
+}
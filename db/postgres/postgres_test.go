@@ -0,0 +1,69 @@
+package postgres_test
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rismaster/allris-db/db"
+	"github.com/rismaster/allris-db/db/golden"
+	"github.com/rismaster/allris-db/db/internal/dbtest"
+	"github.com/rismaster/allris-db/db/postgres"
+)
+
+// dsnEnv names the environment variable with the DSN of a test database,
+// e.g. "postgres://allris@localhost/allris_test?sslmode=disable". Each
+// scenario runs in a schema of its own that is dropped afterwards.
+const dsnEnv = "ALLRIS_TEST_POSTGRES_DSN"
+
+// withSearchPath adds the search_path connection parameter to a URL or a
+// key=value DSN.
+func withSearchPath(dsn string, schema string) string {
+	if strings.Contains(dsn, "://") {
+		if strings.Contains(dsn, "?") {
+			return dsn + "&search_path=" + schema
+		}
+		return dsn + "?search_path=" + schema
+	}
+	return dsn + " search_path=" + schema
+}
+
+func TestSync(t *testing.T) {
+
+	dsn := os.Getenv(dsnEnv)
+	if dsn == "" {
+		t.Skipf("%s not set", dsnEnv)
+	}
+	admin, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer admin.Close()
+
+	ctx := context.Background()
+	n := 0
+	dbtest.RunSyncScenarios(t, func(t *testing.T) db.Repository {
+		n++
+		schema := fmt.Sprintf("allris_test_%d_%d", time.Now().UnixNano(), n)
+		_, err := admin.ExecContext(ctx, "CREATE SCHEMA "+schema)
+		if err != nil {
+			t.Fatal(err)
+		}
+		repo, err := postgres.Open(ctx, withSearchPath(dsn, schema), golden.FixtureConfig{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() {
+			repo.Close()
+			_, err := admin.ExecContext(ctx, "DROP SCHEMA "+schema+" CASCADE")
+			if err != nil {
+				t.Error(err)
+			}
+		})
+		return repo
+	})
+}
@@ -0,0 +1,134 @@
+package db
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Filter is a single property condition of a Query, e.g. "TOLFDNR = 0".
+type Filter struct {
+	Field string
+	Op    string
+	Value interface{}
+}
+
+// Order is a sort order of a Query, by a property ascending or descending.
+type Order struct {
+	Field      string
+	Descending bool
+}
+
+// Query describes a lookup of entities of one kind. Like a datastore query
+// it is immutable, every builder method returns a modified copy.
+type Query struct {
+	Kind     string
+	Ancestor *Key
+	Filters  []Filter
+	// Orders sort the result, the key is the last order.
+	Orders []Order
+
+	keysOnly bool
+	offset   int
+	limit    int
+	err      error
+}
+
+var filterOps = []string{"<=", ">=", "<", ">", "="}
+
+func NewQuery(kind string) *Query {
+	return &Query{Kind: kind}
+}
+
+func (q *Query) clone() *Query {
+	c := *q
+	c.Filters = append([]Filter(nil), q.Filters...)
+	c.Orders = append([]Order(nil), q.Orders...)
+	return &c
+}
+
+// WithAncestor restricts the query to entities having the key as ancestor.
+func (q *Query) WithAncestor(ancestor *Key) *Query {
+	c := q.clone()
+	c.Ancestor = ancestor
+	return c
+}
+
+// Filter adds a condition in the datastore notation "<field> <op>", for
+// example Filter("VOLFDNR =", 5).
+func (q *Query) Filter(filterStr string, value interface{}) *Query {
+	c := q.clone()
+	filterStr = strings.TrimSpace(filterStr)
+	for _, op := range filterOps {
+		if strings.HasSuffix(filterStr, op) {
+			c.Filters = append(c.Filters, Filter{
+				Field: strings.TrimSpace(strings.TrimSuffix(filterStr, op)),
+				Op:    op,
+				Value: value,
+			})
+			return c
+		}
+	}
+	c.err = fmt.Errorf("invalid filter %q", filterStr)
+	return c
+}
+
+// Order adds a sort order by a property, descending with a "-" prefix, for
+// example Order("-DatumAngelegt").
+func (q *Query) Order(fieldName string) *Query {
+	c := q.clone()
+	fieldName = strings.TrimSpace(fieldName)
+	o := Order{Field: strings.TrimPrefix(fieldName, "-"), Descending: strings.HasPrefix(fieldName, "-")}
+	if o.Field == "" {
+		c.err = fmt.Errorf("invalid order %q", fieldName)
+		return c
+	}
+	c.Orders = append(c.Orders, o)
+	return c
+}
+
+// Offset skips the first n results.
+func (q *Query) Offset(n int) *Query {
+	c := q.clone()
+	if n < 0 {
+		c.err = fmt.Errorf("invalid offset %d", n)
+		return c
+	}
+	c.offset = n
+	return c
+}
+
+// Limit returns at most n results, 0 doesn't limit.
+func (q *Query) Limit(n int) *Query {
+	c := q.clone()
+	if n < 0 {
+		c.err = fmt.Errorf("invalid limit %d", n)
+		return c
+	}
+	c.limit = n
+	return c
+}
+
+// GetOffset is the number of results skipped.
+func (q *Query) GetOffset() int {
+	return q.offset
+}
+
+// GetLimit is the maximum number of results, 0 for all.
+func (q *Query) GetLimit() int {
+	return q.limit
+}
+
+func (q *Query) KeysOnly() *Query {
+	c := q.clone()
+	c.keysOnly = true
+	return c
+}
+
+func (q *Query) IsKeysOnly() bool {
+	return q.keysOnly
+}
+
+// Err returns the error of an invalid filter, order, offset or limit.
+func (q *Query) Err() error {
+	return q.err
+}
@@ -0,0 +1,187 @@
+package db
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	allris_common "github.com/rismaster/allris-common"
+)
+
+type TerminStatus string
+
+const (
+	TerminPlanned   TerminStatus = "planned"
+	TerminHeld      TerminStatus = "held"
+	TerminCancelled TerminStatus = "cancelled"
+	TerminMoved     TerminStatus = "moved"
+)
+
+// Reconciliation relates a Termin to its Sitzung. Termin is nil if the
+// Sitzung has no Termin anymore (Disappeared), Sitzung is nil if it isn't
+// stored. Mismatches describe the differences of date, time and Gremium.
+type Reconciliation struct {
+	TerminKey   *Key
+	Termin      *Termin
+	SitzungKey  *Key
+	Sitzung     *Sitzung
+	Status      TerminStatus
+	Disappeared bool
+	Mismatches  []string
+}
+
+// cancelledStatus are the markers of a cancelled Sitzung in its Status.
+var cancelledStatus = []string{"abgesagt", "entfällt", "entfallen", "ausgefallen", "aufgehoben"}
+
+// IsCancelled reports whether the Status of a Sitzung marks it as cancelled,
+// e.g. "abgesagt" or "entfällt".
+func IsCancelled(status string) bool {
+	status = strings.ToLower(status)
+	for _, c := range cancelledStatus {
+		if strings.Contains(status, c) {
+			return true
+		}
+	}
+	return false
+}
+
+// ReconcileTermine matches the Termine starting on or after the day of from
+// to the Sitzungen by SILFDNR, Termine without one by Gremium and day.
+// Sitzungen from that day on without a Termin are reported as disappeared. now decides
+// between planned and held.
+func ReconcileTermine(repo Repository, config allris_common.Config, from time.Time, now time.Time) ([]*Reconciliation, error) {
+
+	loc, err := time.LoadLocation(config.GetTimezone())
+	if err != nil {
+		loc = time.UTC
+	}
+	day := func(t time.Time) string {
+		return t.In(loc).Format("2006-01-02")
+	}
+
+	// both from the start of the day, so a Termin earlier on the day of from
+	// isn't missing while its Sitzung is reported as disappeared
+	fromDay, _ := time.ParseInLocation("2006-01-02", day(from), loc)
+	var termine []*Termin
+	terminKeys, err := repo.GetAll(NewQuery(config.GetEntityTermin()).Filter("Start >=", fromDay), &termine)
+	if err != nil {
+		return nil, errors.Wrap(err, "error getting termine from db")
+	}
+	var sitzungen []*Sitzung
+	sitzungKeys, err := repo.GetAll(NewQuery(config.GetEntitySitzung()).Filter("Datum >=", fromDay), &sitzungen)
+	if err != nil {
+		return nil, errors.Wrap(err, "error getting sitzungen from db")
+	}
+	bySILFDNR := make(map[int]int)
+	byGremiumDay := make(map[string]int)
+	for i, s := range sitzungen {
+		bySILFDNR[s.SILFDNR] = i
+		byGremiumDay[strings.TrimSpace(s.Gremium)+"_"+day(s.Datum)] = i
+	}
+
+	var result []*Reconciliation
+	matched := make(map[int]bool)
+	for i, t := range termine {
+
+		r := &Reconciliation{TerminKey: terminKeys[i], Termin: t}
+		result = append(result, r)
+
+		n, found := bySILFDNR[t.SILFDNR]
+		if t.SILFDNR > 0 && !found {
+			// the Sitzung may be dated before from, e.g. moved
+			key := NameKey(config.GetEntitySitzung(), fmt.Sprintf("%d", t.SILFDNR), nil)
+			var s Sitzung
+			err := repo.Get(key, &s)
+			if err != nil && err != ErrNoSuchEntity {
+				return nil, errors.Wrap(err, "error getting sitzung "+key.Name+" from db")
+			}
+			if err == nil {
+				sitzungen = append(sitzungen, &s)
+				sitzungKeys = append(sitzungKeys, key)
+				n, found = len(sitzungen)-1, true
+				bySILFDNR[t.SILFDNR] = n
+			}
+		}
+		if t.SILFDNR == 0 {
+			n, found = byGremiumDay[strings.TrimSpace(t.Gremium)+"_"+day(t.Start)]
+			if found && sitzungen[n].SILFDNR > 0 {
+				r.Mismatches = append(r.Mismatches, fmt.Sprintf("no SILFDNR, matched Sitzung %d by Gremium and day", sitzungen[n].SILFDNR))
+			}
+		}
+		if !found {
+			if t.SILFDNR > 0 {
+				r.SitzungKey = NameKey(config.GetEntitySitzung(), fmt.Sprintf("%d", t.SILFDNR), nil)
+				r.Mismatches = append(r.Mismatches, fmt.Sprintf("Sitzung %d not stored", t.SILFDNR))
+			}
+			r.Status = byTime(t.Start, now)
+			continue
+		}
+
+		s := sitzungen[n]
+		matched[n] = true
+		r.SitzungKey = sitzungKeys[n]
+		r.Sitzung = s
+
+		if strings.TrimSpace(s.Gremium) != strings.TrimSpace(t.Gremium) {
+			r.Mismatches = append(r.Mismatches, fmt.Sprintf("Gremium: Termin %q, Sitzung %q", t.Gremium, s.Gremium))
+		}
+		moved := false
+		if day(s.Datum) != day(t.Start) {
+			moved = true
+			r.Mismatches = append(r.Mismatches, fmt.Sprintf("date: Termin %s, Sitzung %s", day(t.Start), day(s.Datum)))
+		} else if hasTime(s.Datum, loc) && !s.Datum.Equal(t.Start) {
+			moved = true
+			r.Mismatches = append(r.Mismatches, fmt.Sprintf("time: Termin %s, Sitzung %s", t.Start.In(loc).Format("15:04"), s.Datum.In(loc).Format("15:04")))
+		}
+
+		switch {
+		case IsCancelled(s.Status):
+			r.Status = TerminCancelled
+		case moved:
+			r.Status = TerminMoved
+		default:
+			r.Status = byTime(t.Start, now)
+		}
+	}
+
+	for n, s := range sitzungen {
+		if matched[n] {
+			continue
+		}
+		r := &Reconciliation{
+			SitzungKey:  sitzungKeys[n],
+			Sitzung:     s,
+			Status:      TerminCancelled,
+			Disappeared: true,
+			Mismatches:  []string{"no Termin for the Sitzung"},
+		}
+		result = append(result, r)
+	}
+
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].start().Before(result[j].start())
+	})
+	return result, nil
+}
+
+func (r *Reconciliation) start() time.Time {
+	if r.Termin != nil {
+		return r.Termin.Start
+	}
+	return r.Sitzung.Datum
+}
+
+func byTime(start time.Time, now time.Time) TerminStatus {
+	if start.Before(now) {
+		return TerminHeld
+	}
+	return TerminPlanned
+}
+
+// hasTime is false for a Datum without a time of day.
+func hasTime(t time.Time, loc *time.Location) bool {
+	t = t.In(loc)
+	return t.Hour() != 0 || t.Minute() != 0
+}
@@ -0,0 +1,123 @@
+package db_test
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rismaster/allris-db/db"
+	"github.com/rismaster/allris-db/db/golden"
+	"github.com/rismaster/allris-db/db/memory"
+)
+
+func TestIsCancelled(t *testing.T) {
+	for status, want := range map[string]bool{
+		"Sitzung abgesagt": true,
+		"entfällt":         true,
+		"Entfallen":        true,
+		"ausgefallen":      true,
+		"aufgehoben":       true,
+		"öffentlich":       false,
+		"":                 false,
+	} {
+		if got := db.IsCancelled(status); got != want {
+			t.Errorf("%q: %v", status, got)
+		}
+	}
+}
+
+// reconcileRepo stores the Sitzungen and Termine of the week of 2024-03-14:
+// 1001 as planned, 1002 cancelled, 1003 moved a week, 1004 a Termin without
+// SILFDNR on the day of its Sitzung, 1005 without a Termin and a Termin of
+// 1006 without a Sitzung.
+func reconcileRepo(t *testing.T) db.Repository {
+
+	repo := memory.New()
+	at := func(d int, h int) time.Time {
+		return time.Date(2024, 3, d, h, 0, 0, 0, berlin)
+	}
+	for _, s := range []*db.Sitzung{
+		{SILFDNR: 1001, Gremium: "Stadtrat", Datum: at(14, 18)},
+		{SILFDNR: 1002, Gremium: "Bauausschuss", Datum: at(14, 17), Status: "entfällt"},
+		{SILFDNR: 1003, Gremium: "Sozialausschuss", Datum: at(21, 17)},
+		{SILFDNR: 1004, Gremium: "Ortsbeirat Nord", Datum: at(15, 0)},
+		{SILFDNR: 1005, Gremium: "Kulturausschuss", Datum: at(18, 17)},
+	} {
+		err := repo.Put(db.NameKey("Sitzung", strconv.Itoa(s.SILFDNR), nil), s)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, termin := range []*db.Termin{
+		{SILFDNR: 1001, Gremium: "Stadtrat", Start: at(14, 18)},
+		{SILFDNR: 1002, Gremium: "Bauausschuss", Start: at(14, 17)},
+		{SILFDNR: 1003, Gremium: "Sozialausschuss", Start: at(14, 17)},
+		{Gremium: "Ortsbeirat Nord", Start: at(15, 19)},
+		{SILFDNR: 1006, Gremium: "Jugendausschuss", Start: at(16, 10)},
+	} {
+		err := repo.Put(termin.GetKey(golden.FixtureConfig{}), termin)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return repo
+}
+
+func TestReconcileTermine(t *testing.T) {
+
+	repo := reconcileRepo(t)
+	from := time.Date(2024, 3, 14, 12, 0, 0, 0, berlin)
+	now := time.Date(2024, 3, 15, 12, 0, 0, 0, berlin)
+	result, err := db.ReconcileTermine(repo, golden.FixtureConfig{}, from, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bySitzung := make(map[string]*db.Reconciliation)
+	for _, r := range result {
+		if r.SitzungKey != nil {
+			bySitzung[r.SitzungKey.Name] = r
+		}
+	}
+	for _, c := range []struct {
+		silfdnr     string
+		status      db.TerminStatus
+		disappeared bool
+		mismatch    string
+	}{
+		{"1001", db.TerminHeld, false, ""},
+		{"1002", db.TerminCancelled, false, ""},
+		{"1003", db.TerminMoved, false, "date: Termin 2024-03-14, Sitzung 2024-03-21"},
+		{"1004", db.TerminPlanned, false, "no SILFDNR, matched Sitzung 1004 by Gremium and day"},
+		{"1005", db.TerminCancelled, true, "no Termin for the Sitzung"},
+		{"1006", db.TerminPlanned, false, "Sitzung 1006 not stored"},
+	} {
+		r := bySitzung[c.silfdnr]
+		if r == nil {
+			t.Errorf("%s: no reconciliation", c.silfdnr)
+			continue
+		}
+		if r.Status != c.status || r.Disappeared != c.disappeared {
+			t.Errorf("%s: status %s, disappeared %v, want %s %v", c.silfdnr, r.Status, r.Disappeared, c.status, c.disappeared)
+		}
+		mismatches := strings.Join(r.Mismatches, "; ")
+		if mismatches != c.mismatch {
+			t.Errorf("%s: mismatches %q, want %q", c.silfdnr, mismatches, c.mismatch)
+		}
+	}
+	if len(result) != 6 {
+		t.Errorf("%d reconciliations, want 6", len(result))
+	}
+
+	// before the week everything is planned
+	result, err = db.ReconcileTermine(repo, golden.FixtureConfig{}, from, from.AddDate(0, 0, -7))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range result {
+		if r.SitzungKey != nil && r.SitzungKey.Name == "1001" && r.Status != db.TerminPlanned {
+			t.Errorf("1001 before the week: %s", r.Status)
+		}
+	}
+}
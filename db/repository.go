@@ -0,0 +1,63 @@
+package db
+
+import (
+	"github.com/pkg/errors"
+	"strings"
+)
+
+// ErrNoSuchEntity is returned by Get when no entity is stored for the key.
+var ErrNoSuchEntity = errors.New("db: no such entity")
+
+// MultiError is returned by GetMulti, one error per key (nil on success).
+type MultiError []error
+
+func (m MultiError) Error() string {
+	var msgs []string
+	for _, err := range m {
+		if err != nil {
+			msgs = append(msgs, err.Error())
+		}
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Repository is the storage of Sitzung, Top, Vorlage, Anlage and Termin
+// entities. dst and src follow the datastore conventions: Get takes a pointer
+// to an entity, GetAll a pointer to a slice of entity pointers (or nil for
+// keys only queries), GetMulti and PutMulti a slice of entities.
+type Repository interface {
+	Get(key *Key, dst interface{}) error
+	GetAll(q *Query, dst interface{}) ([]*Key, error)
+	Put(key *Key, src interface{}) error
+	PutMulti(keys []*Key, src interface{}) error
+	Delete(key *Key) error
+	DeleteMulti(keys []*Key) error
+	NewTransaction() (Transaction, error)
+}
+
+// Transaction groups reads and writes that are committed atomically.
+type Transaction interface {
+	Get(key *Key, dst interface{}) error
+	GetMulti(keys []*Key, dst interface{}) error
+	Put(key *Key, src interface{}) error
+	Delete(key *Key) error
+	DeleteMulti(keys []*Key) error
+	Commit() error
+	Rollback() error
+}
+
+// RunInTransaction commits the changes done in f or rolls them back if f fails.
+func RunInTransaction(repo Repository, f func(tx Transaction) error) error {
+	tx, err := repo.NewTransaction()
+	if err != nil {
+		return errors.Wrap(err, "client.NewTransaction")
+	}
+
+	err = f(tx)
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
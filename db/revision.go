@@ -0,0 +1,197 @@
+package db
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+	allris_common "github.com/rismaster/allris-common"
+)
+
+// vorlageRevisionFields are the fields of a Vorlage tracked in its revisions.
+var vorlageRevisionFields = []string{
+	"BSVV",
+	"Betreff",
+	"Status",
+	"Federfuehrend",
+	"Bearbeiter",
+	"BeschlussVorlage",
+	"Begruendung",
+	"FinanzielleAuswirkung",
+	"DatumAngelegt",
+	"BezueglichVOLFDNR",
+	"BezueglichBSVV",
+}
+
+// FieldChange is the change of one field of a Vorlage, the values are JSON
+// encoded. Old is null in the revision that created the Vorlage, New in the
+// one that deleted it.
+type FieldChange struct {
+	Field string
+	Old   json.RawMessage
+	New   json.RawMessage
+}
+
+// VorlageRevision records the fields changed by one save or the delete of a
+// Vorlage. The revisions are kept when the Vorlage is deleted, the last one
+// then has Deleted set.
+type VorlageRevision struct {
+	VOLFDNR   int
+	ChangedAt time.Time
+	Created   bool
+	Deleted   bool
+	Diff      string `datastore:",noindex"` // JSON encoded []FieldChange
+}
+
+func (r *VorlageRevision) GetKey(vorlageKey *Key) *Key {
+	return NameKey(EntityVorlageRevision, strconv.FormatInt(r.ChangedAt.UnixNano(), 10), vorlageKey)
+}
+
+// Changes decodes the field-level diff of the revision.
+func (r *VorlageRevision) Changes() ([]FieldChange, error) {
+	var changes []FieldChange
+	err := json.Unmarshal([]byte(r.Diff), &changes)
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("error decoding diff of revision %d of vorlage %d", r.ChangedAt.UnixNano(), r.VOLFDNR))
+	}
+	return changes, nil
+}
+
+func revisionValue(v reflect.Value) ([]byte, error) {
+	// times come back from the stores in different locations
+	if t, ok := v.Interface().(time.Time); ok {
+		return json.Marshal(t.UTC())
+	}
+	return json.Marshal(v.Interface())
+}
+
+// newVorlageRevision returns the revision from old to v, nil if no tracked
+// field changed. old is nil if v is new, v is nil if old is deleted.
+func newVorlageRevision(old *Vorlage, v *Vorlage) (*VorlageRevision, error) {
+
+	var changes []FieldChange
+	for _, name := range vorlageRevisionFields {
+		var err error
+		newValue := []byte("null")
+		if v != nil {
+			newValue, err = revisionValue(reflect.ValueOf(v).Elem().FieldByName(name))
+			if err != nil {
+				return nil, errors.Wrap(err, "error encoding "+name)
+			}
+		}
+		oldValue := []byte("null")
+		if old != nil {
+			oldValue, err = revisionValue(reflect.ValueOf(old).Elem().FieldByName(name))
+			if err != nil {
+				return nil, errors.Wrap(err, "error encoding "+name)
+			}
+		}
+		if !bytes.Equal(oldValue, newValue) {
+			changes = append(changes, FieldChange{Field: name, Old: oldValue, New: newValue})
+		}
+	}
+	if len(changes) == 0 {
+		return nil, nil
+	}
+
+	diff, err := json.Marshal(changes)
+	if err != nil {
+		return nil, errors.Wrap(err, "error encoding diff")
+	}
+	if v == nil {
+		return &VorlageRevision{
+			VOLFDNR:   old.VOLFDNR,
+			ChangedAt: time.Now(),
+			Deleted:   true,
+			Diff:      string(diff),
+		}, nil
+	}
+	changedAt := v.SavedAt
+	if changedAt.IsZero() {
+		changedAt = time.Now()
+	}
+	return &VorlageRevision{
+		VOLFDNR:   v.VOLFDNR,
+		ChangedAt: changedAt,
+		Created:   old == nil,
+		Diff:      string(diff),
+	}, nil
+}
+
+// ListVorlageRevisions returns the revisions of a Vorlage, oldest first.
+func ListVorlageRevisions(repo Repository, config allris_common.Config, volfdnr int) ([]*VorlageRevision, error) {
+
+	v := &Vorlage{VOLFDNR: volfdnr, config: config}
+	var revisions []*VorlageRevision
+	_, err := repo.GetAll(NewQuery(EntityVorlageRevision).WithAncestor(v.GetKey()), &revisions)
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("error getting revisions of vorlage %d from db", volfdnr))
+	}
+	sort.SliceStable(revisions, func(i, j int) bool {
+		return revisions[i].ChangedAt.Before(revisions[j].ChangedAt)
+	})
+	return revisions, nil
+}
+
+// VorlageAsOf reconstructs a Vorlage as it was stored at the given time by
+// undoing the later revisions on the current one, or on the last one stored
+// if the Vorlage is deleted. It returns ErrNoSuchEntity if the Vorlage did not
+// exist at that time. Only the tracked fields are restored, SavedAt is the
+// time of the revision in effect.
+func VorlageAsOf(repo Repository, config allris_common.Config, volfdnr int, at time.Time) (*Vorlage, error) {
+
+	revisions, err := ListVorlageRevisions(repo, config, volfdnr)
+	if err != nil {
+		return nil, err
+	}
+
+	v := &Vorlage{VOLFDNR: volfdnr, config: config}
+	err = repo.Get(v.GetKey(), v)
+	if err == ErrNoSuchEntity && len(revisions) > 0 && revisions[len(revisions)-1].Deleted {
+		// the deletion revision holds all tracked fields
+		err = nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	rv := reflect.ValueOf(v).Elem()
+	for i := len(revisions) - 1; i >= 0; i-- {
+		r := revisions[i]
+		if !r.ChangedAt.After(at) {
+			if r.Deleted {
+				return nil, ErrNoSuchEntity
+			}
+			v.SavedAt = r.ChangedAt
+			break
+		}
+		if r.Created && i == 0 {
+			return nil, ErrNoSuchEntity
+		}
+		if r.Created {
+			// created again after a delete, the deletion revision before
+			// restores the fields
+			continue
+		}
+		changes, err := r.Changes()
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range changes {
+			f := rv.FieldByName(c.Field)
+			if !f.IsValid() {
+				continue
+			}
+			err = json.Unmarshal(c.Old, f.Addr().Interface())
+			if err != nil {
+				return nil, errors.Wrap(err, fmt.Sprintf("error restoring %s of vorlage %d", c.Field, volfdnr))
+			}
+		}
+	}
+	return v, nil
+}
@@ -0,0 +1,79 @@
+package db_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/rismaster/allris-db/db"
+)
+
+func TestNewVorlageRevision(t *testing.T) {
+
+	savedAt := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	v := &db.Vorlage{VOLFDNR: 2001, Betreff: "Radverkehr", Status: "öffentlich", SavedAt: savedAt}
+
+	created, err := db.NewVorlageRevision(nil, v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !created.Created || created.Deleted || !created.ChangedAt.Equal(savedAt) || created.VOLFDNR != 2001 {
+		t.Errorf("created %+v", created)
+	}
+	changes, err := created.Changes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range changes {
+		if string(c.Old) != "null" {
+			t.Errorf("created: %s from %s", c.Field, c.Old)
+		}
+	}
+
+	same := *v
+	same.Bearbeiter = ""
+	unchanged, err := db.NewVorlageRevision(v, &same)
+	if err != nil || unchanged != nil {
+		t.Errorf("unchanged: %+v, %v", unchanged, err)
+	}
+
+	// times of the stores in other locations are no change
+	moved := *v
+	moved.DatumAngelegt = time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)
+	inBerlin := moved
+	inBerlin.DatumAngelegt = moved.DatumAngelegt.In(berlin)
+	if r, _ := db.NewVorlageRevision(&moved, &inBerlin); r != nil {
+		t.Errorf("time zone change recorded: %s", r.Diff)
+	}
+
+	changed := *v
+	changed.Status = "zurückgezogen"
+	changed.SavedAt = savedAt.Add(time.Hour)
+	revision, err := db.NewVorlageRevision(v, &changed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	changes, err = revision.Changes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if revision.Created || len(changes) != 1 || changes[0].Field != "Status" || string(changes[0].Old) != `"öffentlich"` {
+		t.Errorf("changed %+v", revision)
+	}
+
+	deleted, err := db.NewVorlageRevision(&changed, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	changes, err = deleted.Changes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !deleted.Deleted || deleted.Created || deleted.VOLFDNR != 2001 {
+		t.Errorf("deleted %+v", deleted)
+	}
+	for _, c := range changes {
+		if string(c.New) != "null" {
+			t.Errorf("deleted: %s to %s", c.Field, c.New)
+		}
+	}
+}
@@ -0,0 +1,346 @@
+// Package search is an embedded full-text index over the texts of the Vorlagen
+// and Tops, which are stored noindex and can't be queried in the Repository.
+// The index is kept up to date by the Sink and can be rebuilt with IndexAll.
+package search
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/blevesearch/bleve/v2"
+	"github.com/blevesearch/bleve/v2/analysis/analyzer/keyword"
+	"github.com/blevesearch/bleve/v2/analysis/lang/de"
+	"github.com/blevesearch/bleve/v2/mapping"
+	"github.com/blevesearch/bleve/v2/search/query"
+	"github.com/pkg/errors"
+	allris_common "github.com/rismaster/allris-common"
+	"github.com/rismaster/allris-db/db"
+	"github.com/rismaster/allris-db/db/internal/htmltext"
+)
+
+const (
+	KindVorlage = "vorlage"
+	KindTop     = "top"
+)
+
+// textFields are searched by Search, Betreff counts double.
+var textFields = map[string]float64{
+	"Betreff":          2,
+	"BeschlussVorlage": 1,
+	"Begruendung":      1,
+	"Beschluss":        1,
+	"Protokoll":        1,
+}
+
+// Document is what is indexed of a Vorlage or a Top, the id is the encoded
+// key. Datum is DatumAngelegt for a Vorlage and the Datum of the Sitzung for a
+// Top. Gremium is the Gremium of the Sitzung of a Top and the Gremien of the
+// Beratungsfolge of a Vorlage, or its Federfuehrend if none is stored.
+type Document struct {
+	Kind    string
+	VOLFDNR int
+	SILFDNR int
+	TOLFDNR int
+	BSVV    string
+	Gremium []string
+	Status  string
+	Datum   time.Time
+
+	Betreff          string
+	BeschlussVorlage string
+	Begruendung      string
+	Beschluss        string
+	Protokoll        string
+}
+
+// Index wraps a bleve index with the German mapping of Document.
+type Index struct {
+	idx    bleve.Index
+	config allris_common.Config
+}
+
+// Open opens the index at path and creates it if it doesn't exist.
+func Open(path string, config allris_common.Config) (*Index, error) {
+	idx, err := bleve.Open(path)
+	if err == bleve.ErrorIndexPathDoesNotExist {
+		idx, err = bleve.New(path, newMapping())
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "error opening search index "+path)
+	}
+	return &Index{idx: idx, config: config}, nil
+}
+
+// NewMemory is an index without persistence, e.g. for tests.
+func NewMemory(config allris_common.Config) (*Index, error) {
+	idx, err := bleve.NewMemOnly(newMapping())
+	if err != nil {
+		return nil, errors.Wrap(err, "error creating search index")
+	}
+	return &Index{idx: idx, config: config}, nil
+}
+
+func (i *Index) Close() error {
+	return i.idx.Close()
+}
+
+func newMapping() mapping.IndexMapping {
+
+	text := bleve.NewTextFieldMapping()
+	text.Analyzer = de.AnalyzerName
+	text.Store = true
+	text.IncludeTermVectors = true
+	text.IncludeInAll = false
+
+	exact := bleve.NewTextFieldMapping()
+	exact.Analyzer = keyword.Name
+	exact.Store = true
+	exact.IncludeInAll = false
+
+	number := bleve.NewNumericFieldMapping()
+	number.Store = true
+	number.IncludeInAll = false
+
+	date := bleve.NewDateTimeFieldMapping()
+	date.IncludeInAll = false
+
+	doc := bleve.NewDocumentStaticMapping()
+	for field := range textFields {
+		doc.AddFieldMappingsAt(field, text)
+	}
+	for _, field := range []string{"Kind", "BSVV", "Gremium", "Status"} {
+		doc.AddFieldMappingsAt(field, exact)
+	}
+	for _, field := range []string{"VOLFDNR", "SILFDNR", "TOLFDNR"} {
+		doc.AddFieldMappingsAt(field, number)
+	}
+	doc.AddFieldMappingsAt("Datum", date)
+
+	m := bleve.NewIndexMapping()
+	m.DefaultMapping = doc
+	m.DefaultAnalyzer = de.AnalyzerName
+	return m
+}
+
+// gremien returns the Gremien of the Beratungsfolge of a Vorlage in their
+// order, the Federfuehrend if the Beratungsfolge has none.
+func gremien(v *db.Vorlage, beratungsfolge []*db.Top) []string {
+	var names []string
+	seen := make(map[string]bool)
+	for _, t := range beratungsfolge {
+		if t.Gremium != "" && !seen[t.Gremium] {
+			seen[t.Gremium] = true
+			names = append(names, t.Gremium)
+		}
+	}
+	if len(names) == 0 && v.Federfuehrend != "" {
+		names = append(names, v.Federfuehrend)
+	}
+	return names
+}
+
+func vorlageDocument(v *db.Vorlage, beratungsfolge []*db.Top) *Document {
+	return &Document{
+		Kind:             KindVorlage,
+		VOLFDNR:          v.VOLFDNR,
+		BSVV:             v.BSVV,
+		Gremium:          gremien(v, beratungsfolge),
+		Status:           v.Status,
+		Datum:            v.DatumAngelegt,
+		Betreff:          htmltext.Plain(v.Betreff),
+		BeschlussVorlage: htmltext.Plain(v.BeschlussVorlage),
+		Begruendung:      htmltext.Plain(v.Begruendung),
+	}
+}
+
+func topDocument(t *db.Top) *Document {
+	return &Document{
+		Kind:      KindTop,
+		VOLFDNR:   t.VOLFDNR,
+		SILFDNR:   t.SILFDNR,
+		TOLFDNR:   t.TOLFDNR,
+		BSVV:      t.BSVV,
+		Gremium:   []string{t.Gremium},
+		Status:    t.Status,
+		Datum:     t.Datum,
+		Betreff:   htmltext.Plain(t.Betreff),
+		Beschluss: htmltext.Plain(t.Beschluss),
+		Protokoll: htmltext.Plain(t.Protokoll),
+	}
+}
+
+// IndexVorlage indexes a Vorlage with the Tops of its Beratungsfolge.
+func (i *Index) IndexVorlage(key *db.Key, v *db.Vorlage, beratungsfolge []*db.Top) error {
+	err := i.idx.Index(key.Encode(), vorlageDocument(v, beratungsfolge))
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("error indexing vorlage %d", v.VOLFDNR))
+	}
+	return nil
+}
+
+func (i *Index) IndexTop(key *db.Key, t *db.Top) error {
+	err := i.idx.Index(key.Encode(), topDocument(t))
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("error indexing top %d", t.TOLFDNR))
+	}
+	return nil
+}
+
+// Remove deletes the document of the key, unknown keys are ignored.
+func (i *Index) Remove(key *db.Key) error {
+	err := i.idx.Delete(key.Encode())
+	if err != nil {
+		return errors.Wrap(err, "error removing "+key.Encode()+" from search index")
+	}
+	return nil
+}
+
+// IndexAll (re)indexes all Vorlagen and Tops of the repository.
+func (i *Index) IndexAll(repo db.Repository) error {
+
+	var tops []*db.Top
+	topKeys, err := repo.GetAll(db.NewQuery(i.config.GetEntityTop()), &tops)
+	if err != nil {
+		return errors.Wrap(err, "error getting tops from db")
+	}
+	beratungen := make(map[int][]*db.Top)
+	for _, t := range tops {
+		if t.VOLFDNR != 0 {
+			beratungen[t.VOLFDNR] = append(beratungen[t.VOLFDNR], t)
+		}
+	}
+
+	var vorlagen []*db.Vorlage
+	keys, err := repo.GetAll(db.NewQuery(i.config.GetEntityVorlage()), &vorlagen)
+	if err != nil {
+		return errors.Wrap(err, "error getting vorlagen from db")
+	}
+	batch := i.idx.NewBatch()
+	for n, v := range vorlagen {
+		err = batch.Index(keys[n].Encode(), vorlageDocument(v, beratungen[v.VOLFDNR]))
+		if err != nil {
+			return errors.Wrap(err, fmt.Sprintf("error indexing vorlage %d", v.VOLFDNR))
+		}
+	}
+
+	for n, t := range tops {
+		err = batch.Index(topKeys[n].Encode(), topDocument(t))
+		if err != nil {
+			return errors.Wrap(err, fmt.Sprintf("error indexing top %d", t.TOLFDNR))
+		}
+	}
+
+	err = i.idx.Batch(batch)
+	if err != nil {
+		return errors.Wrap(err, "error writing search index")
+	}
+	return nil
+}
+
+// Request is a search. Query is matched against the texts, the other fields
+// are optional filters: Kind (KindVorlage or KindTop), exact Gremium and
+// Status, and Datum in [From, To).
+type Request struct {
+	Query   string
+	Kind    string
+	Gremium string
+	Status  string
+	From    time.Time
+	To      time.Time
+
+	Offset int
+	Limit  int
+}
+
+// Hit is one ranked result, Snippets are the matching passages per field with
+// the terms highlighted by <mark>.
+type Hit struct {
+	Key      string
+	Kind     string
+	VOLFDNR  int
+	SILFDNR  int
+	TOLFDNR  int
+	Betreff  string
+	Score    float64
+	Snippets map[string][]string
+}
+
+type Result struct {
+	Total uint64
+	Hits  []Hit
+}
+
+func (i *Index) Search(r Request) (*Result, error) {
+
+	if strings.TrimSpace(r.Query) == "" {
+		return nil, errors.New("empty search query")
+	}
+
+	var match []query.Query
+	for field, boost := range textFields {
+		q := bleve.NewMatchQuery(r.Query)
+		q.SetField(field)
+		q.Analyzer = de.AnalyzerName
+		q.SetBoost(boost)
+		match = append(match, q)
+	}
+	must := []query.Query{bleve.NewDisjunctionQuery(match...)}
+
+	for field, value := range map[string]string{"Kind": r.Kind, "Gremium": r.Gremium, "Status": r.Status} {
+		if value != "" {
+			q := bleve.NewTermQuery(value)
+			q.SetField(field)
+			must = append(must, q)
+		}
+	}
+	if !r.From.IsZero() || !r.To.IsZero() {
+		q := bleve.NewDateRangeQuery(r.From, r.To)
+		q.SetField("Datum")
+		must = append(must, q)
+	}
+
+	limit := r.Limit
+	if limit <= 0 {
+		limit = 20
+	}
+	req := bleve.NewSearchRequestOptions(bleve.NewConjunctionQuery(must...), limit, r.Offset, false)
+	req.Fields = []string{"Kind", "VOLFDNR", "SILFDNR", "TOLFDNR", "Betreff"}
+	req.Highlight = bleve.NewHighlightWithStyle("html")
+	for field := range textFields {
+		req.Highlight.AddField(field)
+	}
+
+	res, err := i.idx.Search(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "error searching "+r.Query)
+	}
+
+	result := &Result{Total: res.Total}
+	for _, h := range res.Hits {
+		hit := Hit{
+			Key:      h.ID,
+			Score:    h.Score,
+			Snippets: make(map[string][]string),
+		}
+		for field, fragments := range h.Fragments {
+			for _, f := range fragments {
+				if strings.Contains(f, "<mark>") {
+					hit.Snippets[field] = append(hit.Snippets[field], f)
+				}
+			}
+		}
+		hit.Kind, _ = h.Fields["Kind"].(string)
+		hit.Betreff, _ = h.Fields["Betreff"].(string)
+		hit.VOLFDNR = intField(h.Fields["VOLFDNR"])
+		hit.SILFDNR = intField(h.Fields["SILFDNR"])
+		hit.TOLFDNR = intField(h.Fields["TOLFDNR"])
+		result.Hits = append(result.Hits, hit)
+	}
+	return result, nil
+}
+
+func intField(v interface{}) int {
+	f, _ := v.(float64)
+	return int(f)
+}
@@ -0,0 +1,196 @@
+package search_test
+
+import (
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rismaster/allris-db/db"
+	"github.com/rismaster/allris-db/db/golden"
+	"github.com/rismaster/allris-db/db/memory"
+	"github.com/rismaster/allris-db/db/search"
+)
+
+func day(month time.Month, d int) time.Time {
+	return time.Date(2024, month, d, 0, 0, 0, 0, time.UTC)
+}
+
+var (
+	vorlageKey1 = db.NameKey("Vorlage", "1", nil)
+	vorlageKey2 = db.NameKey("Vorlage", "2", nil)
+)
+
+func topKey(silfdnr string, tolfdnr string) *db.Key {
+	return db.NameKey("Top", tolfdnr, db.NameKey("Sitzung", silfdnr, nil))
+}
+
+// newIndex indexes the Vorlage 1 with a Beratungsfolge in the Bauausschuss
+// and the Stadtrat and the withdrawn Vorlage 2 without one.
+func newIndex(t *testing.T) (*search.Index, db.Repository) {
+
+	repo := memory.New()
+	put := func(key *db.Key, src interface{}) {
+		err := repo.Put(key, src)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	put(vorlageKey1, &db.Vorlage{
+		VOLFDNR:       1,
+		Betreff:       "Radverkehrskonzepte für die Innenstadt",
+		Begruendung:   "<p>Im Stadtgebiet fehlen Radwege.</p>",
+		Status:        "öffentlich",
+		Federfuehrend: "Amt für Verkehr",
+		DatumAngelegt: day(time.February, 1),
+	})
+	put(vorlageKey2, &db.Vorlage{
+		VOLFDNR:       2,
+		Betreff:       "Neue Bäume in der Parkstraße",
+		Begruendung:   "<p>Das Stadtgebiet braucht Schatten.</p>",
+		Status:        "zurückgezogen",
+		Federfuehrend: "Grünflächenamt",
+		DatumAngelegt: day(time.May, 1),
+	})
+	put(topKey("100", "11"), &db.Top{
+		SILFDNR:   100,
+		TOLFDNR:   11,
+		VOLFDNR:   1,
+		Gremium:   "Bauausschuss",
+		Datum:     day(time.March, 14),
+		Betreff:   "Radverkehrskonzepte für die Innenstadt",
+		Beschluss: "<p>Der Ausschuss <b>beschließt</b> das Konzept.</p>",
+	})
+	put(topKey("101", "12"), &db.Top{
+		SILFDNR: 101,
+		TOLFDNR: 12,
+		VOLFDNR: 1,
+		Gremium: "Stadtrat",
+		Datum:   day(time.April, 25),
+		Betreff: "Radverkehrskonzepte für die Innenstadt",
+	})
+
+	index, err := search.NewMemory(golden.FixtureConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { index.Close() })
+	err = index.IndexAll(repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return index, repo
+}
+
+func hits(t *testing.T, index *search.Index, r search.Request) []string {
+	t.Helper()
+	res, err := index.Search(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var keys []string
+	for _, h := range res.Hits {
+		k, err := db.DecodeKey(h.Key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys = append(keys, k.Kind+" "+k.Name)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func TestSearch(t *testing.T) {
+
+	index, _ := newIndex(t)
+	for _, c := range []struct {
+		name string
+		r    search.Request
+		want string
+	}{
+		{"stemmed", search.Request{Query: "Radverkehrskonzept"}, "Top 11, Top 12, Vorlage 1"},
+		{"stemmed umlaut", search.Request{Query: "Baum"}, "Vorlage 2"},
+		{"html stripped", search.Request{Query: "beschließt"}, "Top 11"},
+		{"kind", search.Request{Query: "Radverkehrskonzept", Kind: search.KindVorlage}, "Vorlage 1"},
+		{"gremium of a top", search.Request{Query: "Radverkehrskonzept", Gremium: "Bauausschuss", Kind: search.KindTop}, "Top 11"},
+		{"gremium of the beratungsfolge", search.Request{Query: "Stadtgebiet", Gremium: "Stadtrat"}, "Vorlage 1"},
+		{"federfuehrend without beratungsfolge", search.Request{Query: "Stadtgebiet", Gremium: "Grünflächenamt"}, "Vorlage 2"},
+		{"federfuehrend with beratungsfolge", search.Request{Query: "Stadtgebiet", Gremium: "Amt für Verkehr"}, ""},
+		{"status", search.Request{Query: "Stadtgebiet", Status: "zurückgezogen"}, "Vorlage 2"},
+		{"from", search.Request{Query: "Radverkehrskonzept", From: day(time.April, 1)}, "Top 12"},
+		{"to", search.Request{Query: "Radverkehrskonzept", To: day(time.March, 1)}, "Vorlage 1"},
+		{"from to", search.Request{Query: "Stadtgebiet", From: day(time.April, 1), To: day(time.June, 1)}, "Vorlage 2"},
+	} {
+		got := strings.Join(hits(t, index, c.r), ", ")
+		if got != c.want {
+			t.Errorf("%s: %q, want %q", c.name, got, c.want)
+		}
+	}
+
+	_, err := index.Search(search.Request{Query: " "})
+	if err == nil {
+		t.Error("empty query accepted")
+	}
+}
+
+func TestSearchSnippets(t *testing.T) {
+
+	index, _ := newIndex(t)
+	res, err := index.Search(search.Request{Query: "Konzept", Kind: search.KindTop})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Hits) != 1 {
+		t.Fatalf("%d hits, want 1", len(res.Hits))
+	}
+	h := res.Hits[0]
+	if h.TOLFDNR != 11 || h.SILFDNR != 100 || h.VOLFDNR != 1 || h.Kind != search.KindTop {
+		t.Errorf("hit %+v", h)
+	}
+	snippets := h.Snippets["Beschluss"]
+	if len(snippets) != 1 || !strings.Contains(snippets[0], "<mark>Konzept</mark>") || strings.Contains(snippets[0], "<b>") {
+		t.Errorf("snippets %q", snippets)
+	}
+	if _, ok := h.Snippets["Betreff"]; ok {
+		t.Errorf("snippet of a field without match: %q", h.Snippets["Betreff"])
+	}
+}
+
+func TestSink(t *testing.T) {
+
+	index, repo := newIndex(t)
+	sink := search.NewSink(index, repo)
+	publish := func(typ db.EventType, key *db.Key) {
+		t.Helper()
+		err := sink.Publish(db.Event{Type: typ, Kind: key.Kind, Key: key.Encode()})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// a new Top of the Beratungsfolge reindexes its Vorlage
+	key := topKey("102", "13")
+	err := repo.Put(key, &db.Top{SILFDNR: 102, TOLFDNR: 13, VOLFDNR: 2, Gremium: "Hauptausschuss", Betreff: "Neue Bäume in der Parkstraße"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	publish(db.EventCreated, key)
+	if got := hits(t, index, search.Request{Query: "Stadtgebiet", Gremium: "Hauptausschuss"}); len(got) != 1 || got[0] != "Vorlage 2" {
+		t.Errorf("gremium of the new beratung: %v", got)
+	}
+
+	err = repo.Delete(vorlageKey2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publish(db.EventDeleted, vorlageKey2)
+	if got := hits(t, index, search.Request{Query: "Baum", Kind: search.KindVorlage}); len(got) != 0 {
+		t.Errorf("deleted vorlage found: %v", got)
+	}
+
+	// other kinds are ignored
+	err = sink.Publish(db.Event{Type: db.EventDeleted, Kind: "Sitzung", Key: "not a key"})
+	if err != nil {
+		t.Errorf("sitzung event: %v", err)
+	}
+}
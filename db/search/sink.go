@@ -0,0 +1,75 @@
+package search
+
+import (
+	"strconv"
+
+	"github.com/pkg/errors"
+	"github.com/rismaster/allris-db/db"
+)
+
+// Sink updates the index from the events of Sync and the Delete* entrypoints,
+// use it as Env.Events (together with other sinks in a db.MultiSink).
+type Sink struct {
+	Index *Index
+	Repo  db.Repository
+}
+
+func NewSink(index *Index, repo db.Repository) *Sink {
+	return &Sink{Index: index, Repo: repo}
+}
+
+// Publish reindexes created and updated Vorlagen and Tops from the
+// repository and removes the deleted ones, other kinds are ignored. The
+// Vorlage of a Top is reindexed with it, its Gremien may have changed.
+func (s *Sink) Publish(event db.Event) error {
+
+	config := s.Index.config
+	if event.Kind != config.GetEntityVorlage() && event.Kind != config.GetEntityTop() {
+		return nil
+	}
+	key, err := db.DecodeKey(event.Key)
+	if err != nil {
+		return errors.Wrap(err, "error decoding key of event")
+	}
+	if event.Type == db.EventDeleted {
+		return s.Index.Remove(key)
+	}
+
+	switch event.Kind {
+	case config.GetEntityVorlage():
+		return s.indexVorlage(key)
+	default:
+		var t db.Top
+		err = s.Repo.Get(key, &t)
+		if err == db.ErrNoSuchEntity {
+			return s.Index.Remove(key)
+		}
+		if err != nil {
+			return errors.Wrap(err, "error getting top "+key.Name+" from db")
+		}
+		err = s.Index.IndexTop(key, &t)
+		if err != nil || t.VOLFDNR == 0 {
+			return err
+		}
+		return s.indexVorlage(db.NameKey(config.GetEntityVorlage(), strconv.Itoa(t.VOLFDNR), nil))
+	}
+}
+
+func (s *Sink) indexVorlage(key *db.Key) error {
+
+	config := s.Index.config
+	var v db.Vorlage
+	err := s.Repo.Get(key, &v)
+	if err == db.ErrNoSuchEntity {
+		return s.Index.Remove(key)
+	}
+	if err != nil {
+		return errors.Wrap(err, "error getting vorlage "+key.Name+" from db")
+	}
+	var beratungsfolge []*db.Top
+	_, err = s.Repo.GetAll(db.NewQuery(config.GetEntityTop()).Filter("VOLFDNR =", v.VOLFDNR), &beratungsfolge)
+	if err != nil {
+		return errors.Wrap(err, "error getting beratungsfolge of vorlage "+key.Name+" from db")
+	}
+	return s.Index.IndexVorlage(key, &v, beratungsfolge)
+}
@@ -1,10 +1,10 @@
 package db
 
 import (
-	"cloud.google.com/go/datastore"
 	"fmt"
 	"github.com/PuerkitoBio/goquery"
 	"github.com/pkg/errors"
+	allris_common "github.com/rismaster/allris-common"
 	"github.com/rismaster/allris-common/application"
 	"github.com/rismaster/allris-common/common/domtools"
 	"github.com/rismaster/allris-common/common/files"
@@ -30,8 +30,11 @@ type Sitzung struct {
 	anlagen []*Anlage
 
 	SavedAt time.Time
-	file    *files.File
-	app     *application.AppContext
+
+	ContentHash      string `datastore:",noindex"`
+	SourceGeneration string `datastore:",noindex"`
+	file             *files.File
+	config           allris_common.Config
 }
 
 func NewSitzung(app *application.AppContext, file *files.File) (*Sitzung, error) {
@@ -44,16 +47,16 @@ func NewSitzung(app *application.AppContext, file *files.File) (*Sitzung, error)
 	return &Sitzung{
 		SILFDNR: silfdnr,
 		file:    file,
-		app:     app,
+		config:  app.Config,
 	}, nil
 }
 
-func (s *Sitzung) GetTopQuery() *datastore.Query {
-	return datastore.NewQuery(s.app.Config.GetEntityTop()).Ancestor(s.GetKey())
+func (s *Sitzung) GetTopQuery() *Query {
+	return NewQuery(s.config.GetEntityTop()).WithAncestor(s.GetKey())
 }
 
-func (s *Sitzung) GetDirectAnlagenQuery() *datastore.Query {
-	return datastore.NewQuery(s.app.Config.GetEntityAnlage()).Ancestor(s.GetKey()).Filter("TOLFDNR = ", 0)
+func (s *Sitzung) GetDirectAnlagenQuery() *Query {
+	return NewQuery(s.config.GetEntityAnlage()).WithAncestor(s.GetKey()).Filter("TOLFDNR = ", 0)
 }
 
 func (s *Sitzung) GetFile() *files.File {
@@ -64,6 +67,19 @@ func (s *Sitzung) SetSavedAt(t time.Time) {
 	s.SavedAt = t
 }
 
+func (s *Sitzung) GetContentHash() string {
+	return s.ContentHash
+}
+
+func (s *Sitzung) GetSourceGeneration() string {
+	return s.SourceGeneration
+}
+
+func (s *Sitzung) SetSourceVersion(hash string, generation string) {
+	s.ContentHash = hash
+	s.SourceGeneration = generation
+}
+
 func (s *Sitzung) GetTops() []*Top {
 	return s.tops
 }
@@ -72,8 +88,8 @@ func (s *Sitzung) GetAnlagen() []*Anlage {
 	return s.anlagen
 }
 
-func (s *Sitzung) GetKey() *datastore.Key {
-	return datastore.NameKey(s.app.Config.GetEntitySitzung(), fmt.Sprintf("%d", s.SILFDNR), nil)
+func (s *Sitzung) GetKey() *Key {
+	return NameKey(s.config.GetEntitySitzung(), fmt.Sprintf("%d", s.SILFDNR), nil)
 }
 
 func (s *Sitzung) Parse(doc *goquery.Document) error {
@@ -90,13 +106,13 @@ func (s *Sitzung) parseElement(dom *goquery.Selection) error {
 
 	bez, cont := domtools.ParseTable(dom.Find("table.tk1").Find("tr > td.kb1"))
 
-	s.anlagen = ExtractAnlagen(dom, s.app.Config)
+	s.anlagen = ExtractAnlagen(dom, s.config)
 
 	for _, a := range s.anlagen {
 		a.SILFDNR = s.SILFDNR
 	}
 
-	basisanlagen := ExtractBasisAnlagen(dom, s.app.Config)
+	basisanlagen := ExtractBasisAnlagen(dom, s.config)
 	for _, a := range basisanlagen {
 		s.anlagen = append(s.anlagen, a)
 		a.SILFDNR = s.SILFDNR
@@ -111,7 +127,7 @@ func (s *Sitzung) parseElement(dom *goquery.Selection) error {
 	s.Uhrzeit = domtools.FindIndex(bez, cont, "Zeit:")
 	datumString := domtools.FindIndex(bez, cont, "Datum:")
 
-	datum, err := domtools.ExtractWeekdayDateFromCommaSeparated(datumString, s.Uhrzeit, s.app.Config)
+	datum, err := domtools.ExtractWeekdayDateFromCommaSeparated(datumString, s.Uhrzeit, s.config)
 	if err != nil {
 		return err
 	}
@@ -142,7 +158,7 @@ func (s *Sitzung) parseTop(selection *goquery.Selection) *Top {
 		Datum:   s.Datum,
 		Gremium: s.Gremium,
 		SavedAt: time.Now(),
-		app:     s.app,
+		config:  s.config,
 	}
 
 	topHref, exist := topTds.Find("a[title=\"Auswählen\"]").Attr("href")
@@ -197,62 +213,78 @@ func (s *Sitzung) UpdateAnlage(oldAnlage *Anlage, newAnlage *Anlage) *Anlage {
 	oldAnlage.Title = newAnlage.Title
 	return oldAnlage
 }
-func (s *Sitzung) SaveOrUpdate() error {
-	tx, err := s.app.Db().NewTransaction(s.app.Ctx())
-	if err != nil {
-		return errors.Wrap(err, "client.NewTransaction")
-	}
+func (s *Sitzung) SaveOrUpdate(repo Repository) error {
+	return RunInTransaction(repo, func(tx Transaction) error {
+
+		var oldSitzung Sitzung
+		err := tx.Get(s.GetKey(), &oldSitzung)
+		if err != nil && err != ErrNoSuchEntity {
+			return err
+		} else if err == nil {
+			//update
+			//v.VOLFDNR = oldVorlage.VOLFDNR
+		}
 
-	var oldSitzung Sitzung
-	err = tx.Get(s.GetKey(), &oldSitzung)
-	if err != nil && err != datastore.ErrNoSuchEntity {
-		return err
-	} else if err == nil {
-		//update
-		//v.VOLFDNR = oldVorlage.VOLFDNR
-	}
+		err = tx.Put(s.GetKey(), s)
+		if err != nil {
+			return errors.Wrap(err, fmt.Sprintf("error saving to db sitzung from %s", s.file.GetName()))
+		}
+		return nil
+	})
+}
 
-	_, err = tx.Put(s.GetKey(), s)
-	if err != nil {
-		return errors.Wrap(err, fmt.Sprintf("error saving to db sitzung from %s", s.file.GetName()))
-	}
-	_, err = tx.Commit()
-	return err
+func (s *Sitzung) Delete(repo Repository) error {
+	return s.delete(repo, nil)
 }
 
-func (s *Sitzung) Delete() error {
+func (s *Sitzung) delete(repo Repository, changes *changeLog) error {
 
-	ks, err := s.app.Db().GetAll(s.app.Ctx(), datastore.NewQuery(s.app.Config.GetEntityAnlage()).Ancestor(s.GetKey()).KeysOnly(), nil)
+	ks, err := repo.GetAll(NewQuery(s.config.GetEntityAnlage()).WithAncestor(s.GetKey()).KeysOnly(), nil)
 	if err != nil {
 		return errors.Wrap(err, "error getting anlagen from db")
 	}
 
-	tks, err := s.app.Db().GetAll(s.app.Ctx(), s.GetTopQuery().KeysOnly(), nil)
+	tks, err := repo.GetAll(s.GetTopQuery().KeysOnly(), nil)
 	if err != nil {
 		return errors.Wrap(err, "error getting tops from db")
 	}
 
-	tx, err := s.app.Db().NewTransaction(s.app.Ctx())
+	aks, err := abstimmungKeys(repo, s.GetKey())
 	if err != nil {
-		return errors.Wrap(err, "client.NewTransaction")
+		return err
 	}
 
-	err = tx.DeleteMulti(ks)
-	if err != nil {
-		slog.Error("error delete anlagen of sitzung in db for %s: %v", s.file.GetName(), err)
-	}
+	return RunInTransaction(repo, func(tx Transaction) error {
 
-	err = tx.DeleteMulti(tks)
-	if err != nil {
-		slog.Error("error delete tops of sitzung in db for %s: %v", s.file.GetName(), err)
-	}
+		exists := tx.Get(s.GetKey(), &Sitzung{}) == nil
 
-	err = tx.Delete(s.GetKey())
-	if err != nil {
-		slog.Error("error delete sitzung in db for %s: %v", s.file.GetName(), err)
-	}
+		err := tx.DeleteMulti(ks)
+		if err != nil {
+			slog.Error("error delete anlagen of sitzung in db for %s: %v", s.file.GetName(), err)
+		} else {
+			changes.deleted(ks...)
+		}
 
-	_, err = tx.Commit()
+		err = tx.DeleteMulti(aks)
+		if err != nil {
+			slog.Error("error delete abstimmungen of sitzung in db for %s: %v", s.file.GetName(), err)
+		} else {
+			changes.deleted(aks...)
+		}
 
-	return err
+		err = tx.DeleteMulti(tks)
+		if err != nil {
+			slog.Error("error delete tops of sitzung in db for %s: %v", s.file.GetName(), err)
+		} else {
+			changes.deleted(tks...)
+		}
+
+		err = tx.Delete(s.GetKey())
+		if err != nil {
+			slog.Error("error delete sitzung in db for %s: %v", s.file.GetName(), err)
+		} else if exists {
+			changes.deleted(s.GetKey())
+		}
+		return nil
+	})
 }
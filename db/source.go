@@ -0,0 +1,116 @@
+package db
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+
+	"cloud.google.com/go/storage"
+	"github.com/rismaster/allris-common/application"
+	"github.com/rismaster/allris-common/common/files"
+)
+
+// Source provides the content of the fetched ALLRIS html files. ReadFile
+// returns an error matching os.ErrNotExist if there is no such file.
+type Source interface {
+	ReadFile(file *files.File) ([]byte, error)
+}
+
+// GenerationSource is a Source that can tell the version of a file without
+// reading it. Sync skips a file whose generation it has already stored.
+type GenerationSource interface {
+	Source
+	Generation(file *files.File) (string, error)
+}
+
+// ListingSource is a Source that can enumerate the files of a folder, see
+// Backfill. List returns the paths of the files directly in the folder.
+type ListingSource interface {
+	Source
+	List(folder string) ([]string, error)
+}
+
+// BucketSource reads the files from the fetched bucket in Cloud Storage.
+type BucketSource struct {
+	app *application.AppContext
+}
+
+func NewBucketSource(app *application.AppContext) *BucketSource {
+	return &BucketSource{app: app}
+}
+
+func (b *BucketSource) ReadFile(file *files.File) ([]byte, error) {
+	err := file.ReadDocument(b.app.Config.GetBucketFetched())
+	if err == storage.ErrObjectNotExist {
+		return nil, &os.PathError{Op: "read", Path: file.GetPath(), Err: os.ErrNotExist}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return file.GetContent(), nil
+}
+
+// Generation is the generation of the Cloud Storage object.
+func (b *BucketSource) Generation(file *files.File) (string, error) {
+	attrs, err := b.app.Store().Bucket(b.app.Config.GetBucketFetched()).Object(file.GetPath()).Attrs(b.app.Ctx())
+	if err == storage.ErrObjectNotExist {
+		return "", &os.PathError{Op: "stat", Path: file.GetPath(), Err: os.ErrNotExist}
+	}
+	if err != nil {
+		return "", err
+	}
+	return strconv.FormatInt(attrs.Generation, 10), nil
+}
+
+// List lists the objects of the fetched bucket with the folder as prefix.
+func (b *BucketSource) List(folder string) ([]string, error) {
+	list, err := files.ListFiles(b.app, folder)
+	if err != nil {
+		return nil, err
+	}
+	var paths []string
+	for _, file := range list {
+		if file.GetFolder() == folder {
+			paths = append(paths, file.GetPath())
+		}
+	}
+	return paths, nil
+}
+
+// DirSource reads the files from a local directory laid out like the bucket.
+type DirSource struct {
+	Dir string
+}
+
+func (d *DirSource) ReadFile(file *files.File) ([]byte, error) {
+	return ioutil.ReadFile(filepath.Join(d.Dir, filepath.FromSlash(file.GetPath())))
+}
+
+// Generation is made of the modification time and the size of the file.
+func (d *DirSource) Generation(file *files.File) (string, error) {
+	info, err := os.Stat(filepath.Join(d.Dir, filepath.FromSlash(file.GetPath())))
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%d-%d", info.ModTime().UnixNano(), info.Size()), nil
+}
+
+// List lists the files of the folder below Dir. A missing folder is an
+// error, not an empty listing, so a wrong Dir doesn't look like every file
+// is gone.
+func (d *DirSource) List(folder string) ([]string, error) {
+	infos, err := ioutil.ReadDir(filepath.Join(d.Dir, filepath.FromSlash(folder)))
+	if err != nil {
+		return nil, err
+	}
+	var paths []string
+	for _, info := range infos {
+		if !info.IsDir() {
+			paths = append(paths, path.Join(folder, info.Name()))
+		}
+	}
+	return paths, nil
+}
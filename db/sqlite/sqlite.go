@@ -0,0 +1,53 @@
+// Package sqlite stores the ALLRIS entities in a single SQLite file, e.g. for
+// an offline mirror populated from html files on local disk.
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/pkg/errors"
+	allris_common "github.com/rismaster/allris-common"
+	"github.com/rismaster/allris-db/db/internal/sqlstore"
+)
+
+var dialect = sqlstore.Dialect{
+	Name: "sqlite",
+	Placeholder: func(n int) string {
+		return "?"
+	},
+	Value: func(v interface{}) interface{} {
+		// times are compared as text, so they have to share one offset
+		if t, ok := v.(time.Time); ok {
+			return t.UTC()
+		}
+		return v
+	},
+	Types: map[string]string{
+		sqlstore.TypeInt:  "INTEGER",
+		sqlstore.TypeTime: "DATETIME",
+	},
+	NoLimit: "-1",
+}
+
+// Open opens or creates the database file at path and migrates the schema
+// to the latest version.
+func Open(ctx context.Context, path string, config allris_common.Config) (*sqlstore.Repository, error) {
+
+	sqlDb, err := sql.Open("sqlite3", "file:"+path+"?_foreign_keys=on&_busy_timeout=5000")
+	if err != nil {
+		return nil, errors.Wrap(err, "error opening sqlite")
+	}
+	// sqlite allows a single writer, share one connection
+	sqlDb.SetMaxOpenConns(1)
+
+	err = sqlstore.Migrate(ctx, sqlDb, dialect)
+	if err != nil {
+		sqlDb.Close()
+		return nil, err
+	}
+
+	return sqlstore.New(ctx, sqlDb, dialect, config), nil
+}
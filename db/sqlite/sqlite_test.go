@@ -0,0 +1,25 @@
+package sqlite_test
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/rismaster/allris-db/db"
+	"github.com/rismaster/allris-db/db/golden"
+	"github.com/rismaster/allris-db/db/internal/dbtest"
+	"github.com/rismaster/allris-db/db/sqlite"
+)
+
+func TestSync(t *testing.T) {
+	dbtest.RunSyncScenarios(t, func(t *testing.T) db.Repository {
+		repo, err := sqlite.Open(context.Background(), filepath.Join(t.TempDir(), "allris.db"), golden.FixtureConfig{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() {
+			repo.Close()
+		})
+		return repo
+	})
+}
@@ -0,0 +1,442 @@
+package db
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/pkg/errors"
+	"github.com/rismaster/allris-common/common/db"
+	"github.com/rismaster/allris-common/common/slog"
+)
+
+// DefaultSweepBatch is the number of entities Sweep deletes per transaction
+// if SweepOptions.BatchSize isn't set.
+const DefaultSweepBatch = 100
+
+// DefaultMaxNoSource is the share of the entities of a folder whose file may
+// be gone before Sweep refuses the sources check, if
+// SweepOptions.MaxNoSource isn't set.
+const DefaultMaxNoSource = 0.1
+
+// SweepFinding is why Sweep reports an entity.
+type SweepFinding string
+
+const (
+	// FindingNoParent is a Top, Anlage, Abstimmung, FraktionsVotum or
+	// AnlageText whose parent is gone. Repaired by deleting it.
+	// VorlageRevisions and TerminMoves outlive their parent and are no
+	// finding.
+	FindingNoParent SweepFinding = "no-parent"
+	// FindingUnreferenced is an AnlageContent no Anlage refers to anymore.
+	// Repaired by deleting it with its text.
+	FindingUnreferenced SweepFinding = "unreferenced"
+	// FindingNoVorlage is a Top or Abstimmung whose VOLFDNR refers to a
+	// Vorlage not stored. Repaired by syncing the Vorlage if its file is
+	// there, otherwise by setting VOLFDNR to 0 like the delete of a Vorlage
+	// does.
+	FindingNoVorlage SweepFinding = "no-vorlage"
+	// FindingNoSource is a Sitzung, Top or Vorlage whose file is gone from the
+	// Source. Tops only known from the page of their Sitzung have no file of
+	// their own and are left out. Tops without ContentHash can't be told
+	// apart from those, so Tops last synced before the content hash was
+	// stored are left out as well until their next sync. Repaired by the
+	// Delete* entrypoint of the file.
+	FindingNoSource SweepFinding = "no-source"
+)
+
+// SweepItem is an entity found by Sweep, File is set for FindingNoSource and
+// VOLFDNR for FindingNoVorlage.
+type SweepItem struct {
+	Finding SweepFinding
+	Key     *Key
+	File    string `json:",omitempty"`
+	VOLFDNR int    `json:",omitempty"`
+}
+
+// SweepOptions configure Sweep.
+type SweepOptions struct {
+	// Repair repairs or deletes the findings instead of only reporting them.
+	Repair bool
+	// BatchSize is the number of entities deleted per transaction,
+	// DefaultSweepBatch if <= 0.
+	BatchSize int
+	// Sources checks the Sitzungen, Tops and Vorlagen against the files of
+	// env.Source, which must be a ListingSource.
+	Sources bool
+	// MaxNoSource is the share of the entities of a folder whose file may be
+	// gone, more fails the sweep as the Source is likely wrong.
+	// DefaultMaxNoSource if <= 0.
+	MaxNoSource float64
+}
+
+// SweepReport lists the findings of Sweep sorted by finding and key.
+// Repaired is the number of findings repaired or deleted.
+type SweepReport struct {
+	Items    []*SweepItem
+	Repaired int
+}
+
+// Count is the number of findings of a kind.
+func (r *SweepReport) Count(finding SweepFinding) int {
+	var n int
+	for _, item := range r.Items {
+		if item.Finding == finding {
+			n++
+		}
+	}
+	return n
+}
+
+// keySet is a set of keys by their encoding.
+type keySet map[string]bool
+
+func (s keySet) has(key *Key) bool {
+	return key != nil && s[key.Encode()]
+}
+
+func (s keySet) add(keys ...*Key) {
+	for _, k := range keys {
+		s[k.Encode()] = true
+	}
+}
+
+// Sweep finds the entities that fell out of sync: children whose parent is
+// gone, VOLFDNR references to deleted Vorlagen, contents no Anlage refers to
+// and, with opts.Sources, entities whose file is gone. The children of a
+// finding are findings as well. With opts.Repair the findings are repaired,
+// deletions in batched transactions, children before their parents, and the
+// changes published to env.Events.
+func Sweep(env *Env, opts SweepOptions) (*SweepReport, error) {
+
+	config := env.App.Config
+	repo := env.Repo
+	report := &SweepReport{}
+	found := func(finding SweepFinding, keys ...*Key) {
+		for _, k := range keys {
+			report.Items = append(report.Items, &SweepItem{Finding: finding, Key: k})
+		}
+	}
+
+	sitzungKeys, err := repo.GetAll(NewQuery(config.GetEntitySitzung()).KeysOnly(), nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "error getting sitzungen from db")
+	}
+	vorlageKeys, err := repo.GetAll(NewQuery(config.GetEntityVorlage()).KeysOnly(), nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "error getting vorlagen from db")
+	}
+	live := keySet{}
+	live.add(sitzungKeys...)
+	live.add(vorlageKeys...)
+	vorlageKey := func(volfdnr int) *Key {
+		return NameKey(config.GetEntityVorlage(), strconv.Itoa(volfdnr), nil)
+	}
+
+	var tops []*Top
+	topKeys, err := repo.GetAll(NewQuery(config.GetEntityTop()), &tops)
+	if err != nil {
+		return nil, errors.Wrap(err, "error getting tops from db")
+	}
+	for i, t := range tops {
+		switch {
+		case !live.has(topKeys[i].Parent):
+			found(FindingNoParent, topKeys[i])
+		case t.VOLFDNR > 0 && !live.has(vorlageKey(t.VOLFDNR)):
+			report.Items = append(report.Items, &SweepItem{Finding: FindingNoVorlage, Key: topKeys[i], VOLFDNR: t.VOLFDNR})
+			live.add(topKeys[i])
+		default:
+			live.add(topKeys[i])
+		}
+	}
+
+	var abstimmungen []*Abstimmung
+	abstimmungKeys, err := repo.GetAll(NewQuery(EntityAbstimmung), &abstimmungen)
+	if err != nil {
+		return nil, errors.Wrap(err, "error getting abstimmungen from db")
+	}
+	for i, a := range abstimmungen {
+		switch {
+		case !live.has(abstimmungKeys[i].Parent):
+			found(FindingNoParent, abstimmungKeys[i])
+		case a.VOLFDNR > 0 && !live.has(vorlageKey(a.VOLFDNR)):
+			report.Items = append(report.Items, &SweepItem{Finding: FindingNoVorlage, Key: abstimmungKeys[i], VOLFDNR: a.VOLFDNR})
+			live.add(abstimmungKeys[i])
+		default:
+			live.add(abstimmungKeys[i])
+		}
+	}
+
+	var anlagen []*Anlage
+	anlageKeys, err := repo.GetAll(NewQuery(config.GetEntityAnlage()), &anlagen)
+	if err != nil {
+		return nil, errors.Wrap(err, "error getting anlagen from db")
+	}
+	referenced := make(map[string]bool)
+	for i, a := range anlagen {
+		if !live.has(anlageKeys[i].Parent) {
+			found(FindingNoParent, anlageKeys[i])
+			continue
+		}
+		if a.SHA256 != "" {
+			referenced[a.SHA256] = true
+		}
+	}
+
+	votumKeys, err := repo.GetAll(NewQuery(EntityFraktionsVotum).KeysOnly(), nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "error getting fraktions voten from db")
+	}
+	for _, k := range votumKeys {
+		if !live.has(k.Parent) {
+			found(FindingNoParent, k)
+		}
+	}
+
+	contentKeys, err := repo.GetAll(NewQuery(EntityAnlageContent).KeysOnly(), nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "error getting anlage contents from db")
+	}
+	for _, k := range contentKeys {
+		if referenced[k.Name] {
+			live.add(k)
+		} else {
+			found(FindingUnreferenced, k)
+		}
+	}
+	textKeys, err := repo.GetAll(NewQuery(EntityAnlageText).KeysOnly(), nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "error getting anlage text from db")
+	}
+	for _, k := range textKeys {
+		if !live.has(k.Parent) {
+			found(FindingNoParent, k)
+		}
+	}
+
+	if opts.Sources {
+		maxShare := opts.MaxNoSource
+		if maxShare <= 0 {
+			maxShare = DefaultMaxNoSource
+		}
+		err = sweepSources(env, report, sitzungKeys, topKeys, tops, vorlageKeys, live, maxShare)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	sort.SliceStable(report.Items, func(i, j int) bool {
+		if report.Items[i].Finding != report.Items[j].Finding {
+			return report.Items[i].Finding < report.Items[j].Finding
+		}
+		return report.Items[i].Key.String() < report.Items[j].Key.String()
+	})
+	if opts.Repair {
+		err = repairSweep(env, report, opts.BatchSize)
+		if err != nil {
+			return report, err
+		}
+	}
+	return report, nil
+}
+
+// sweepSources reports the live Sitzungen, Tops and Vorlagen without a file.
+// A folder listing no file, or more files gone than maxShare of its
+// entities, is taken for a wrong or unreachable Source rather than deleted
+// files and fails the sweep, so a repair can't empty the database.
+func sweepSources(env *Env, report *SweepReport, sitzungKeys []*Key, topKeys []*Key, tops []*Top, vorlageKeys []*Key, live keySet, maxShare float64) error {
+
+	ls, ok := env.Source.(ListingSource)
+	if !ok {
+		return errors.New("source can't list files")
+	}
+	config := env.App.Config
+
+	check := func(folder string, keys []*Key, file func(i int) string) error {
+		paths, err := ls.List(folder)
+		if err != nil {
+			return errors.Wrap(err, "error listing "+folder)
+		}
+		listed := make(map[string]bool, len(paths))
+		for _, p := range paths {
+			listed[p] = true
+		}
+		var checked int
+		var items []*SweepItem
+		for i, k := range keys {
+			f := file(i)
+			if f == "" || !live.has(k) {
+				continue
+			}
+			checked++
+			if !listed[folder+f] {
+				items = append(items, &SweepItem{Finding: FindingNoSource, Key: k, File: folder + f})
+			}
+		}
+		if checked > 0 && len(paths) == 0 {
+			return errors.Errorf("%s lists no files for %d entities, refusing to sweep sources", folder, checked)
+		}
+		if float64(len(items)) > maxShare*float64(checked) {
+			return errors.Errorf("%d of %d files gone from %s, refusing to sweep sources", len(items), checked, folder)
+		}
+		report.Items = append(report.Items, items...)
+		return nil
+	}
+
+	err := check(config.GetSitzungenFolder(), sitzungKeys, func(i int) string {
+		return "sitzung-" + sitzungKeys[i].Name + ".html"
+	})
+	if err != nil {
+		return err
+	}
+	err = check(config.GetTopFolder(), topKeys, func(i int) string {
+		// only a Top synced from its own file has a hash, older ones are
+		// skipped too
+		if tops[i].ContentHash == "" {
+			return ""
+		}
+		return "sitzung-" + topKeys[i].Parent.Name + "-top-" + topKeys[i].Name + ".html"
+	})
+	if err != nil {
+		return err
+	}
+	return check(config.GetVorlagenFolder(), vorlageKeys, func(i int) string {
+		return "vorlage-" + vorlageKeys[i].Name + ".html"
+	})
+}
+
+// repairSweep syncs or clears the VOLFDNR references, deletes the entities
+// without parent or reference, deepest keys first so no child outlives its
+// parent, and deletes the entities without file with their Delete*
+// entrypoint.
+func repairSweep(env *Env, report *SweepReport, batchSize int) error {
+
+	if batchSize <= 0 {
+		batchSize = DefaultSweepBatch
+	}
+	var deletions []*Key
+	var noSource []*SweepItem
+	byVorlage := make(map[int][]*Key)
+	for _, item := range report.Items {
+		switch item.Finding {
+		case FindingNoParent:
+			deletions = append(deletions, item.Key)
+		case FindingUnreferenced:
+			textKeys, err := env.Repo.GetAll(NewQuery(EntityAnlageText).WithAncestor(item.Key).KeysOnly(), nil)
+			if err != nil {
+				return errors.Wrap(err, "error getting text of "+item.Key.Name+" from db")
+			}
+			deletions = append(deletions, textKeys...)
+			deletions = append(deletions, item.Key)
+		case FindingNoVorlage:
+			byVorlage[item.VOLFDNR] = append(byVorlage[item.VOLFDNR], item.Key)
+		case FindingNoSource:
+			noSource = append(noSource, item)
+		}
+	}
+
+	for volfdnr, keys := range byVorlage {
+		file := env.App.Config.GetVorlagenFolder() + "vorlage-" + strconv.Itoa(volfdnr) + ".html"
+		_, err := UpdateVorlage(env, file)
+		if errors.Is(err, ErrNotFound) {
+			for _, key := range keys {
+				err = clearVorlageReference(env, key)
+				if err != nil {
+					return err
+				}
+			}
+		} else if errors.Is(err, ErrStorage) {
+			return err
+		} else if err != nil {
+			slog.Error("error syncing vorlage %d: %v", volfdnr, err)
+			continue
+		}
+		report.Repaired += len(keys)
+	}
+
+	sort.SliceStable(deletions, func(i, j int) bool {
+		return keyDepth(deletions[i]) > keyDepth(deletions[j])
+	})
+	err := db.DoInBatch(batchSize, len(deletions), func(i int, j int) error {
+		batch := deletions[i:j]
+		err := RunInTransaction(env.Repo, func(tx Transaction) error {
+			return tx.DeleteMulti(batch)
+		})
+		if err != nil {
+			return errors.Wrap(err, fmt.Sprintf("error deleting %d orphans", len(batch)))
+		}
+		changes := &changeLog{}
+		changes.deleted(batch...)
+		env.publish(changes)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	report.Repaired += report.Count(FindingNoParent) + report.Count(FindingUnreferenced)
+
+	// Tops first, the delete of their Sitzung would leave nothing to do
+	config := env.App.Config
+	sort.SliceStable(noSource, func(i, j int) bool {
+		return keyDepth(noSource[i].Key) > keyDepth(noSource[j].Key)
+	})
+	for _, item := range noSource {
+		var err error
+		switch item.Key.Kind {
+		case config.GetEntityTop():
+			err = DeleteTop(env, item.File)
+		case config.GetEntitySitzung():
+			err = DeleteSitzung(env, item.File)
+		case config.GetEntityVorlage():
+			err = DeleteVorlage(env, item.File)
+		}
+		if err != nil {
+			return err
+		}
+		report.Repaired++
+	}
+	return nil
+}
+
+func keyDepth(key *Key) int {
+	var depth int
+	for k := key; k != nil; k = k.Parent {
+		depth++
+	}
+	return depth
+}
+
+// clearVorlageReference sets the VOLFDNR of a Top or Abstimmung to 0.
+func clearVorlageReference(env *Env, key *Key) error {
+
+	changes := &changeLog{}
+	err := RunInTransaction(env.Repo, func(tx Transaction) error {
+		changes.events = nil
+		var entity interface{}
+		if key.Kind == EntityAbstimmung {
+			entity = &Abstimmung{}
+		} else {
+			entity = &Top{}
+		}
+		err := tx.Get(key, entity)
+		if err != nil {
+			return err
+		}
+		switch e := entity.(type) {
+		case *Abstimmung:
+			before := *e
+			e.VOLFDNR = 0
+			changes.updated(key, &before, e)
+		case *Top:
+			before := *e
+			e.VOLFDNR = 0
+			changes.updated(key, &before, e)
+		}
+		return tx.Put(key, entity)
+	})
+	if err != nil {
+		return errors.Wrap(err, "error clearing volfdnr of "+key.String())
+	}
+	env.publish(changes)
+	return nil
+}
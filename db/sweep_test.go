@@ -0,0 +1,195 @@
+package db_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rismaster/allris-db/db"
+	"github.com/rismaster/allris-db/db/internal/dbtest"
+	"github.com/rismaster/allris-db/db/memory"
+)
+
+// txRepo counts the transactions.
+type txRepo struct {
+	db.Repository
+	transactions int
+}
+
+func (r *txRepo) NewTransaction() (db.Transaction, error) {
+	r.transactions++
+	return r.Repository.NewTransaction()
+}
+
+func findings(report *db.SweepReport, finding db.SweepFinding) []string {
+	var keys []string
+	for _, item := range report.Items {
+		if item.Finding == finding {
+			keys = append(keys, item.Key.String())
+		}
+	}
+	return keys
+}
+
+func TestSweepOrphans(t *testing.T) {
+
+	repo := &txRepo{Repository: memory.New()}
+	env, _ := dbtest.Env(t, repo)
+	top := db.NameKey("Top", "7001", db.NameKey("Sitzung", "9999", nil))
+	topAnlage := db.NameKey("Anlage", "protokoll", top)
+	vorlageAnlage := db.NameKey("Anlage", "lageplan", db.NameKey("Vorlage", "9998", nil))
+	for _, put := range []struct {
+		key *db.Key
+		src interface{}
+	}{
+		{top, &db.Top{SILFDNR: 9999, TOLFDNR: 7001}},
+		{topAnlage, &db.Anlage{SILFDNR: 9999, TOLFDNR: 7001}},
+		{vorlageAnlage, &db.Anlage{VOLFDNR: 9998}},
+	} {
+		err := repo.Put(put.key, put.src)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	report, err := db.Sweep(env, db.SweepOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := strings.Join(findings(report, db.FindingNoParent), " ")
+	want := strings.Join([]string{top.String(), topAnlage.String(), vorlageAnlage.String()}, " ")
+	if got != want || report.Repaired != 0 {
+		t.Errorf("findings %s, want %s, repaired %d", got, want, report.Repaired)
+	}
+	if n := dbtest.Count(t, repo, db.NewQuery("Anlage")); n != 2 {
+		t.Errorf("%d anlagen left by a report only sweep", n)
+	}
+
+	sink := make(db.ChannelSink, 10)
+	env.Events = sink
+	repo.transactions = 0
+	report, err = db.Sweep(env, db.SweepOptions{Repair: true, BatchSize: 2})
+	env.Events = nil
+	close(sink)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Repaired != 3 || repo.transactions != 2 {
+		t.Errorf("repaired %d in %d transactions", report.Repaired, repo.transactions)
+	}
+	for _, kind := range []string{"Top", "Anlage"} {
+		if n := dbtest.Count(t, repo, db.NewQuery(kind)); n != 0 {
+			t.Errorf("%d %s left", n, kind)
+		}
+	}
+	// the children before their parents
+	var deleted []string
+	for e := range sink {
+		if e.Type == db.EventDeleted {
+			deleted = append(deleted, e.Key)
+		}
+	}
+	if len(deleted) != 3 || deleted[0] != topAnlage.Encode() || deleted[1] != top.Encode() {
+		t.Errorf("deleted %v, the anlage before its top", deleted)
+	}
+}
+
+func TestSweepNoVorlage(t *testing.T) {
+
+	repo := memory.New()
+	env, dir := dbtest.Env(t, repo)
+	dbtest.MustSync(t, db.UpdateSitzung, env, "sitzungen/sitzung-1001.html")
+	dbtest.MustSync(t, db.UpdateSitzung, env, "sitzungen/sitzung-1002.html")
+	err := os.Remove(filepath.Join(dir, "vorlagen", "vorlage-2002.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := db.Sweep(env, db.SweepOptions{Repair: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := report.Count(db.FindingNoVorlage); n != 3 || report.Repaired != 3 {
+		t.Errorf("%d dangling VOLFDNR, %d repaired", n, report.Repaired)
+	}
+	// 2001 is synced from its file, 2002 is gone and cleared
+	if n := dbtest.Count(t, repo, db.NewQuery("Vorlage")); n != 1 {
+		t.Errorf("%d vorlagen synced", n)
+	}
+	for name, want := range map[string]int{"5101": 2001, "5102": 0} {
+		var top db.Top
+		err = repo.Get(db.NameKey("Top", name, db.NameKey("Sitzung", "1002", nil)), &top)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if top.VOLFDNR != want {
+			t.Errorf("top %s: VOLFDNR %d, want %d", name, top.VOLFDNR, want)
+		}
+	}
+
+	report, err = db.Sweep(env, db.SweepOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Items) != 0 {
+		t.Errorf("after the repair: %v", findings(report, db.FindingNoVorlage))
+	}
+}
+
+func TestSweepSources(t *testing.T) {
+
+	repo := memory.New()
+	env, dir := dbtest.Env(t, repo)
+	for folder, pages := range dbtest.Fixtures {
+		for _, page := range pages {
+			update := map[string]func(*db.Env, string) (bool, error){
+				"sitzungen/": db.UpdateSitzung, "tops/": db.UpdateTop, "vorlagen/": db.UpdateVorlage,
+			}[folder]
+			dbtest.MustSync(t, update, env, folder+page)
+		}
+	}
+	for _, file := range []string{"sitzungen/sitzung-1002.html", "tops/sitzung-1001-top-5002.html"} {
+		err := os.Remove(filepath.Join(dir, filepath.FromSlash(file)))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	// a Top synced before the content hash was stored isn't checked
+	top := db.NameKey("Top", "5002", db.NameKey("Sitzung", "1001", nil))
+	var t5002 db.Top
+	err := repo.Get(top, &t5002)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t5002.ContentHash = ""
+	err = repo.Put(top, &t5002)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// one of two Sitzungen gone is more than the default share
+	_, err = db.Sweep(env, db.SweepOptions{Sources: true, Repair: true})
+	if err == nil || !strings.Contains(err.Error(), "refusing") {
+		t.Fatalf("no refusal: %v", err)
+	}
+	if n := dbtest.Count(t, repo, db.NewQuery("Sitzung")); n != 2 {
+		t.Errorf("%d sitzungen left after the refusal", n)
+	}
+
+	report, err := db.Sweep(env, db.SweepOptions{Sources: true, Repair: true, MaxNoSource: 0.5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	items := findings(report, db.FindingNoSource)
+	if len(items) != 1 || report.Items[0].File != "sitzungen/sitzung-1002.html" {
+		t.Errorf("no source: %v", items)
+	}
+	err = repo.Get(db.NameKey("Sitzung", "1002", nil), &db.Sitzung{})
+	if err != db.ErrNoSuchEntity {
+		t.Errorf("sitzung 1002 not deleted: %v", err)
+	}
+	if n := dbtest.Count(t, repo, db.NewQuery("Top").WithAncestor(db.NameKey("Sitzung", "1001", nil))); n == 0 {
+		t.Error("tops of 1001 deleted")
+	}
+}
@@ -2,12 +2,10 @@ package db
 
 import (
 	"bytes"
-	"cloud.google.com/go/datastore"
 	"fmt"
 	"github.com/PuerkitoBio/goquery"
-	"github.com/kennygrant/sanitize"
 	"github.com/pkg/errors"
-	"github.com/rismaster/allris-common/application"
+	allris_common "github.com/rismaster/allris-common"
 	"github.com/rismaster/allris-common/common/db"
 	"github.com/rismaster/allris-common/common/files"
 	"github.com/rismaster/allris-common/common/slog"
@@ -27,62 +25,105 @@ type Termin struct {
 	SavedAt time.Time
 }
 
-func UpdateTermine(app *application.AppContext, minDate time.Time) error {
+// UpdateTermine replaces the Termine after minDate with the ones of the list
+// of all Sitzungen (si010) and publishes the changes to env.Events. Termine
+// of a Sitzung are keyed by SILFDNR, a changed Start or End is recorded as a
+// TerminMove. Errors are of type *Error.
+func UpdateTermine(env *Env, minDate time.Time) error {
+
+	app := env.App
+	repo := env.Repo
 
 	f := files.NewFileFromStore(app, "", app.Config.GetAlleSitzungenType()+".html")
-	err := f.ReadDocument(app.Config.GetBucketFetched())
+	content, err := env.Source.ReadFile(f)
 	if err != nil {
-		return errors.Wrap(err, fmt.Sprintf("error reading file %s", app.Config.GetAlleSitzungenType()))
+		return readError(f.GetPath(), errors.Wrap(err, fmt.Sprintf("error reading file %s", app.Config.GetAlleSitzungenType())))
 	}
 
-	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(f.GetContent()))
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(content))
 	if err != nil {
-		return errors.Wrap(err, fmt.Sprintf("error create dom from %s", f.GetName()))
+		return newError(ErrParse, f.GetPath(), errors.Wrap(err, fmt.Sprintf("error create dom from %s", f.GetName())))
 	}
 
-	termine, err := parseTerminList(app, doc)
+	termine, err := parseTerminList(app.Config, doc)
 	if err != nil {
-		return errors.Wrap(err, fmt.Sprintf("error parsing dom from %s", f.GetName()))
+		return newError(ErrParse, f.GetPath(), errors.Wrap(err, fmt.Sprintf("error parsing dom from %s", f.GetName())))
 	}
 
 	if len(termine) < 1 {
-		return errors.New("empty termine")
+		return newError(ErrParse, f.GetPath(), errors.New("empty termine"))
 	}
 
 	var tmap = make(map[string]bool)
-	var terminKeys []*datastore.Key
+	var terminKeys []*Key
+	var termineToSave []Termin
 	for _, termin := range termine {
-		keyName := sanitize.Path(termin.Gremium + "_" + termin.Start.Format(app.Config.GetDateFormatTech()))
-		key := datastore.NameKey(app.Config.GetEntityTermin(), keyName, nil)
+		key := termin.GetKey(app.Config)
 		exist := tmap[key.Encode()]
 		if !exist && termin.Start.After(minDate) {
 
 			tmap[key.Encode()] = true
 			terminKeys = append(terminKeys, key)
+			termineToSave = append(termineToSave, termin)
 		}
 	}
 
-	qberdel := datastore.NewQuery(app.Config.GetEntityTermin()).Filter("Start > ", minDate).KeysOnly()
+	qberdel := NewQuery(app.Config.GetEntityTermin()).Filter("Start > ", minDate)
 
-	oldKeys, err1 := app.Db().GetAll(app.Ctx(), qberdel, nil)
+	var oldTermine []Termin
+	oldKeys, err1 := repo.GetAll(qberdel, &oldTermine)
 	if err1 != nil {
-		return errors.Wrap(err1, "error getting termine from db")
+		return newError(ErrStorage, f.GetPath(), errors.Wrap(err1, "error getting termine from db"))
 	}
 
-	var kstodelete []*datastore.Key
-	for _, k := range oldKeys {
+	var kstodelete []*Key
+	oldMap := make(map[string]*Termin)
+	// Termine of a Sitzung stored under their former Gremium_Start key
+	legacy := make(map[int]*Termin)
+	for i, k := range oldKeys {
 		exist := tmap[k.Encode()]
 		if !exist {
 			kstodelete = append(kstodelete, k)
 		}
+		oldMap[k.Encode()] = &oldTermine[i]
+		if oldTermine[i].SILFDNR > 0 && k.Name != strconv.Itoa(oldTermine[i].SILFDNR) {
+			legacy[oldTermine[i].SILFDNR] = &oldTermine[i]
+		}
+	}
+
+	now := time.Now()
+	var moveKeys []*Key
+	var moves []*TerminMove
+	for i, k := range terminKeys {
+		old, exist := oldMap[k.Encode()]
+		if !exist {
+			old = legacy[termineToSave[i].SILFDNR]
+		}
+		move := newTerminMove(old, &termineToSave[i], now)
+		if move != nil {
+			moveKeys = append(moveKeys, move.GetKey(k))
+			moves = append(moves, move)
+		}
 	}
 
+	// the moves are saved before the Termine, a failed save of the Termine
+	// is retried with the old Start and End still stored, so no move is lost
+	err1 = db.DoInBatch(500, len(moveKeys), func(i int, j int) error {
+		slog.Info("save %d moved termine", j-i)
+		return repo.PutMulti(moveKeys[i:j], moves[i:j])
+	})
+	if err1 != nil {
+		return newError(ErrStorage, f.GetPath(), errors.Wrap(err1, "error save moves of termine to db"))
+	}
+
+	// the moves of deleted termine are kept, the history of a Termin that
+	// dropped out of the list stays queryable by MovedTermine
 	err1 = db.DoInBatch(500, len(kstodelete), func(i int, j int) error {
 		slog.Info("delete %d termine", j-i)
-		return app.Db().DeleteMulti(app.Ctx(), kstodelete[i:j])
+		return repo.DeleteMulti(kstodelete[i:j])
 	})
 	if err1 != nil {
-		return errors.Wrap(err1, "error delete old termine from db")
+		return newError(ErrStorage, f.GetPath(), errors.Wrap(err1, "error delete old termine from db"))
 	}
 
 	err1 = db.DoInBatch(500, len(terminKeys), func(i int, j int) error {
@@ -90,25 +131,38 @@ func UpdateTermine(app *application.AppContext, minDate time.Time) error {
 			slog.Info(tk.Name)
 		}
 		slog.Info("save %d termine", j-i)
-		_, err2 := app.Db().PutMulti(app.Ctx(), terminKeys[i:j], termine[i:j])
-		return err2
+		return repo.PutMulti(terminKeys[i:j], termineToSave[i:j])
 	})
 	if err1 != nil {
+		return newError(ErrStorage, f.GetPath(), errors.Wrap(err1, "error save termine to db"))
+	}
 
-		slog.Fatal("err: %+v", err)
+	changes := &changeLog{}
+	changes.deleted(kstodelete...)
+	for i, k := range terminKeys {
+		old, exist := oldMap[k.Encode()]
+		if exist {
+			changes.updated(k, old, &termineToSave[i])
+		} else {
+			changes.created(k)
+		}
+	}
+	for _, k := range moveKeys {
+		changes.created(k)
 	}
+	env.publish(changes)
 
 	return nil
 }
 
-func parseTerminList(app *application.AppContext, doc *goquery.Document) (termine []Termin, err error) {
+func parseTerminList(config allris_common.Config, doc *goquery.Document) (termine []Termin, err error) {
 
 	selector := "tr.zl11,tr.zl12"
 	doc.Find(selector).Each(func(index int, selection *goquery.Selection) {
 
 		if selection.Children().Size() >= 8 {
 
-			sitzung, lastErr := parseTermin(app, selection)
+			sitzung, lastErr := parseTermin(config, selection)
 			if lastErr == nil {
 				termine = append(termine, *sitzung)
 			} else {
@@ -119,7 +173,7 @@ func parseTerminList(app *application.AppContext, doc *goquery.Document) (termin
 	return termine, err
 }
 
-func parseTermin(app *application.AppContext, e *goquery.Selection) (*Termin, error) {
+func parseTermin(config allris_common.Config, e *goquery.Selection) (*Termin, error) {
 
 	lnkTr := e.Find(":nth-child(2) a")
 	lnk, _ := lnkTr.Attr("href")
@@ -134,8 +188,8 @@ func parseTermin(app *application.AppContext, e *goquery.Selection) (*Termin, er
 	timeTr := strings.Split(strings.TrimSpace(e.Find(":nth-child(7)").Text()), " - ")
 	dateTimetxt := fmt.Sprintf("%s %s:00", dateText, timeTr[0])
 
-	localTz, _ := time.LoadLocation(app.Config.GetTimezone())
-	startTime, err := time.ParseInLocation(app.Config.GetDateFormatWithTime(), dateTimetxt, localTz)
+	localTz, _ := time.LoadLocation(config.GetTimezone())
+	startTime, err := time.ParseInLocation(config.GetDateFormatWithTime(), dateTimetxt, localTz)
 	if err != nil {
 		return nil, err
 	}
@@ -144,7 +198,7 @@ func parseTermin(app *application.AppContext, e *goquery.Selection) (*Termin, er
 	if len(timeTr) > 1 && len(timeTr[1]) > 0 {
 
 		endDateTxt := fmt.Sprintf("%s %s:00", dateText, timeTr[1])
-		endTime, err = time.ParseInLocation(app.Config.GetDateFormatWithTime(), endDateTxt, localTz)
+		endTime, err = time.ParseInLocation(config.GetDateFormatWithTime(), endDateTxt, localTz)
 		if err != nil {
 			return nil, err
 		}
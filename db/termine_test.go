@@ -0,0 +1,187 @@
+package db_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rismaster/allris-db/db"
+	"github.com/rismaster/allris-db/db/golden"
+	"github.com/rismaster/allris-db/db/memory"
+)
+
+var berlin, _ = time.LoadLocation("Europe/Berlin")
+
+// termineEnv returns an Env reading the si010 fixture from a temporary
+// directory.
+func termineEnv(t *testing.T, repo db.Repository) (*db.Env, string) {
+	dir := t.TempDir()
+	writeSi010(t, dir)
+	return db.NewLocalEnv(golden.FixtureConfig{}, repo, dir), dir
+}
+
+// writeSi010 writes the si010 fixture edited by the pairs of old and new
+// strings to dir.
+func writeSi010(t *testing.T, dir string, replacements ...string) {
+	t.Helper()
+	content, err := ioutil.ReadFile(filepath.Join("golden", "testdata", "si010.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i+1 < len(replacements); i += 2 {
+		content = bytes.ReplaceAll(content, []byte(replacements[i]), []byte(replacements[i+1]))
+	}
+	err = ioutil.WriteFile(filepath.Join(dir, "si010.html"), content, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+var minDate = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func updateTermine(t *testing.T, env *db.Env) {
+	t.Helper()
+	err := db.UpdateTermine(env, minDate)
+	if err != nil {
+		t.Fatalf("update termine: %+v", err)
+	}
+}
+
+func TestTermineKeyedBySILFDNR(t *testing.T) {
+
+	repo := memory.New()
+	env, _ := termineEnv(t, repo)
+	updateTermine(t, env)
+
+	var termine []*db.Termin
+	keys, err := repo.GetAll(db.NewQuery("Termin"), &termine)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := make(map[string]*db.Termin)
+	for i, k := range keys {
+		names[k.Name] = termine[i]
+	}
+	if len(names) != 3 || names["1001"] == nil || names["1002"] == nil {
+		t.Fatalf("termine %v", keys)
+	}
+	ortsbeirat := &db.Termin{Gremium: "Ortsbeirat Nord", Start: time.Date(2024, 5, 6, 19, 0, 0, 0, berlin)}
+	if names[ortsbeirat.GetKey(golden.FixtureConfig{}).Name] == nil {
+		t.Errorf("termin without sitzung not keyed by Gremium_Start: %v", keys)
+	}
+	if !names["1001"].End.Equal(time.Date(2024, 3, 14, 20, 30, 0, 0, berlin)) {
+		t.Errorf("end %s", names["1001"].End)
+	}
+}
+
+func TestTermineMigratesLegacyKeys(t *testing.T) {
+
+	repo := memory.New()
+	// stored under the former Gremium_Start key, a week before
+	legacy := &db.Termin{Gremium: "Ausschuss für Umwelt und Verkehr", Start: time.Date(2024, 3, 7, 18, 0, 0, 0, berlin)}
+	legacy.End = legacy.Start
+	legacyKey := legacy.GetKey(golden.FixtureConfig{})
+	legacy.SILFDNR = 1001
+	err := repo.Put(legacyKey, legacy)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	env, _ := termineEnv(t, repo)
+	updateTermine(t, env)
+
+	err = repo.Get(legacyKey, &db.Termin{})
+	if err != db.ErrNoSuchEntity {
+		t.Errorf("legacy key kept: %v", err)
+	}
+	err = repo.Get(db.NameKey("Termin", "1001", nil), &db.Termin{})
+	if err != nil {
+		t.Errorf("termin under SILFDNR: %v", err)
+	}
+	moves, err := db.ListTerminMoves(repo, golden.FixtureConfig{}, 1001)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(moves) != 1 || !moves[0].OldStart.Equal(legacy.Start) {
+		t.Errorf("move from the legacy termin: %+v", moves)
+	}
+}
+
+func TestTermineRecordsMoves(t *testing.T) {
+
+	repo := memory.New()
+	env, dir := termineEnv(t, repo)
+	updateTermine(t, env)
+
+	// 1001 is postponed a week
+	writeSi010(t, dir, "14.03.2024", "21.03.2024")
+	events := make(db.ChannelSink, 100)
+	env.Events = events
+	updateTermine(t, env)
+
+	moves, err := db.ListTerminMoves(repo, golden.FixtureConfig{}, 1001)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(moves) != 1 {
+		t.Fatalf("%d moves, want 1", len(moves))
+	}
+	m := moves[0]
+	if !m.OldStart.Equal(time.Date(2024, 3, 14, 18, 0, 0, 0, berlin)) || !m.NewStart.Equal(time.Date(2024, 3, 21, 18, 0, 0, 0, berlin)) {
+		t.Errorf("move %s -> %s", m.OldStart, m.NewStart)
+	}
+	if n, _ := db.ListTerminMoves(repo, golden.FixtureConfig{}, 1002); len(n) != 0 {
+		t.Errorf("unchanged termin moved: %+v", n)
+	}
+
+	close(events)
+	var moveEvents int
+	for e := range events {
+		if e.Kind == db.EntityTerminMove && e.Type == db.EventCreated {
+			moveEvents++
+		}
+	}
+	if moveEvents != 1 {
+		t.Errorf("%d created events of moves, want 1", moveEvents)
+	}
+
+	// the moves outlive a Termin dropping out of the list
+	env.Events = nil
+	writeSi010(t, dir, "to010.asp?SILFDNR=1001", "to010.asp?SILFDNR=1003")
+	updateTermine(t, env)
+	if moves, _ := db.ListTerminMoves(repo, golden.FixtureConfig{}, 1001); len(moves) != 1 {
+		t.Errorf("%d moves of the dropped termin, want 1", len(moves))
+	}
+}
+
+func TestMovedTermine(t *testing.T) {
+
+	repo := memory.New()
+	env, dir := termineEnv(t, repo)
+	updateTermine(t, env)
+	writeSi010(t, dir, "14.03.2024", "21.03.2024")
+	updateTermine(t, env)
+
+	day := func(d int) time.Time {
+		return time.Date(2024, 3, d, 0, 0, 0, 0, berlin)
+	}
+	for _, c := range []struct {
+		from, to int
+		want     int
+	}{
+		{14, 15, 1}, // planned before the move
+		{21, 22, 1}, // planned after the move
+		{14, 22, 1}, // both, listed once
+		{15, 21, 0},
+	} {
+		moves, err := db.MovedTermine(repo, day(c.from), day(c.to))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(moves) != c.want {
+			t.Errorf("[%d, %d): %d moves, want %d", c.from, c.to, len(moves), c.want)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package db
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/kennygrant/sanitize"
+	"github.com/pkg/errors"
+	allris_common "github.com/rismaster/allris-common"
+)
+
+// GetKey is the SILFDNR for a Termin of a Sitzung, so a rescheduled Termin
+// keeps its key, and Gremium_Start for the others.
+func (t *Termin) GetKey(config allris_common.Config) *Key {
+	if t.SILFDNR > 0 {
+		return NameKey(config.GetEntityTermin(), strconv.Itoa(t.SILFDNR), nil)
+	}
+	keyName := sanitize.Path(t.Gremium + "_" + t.Start.Format(config.GetDateFormatTech()))
+	return NameKey(config.GetEntityTermin(), keyName, nil)
+}
+
+// TerminMove records one rescheduling of a Termin, the prior and the new
+// Start and End.
+type TerminMove struct {
+	SILFDNR  int
+	Gremium  string
+	OldStart time.Time
+	OldEnd   time.Time
+	NewStart time.Time
+	NewEnd   time.Time
+	MovedAt  time.Time
+}
+
+func (m *TerminMove) GetKey(terminKey *Key) *Key {
+	return NameKey(EntityTerminMove, strconv.FormatInt(m.MovedAt.UnixNano(), 10), terminKey)
+}
+
+// newTerminMove returns the move from old to t, nil if Start and End are
+// unchanged or the Termin has no Sitzung to identify it.
+func newTerminMove(old *Termin, t *Termin, movedAt time.Time) *TerminMove {
+	if old == nil || t.SILFDNR == 0 || (old.Start.Equal(t.Start) && old.End.Equal(t.End)) {
+		return nil
+	}
+	return &TerminMove{
+		SILFDNR:  t.SILFDNR,
+		Gremium:  t.Gremium,
+		OldStart: old.Start,
+		OldEnd:   old.End,
+		NewStart: t.Start,
+		NewEnd:   t.End,
+		MovedAt:  movedAt,
+	}
+}
+
+// ListTerminMoves returns the moves of the Termin of a Sitzung, oldest first.
+func ListTerminMoves(repo Repository, config allris_common.Config, silfdnr int) ([]*TerminMove, error) {
+
+	t := &Termin{SILFDNR: silfdnr}
+	var moves []*TerminMove
+	_, err := repo.GetAll(NewQuery(EntityTerminMove).WithAncestor(t.GetKey(config)), &moves)
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("error getting moves of termin %d from db", silfdnr))
+	}
+	sort.SliceStable(moves, func(i, j int) bool {
+		return moves[i].MovedAt.Before(moves[j].MovedAt)
+	})
+	return moves, nil
+}
+
+// MovedTermine returns the moves of the meetings that were planned or are now
+// planned in [from, to), ordered by the new Start.
+func MovedTermine(repo Repository, from time.Time, to time.Time) ([]*TerminMove, error) {
+
+	seen := make(map[string]bool)
+	var moves []*TerminMove
+	for _, field := range []string{"OldStart", "NewStart"} {
+		var found []*TerminMove
+		q := NewQuery(EntityTerminMove).Filter(field+" >=", from).Filter(field+" <", to)
+		keys, err := repo.GetAll(q, &found)
+		if err != nil {
+			return nil, errors.Wrap(err, "error getting moved termine from db")
+		}
+		for i, m := range found {
+			if !seen[keys[i].Encode()] {
+				seen[keys[i].Encode()] = true
+				moves = append(moves, m)
+			}
+		}
+	}
+	sort.SliceStable(moves, func(i, j int) bool {
+		if moves[i].NewStart.Equal(moves[j].NewStart) {
+			return moves[i].MovedAt.Before(moves[j].MovedAt)
+		}
+		return moves[i].NewStart.Before(moves[j].NewStart)
+	})
+	return moves, nil
+}
@@ -0,0 +1,151 @@
+package db
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rismaster/allris-common/common/db"
+	"github.com/rismaster/allris-common/common/slog"
+	"github.com/rismaster/allris-db/db/internal/pdftext"
+)
+
+// AnlageText is the plain text of one page of a PDF, Page counts from 1.
+type AnlageText struct {
+	SHA256 string
+	Page   int
+	Text   string `datastore:",noindex"`
+}
+
+func AnlageTextKey(sha256 string, page int) *Key {
+	return NameKey(EntityAnlageText, strconv.Itoa(page), AnlageContentKey(sha256))
+}
+
+func isPDF(mimeType string) bool {
+	return strings.HasPrefix(mimeType, "application/pdf")
+}
+
+// UpdateAnlageText records the content of an Anlage file like
+// UpdateAnlageContent and extracts the text of its pages if it is a PDF.
+// The text is extracted once per content, extracted reports if it was
+// extracted in this call. A PDF without extractable text, malformed or a
+// scan whose pages are all empty, is an ErrParse, its TextError is kept so
+// it isn't retried until env.Force. Errors are of type *Error.
+func UpdateAnlageText(env *Env, filepath string) (extracted bool, err error) {
+
+	af, _, err := updateAnlageContent(env, filepath)
+	if err != nil {
+		return false, err
+	}
+	a := af.anlage
+	if !isPDF(a.MimeType) {
+		return false, nil
+	}
+
+	contentKey := AnlageContentKey(a.SHA256)
+	var c AnlageContent
+	err = env.Repo.Get(contentKey, &c)
+	if err != nil {
+		return false, newError(ErrStorage, filepath, errors.Wrap(err, "error getting content "+a.SHA256+" from db"))
+	}
+	if !env.Force && !c.TextExtractedAt.IsZero() {
+		slog.Debug("text of %s already extracted", a.SHA256)
+		return false, nil
+	}
+
+	content := af.content
+	if content == nil {
+		content, err = env.Source.ReadFile(af.file)
+		if err != nil {
+			return false, readError(af.file.GetPath(), errors.Wrap(err, fmt.Sprintf("error reading file %s", af.file.GetName())))
+		}
+		if HashContent(content) != a.SHA256 {
+			// changed after its generation was recorded, pick it up next sync
+			return false, nil
+		}
+	}
+
+	pages, extractErr := pdftext.Pages(content)
+	if extractErr == nil && !hasText(pages) {
+		extractErr = errors.New("no extractable text, e.g. a scan")
+	}
+	c.Pages = len(pages)
+	c.TextExtractedAt = time.Now()
+	c.TextError = ""
+	if extractErr != nil {
+		c.TextError = extractErr.Error()
+	}
+
+	oldKeys, err := env.Repo.GetAll(NewQuery(EntityAnlageText).WithAncestor(contentKey).KeysOnly(), nil)
+	if err != nil {
+		return false, newError(ErrStorage, filepath, errors.Wrap(err, "error getting text of "+a.SHA256+" from db"))
+	}
+	var stale []*Key
+	for _, k := range oldKeys {
+		if n, _ := strconv.Atoi(k.Name); n > len(pages) {
+			stale = append(stale, k)
+		}
+	}
+
+	// the pages may be more than a transaction takes, they are written in
+	// batches and the content last, so an interrupted write has no
+	// TextExtractedAt and is retried
+	err = db.DoInBatch(500, len(stale), func(i int, j int) error {
+		return env.Repo.DeleteMulti(stale[i:j])
+	})
+	if err != nil {
+		return false, newError(ErrStorage, filepath, errors.Wrap(err, "error deleting text of "+a.SHA256))
+	}
+	textKeys := make([]*Key, len(pages))
+	texts := make([]*AnlageText, len(pages))
+	for i, text := range pages {
+		textKeys[i] = AnlageTextKey(a.SHA256, i+1)
+		texts[i] = &AnlageText{SHA256: a.SHA256, Page: i + 1, Text: text}
+	}
+	err = db.DoInBatch(500, len(textKeys), func(i int, j int) error {
+		return env.Repo.PutMulti(textKeys[i:j], texts[i:j])
+	})
+	if err != nil {
+		return false, newError(ErrStorage, filepath, errors.Wrap(err, "error saving text of "+a.SHA256))
+	}
+	err = env.Repo.Put(contentKey, &c)
+	if err != nil {
+		return false, newError(ErrStorage, filepath, errors.Wrap(err, "error saving content "+a.SHA256))
+	}
+
+	changes := &changeLog{}
+	changes.deleted(stale...)
+	changes.add(EventUpdated, contentKey, []string{"Pages", "TextExtractedAt", "TextError"})
+	env.publish(changes)
+
+	if extractErr != nil {
+		return false, newError(ErrParse, filepath, extractErr)
+	}
+	return true, nil
+}
+
+func hasText(pages []string) bool {
+	for _, p := range pages {
+		if strings.TrimSpace(p) != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// ListAnlageText returns the pages of a content, the first page first.
+func ListAnlageText(repo Repository, sha256 string) ([]*AnlageText, error) {
+
+	var pages []*AnlageText
+	_, err := repo.GetAll(NewQuery(EntityAnlageText).WithAncestor(AnlageContentKey(sha256)), &pages)
+	if err != nil {
+		return nil, errors.Wrap(err, "error getting text of "+sha256+" from db")
+	}
+	sort.SliceStable(pages, func(i, j int) bool {
+		return pages[i].Page < pages[j].Page
+	})
+	return pages, nil
+}
@@ -0,0 +1,167 @@
+package db_test
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/rismaster/allris-db/db"
+	"github.com/rismaster/allris-db/db/golden"
+	"github.com/rismaster/allris-db/db/memory"
+)
+
+const lageplanFile = "anlagen/vorlage-2001-anlage-1-lageplan.pdf"
+
+// countingRepo records the sizes of the PutMulti calls.
+type countingRepo struct {
+	db.Repository
+	putMulti []int
+}
+
+func (r *countingRepo) PutMulti(keys []*db.Key, src interface{}) error {
+	r.putMulti = append(r.putMulti, len(keys))
+	return r.Repository.PutMulti(keys, src)
+}
+
+// newPDF returns a PDF with one line of text per page.
+func newPDF(texts []string) []byte {
+	objects := []string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"",
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>",
+	}
+	var kids []string
+	for i, text := range texts {
+		kids = append(kids, fmt.Sprintf("%d 0 R", 4+2*i))
+		stream := fmt.Sprintf("BT /F1 12 Tf 72 770 Td (%s) Tj ET", text)
+		objects = append(objects,
+			fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 595 842] /Resources << /Font << /F1 3 0 R >> >> /Contents %d 0 R >>", 5+2*i),
+			fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", len(stream), stream))
+	}
+	objects[1] = fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(texts))
+
+	var buf bytes.Buffer
+	buf.WriteString("%PDF-1.4\n")
+	var offsets []int
+	for i, o := range objects {
+		offsets = append(offsets, buf.Len())
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", i+1, o)
+	}
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, off := range offsets {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", off)
+	}
+	fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+	return buf.Bytes()
+}
+
+// anlageEnv stores the Anlage "Lageplan" of the Vorlage 2001 and writes its
+// file with content.
+func anlageEnv(t *testing.T, repo db.Repository, content []byte) *db.Env {
+	dir := t.TempDir()
+	err := repo.Put(db.NameKey("Anlage", "lageplan", db.NameKey("Vorlage", "2001", nil)), &db.Anlage{VOLFDNR: 2001, Title: "Lageplan"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.MkdirAll(filepath.Join(dir, "anlagen"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(filepath.Join(dir, filepath.FromSlash(lageplanFile)), content, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db.NewLocalEnv(golden.FixtureConfig{}, repo, dir)
+}
+
+func storedText(t *testing.T, repo db.Repository) []string {
+	t.Helper()
+	var a db.Anlage
+	err := repo.Get(db.NameKey("Anlage", "lageplan", db.NameKey("Vorlage", "2001", nil)), &a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pages, err := db.ListAnlageText(repo, a.SHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var texts []string
+	for _, p := range pages {
+		texts = append(texts, p.Text)
+	}
+	return texts
+}
+
+func TestUpdateAnlageText(t *testing.T) {
+
+	content, err := ioutil.ReadFile(filepath.Join("internal", "pdftext", "testdata", "zwei-seiten.pdf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo := memory.New()
+	env := anlageEnv(t, repo, content)
+
+	extracted, err := db.UpdateAnlageText(env, lageplanFile)
+	if err != nil || !extracted {
+		t.Fatalf("first run: %v, %+v", extracted, err)
+	}
+	if got := strings.Join(storedText(t, repo), "|"); got != "Lageplan Marktplatz|Seite zwei" {
+		t.Errorf("text %q", got)
+	}
+
+	extracted, err = db.UpdateAnlageText(env, lageplanFile)
+	if err != nil || extracted {
+		t.Errorf("second run: %v, %+v", extracted, err)
+	}
+
+	env.Force = true
+	extracted, err = db.UpdateAnlageText(env, lageplanFile)
+	if err != nil || !extracted {
+		t.Errorf("forced run: %v, %+v", extracted, err)
+	}
+}
+
+func TestUpdateAnlageTextBatches(t *testing.T) {
+
+	texts := make([]string, 501)
+	for i := range texts {
+		texts[i] = "Seite " + strconv.Itoa(i+1)
+	}
+	repo := &countingRepo{Repository: memory.New()}
+	env := anlageEnv(t, repo, newPDF(texts))
+
+	extracted, err := db.UpdateAnlageText(env, lageplanFile)
+	if err != nil || !extracted {
+		t.Fatalf("%v, %+v", extracted, err)
+	}
+	if fmt.Sprint(repo.putMulti) != "[500 1]" {
+		t.Errorf("batches %v", repo.putMulti)
+	}
+	stored := storedText(t, repo)
+	if len(stored) != 501 || stored[0] != "Seite 1" || stored[500] != "Seite 501" {
+		t.Errorf("%d pages stored", len(stored))
+	}
+}
+
+func TestUpdateAnlageTextMalformed(t *testing.T) {
+
+	content := bytes.Replace(newPDF([]string{"Seite 1"}), []byte("/Contents 5 0 R"), []byte("/Contents 3 0 R"), 1)
+	repo := memory.New()
+	env := anlageEnv(t, repo, content)
+
+	extracted, err := db.UpdateAnlageText(env, lageplanFile)
+	if e, ok := err.(*db.Error); !ok || e.Kind != db.ErrParse || extracted {
+		t.Fatalf("%v, %+v", extracted, err)
+	}
+	// the error is kept, the file isn't tried again
+	extracted, err = db.UpdateAnlageText(env, lageplanFile)
+	if err != nil || extracted {
+		t.Errorf("second run: %v, %+v", extracted, err)
+	}
+}
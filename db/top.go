@@ -1,10 +1,10 @@
 package db
 
 import (
-	"cloud.google.com/go/datastore"
 	"fmt"
 	"github.com/PuerkitoBio/goquery"
 	"github.com/pkg/errors"
+	allris_common "github.com/rismaster/allris-common"
 	"github.com/rismaster/allris-common/application"
 	"github.com/rismaster/allris-common/common/domtools"
 	"github.com/rismaster/allris-common/common/files"
@@ -22,6 +22,9 @@ type Top struct {
 
 	SavedAt time.Time
 
+	ContentHash      string `datastore:",noindex"`
+	SourceGeneration string `datastore:",noindex"`
+
 	Betreff       string `datastore:",noindex"`
 	Beschluss     string
 	Protokoll     string `datastore:",noindex"`
@@ -44,9 +47,10 @@ type Top struct {
 	BSVV            string
 	Beschlussstatus string
 
-	file    *files.File
-	app     *application.AppContext
-	anlagen []*Anlage
+	file       *files.File
+	config     allris_common.Config
+	anlagen    []*Anlage
+	abstimmung *Abstimmung
 }
 
 func NewTop(app *application.AppContext, file *files.File) (*Top, error) {
@@ -68,7 +72,7 @@ func NewTop(app *application.AppContext, file *files.File) (*Top, error) {
 			SILFDNR: silfdnr,
 			TOLFDNR: tolfdnr,
 			file:    file,
-			app:     app,
+			config:  app.Config,
 		}, nil
 	}
 
@@ -76,16 +80,16 @@ func NewTop(app *application.AppContext, file *files.File) (*Top, error) {
 
 }
 
-func (t *Top) GetDirectAnlagenQuery() *datastore.Query {
-	return datastore.NewQuery(t.app.Config.GetEntityAnlage()).Ancestor(t.GetKey())
+func (t *Top) GetDirectAnlagenQuery() *Query {
+	return NewQuery(t.config.GetEntityAnlage()).WithAncestor(t.GetKey())
 }
 
-func (t *Top) GetSitzungKey() *datastore.Key {
-	return datastore.NameKey(t.app.Config.GetEntitySitzung(), fmt.Sprintf("%d", t.SILFDNR), nil)
+func (t *Top) GetSitzungKey() *Key {
+	return NameKey(t.config.GetEntitySitzung(), fmt.Sprintf("%d", t.SILFDNR), nil)
 }
 
-func (t *Top) GetKey() *datastore.Key {
-	return datastore.NameKey(t.app.Config.GetEntityTop(), fmt.Sprintf("%d", t.TOLFDNR), t.GetSitzungKey())
+func (t *Top) GetKey() *Key {
+	return NameKey(t.config.GetEntityTop(), fmt.Sprintf("%d", t.TOLFDNR), t.GetSitzungKey())
 }
 
 func (t *Top) GetFile() *files.File {
@@ -96,6 +100,19 @@ func (t *Top) SetSavedAt(ti time.Time) {
 	t.SavedAt = ti
 }
 
+func (t *Top) GetContentHash() string {
+	return t.ContentHash
+}
+
+func (t *Top) GetSourceGeneration() string {
+	return t.SourceGeneration
+}
+
+func (t *Top) SetSourceVersion(hash string, generation string) {
+	t.ContentHash = hash
+	t.SourceGeneration = generation
+}
+
 func (t *Top) GetTops() []*Top {
 	return []*Top{}
 }
@@ -104,7 +121,7 @@ func (t *Top) GetAnlagen() []*Anlage {
 	return t.anlagen
 }
 
-func (t *Top) GetTopQuery() *datastore.Query {
+func (t *Top) GetTopQuery() *Query {
 	return nil
 }
 
@@ -120,7 +137,7 @@ func (t *Top) Parse(doc *goquery.Document) error {
 
 func (t *Top) parseElement(dom *goquery.Selection) error {
 
-	t.anlagen = ExtractAnlagen(dom, t.app.Config)
+	t.anlagen = ExtractAnlagen(dom, t.config)
 
 	for _, a := range t.anlagen {
 		a.SILFDNR = t.SILFDNR
@@ -131,23 +148,24 @@ func (t *Top) parseElement(dom *goquery.Selection) error {
 
 	allrisBS, _ := dom.Find("a[name=\"allrisBS\"]").
 		NextFilteredUntil("div", "a").Html()
-	t.Beschluss = domtools.SanatizeHtml(allrisBS, t.app.Config)
+	t.Beschluss = domtools.SanatizeHtml(allrisBS, t.config)
 
 	allrisWP, _ := dom.Find("a[name=\"allrisWP\"]").
 		NextFilteredUntil("div", "a").Html()
-	t.Protokoll = domtools.SanatizeHtml(allrisWP, t.app.Config)
+	t.Protokoll = domtools.SanatizeHtml(allrisWP, t.config)
 
 	allrisRE, _ := dom.Find("a[name=\"allrisRE\"]").
 		NextFilteredUntil("div", "a").Html()
-	t.ProtokollRe = domtools.SanatizeHtml(allrisRE, t.app.Config)
+	t.ProtokollRe = domtools.SanatizeHtml(allrisRE, t.config)
 
-	t.parseAbstimmungsErgebnis(dom.Find("a[name=\"allrisAE\"]").
-		NextFilteredUntil("div", "a"))
+	allrisAE := dom.Find("a[name=\"allrisAE\"]").NextFilteredUntil("div", "a")
+	t.parseAbstimmungsErgebnis(allrisAE)
 
 	bez, cont := domtools.ParseTable(dom.Find("table.tk1").Find("tr > td.kb1"))
 	t.Nr = domtools.FindIndex(bez, cont, "TOP:")
 	t.Beschlussart = domtools.FindIndex(bez, cont, "Beschlussart:")
 	t.Status = domtools.FindIndexI(bez, cont, "Status:", 2)
+	t.abstimmung = ParseAbstimmung(allrisAE, t.Beschlussart)
 
 	t.Gremium = domtools.FindIndex(bez, cont, "Gremium:")
 	if t.Gremium == "" {
@@ -158,13 +176,16 @@ func (t *Top) parseElement(dom *goquery.Selection) error {
 	t.VOLFDNR = domtools.ExtractIntFromInput(dom, "VOLFDNR")
 
 	datumString := domtools.FindIndex(bez, cont, "Datum:")
-	datum, err2 := domtools.ExtractWeekdayDateFromCommaSeparated(datumString, "00:00", t.app.Config)
+	datum, err2 := domtools.ExtractWeekdayDateFromCommaSeparated(datumString, "00:00", t.config)
 	if err2 != nil {
 		return err2
 	} else {
 		t.Datum = datum
 	}
 
+	if t.abstimmung != nil {
+		t.abstimmung.setTop(t)
+	}
 	return nil
 }
 
@@ -198,59 +219,73 @@ func (t *Top) UpdateAnlage(oldAnlage *Anlage, newAnlage *Anlage) *Anlage {
 func (t *Top) UpdateTop(oldTop *Top, newTop *Top) *Top {
 	return oldTop
 }
-func (t *Top) SaveOrUpdate() error {
-	tx, err := t.app.Db().NewTransaction(t.app.Ctx())
-	if err != nil {
-		return errors.Wrap(err, "client.NewTransaction")
-	}
+func (t *Top) SaveOrUpdate(repo Repository) error {
+	return RunInTransaction(repo, func(tx Transaction) error {
+
+		var oldTop Top
+		err := tx.Get(t.GetKey(), &oldTop)
+		if err != nil && err != ErrNoSuchEntity {
+			return err
+		} else if err == nil {
+			//update
+			//uebernehmen von uebersicht
+
+			t.BSVV = oldTop.BSVV
+			t.Typ = oldTop.Typ
+			t.IndexTop = oldTop.IndexTop
+			t.IndexBeratung = oldTop.IndexBeratung
+			t.Beschlussstatus = oldTop.Beschlussstatus
+			t.SavedAt = time.Now()
+		}
 
-	var oldTop Top
-	err = tx.Get(t.GetKey(), &oldTop)
-	if err != nil && err != datastore.ErrNoSuchEntity {
-		return err
-	} else if err == nil {
-		//update
-		//uebernehmen von uebersicht
-
-		t.BSVV = oldTop.BSVV
-		t.Typ = oldTop.Typ
-		t.IndexTop = oldTop.IndexTop
-		t.IndexBeratung = oldTop.IndexBeratung
-		t.Beschlussstatus = oldTop.Beschlussstatus
-		t.SavedAt = time.Now()
-	}
+		err = tx.Put(t.GetKey(), t)
+		if err != nil {
+			return errors.Wrap(err, fmt.Sprintf("error saving to db top from %s", t.file.GetName()))
+		}
+		return nil
+	})
+}
 
-	_, err = tx.Put(t.GetKey(), t)
-	if err != nil {
-		return errors.Wrap(err, fmt.Sprintf("error saving to db top from %s", t.file.GetName()))
-	}
-	_, err = tx.Commit()
-	return err
+func (t *Top) Delete(repo Repository) error {
+	return t.delete(repo, nil)
 }
 
-func (t *Top) Delete() error {
+func (t *Top) delete(repo Repository, changes *changeLog) error {
 
-	ks, err := t.app.Db().GetAll(t.app.Ctx(), datastore.NewQuery(t.app.Config.GetEntityAnlage()).Ancestor(t.GetKey()).KeysOnly(), nil)
+	ks, err := repo.GetAll(t.GetDirectAnlagenQuery().KeysOnly(), nil)
 	if err != nil {
 		return errors.Wrap(err, "error getting anlagen from db")
 	}
 
-	tx, err := t.app.Db().NewTransaction(t.app.Ctx())
+	aks, err := abstimmungKeys(repo, t.GetKey())
 	if err != nil {
-		return errors.Wrap(err, "client.NewTransaction")
+		return err
 	}
 
-	err = tx.DeleteMulti(ks)
-	if err != nil {
-		slog.Error("error delete anlagen of sitzung in db for %t: %v", t.file.GetName(), err)
-	}
+	return RunInTransaction(repo, func(tx Transaction) error {
 
-	err = tx.Delete(t.GetKey())
-	if err != nil {
-		slog.Error("error delete sitzung in db for %t: %v", t.file.GetName(), err)
-	}
+		exists := tx.Get(t.GetKey(), &Top{}) == nil
 
-	_, err = tx.Commit()
+		err := tx.DeleteMulti(ks)
+		if err != nil {
+			slog.Error("error delete anlagen of top in db for %s: %v", t.file.GetName(), err)
+		} else {
+			changes.deleted(ks...)
+		}
 
-	return err
+		err = tx.DeleteMulti(aks)
+		if err != nil {
+			slog.Error("error delete abstimmung of top in db for %s: %v", t.file.GetName(), err)
+		} else {
+			changes.deleted(aks...)
+		}
+
+		err = tx.Delete(t.GetKey())
+		if err != nil {
+			slog.Error("error delete top in db for %s: %v", t.file.GetName(), err)
+		} else if exists {
+			changes.deleted(t.GetKey())
+		}
+		return nil
+	})
 }
@@ -2,13 +2,14 @@ package db
 
 import (
 	"bytes"
-	"cloud.google.com/go/datastore"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"github.com/PuerkitoBio/goquery"
 	"github.com/pkg/errors"
-	"github.com/rismaster/allris-common/application"
 	"github.com/rismaster/allris-common/common/files"
 	"github.com/rismaster/allris-common/common/slog"
+	"reflect"
 	"time"
 )
 
@@ -16,106 +17,216 @@ type TopHolder interface {
 	GetFile() *files.File
 	Parse(doc *goquery.Document) error
 	SetSavedAt(time time.Time)
+	GetContentHash() string
+	GetSourceGeneration() string
+	SetSourceVersion(hash string, generation string)
 	GetTops() []*Top
 	GetAnlagen() []*Anlage
-	GetKey() *datastore.Key
+	GetKey() *Key
 	UpdateTop(*Top, *Top) *Top
 	UpdateAnlage(*Anlage, *Anlage) *Anlage
-	GetTopQuery() *datastore.Query
-	GetDirectAnlagenQuery() *datastore.Query
-	SaveOrUpdate() error
+	GetTopQuery() *Query
+	GetDirectAnlagenQuery() *Query
+	SaveOrUpdate(repo Repository) error
 }
 
 type HasKey interface {
-	GetKey() *datastore.Key
+	GetKey() *Key
 }
 
-func Sync(app *application.AppContext, s TopHolder) error {
+// Sync parses the file of s and stores it with its Tops and Anlagen. If the
+// file is unchanged since the last Sync (same generation in the Source or
+// same content hash) nothing but a new generation is written and changed is
+// false, unless env.Force is set. The changes are published to env.Events. Errors are of
+// type *Error.
+func Sync(env *Env, s TopHolder) (changed bool, err error) {
 
 	file := s.GetFile()
 
-	err := file.ReadDocument(app.Config.GetBucketFetched())
+	stored, err := loadStored(env.Repo, s)
 	if err != nil {
-		return errors.Wrap(err, fmt.Sprintf("error reading file %s", app.Config.GetAlleSitzungenType()))
+		return false, newError(ErrStorage, file.GetPath(), errors.Wrap(err, fmt.Sprintf("error getting %s from db", s.GetKey().String())))
 	}
 
-	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(file.GetContent()))
+	var generation string
+	if gs, ok := env.Source.(GenerationSource); ok {
+		generation, err = gs.Generation(file)
+		if err != nil {
+			return false, readError(file.GetPath(), errors.Wrap(err, fmt.Sprintf("error reading generation of %s", file.GetName())))
+		}
+		if !env.Force && stored != nil && generation != "" && generation == stored.GetSourceGeneration() {
+			slog.Debug("unchanged generation %s of %s", generation, file.GetName())
+			return false, nil
+		}
+	}
+
+	content, err := env.Source.ReadFile(file)
+	if err != nil {
+		return false, readError(file.GetPath(), errors.Wrap(err, fmt.Sprintf("error reading file %s", file.GetName())))
+	}
+
+	hash := HashContent(content)
+	if !env.Force && stored != nil && hash == stored.GetContentHash() {
+		slog.Debug("unchanged content of %s", file.GetName())
+		if generation != "" && generation != stored.GetSourceGeneration() {
+			// record the generation, so the next Sync doesn't read the file
+			stored.SetSourceVersion(hash, generation)
+			err = env.Repo.Put(s.GetKey(), stored)
+			if err != nil {
+				return false, newError(ErrStorage, file.GetPath(), errors.Wrap(err, fmt.Sprintf("error saving generation of %s", s.GetKey().String())))
+			}
+		}
+		return false, nil
+	}
+
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(content))
 	if err != nil {
-		return errors.Wrap(err, fmt.Sprintf("error create dom from %s", file.GetName()))
+		return false, newError(ErrParse, file.GetPath(), errors.Wrap(err, fmt.Sprintf("error create dom from %s", file.GetName())))
 	}
 
 	err = s.Parse(doc)
 	if err != nil {
-		return errors.Wrap(err, fmt.Sprintf("error parsing sitzung from %s", file.GetName()))
+		return false, newError(ErrParse, file.GetPath(), errors.Wrap(err, fmt.Sprintf("error parsing sitzung from %s", file.GetName())))
 	}
 
 	s.SetSavedAt(time.Now())
+	s.SetSourceVersion(hash, generation)
+
+	// each step publishes its changes once committed, so a later failing
+	// step doesn't lose them
 
 	///
 	if s.GetTopQuery() != nil {
 
-		err = saveTops(app, s, err)
+		changes := &changeLog{}
+		err = saveTops(env.Repo, s, changes)
 		if err != nil {
-			return errors.Wrap(err, fmt.Sprintf("error saving top from %s", file.GetName()))
+			return false, newError(ErrStorage, file.GetPath(), errors.Wrap(err, fmt.Sprintf("error saving top from %s", file.GetName())))
 		}
+		env.publish(changes)
 	}
 
-	err = saveAnlagen(app, s, err)
+	changes := &changeLog{}
+	err = saveAnlagen(env.Repo, s, changes)
 	if err != nil {
-		return errors.Wrap(err, fmt.Sprintf("error saving anlagen from %s", file.GetName()))
+		return false, newError(ErrStorage, file.GetPath(), errors.Wrap(err, fmt.Sprintf("error saving anlagen from %s", file.GetName())))
 	}
+	env.publish(changes)
 
-	return s.SaveOrUpdate()
-}
+	err = s.SaveOrUpdate(env.Repo)
+	if err != nil {
+		return false, newError(ErrStorage, file.GetPath(), err)
+	}
+	parent := &changeLog{}
+	if stored == nil {
+		parent.created(s.GetKey())
+	} else {
+		parent.updated(s.GetKey(), stored, s)
+	}
+	env.publish(parent)
 
-func saveTops(app *application.AppContext, s TopHolder, err error) error {
-	newTopsMap := make(map[string]*Top)
-	for _, t := range s.GetTops() {
-		newTopsMap[t.GetKey().Encode()] = t
+	if t, ok := s.(*Top); ok {
+		changes := &changeLog{}
+		err = saveAbstimmung(env.Repo, t, changes)
+		if err != nil {
+			return false, newError(ErrStorage, file.GetPath(), err)
+		}
+		env.publish(changes)
 	}
 
-	ks, err := app.Db().GetAll(app.Ctx(), s.GetTopQuery().KeysOnly(), nil)
+	return true, nil
+}
+
+// HashContent is the content hash stored with the synced entities, the hex
+// encoded SHA-256 of the file.
+func HashContent(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+// loadStored returns the stored version of s or nil if there is none.
+func loadStored(repo Repository, s TopHolder) (TopHolder, error) {
+	stored := reflect.New(reflect.TypeOf(s).Elem()).Interface()
+	err := repo.Get(s.GetKey(), stored)
+	if err == ErrNoSuchEntity {
+		return nil, nil
+	}
 	if err != nil {
-		return errors.Wrap(err, "error getting beratungen from db")
+		return nil, err
 	}
+	return stored.(TopHolder), nil
+}
 
-	tx, err := app.Db().NewTransaction(app.Ctx())
-	if err != nil {
-		return errors.Wrap(err, "client.NewTransaction")
+func saveTops(repo Repository, s TopHolder, changes *changeLog) error {
+	newTopsMap := make(map[string]*Top)
+	for _, t := range s.GetTops() {
+		newTopsMap[t.GetKey().Encode()] = t
 	}
 
-	oldTops := make([]*Top, len(ks))
-	err = tx.GetMulti(ks, oldTops)
+	ks, err := repo.GetAll(s.GetTopQuery().KeysOnly(), nil)
 	if err != nil {
 		return errors.Wrap(err, "error getting beratungen from db")
 	}
 
-	for i, oldTop := range oldTops {
-		oldkey := ks[i]
-		kstr := oldTop.GetKey().Encode()
-		newTop, exist := newTopsMap[kstr]
-		if !exist {
-			err = tx.Delete(oldkey)
-			if err != nil {
-				slog.Error("delete old top %s: %v", newTop.GetKey().String(), err)
-			}
-		} else {
-			_, err = tx.Put(newTop.GetKey(), s.UpdateTop(oldTop, newTop))
+	// the Abstimmungen of disappeared Tops go with them, like in Top.delete
+	goneChildren := make(map[string][]*Key)
+	for _, k := range ks {
+		if _, exist := newTopsMap[k.Encode()]; !exist {
+			aks, err := abstimmungKeys(repo, k)
 			if err != nil {
-				return errors.Wrap(err, fmt.Sprintf("put new top %s", newTop.GetKey().String()))
+				return err
 			}
-			delete(newTopsMap, kstr)
+			goneChildren[k.Encode()] = aks
 		}
 	}
 
-	for _, newTop := range newTopsMap {
-		_, err = tx.Put(newTop.GetKey(), newTop)
+	err = RunInTransaction(repo, func(tx Transaction) error {
+
+		oldTops := make([]*Top, len(ks))
+		err := tx.GetMulti(ks, oldTops)
 		if err != nil {
-			return errors.Wrap(err, fmt.Sprintf("put new top %s", newTop.GetKey().String()))
+			return errors.Wrap(err, "error getting beratungen from db")
+		}
+
+		for i, oldTop := range oldTops {
+			oldkey := ks[i]
+			kstr := oldkey.Encode()
+			newTop, exist := newTopsMap[kstr]
+			if !exist {
+				aks := goneChildren[kstr]
+				err = tx.DeleteMulti(aks)
+				if err != nil {
+					slog.Error("delete abstimmung of old top %s: %v", oldkey.String(), err)
+				} else {
+					changes.deleted(aks...)
+				}
+				err = tx.Delete(oldkey)
+				if err != nil {
+					slog.Error("delete old top %s: %v", oldkey.String(), err)
+				} else {
+					changes.deleted(oldkey)
+				}
+			} else {
+				before := *oldTop
+				updated := s.UpdateTop(oldTop, newTop)
+				err = tx.Put(oldkey, updated)
+				if err != nil {
+					return errors.Wrap(err, fmt.Sprintf("put new top %s", oldkey.String()))
+				}
+				changes.updated(oldkey, &before, updated)
+				delete(newTopsMap, kstr)
+			}
 		}
-	}
 
-	_, err = tx.Commit()
+		for _, newTop := range newTopsMap {
+			err = tx.Put(newTop.GetKey(), newTop)
+			if err != nil {
+				return errors.Wrap(err, fmt.Sprintf("put new top %s", newTop.GetKey().String()))
+			}
+			changes.created(newTop.GetKey())
+		}
+		return nil
+	})
 	if err != nil {
 		return errors.Wrap(err, fmt.Sprintf("error commiting to db sitzung from %s", s.GetKey()))
 	}
@@ -123,54 +234,57 @@ func saveTops(app *application.AppContext, s TopHolder, err error) error {
 	return nil
 }
 
-func saveAnlagen(app *application.AppContext, s TopHolder, err error) error {
-	newTopsMap := make(map[string]*Anlage)
-	for _, t := range s.GetAnlagen() {
-		newTopsMap[t.GetKey(s.GetKey()).Encode()] = t
+func saveAnlagen(repo Repository, s TopHolder, changes *changeLog) error {
+	newAnlagenMap := make(map[string]*Anlage)
+	for _, a := range s.GetAnlagen() {
+		newAnlagenMap[a.GetKey(s.GetKey()).Encode()] = a
 	}
 
-	ks, err := app.Db().GetAll(app.Ctx(), s.GetDirectAnlagenQuery().KeysOnly(), nil)
+	ks, err := repo.GetAll(s.GetDirectAnlagenQuery().KeysOnly(), nil)
 	if err != nil {
-		return errors.Wrap(err, "error getting beratungen from db")
+		return errors.Wrap(err, "error getting anlagen from db")
 	}
 
-	tx, err := app.Db().NewTransaction(app.Ctx())
-	if err != nil {
-		return errors.Wrap(err, "client.NewTransaction")
-	}
+	err = RunInTransaction(repo, func(tx Transaction) error {
 
-	oldTops := make([]*Anlage, len(ks))
-	err = tx.GetMulti(ks, oldTops)
-	if err != nil {
-		return errors.Wrap(err, "error getting beratungen from db")
-	}
+		oldAnlagen := make([]*Anlage, len(ks))
+		err := tx.GetMulti(ks, oldAnlagen)
+		if err != nil {
+			return errors.Wrap(err, "error getting anlagen from db")
+		}
 
-	for i, oldTop := range oldTops {
-		oldkey := ks[i]
-		kstr := oldTop.GetKey(s.GetKey()).Encode()
-		newTop, exist := newTopsMap[kstr]
-		if !exist {
-			err = tx.Delete(oldkey)
-			if err != nil {
-				slog.Error("delete old top %s: %v", newTop.GetKey(s.GetKey()).String(), err)
+		for i, oldAnlage := range oldAnlagen {
+			oldkey := ks[i]
+			kstr := oldkey.Encode()
+			newAnlage, exist := newAnlagenMap[kstr]
+			if !exist {
+				err = tx.Delete(oldkey)
+				if err != nil {
+					slog.Error("delete old anlage %s: %v", oldkey.String(), err)
+				} else {
+					changes.deleted(oldkey)
+				}
+			} else {
+				before := *oldAnlage
+				updated := s.UpdateAnlage(oldAnlage, newAnlage)
+				err = tx.Put(oldkey, updated)
+				if err != nil {
+					return errors.Wrap(err, fmt.Sprintf("put new anlage %s", oldkey.String()))
+				}
+				changes.updated(oldkey, &before, updated)
+				delete(newAnlagenMap, kstr)
 			}
-		} else {
-			_, err = tx.Put(newTop.GetKey(s.GetKey()), s.UpdateAnlage(oldTop, newTop))
-			if err != nil {
-				return errors.Wrap(err, fmt.Sprintf("put new top %s", newTop.GetKey(s.GetKey()).String()))
-			}
-			delete(newTopsMap, kstr)
 		}
-	}
 
-	for _, newTop := range newTopsMap {
-		_, err = tx.Put(newTop.GetKey(s.GetKey()), newTop)
-		if err != nil {
-			return errors.Wrap(err, fmt.Sprintf("put new top %s", newTop.GetKey(s.GetKey()).String()))
+		for _, newAnlage := range newAnlagenMap {
+			err = tx.Put(newAnlage.GetKey(s.GetKey()), newAnlage)
+			if err != nil {
+				return errors.Wrap(err, fmt.Sprintf("put new anlage %s", newAnlage.GetKey(s.GetKey()).String()))
+			}
+			changes.created(newAnlage.GetKey(s.GetKey()))
 		}
-	}
-
-	_, err = tx.Commit()
+		return nil
+	})
 	if err != nil {
 		return errors.Wrap(err, fmt.Sprintf("error commiting to db sitzung from %s", s.GetKey()))
 	}
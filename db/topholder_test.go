@@ -0,0 +1,15 @@
+package db_test
+
+import (
+	"testing"
+
+	"github.com/rismaster/allris-db/db"
+	"github.com/rismaster/allris-db/db/internal/dbtest"
+	"github.com/rismaster/allris-db/db/memory"
+)
+
+func TestSync(t *testing.T) {
+	dbtest.RunSyncScenarios(t, func(t *testing.T) db.Repository {
+		return memory.New()
+	})
+}
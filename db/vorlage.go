@@ -1,10 +1,10 @@
 package db
 
 import (
-	"cloud.google.com/go/datastore"
 	"fmt"
 	"github.com/PuerkitoBio/goquery"
 	"github.com/pkg/errors"
+	allris_common "github.com/rismaster/allris-common"
 	"github.com/rismaster/allris-common/application"
 	"github.com/rismaster/allris-common/common/domtools"
 	"github.com/rismaster/allris-common/common/files"
@@ -36,8 +36,11 @@ type Vorlage struct {
 
 	SavedAt time.Time
 
-	file *files.File
-	app  *application.AppContext
+	ContentHash      string `datastore:",noindex"`
+	SourceGeneration string `datastore:",noindex"`
+
+	file   *files.File
+	config allris_common.Config
 }
 
 func NewVorlage(app *application.AppContext, file *files.File) (*Vorlage, error) {
@@ -50,16 +53,16 @@ func NewVorlage(app *application.AppContext, file *files.File) (*Vorlage, error)
 	return &Vorlage{
 		VOLFDNR: volfdnr,
 		file:    file,
-		app:     app,
+		config:  app.Config,
 	}, nil
 }
 
-func (v *Vorlage) GetTopQuery() *datastore.Query {
-	return datastore.NewQuery(v.app.Config.GetEntityTop()).Filter("VOLFDNR =", v.VOLFDNR)
+func (v *Vorlage) GetTopQuery() *Query {
+	return NewQuery(v.config.GetEntityTop()).Filter("VOLFDNR =", v.VOLFDNR)
 }
 
-func (v *Vorlage) GetDirectAnlagenQuery() *datastore.Query {
-	return datastore.NewQuery(v.app.Config.GetEntityAnlage()).Ancestor(v.GetKey())
+func (v *Vorlage) GetDirectAnlagenQuery() *Query {
+	return NewQuery(v.config.GetEntityAnlage()).WithAncestor(v.GetKey())
 }
 
 func (v *Vorlage) GetFile() *files.File {
@@ -70,6 +73,19 @@ func (v *Vorlage) SetSavedAt(t time.Time) {
 	v.SavedAt = t
 }
 
+func (v *Vorlage) GetContentHash() string {
+	return v.ContentHash
+}
+
+func (v *Vorlage) GetSourceGeneration() string {
+	return v.SourceGeneration
+}
+
+func (v *Vorlage) SetSourceVersion(hash string, generation string) {
+	v.ContentHash = hash
+	v.SourceGeneration = generation
+}
+
 func (v *Vorlage) GetTops() []*Top {
 	return v.beratungsfolge
 }
@@ -78,8 +94,8 @@ func (v *Vorlage) GetAnlagen() []*Anlage {
 	return v.anlagen
 }
 
-func (v *Vorlage) GetKey() *datastore.Key {
-	return datastore.NameKey(v.app.Config.GetEntityVorlage(), fmt.Sprintf("%d", v.VOLFDNR), nil)
+func (v *Vorlage) GetKey() *Key {
+	return NameKey(v.config.GetEntityVorlage(), fmt.Sprintf("%d", v.VOLFDNR), nil)
 }
 
 func (v *Vorlage) Parse(doc *goquery.Document) error {
@@ -97,13 +113,13 @@ func (v *Vorlage) parseElement(dom *goquery.Selection) error {
 	topTblx := dom.Find("table.tk1")
 
 	bez, cont := domtools.ParseTable(topTblx.Find("tr > td.kb1"))
-	v.anlagen = ExtractAnlagen(dom, v.app.Config)
+	v.anlagen = ExtractAnlagen(dom, v.config)
 
 	for _, a := range v.anlagen {
 		a.VOLFDNR = v.VOLFDNR
 	}
 
-	basisanlagen := ExtractBasisAnlagen(dom, v.app.Config)
+	basisanlagen := ExtractBasisAnlagen(dom, v.config)
 	for _, a := range basisanlagen {
 		v.anlagen = append(v.anlagen, a)
 		a.VOLFDNR = v.VOLFDNR
@@ -120,13 +136,13 @@ func (v *Vorlage) parseElement(dom *goquery.Selection) error {
 	v.Bearbeiter = domtools.FindIndex(bez, cont, "Bearbeiter/-in:")
 
 	bvhtml, _ := dom.Find("a[name=\"allrisBV\"]").NextFilteredUntil("div", "a").Html()
-	v.BeschlussVorlage = domtools.SanatizeHtml(bvhtml, v.app.Config)
+	v.BeschlussVorlage = domtools.SanatizeHtml(bvhtml, v.config)
 	bghtml, _ := dom.Find("a[name=\"allrisSV\"]").
 		NextFilteredUntil("div", "a").Html()
-	v.Begruendung = domtools.SanatizeHtml(bghtml, v.app.Config)
+	v.Begruendung = domtools.SanatizeHtml(bghtml, v.config)
 	fahtml, _ := dom.Find("a[name=\"allrisFA\"]").
 		NextFilteredUntil("div", "a").Html()
-	v.FinanzielleAuswirkung = domtools.SanatizeHtml(fahtml, v.app.Config)
+	v.FinanzielleAuswirkung = domtools.SanatizeHtml(fahtml, v.config)
 
 	//theTopTable := dom.Find(".me1 > table.tk1").First()
 
@@ -163,7 +179,7 @@ func (v *Vorlage) parseElement(dom *goquery.Selection) error {
 		} else if topTds.Size() == 7 {
 
 			missingBerDetails = false
-			t, err := time.Parse(v.app.Config.GetDateFormat(), domtools.CleanText(topTds.Find("a").First().Text()))
+			t, err := time.Parse(v.config.GetDateFormat(), domtools.CleanText(topTds.Find("a").First().Text()))
 			if err == nil {
 				beratung.Datum = t
 			}
@@ -239,6 +255,7 @@ func (v *Vorlage) createBeratung(beratung *Top) *Top {
 	beratung.Typ = beratungTyp
 	beratung.Gremium = beratungGremium
 	beratung.SavedAt = time.Now()
+	beratung.config = v.config
 	return beratung
 }
 
@@ -265,67 +282,100 @@ func (v *Vorlage) UpdateAnlage(oldAnlage *Anlage, newAnlage *Anlage) *Anlage {
 	return oldAnlage
 }
 
-func (v *Vorlage) SaveOrUpdate() error {
-	tx, err := v.app.Db().NewTransaction(v.app.Ctx())
-	if err != nil {
-		return errors.Wrap(err, "client.NewTransaction")
-	}
+func (v *Vorlage) SaveOrUpdate(repo Repository) error {
+	return RunInTransaction(repo, func(tx Transaction) error {
+
+		var oldVorlage Vorlage
+		var old *Vorlage
+		err := tx.Get(v.GetKey(), &oldVorlage)
+		if err != nil && err != ErrNoSuchEntity {
+			return err
+		} else if err == nil {
+			//update
+			//v.VOLFDNR = oldVorlage.VOLFDNR
+			old = &oldVorlage
+		}
 
-	var oldVorlage Vorlage
-	err = tx.Get(v.GetKey(), &oldVorlage)
-	if err != nil && err != datastore.ErrNoSuchEntity {
-		return err
-	} else if err == nil {
-		//update
-		//v.VOLFDNR = oldVorlage.VOLFDNR
-	}
+		revision, err := newVorlageRevision(old, v)
+		if err != nil {
+			return errors.Wrap(err, fmt.Sprintf("error creating revision of vorlage from %s", v.file.GetName()))
+		}
+		if revision != nil {
+			err = tx.Put(revision.GetKey(v.GetKey()), revision)
+			if err != nil {
+				return errors.Wrap(err, fmt.Sprintf("error saving revision of vorlage from %s", v.file.GetName()))
+			}
+		}
 
-	_, err = tx.Put(v.GetKey(), v)
-	if err != nil {
-		return errors.Wrap(err, fmt.Sprintf("error saving to db vorlage from %s", v.file.GetName()))
-	}
+		err = tx.Put(v.GetKey(), v)
+		if err != nil {
+			return errors.Wrap(err, fmt.Sprintf("error saving to db vorlage from %s", v.file.GetName()))
+		}
+		return nil
+	})
+}
 
-	_, err = tx.Commit()
-	return err
+func (v *Vorlage) Delete(repo Repository) error {
+	return v.delete(repo, nil)
 }
 
-func (v *Vorlage) Delete() error {
+func (v *Vorlage) delete(repo Repository, changes *changeLog) error {
 
-	ks, err := v.app.Db().GetAll(v.app.Ctx(), v.GetDirectAnlagenQuery().KeysOnly(), nil)
+	ks, err := repo.GetAll(v.GetDirectAnlagenQuery().KeysOnly(), nil)
 	if err != nil {
 		return errors.Wrap(err, "error getting anlagen from db")
 	}
 
 	var tops []*Top
-	_, err = v.app.Db().GetAll(v.app.Ctx(), v.GetTopQuery(), &tops)
+	tks, err := repo.GetAll(v.GetTopQuery(), &tops)
 	if err != nil {
 		return errors.Wrap(err, "error getting beratungen from db")
 	}
 
-	tx, err := v.app.Db().NewTransaction(v.app.Ctx())
-	if err != nil {
-		return errors.Wrap(err, "client.NewTransaction")
-	}
+	return RunInTransaction(repo, func(tx Transaction) error {
 
-	for _, top := range tops {
-		top.VOLFDNR = 0
-		_, err1 := tx.Put(top.GetKey(), top)
-		if err1 != nil {
-			return errors.Wrap(err, fmt.Sprintf("error edit top volfdnr in db for %s", v.file.GetName()))
-		}
-	}
+		var old Vorlage
+		exists := tx.Get(v.GetKey(), &old) == nil
 
-	err = tx.DeleteMulti(ks)
-	if err != nil {
-		slog.Error("error delete tops of vorlage in db for %s: %v", v.file.GetName(), err)
-	}
+		for i, top := range tops {
+			before := *top
+			top.VOLFDNR = 0
+			err1 := tx.Put(tks[i], top)
+			if err1 != nil {
+				return errors.Wrap(err1, fmt.Sprintf("error edit top volfdnr in db for %s", v.file.GetName()))
+			}
+			changes.updated(tks[i], &before, top)
+		}
 
-	err = tx.Delete(v.GetKey())
-	if err != nil {
-		slog.Error("error delete vorlage in db for %s: %v", v.file.GetName(), err)
-	}
+		err := tx.DeleteMulti(ks)
+		if err != nil {
+			slog.Error("error delete anlagen of vorlage in db for %s: %v", v.file.GetName(), err)
+		} else {
+			changes.deleted(ks...)
+		}
 
-	_, err = tx.Commit()
+		err = tx.Delete(v.GetKey())
+		if err != nil {
+			slog.Error("error delete vorlage in db for %s: %v", v.file.GetName(), err)
+			return nil
+		}
+		if !exists {
+			return nil
+		}
+		changes.deleted(v.GetKey())
 
-	return err
+		// the revisions are kept, the last one records the delete
+		revision, err := newVorlageRevision(&old, nil)
+		if err != nil {
+			return errors.Wrap(err, fmt.Sprintf("error creating deletion revision of vorlage for %s", v.file.GetName()))
+		}
+		if revision != nil {
+			err = tx.Put(revision.GetKey(v.GetKey()), revision)
+			if err != nil {
+				return errors.Wrap(err, fmt.Sprintf("error saving deletion revision of vorlage for %s", v.file.GetName()))
+			}
+			changes.created(revision.GetKey(v.GetKey()))
+		}
+		return nil
+	})
 }
@@ -6,14 +6,18 @@ require (
 	cloud.google.com/go v0.81.0 // indirect
 	cloud.google.com/go/datastore v1.1.0
 	cloud.google.com/go/pubsub v1.3.1 // indirect
-	cloud.google.com/go/storage v1.15.0 // indirect
+	cloud.google.com/go/storage v1.15.0
 	github.com/PuerkitoBio/goquery v1.6.1
+	github.com/blevesearch/bleve/v2 v2.0.5
 	github.com/google/go-cmp v0.5.5 // indirect
 	github.com/kennygrant/sanitize v1.2.4
+	github.com/ledongthuc/pdf v0.0.0-20240201131950-da5b75280b06
+	github.com/lib/pq v1.10.9
 	github.com/mailgun/mailgun-go/v4 v4.5.1 // indirect
+	github.com/mattn/go-sqlite3 v1.14.22
 	github.com/microcosm-cc/bluemonday v1.0.9 // indirect
 	github.com/pkg/errors v0.9.1
-	github.com/rismaster/allris-common v0.0.0-20210907094820-06f9bf183f2a // indirect
+	github.com/rismaster/allris-common v0.0.0-20210907094820-06f9bf183f2a
 	golang.org/x/net v0.0.0-20210503060351-7fd8e65b6420 // indirect
 	google.golang.org/api v0.45.0 // indirect
 	h12.io/socks v1.0.2 // indirect